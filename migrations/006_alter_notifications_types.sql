@@ -0,0 +1,53 @@
+-- Migration: 006_alter_notifications_types
+-- Description: Replaces the notification type and status enums with CHECK
+--              constraints, which the notification service's models also
+--              declare, and adds the index behind cursor pagination. New
+--              notification types are added by replacing
+--              chk_notifications_type.
+-- Created: 2026-10-18
+
+-- The partial index compares status with an enum value
+DROP INDEX IF EXISTS idx_notifications_unread;
+
+ALTER TABLE notifications
+    ALTER COLUMN type TYPE VARCHAR(50) USING type::text,
+    ALTER COLUMN status DROP DEFAULT,
+    ALTER COLUMN status TYPE VARCHAR(20) USING status::text,
+    ALTER COLUMN status SET DEFAULT 'pending',
+    ALTER COLUMN status SET NOT NULL;
+
+DROP TYPE IF EXISTS notification_type;
+DROP TYPE IF EXISTS notification_status;
+
+ALTER TABLE notifications
+    ADD CONSTRAINT chk_notifications_type CHECK (type IN (
+        'welcome', 'verification', 'digest',
+        'feed_created', 'feed_liked', 'feed_commented',
+        'user_followed', 'user_mentioned', 'system_announcement'
+    )),
+    ADD CONSTRAINT chk_notifications_status CHECK (status IN ('pending', 'sent', 'read', 'failed'));
+
+CREATE INDEX idx_notifications_unread ON notifications(user_id, status)
+    WHERE status <> 'read';
+
+-- Newest first per user, ties broken by ID
+CREATE INDEX IF NOT EXISTS idx_notifications_user_cursor
+    ON notifications(user_id, created_at DESC, id DESC);
+
+-- Down migration
+-- Notifications of types the enum lacks (welcome, verification, digest)
+-- must be deleted first.
+-- DROP INDEX IF EXISTS idx_notifications_user_cursor;
+-- DROP INDEX IF EXISTS idx_notifications_unread;
+-- ALTER TABLE notifications
+--     DROP CONSTRAINT IF EXISTS chk_notifications_type,
+--     DROP CONSTRAINT IF EXISTS chk_notifications_status;
+-- CREATE TYPE notification_type AS ENUM ('feed_created', 'feed_liked', 'feed_commented', 'user_followed', 'user_mentioned', 'system_announcement');
+-- CREATE TYPE notification_status AS ENUM ('pending', 'sent', 'read', 'failed');
+-- ALTER TABLE notifications
+--     ALTER COLUMN type TYPE notification_type USING type::notification_type,
+--     ALTER COLUMN status DROP DEFAULT,
+--     ALTER COLUMN status TYPE notification_status USING status::notification_status,
+--     ALTER COLUMN status SET DEFAULT 'pending';
+-- CREATE INDEX idx_notifications_unread ON notifications(user_id, status)
+--     WHERE status != 'read';
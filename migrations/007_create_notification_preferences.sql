@@ -0,0 +1,45 @@
+-- Migration: 007_create_notification_preferences
+-- Description: Creates per-user notification settings (time zone and quiet
+--              hours) and per-type channel preferences
+-- Created: 2026-10-18
+
+CREATE TABLE IF NOT EXISTS notification_settings (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    quiet_hours_start VARCHAR(5), -- Local time as HH:MM
+    quiet_hours_end VARCHAR(5),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((quiet_hours_start IS NULL) = (quiet_hours_end IS NULL))
+);
+
+-- One row per notification type the user chose channels for, plus a
+-- 'default' row for every other type
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    in_app BOOLEAN NOT NULL DEFAULT TRUE,
+    email BOOLEAN NOT NULL DEFAULT FALSE,
+    push BOOLEAN NOT NULL DEFAULT TRUE,
+    digest BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, type)
+);
+
+-- Triggers to auto-update updated_at
+CREATE TRIGGER update_notification_settings_updated_at
+    BEFORE UPDATE ON notification_settings
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_notification_preferences_updated_at
+    BEFORE UPDATE ON notification_preferences
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Down migration
+-- DROP TRIGGER IF EXISTS update_notification_preferences_updated_at ON notification_preferences;
+-- DROP TRIGGER IF EXISTS update_notification_settings_updated_at ON notification_settings;
+-- DROP TABLE IF EXISTS notification_preferences;
+-- DROP TABLE IF EXISTS notification_settings;
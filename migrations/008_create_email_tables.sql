@@ -0,0 +1,42 @@
+-- Migration: 008_create_email_tables
+-- Description: Adds email recipients, suppressed addresses and the locale
+--              emails are rendered in
+-- Created: 2026-10-18
+
+ALTER TABLE notification_settings
+    ADD COLUMN IF NOT EXISTS locale VARCHAR(10) NOT NULL DEFAULT 'en';
+
+-- Addresses learned from user events
+CREATE TABLE IF NOT EXISTS notification_recipients (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Addresses that hard bounced or complained; stored lowercase
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    email VARCHAR(255) PRIMARY KEY,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('bounce', 'complaint')),
+    detail TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Triggers to auto-update updated_at
+CREATE TRIGGER update_notification_recipients_updated_at
+    BEFORE UPDATE ON notification_recipients
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE TRIGGER update_email_suppressions_updated_at
+    BEFORE UPDATE ON email_suppressions
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Down migration
+-- DROP TRIGGER IF EXISTS update_email_suppressions_updated_at ON email_suppressions;
+-- DROP TRIGGER IF EXISTS update_notification_recipients_updated_at ON notification_recipients;
+-- DROP TABLE IF EXISTS email_suppressions;
+-- DROP TABLE IF EXISTS notification_recipients;
+-- ALTER TABLE notification_settings DROP COLUMN IF EXISTS locale;
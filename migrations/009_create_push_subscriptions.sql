@@ -0,0 +1,28 @@
+-- Migration: 009_create_push_subscriptions
+-- Description: Creates the push_subscriptions table for Web Push delivery
+-- Created: 2026-10-18
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    endpoint TEXT NOT NULL UNIQUE, -- Push service URL for one browser
+    p256dh VARCHAR(128) NOT NULL,  -- Browser public key, base64url
+    auth VARCHAR(64) NOT NULL,     -- Authentication secret, base64url
+    device VARCHAR(100),
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes
+CREATE INDEX idx_push_subscriptions_user_id ON push_subscriptions(user_id);
+
+-- Trigger to auto-update updated_at
+CREATE TRIGGER update_push_subscriptions_updated_at
+    BEFORE UPDATE ON push_subscriptions
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Down migration
+-- DROP TRIGGER IF EXISTS update_push_subscriptions_updated_at ON push_subscriptions;
+-- DROP TABLE IF EXISTS push_subscriptions;
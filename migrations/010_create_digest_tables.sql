@@ -0,0 +1,61 @@
+-- Migration: 010_create_digest_tables
+-- Description: Adds digest schedules, queued digest items, sent digests and
+--              the lease that keeps one scheduler running across replicas
+-- Created: 2026-10-18
+
+ALTER TABLE notification_settings
+    ADD COLUMN IF NOT EXISTS digest_frequency VARCHAR(10) NOT NULL DEFAULT 'daily',
+    ADD COLUMN IF NOT EXISTS digest_time VARCHAR(5) NOT NULL DEFAULT '08:00',  -- Local time, HH:MM
+    ADD COLUMN IF NOT EXISTS digest_day VARCHAR(9) NOT NULL DEFAULT 'monday',  -- Weekly digests only
+    ADD COLUMN IF NOT EXISTS digest_channel VARCHAR(10) NOT NULL DEFAULT 'email';
+
+-- One digest per user per scheduled time, so a digest is only sent once
+CREATE TABLE IF NOT EXISTS digests (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    scheduled_for TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    summary TEXT,
+    sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT idx_digests_user_scheduled UNIQUE (user_id, scheduled_for)
+);
+
+-- Notifications waiting for a digest
+CREATE TABLE IF NOT EXISTS digest_items (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    digest_id UUID REFERENCES digests(id) ON DELETE CASCADE, -- NULL until claimed
+    notification_id UUID,
+    type VARCHAR(50) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS scheduler_leases (
+    name VARCHAR(100) PRIMARY KEY,
+    holder VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Indexes
+CREATE INDEX idx_digests_status ON digests(status);
+CREATE INDEX idx_digest_items_user_id ON digest_items(user_id);
+CREATE INDEX idx_digest_items_digest_id ON digest_items(digest_id);
+
+-- Trigger to auto-update updated_at
+CREATE TRIGGER update_digests_updated_at
+    BEFORE UPDATE ON digests
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Down migration
+-- DROP TRIGGER IF EXISTS update_digests_updated_at ON digests;
+-- DROP TABLE IF EXISTS scheduler_leases;
+-- DROP TABLE IF EXISTS digest_items;
+-- DROP TABLE IF EXISTS digests;
+-- ALTER TABLE notification_settings DROP COLUMN IF EXISTS digest_frequency,
+--     DROP COLUMN IF EXISTS digest_time, DROP COLUMN IF EXISTS digest_day,
+--     DROP COLUMN IF EXISTS digest_channel;
@@ -0,0 +1,34 @@
+-- Migration: 011_add_notification_aggregation
+-- Description: Aggregates notifications about the same thing into one and
+--              records handled events so redelivered ones are skipped
+-- Created: 2026-10-18
+
+ALTER TABLE notifications
+    ADD COLUMN IF NOT EXISTS group_key VARCHAR(255), -- type:reference_type:reference_id
+    ADD COLUMN IF NOT EXISTS actor_count INTEGER NOT NULL DEFAULT 1;
+
+-- Actors counted in an aggregated notification, so one actor counts once
+CREATE TABLE IF NOT EXISTS notification_actors (
+    notification_id UUID NOT NULL REFERENCES notifications(id) ON DELETE CASCADE,
+    actor_id UUID NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (notification_id, actor_id)
+);
+
+-- Event IDs already handled, pruned after the retention period
+CREATE TABLE IF NOT EXISTS processed_events (
+    event_id VARCHAR(255) PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes
+CREATE INDEX idx_notifications_group ON notifications(user_id, group_key)
+    WHERE group_key IS NOT NULL AND group_key <> '';
+CREATE INDEX idx_processed_events_created_at ON processed_events(created_at);
+
+-- Down migration
+-- DROP TABLE IF EXISTS processed_events;
+-- DROP TABLE IF EXISTS notification_actors;
+-- DROP INDEX IF EXISTS idx_notifications_group;
+-- ALTER TABLE notifications DROP COLUMN IF EXISTS actor_count,
+--     DROP COLUMN IF EXISTS group_key;
@@ -0,0 +1,20 @@
+-- Migration: 012_alter_audit_log_actions
+-- Description: Stores audit actions as text, since services record actions
+--              such as notification sends without a migration
+-- Created: 2026-10-18
+
+DROP INDEX IF EXISTS idx_audit_logs_action;
+
+ALTER TABLE audit_logs
+    ALTER COLUMN action TYPE VARCHAR(50) USING action::text;
+
+DROP TYPE IF EXISTS audit_action;
+
+CREATE INDEX idx_audit_logs_action ON audit_logs(action);
+
+-- Down migration
+-- DROP INDEX IF EXISTS idx_audit_logs_action;
+-- CREATE TYPE audit_action AS ENUM ('create', 'read', 'update', 'delete', 'login', 'logout', 'password_change', 'permission_change');
+-- ALTER TABLE audit_logs
+--     ALTER COLUMN action TYPE audit_action USING action::audit_action;
+-- CREATE INDEX idx_audit_logs_action ON audit_logs(action);
@@ -0,0 +1,19 @@
+-- Migration: 013_create_outbox
+-- Description: Adds the outbox that domain events are written to in the
+--              same transaction as the change they describe, until published
+-- Created: 2026-10-18
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY, -- Publish order
+    topic VARCHAR(255) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    event_id VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes
+CREATE INDEX idx_outbox_created_at ON outbox(created_at);
+
+-- Down migration
+-- DROP TABLE IF EXISTS outbox;
@@ -0,0 +1,10 @@
+-- Migration: 014_add_outbox_trace_context
+-- Description: Keeps the trace context of the request that wrote each outbox
+--              event, so publishing it continues the request's trace
+-- Created: 2026-10-18
+
+ALTER TABLE outbox
+    ADD COLUMN IF NOT EXISTS trace_context JSONB NOT NULL DEFAULT '{}'; -- traceparent and baggage
+
+-- Down migration
+-- ALTER TABLE outbox DROP COLUMN IF EXISTS trace_context;
@@ -0,0 +1,18 @@
+-- Migration: 015_create_email_verifications
+-- Description: Adds the pending email address verifications, by a hash of
+--              the token in the verification link sent on registration
+-- Created: 2026-10-18
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE, -- SHA-256 of the token, hex
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Indexes
+CREATE INDEX idx_email_verifications_user_id ON email_verifications(user_id);
+
+-- Down migration
+-- DROP TABLE IF EXISTS email_verifications;
@@ -0,0 +1,18 @@
+// Package requestid carries request IDs in contexts. It has no dependencies,
+// so the HTTP middleware and message consumers can share it.
+package requestid
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying a request ID
+func NewContext(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, requestID)
+}
+
+// FromContext returns the request ID in ctx, or "" if it has none
+func FromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(contextKey{}).(string)
+	return requestID
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"encoding/json"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -159,6 +160,28 @@ func TooManyRequestsResponse(c *gin.Context) {
 	})
 }
 
+// PayloadTooLargeResponse sends a payload too large response
+func PayloadTooLargeResponse(c *gin.Context, message string) {
+	c.JSON(http.StatusRequestEntityTooLarge, Response{
+		Success: false,
+		Error: &ErrorInfo{
+			Code:    "PAYLOAD_TOO_LARGE",
+			Message: message,
+		},
+	})
+}
+
+// UnsupportedMediaTypeResponse sends an unsupported media type response
+func UnsupportedMediaTypeResponse(c *gin.Context, message string) {
+	c.JSON(http.StatusUnsupportedMediaType, Response{
+		Success: false,
+		Error: &ErrorInfo{
+			Code:    "UNSUPPORTED_MEDIA_TYPE",
+			Message: message,
+		},
+	})
+}
+
 // ServiceUnavailableResponse sends a service unavailable response
 func ServiceUnavailableResponse(c *gin.Context, message string) {
 	c.JSON(http.StatusServiceUnavailable, Response{
@@ -169,3 +192,18 @@ func ServiceUnavailableResponse(c *gin.Context, message string) {
 		},
 	})
 }
+
+// WriteErrorJSON writes an error response directly to an http.ResponseWriter.
+// It is used by code that runs outside a gin handler, such as reverse proxy
+// error handlers.
+func WriteErrorJSON(w http.ResponseWriter, statusCode int, code, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(Response{
+		Success: false,
+		Error: &ErrorInfo{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
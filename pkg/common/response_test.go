@@ -131,6 +131,26 @@ func TestTooManyRequestsResponse(t *testing.T) {
 	assert.Equal(t, http.StatusTooManyRequests, w.Code)
 }
 
+func TestPayloadTooLargeResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	PayloadTooLargeResponse(c, "request body too large")
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "PAYLOAD_TOO_LARGE")
+}
+
+func TestUnsupportedMediaTypeResponse(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	UnsupportedMediaTypeResponse(c, "content type must be application/json")
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	assert.Contains(t, w.Body.String(), "UNSUPPORTED_MEDIA_TYPE")
+}
+
 func TestServiceUnavailableResponse(t *testing.T) {
 	w := httptest.NewRecorder()
 	c, _ := gin.CreateTestContext(w)
@@ -179,3 +199,20 @@ func TestErrorResponse(t *testing.T) {
 	assert.NotNil(t, response.Error)
 	assert.Equal(t, "invalid input", response.Error.Message)
 }
+
+func TestWriteErrorJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	WriteErrorJSON(w, http.StatusGatewayTimeout, "GATEWAY_TIMEOUT", "upstream timed out")
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+
+	var response Response
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	assert.False(t, response.Success)
+	assert.Equal(t, "GATEWAY_TIMEOUT", response.Error.Code)
+	assert.Equal(t, "upstream timed out", response.Error.Message)
+}
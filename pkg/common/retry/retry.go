@@ -0,0 +1,44 @@
+// Package retry paces retries of transient failures. It has no dependencies,
+// so clients of any kind can share it.
+package retry
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Config holds retry settings for transient failures
+type Config struct {
+	MaxRetries  int
+	InitialWait time.Duration
+	MaxWait     time.Duration
+	Multiplier  float64
+}
+
+// DefaultConfig returns default retry configuration
+func DefaultConfig() Config {
+	return Config{
+		MaxRetries:  3,
+		InitialWait: 100 * time.Millisecond,
+		MaxWait:     2 * time.Second,
+		Multiplier:  2.0,
+	}
+}
+
+// Backoff returns how long to wait before the given retry attempt (starting
+// at 1). The ceiling grows exponentially from InitialWait by Multiplier up to
+// MaxWait, and the actual wait is drawn uniformly from [0, ceiling] so that
+// clients retrying together spread out.
+func (c Config) Backoff(attempt int) time.Duration {
+	if attempt < 1 || c.InitialWait <= 0 {
+		return 0
+	}
+
+	ceiling := float64(c.InitialWait) * math.Pow(math.Max(c.Multiplier, 1), float64(attempt-1))
+	if c.MaxWait > 0 && ceiling > float64(c.MaxWait) {
+		ceiling = float64(c.MaxWait)
+	}
+
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
@@ -0,0 +1,18 @@
+package retry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_Backoff(t *testing.T) {
+	cfg := DefaultConfig()
+
+	assert.Zero(t, cfg.Backoff(0))
+	for i := 0; i < 100; i++ {
+		assert.LessOrEqual(t, cfg.Backoff(1), cfg.InitialWait)
+		assert.LessOrEqual(t, cfg.Backoff(2), 2*cfg.InitialWait)
+		assert.LessOrEqual(t, cfg.Backoff(10), cfg.MaxWait)
+	}
+}
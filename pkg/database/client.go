@@ -88,6 +88,14 @@ func NewClient(cfg Config, log *zap.Logger) (*Client, error) {
 	}, nil
 }
 
+// NewClientFromDB wraps an open GORM database, such as a test database
+func NewClientFromDB(db *gorm.DB, log *zap.Logger) *Client {
+	return &Client{
+		db:     db,
+		logger: log,
+	}
+}
+
 // DB returns the underlying GORM database
 func (c *Client) DB() *gorm.DB {
 	return c.db
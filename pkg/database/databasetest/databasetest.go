@@ -0,0 +1,72 @@
+// Package databasetest provides throwaway databases for tests. They are
+// SQLite databases with the Postgres functions the services rely on stubbed
+// out, so tests can run handlers against real tables without a server.
+package databasetest
+
+import (
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/Femi-lawal/udagram-app/pkg/database"
+)
+
+const driverName = "sqlite3_udagram"
+
+func init() {
+	sql.Register(driverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			// Writes are serialized by SQLite, so advisory locks are always
+			// granted
+			functions := map[string]any{
+				"hashtext": func(s string) int64 {
+					h := fnv.New32a()
+					_, _ = h.Write([]byte(s))
+					return int64(int32(h.Sum32()))
+				},
+				"pg_advisory_xact_lock":     func(int64) string { return "" },
+				"pg_try_advisory_xact_lock": func(int64) bool { return true },
+			}
+			for name, fn := range functions {
+				if err := conn.RegisterFunc(name, fn, true); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// New returns a client for a new database with tables for models. The
+// database is removed when the test ends.
+func New(t testing.TB, models ...any) *database.Client {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on",
+		filepath.Join(t.TempDir(), "test.db"))
+	db, err := gorm.Open(&sqlite.Dialector{DriverName: driverName, DSN: dsn}, &gorm.Config{
+		Logger:                 logger.Discard,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+
+	client := database.NewClientFromDB(db, zap.NewNop())
+	t.Cleanup(func() {
+		_ = client.Close()
+	})
+
+	if err := client.Migrate(models...); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	return client
+}
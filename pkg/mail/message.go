@@ -0,0 +1,125 @@
+package mail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidMessage is returned for messages that cannot be sent as given
+var ErrInvalidMessage = errors.New("mail: invalid message")
+
+// Message is an email with a plain text and an HTML body
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// Headers are extra headers such as List-Unsubscribe
+	Headers map[string]string
+}
+
+// recipient returns the address the message is delivered to
+func (m *Message) recipient() (*mail.Address, error) {
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return nil, fmt.Errorf("%w: recipient %q: %v", ErrInvalidMessage, m.To, err)
+	}
+	return to, nil
+}
+
+// build renders the message as multipart/alternative MIME
+func (m *Message) build(from *mail.Address, now time.Time) ([]byte, error) {
+	to, err := m.recipient()
+	if err != nil {
+		return nil, err
+	}
+	if m.Text == "" && m.HTML == "" {
+		return nil, fmt.Errorf("%w: no body", ErrInvalidMessage)
+	}
+
+	header := textproto.MIMEHeader{}
+	for name, value := range m.Headers {
+		header.Set(name, value)
+	}
+	header.Set("From", from.String())
+	header.Set("To", to.String())
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header.Set("Date", now.Format(time.RFC1123Z))
+	header.Set("Message-ID", fmt.Sprintf("<%s@%s>", uuid.New().String(), domain(from.Address)))
+	header.Set("MIME-Version", "1.0")
+
+	for name, values := range header {
+		for _, value := range values {
+			if strings.ContainsAny(name+value, "\r\n") {
+				return nil, fmt.Errorf("%w: line break in header %s", ErrInvalidMessage, name)
+			}
+		}
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	if m.Text != "" {
+		if err := writePart(parts, "text/plain", m.Text); err != nil {
+			return nil, err
+		}
+	}
+	if m.HTML != "" {
+		if err := writePart(parts, "text/html", m.HTML); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	header.Set("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{
+		"boundary": parts.Boundary(),
+	}))
+
+	var msg bytes.Buffer
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, header.Get(name))
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// writePart adds a quoted-printable UTF-8 part
+func writePart(parts *multipart.Writer, contentType, content string) error {
+	w, err := parts.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return err
+	}
+
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func domain(address string) string {
+	if _, host, ok := strings.Cut(address, "@"); ok {
+		return host
+	}
+	return "localhost"
+}
@@ -0,0 +1,81 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessage_Build(t *testing.T) {
+	from := &mail.Address{Name: "Udagram", Address: "no-reply@udagram.app"}
+	msg := &Message{
+		To:      "jane@example.com",
+		Subject: "Bienvenue à Udagram",
+		Text:    "Bonjour Jane",
+		HTML:    "<p>Bonjour Jane</p>",
+		Headers: map[string]string{"List-Unsubscribe": "<https://udagram.app/settings>"},
+	}
+
+	data, err := msg.build(from, time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Bienvenue à Udagram", subject)
+	assert.Equal(t, `"Udagram" <no-reply@udagram.app>`, parsed.Header.Get("From"))
+	assert.Equal(t, "<jane@example.com>", parsed.Header.Get("To"))
+	assert.Equal(t, "<https://udagram.app/settings>", parsed.Header.Get("List-Unsubscribe"))
+	assert.Contains(t, parsed.Header.Get("Message-ID"), "@udagram.app>")
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+
+	// multipart.Reader decodes quoted-printable parts
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		bodies = append(bodies, part.Header.Get("Content-Type")+": "+string(body))
+	}
+	assert.Equal(t, []string{
+		"text/plain; charset=utf-8: Bonjour Jane",
+		"text/html; charset=utf-8: <p>Bonjour Jane</p>",
+	}, bodies)
+}
+
+func TestMessage_BuildInvalid(t *testing.T) {
+	from := &mail.Address{Address: "no-reply@udagram.app"}
+
+	tests := []struct {
+		name string
+		msg  Message
+	}{
+		{"bad recipient", Message{To: "jane", Text: "hi"}},
+		{"no body", Message{To: "jane@example.com"}},
+		{"header injection", Message{To: "jane@example.com", Text: "hi", Headers: map[string]string{"X-Note": "a\r\nBcc: eve@example.com"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.msg.build(from, time.Now())
+			assert.ErrorIs(t, err, ErrInvalidMessage)
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common/retry"
+)
+
+// Metrics
+var (
+	mailSent = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mail_sent_total",
+			Help: "Total number of emails by result",
+		},
+		[]string{"result"},
+	)
+
+	mailRetries = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mail_retries_total",
+			Help: "Total number of email send retries after transient failures",
+		},
+	)
+
+	mailLatency = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "mail_send_duration_seconds",
+			Help:    "Duration of a single SMTP delivery attempt in seconds",
+			Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+	)
+)
+
+// Config holds SMTP configuration
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the sender, such as "Udagram <no-reply@udagram.app>"
+	From string
+	// HeloName is the name given in EHLO
+	HeloName string
+	// RequireTLS refuses servers that do not offer STARTTLS. Without it,
+	// STARTTLS is still used whenever it is offered.
+	RequireTLS bool
+	// Timeout bounds a single delivery attempt
+	Timeout time.Duration
+	// Retry paces retries after transient failures
+	Retry retry.Config
+}
+
+// DefaultConfig returns default SMTP configuration
+func DefaultConfig() Config {
+	return Config{
+		Port:     587,
+		From:     "Udagram <no-reply@udagram.local>",
+		HeloName: "localhost",
+		Timeout:  10 * time.Second,
+		Retry: retry.Config{
+			MaxRetries:  3,
+			InitialWait: 500 * time.Millisecond,
+			MaxWait:     5 * time.Second,
+			Multiplier:  2.0,
+		},
+	}
+}
+
+// Stage is the step of the SMTP conversation a reply came from
+type Stage string
+
+// SMTP conversation stages
+const (
+	StageConnect  Stage = "connect"
+	StageHello    Stage = "hello"
+	StageStartTLS Stage = "starttls"
+	StageAuth     Stage = "auth"
+	StageMail     Stage = "mail"
+	StageRcpt     Stage = "rcpt"
+	StageData     Stage = "data"
+)
+
+// Error is an SMTP reply that rejected a message
+type Error struct {
+	Stage   Stage
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("mail: smtp %s %d %s", e.Stage, e.Code, e.Message)
+}
+
+// Permanent reports whether the rejection is final, so retrying is pointless
+func (e *Error) Permanent() bool {
+	return e.Code >= 500
+}
+
+// Bounce reports whether the server permanently rejected the recipient. Only
+// these are hard bounces; permanent failures at other stages, such as a
+// wrong password or a rejected sender, say nothing about the recipient.
+func (e *Error) Bounce() bool {
+	return e.Stage == StageRcpt && e.Permanent()
+}
+
+// IsPermanent reports whether err is a permanent SMTP rejection
+func IsPermanent(err error) bool {
+	var smtpErr *Error
+	return errors.As(err, &smtpErr) && smtpErr.Permanent()
+}
+
+// IsBounce reports whether err is a hard bounce of the recipient
+func IsBounce(err error) bool {
+	var smtpErr *Error
+	return errors.As(err, &smtpErr) && smtpErr.Bounce()
+}
+
+// Client sends email over SMTP
+type Client struct {
+	cfg    Config
+	from   *mail.Address
+	logger *zap.Logger
+}
+
+// NewClient creates a new SMTP client
+func NewClient(cfg Config, logger *zap.Logger) (*Client, error) {
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return nil, fmt.Errorf("mail: invalid sender %q: %w", cfg.From, err)
+	}
+	if cfg.HeloName == "" {
+		cfg.HeloName = "localhost"
+	}
+
+	return &Client{
+		cfg:    cfg,
+		from:   from,
+		logger: logger,
+	}, nil
+}
+
+// Send delivers a message, retrying transient failures with backoff.
+// Permanent rejections are returned as an *Error right away.
+func (c *Client) Send(ctx context.Context, msg *Message) error {
+	to, err := msg.recipient()
+	if err != nil {
+		mailSent.WithLabelValues("invalid").Inc()
+		return err
+	}
+	data, err := msg.build(c.from, time.Now())
+	if err != nil {
+		mailSent.WithLabelValues("invalid").Inc()
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			mailRetries.Inc()
+			select {
+			case <-ctx.Done():
+				mailSent.WithLabelValues("failed").Inc()
+				return ctx.Err()
+			case <-time.After(c.cfg.Retry.Backoff(attempt)):
+			}
+		}
+
+		start := time.Now()
+		err = c.send(ctx, to.Address, data)
+		mailLatency.Observe(time.Since(start).Seconds())
+
+		switch {
+		case err == nil:
+			mailSent.WithLabelValues("sent").Inc()
+			return nil
+		case IsPermanent(err):
+			mailSent.WithLabelValues("rejected").Inc()
+			return err
+		case attempt >= c.cfg.Retry.MaxRetries || ctx.Err() != nil:
+			mailSent.WithLabelValues("failed").Inc()
+			return err
+		}
+
+		c.logger.Warn("email delivery failed, retrying",
+			zap.Int("attempt", attempt+1),
+			zap.Error(err),
+		)
+	}
+}
+
+// send makes one delivery attempt
+func (c *Client) send(ctx context.Context, to string, data []byte) error {
+	if c.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(c.cfg.Host, strconv.Itoa(c.cfg.Port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	// Unblock the conversation if the context is canceled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	client, err := smtp.NewClient(conn, c.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return smtpError(StageConnect, err)
+	}
+	defer client.Close()
+
+	if err := client.Hello(c.cfg.HeloName); err != nil {
+		return smtpError(StageHello, err)
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: c.cfg.Host, MinVersion: tls.VersionTLS12}); err != nil {
+			return smtpError(StageStartTLS, err)
+		}
+	} else if c.cfg.RequireTLS {
+		return errors.New("mail: server does not support STARTTLS")
+	}
+
+	if c.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", c.cfg.Username, c.cfg.Password, c.cfg.Host)); err != nil {
+			return smtpError(StageAuth, err)
+		}
+	}
+
+	if err := client.Mail(c.from.Address); err != nil {
+		return smtpError(StageMail, err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return smtpError(StageRcpt, err)
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return smtpError(StageData, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return smtpError(StageData, err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(StageData, err)
+	}
+
+	// The message is accepted once DATA completes
+	_ = client.Quit()
+	return nil
+}
+
+// smtpError converts SMTP replies at stage to *Error
+func smtpError(stage Stage, err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return &Error{Stage: stage, Code: protoErr.Code, Message: protoErr.Msg}
+	}
+	return err
+}
@@ -0,0 +1,293 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common/retry"
+)
+
+// fakeSMTP is an in-process SMTP server that records the messages it accepts
+type fakeSMTP struct {
+	listener net.Listener
+	// rcpt replies to RCPT TO, by attempt; later attempts get the last reply
+	rcpt []string
+	// reject replaces the reply to other commands, keyed by command; the
+	// server offers AUTH when it has a reply for it
+	reject map[string]string
+
+	mu       sync.Mutex
+	attempts int
+	messages []fakeMessage
+}
+
+type fakeMessage struct {
+	From string
+	To   string
+	Data string
+}
+
+func newFakeSMTP(t *testing.T, rcpt ...string) *fakeSMTP {
+	if len(rcpt) == 0 {
+		rcpt = []string{"250 OK"}
+	}
+	return startFakeSMTP(t, &fakeSMTP{rcpt: rcpt})
+}
+
+// newRejectingSMTP returns a server that answers command with reply
+func newRejectingSMTP(t *testing.T, command, reply string) *fakeSMTP {
+	return startFakeSMTP(t, &fakeSMTP{
+		rcpt:   []string{"250 OK"},
+		reject: map[string]string{command: reply},
+	})
+}
+
+func startFakeSMTP(t *testing.T, s *fakeSMTP) *fakeSMTP {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s.listener = listener
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(conn)
+	}
+}
+
+func (s *fakeSMTP) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	s.mu.Lock()
+	attempt := s.attempts
+	s.attempts++
+	s.mu.Unlock()
+
+	var msg fakeMessage
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		switch upper := strings.ToUpper(cmd); {
+		case strings.HasPrefix(upper, "EHLO"), strings.HasPrefix(upper, "HELO"):
+			if _, ok := s.reject["AUTH"]; ok {
+				reply("250-fake")
+				reply("250 AUTH PLAIN")
+			} else {
+				reply("250 fake")
+			}
+		case strings.HasPrefix(upper, "AUTH"):
+			reply(s.reject["AUTH"])
+		case strings.HasPrefix(upper, "MAIL FROM:"):
+			if rejection, ok := s.reject["MAIL"]; ok {
+				reply(rejection)
+				continue
+			}
+			msg.From = strings.Trim(cmd[len("MAIL FROM:"):], "<> ")
+			reply("250 OK")
+		case strings.HasPrefix(upper, "RCPT TO:"):
+			msg.To = strings.Trim(cmd[len("RCPT TO:"):], "<> ")
+			reply(s.rcpt[min(attempt, len(s.rcpt)-1)])
+		case upper == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			if rejection, ok := s.reject["DATA"]; ok {
+				reply(rejection)
+				continue
+			}
+			msg.Data = data.String()
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			reply("250 queued")
+		case upper == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTP) received() []fakeMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]fakeMessage(nil), s.messages...)
+}
+
+func (s *fakeSMTP) attemptCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts
+}
+
+func newTestClient(t *testing.T, server *fakeSMTP) *Client {
+	addr := server.listener.Addr().(*net.TCPAddr)
+	cfg := DefaultConfig()
+	cfg.Host = addr.IP.String()
+	cfg.Port = addr.Port
+	cfg.Timeout = time.Second
+	cfg.Retry = retry.Config{
+		MaxRetries:  2,
+		InitialWait: time.Millisecond,
+		MaxWait:     time.Millisecond,
+		Multiplier:  2,
+	}
+
+	client, err := NewClient(cfg, zap.NewNop())
+	require.NoError(t, err)
+	return client
+}
+
+func testMessage() *Message {
+	return &Message{
+		To:      "Jane <jane@example.com>",
+		Subject: "Welcome to Udagram",
+		Text:    "Hello Jane",
+		HTML:    "<p>Hello Jane</p>",
+	}
+}
+
+func TestClient_Send(t *testing.T) {
+	server := newFakeSMTP(t)
+	client := newTestClient(t, server)
+
+	require.NoError(t, client.Send(context.Background(), testMessage()))
+
+	messages := server.received()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "no-reply@udagram.local", messages[0].From)
+	assert.Equal(t, "jane@example.com", messages[0].To)
+	assert.Contains(t, messages[0].Data, "Subject: Welcome to Udagram\r\n")
+	assert.Contains(t, messages[0].Data, "Hello Jane")
+	assert.Contains(t, messages[0].Data, "<p>Hello Jane</p>")
+}
+
+func TestClient_SendRetriesTransientFailures(t *testing.T) {
+	server := newFakeSMTP(t, "451 try again later", "421 busy", "250 OK")
+	client := newTestClient(t, server)
+
+	require.NoError(t, client.Send(context.Background(), testMessage()))
+	assert.Equal(t, 3, server.attemptCount())
+	assert.Len(t, server.received(), 1)
+}
+
+func TestClient_SendGivesUp(t *testing.T) {
+	server := newFakeSMTP(t, "451 try again later")
+	client := newTestClient(t, server)
+
+	err := client.Send(context.Background(), testMessage())
+	require.Error(t, err)
+	assert.False(t, IsPermanent(err))
+	assert.Equal(t, 3, server.attemptCount())
+}
+
+func TestClient_SendBounce(t *testing.T) {
+	server := newFakeSMTP(t, "550 no such user")
+	client := newTestClient(t, server)
+
+	err := client.Send(context.Background(), testMessage())
+	require.Error(t, err)
+	assert.True(t, IsPermanent(err))
+
+	assert.True(t, IsBounce(err))
+
+	var smtpErr *Error
+	require.ErrorAs(t, err, &smtpErr)
+	assert.Equal(t, StageRcpt, smtpErr.Stage)
+	assert.Equal(t, 550, smtpErr.Code)
+	assert.Equal(t, 1, server.attemptCount())
+	assert.Empty(t, server.received())
+}
+
+func TestClient_SendPermanentFailuresAreNotBounces(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		reply   string
+		stage   Stage
+	}{
+		{"wrong password", "AUTH", "535 authentication failed", StageAuth},
+		{"sender rejected", "MAIL", "550 sender not allowed", StageMail},
+		{"message rejected", "DATA", "554 message refused", StageData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRejectingSMTP(t, tt.command, tt.reply)
+			client := newTestClient(t, server)
+			if tt.command == "AUTH" {
+				client.cfg.Username = "udagram"
+				client.cfg.Password = "secret"
+			}
+
+			err := client.Send(context.Background(), testMessage())
+			require.Error(t, err)
+			assert.True(t, IsPermanent(err))
+			assert.False(t, IsBounce(err))
+
+			var smtpErr *Error
+			require.ErrorAs(t, err, &smtpErr)
+			assert.Equal(t, tt.stage, smtpErr.Stage)
+			assert.Equal(t, 1, server.attemptCount())
+		})
+	}
+}
+
+func TestClient_SendRequireTLS(t *testing.T) {
+	server := newFakeSMTP(t)
+	client := newTestClient(t, server)
+	client.cfg.RequireTLS = true
+
+	err := client.Send(context.Background(), testMessage())
+	assert.ErrorContains(t, err, "STARTTLS")
+	assert.Empty(t, server.received())
+}
+
+func TestClient_SendInvalidMessage(t *testing.T) {
+	server := newFakeSMTP(t)
+	client := newTestClient(t, server)
+
+	msg := testMessage()
+	msg.To = "not an address"
+	assert.ErrorIs(t, client.Send(context.Background(), msg), ErrInvalidMessage)
+	assert.Zero(t, server.attemptCount())
+}
+
+func TestNewClient_InvalidSender(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.From = "nobody"
+	_, err := NewClient(cfg, zap.NewNop())
+	assert.Error(t, err)
+}
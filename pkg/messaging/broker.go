@@ -0,0 +1,107 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Broker carries messages between producers and consumers. KafkaBroker is
+// used by default; MemoryBroker keeps messages in memory, for tests and
+// running without Kafka.
+type Broker interface {
+	// writer returns a writer of messages to the topics they name. Topics
+	// are created as needed if createTopics is set.
+	writer(createTopics bool) messageWriter
+	// reader returns a reader for the topic and consumer group in cfg,
+	// starting from cfg.StartOffset if the group has not committed one
+	reader(cfg kafka.ReaderConfig) messageReader
+	// read returns up to limit messages in a partition of a topic from
+	// offset, whichever group has consumed them
+	read(ctx context.Context, topic string, partition int, offset int64, limit int) ([]kafka.Message, error)
+}
+
+// broker returns the broker the configuration selects
+func (cfg Config) broker() Broker {
+	if cfg.Broker != nil {
+		return cfg.Broker
+	}
+	return NewKafkaBroker(cfg.Brokers)
+}
+
+// KafkaBroker carries messages through a Kafka cluster
+type KafkaBroker struct {
+	brokers []string
+}
+
+// NewKafkaBroker creates a broker for the Kafka cluster at brokers
+func NewKafkaBroker(brokers []string) *KafkaBroker {
+	return &KafkaBroker{brokers: brokers}
+}
+
+func (b *KafkaBroker) writer(createTopics bool) messageWriter {
+	// Events with the same key go to the same partition, so they are
+	// consumed in order
+	return &kafka.Writer{
+		Addr:                   kafka.TCP(b.brokers...),
+		Balancer:               &kafka.Hash{},
+		BatchTimeout:           10 * time.Millisecond,
+		AllowAutoTopicCreation: createTopics,
+	}
+}
+
+func (b *KafkaBroker) reader(cfg kafka.ReaderConfig) messageReader {
+	cfg.Brokers = b.brokers
+	return kafka.NewReader(cfg)
+}
+
+func (b *KafkaBroker) read(ctx context.Context, topic string, partition int, offset int64, limit int) ([]kafka.Message, error) {
+	if len(b.brokers) == 0 {
+		return nil, errors.New("messaging: no brokers configured")
+	}
+
+	conn, err := kafka.DialLeader(ctx, "tcp", b.brokers[0], topic, partition)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	first, last, err := conn.ReadOffsets()
+	if err != nil {
+		return nil, err
+	}
+	offset = max(offset, first)
+	if offset >= last || limit <= 0 {
+		return nil, nil
+	}
+	if _, err := conn.Seek(offset, kafka.SeekAbsolute); err != nil {
+		return nil, err
+	}
+
+	batch := conn.ReadBatch(1, 10e6) // 10MB
+	defer batch.Close()
+
+	var msgs []kafka.Message
+	for len(msgs) < limit {
+		msg, err := batch.ReadMessage()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+		if msg.Offset+1 >= last {
+			break
+		}
+	}
+	return msgs, nil
+}
@@ -0,0 +1,111 @@
+package messaging
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// DeadLetter is a message in a dead-letter topic
+type DeadLetter struct {
+	Partition     int               `json:"partition"`
+	Offset        int64             `json:"offset"`
+	Key           string            `json:"key,omitempty"`
+	Value         string            `json:"value"`
+	Headers       map[string]string `json:"headers"`
+	OriginalTopic string            `json:"original_topic"`
+	Attempts      int               `json:"attempts"`
+	Error         string            `json:"error"`
+	Time          time.Time         `json:"time"`
+}
+
+// DeadLetterQueue inspects and replays a consumer's dead-letter topic
+type DeadLetterQueue struct {
+	broker Broker
+	topic  string
+	// replayTopic is where replayed messages go: the first retry topic, so
+	// only the consumer's own group handles them again, or the topic itself
+	// when there are no retry topics
+	replayTopic string
+	writer      messageWriter
+}
+
+// DeadLetters returns the consumer's dead-letter queue
+func (c *Consumer) DeadLetters() *DeadLetterQueue {
+	replayTopic := c.topic
+	if len(c.retry.Delays) > 0 {
+		replayTopic = RetryTopic(c.topic, c.groupID, 1)
+	}
+
+	return &DeadLetterQueue{
+		broker:      c.broker,
+		topic:       DeadLetterTopic(c.topic, c.groupID),
+		replayTopic: replayTopic,
+		writer:      c.writer,
+	}
+}
+
+// Topic returns the dead-letter topic
+func (q *DeadLetterQueue) Topic() string {
+	return q.topic
+}
+
+// List returns up to limit dead letters in a partition, from offset on. It
+// may return fewer than are left; list again after the last one returned.
+func (q *DeadLetterQueue) List(ctx context.Context, partition int, offset int64, limit int) ([]DeadLetter, error) {
+	msgs, err := q.read(ctx, partition, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		letters = append(letters, newDeadLetter(msg))
+	}
+	return letters, nil
+}
+
+// Replay sends the dead letter at offset back to its consumer to be handled
+// again, with its attempts reset. Dead letters stay in the topic, so one
+// replayed twice is handled twice.
+func (q *DeadLetterQueue) Replay(ctx context.Context, partition int, offset int64) error {
+	msgs, err := q.read(ctx, partition, offset, 1)
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 || msgs[0].Offset != offset {
+		return ErrDeadLetterNotFound
+	}
+
+	return q.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   q.replayTopic,
+		Key:     msgs[0].Key,
+		Value:   msgs[0].Value,
+		Headers: originalHeaders(msgs[0].Headers),
+	})
+}
+
+// read returns up to limit messages in a partition of the topic from offset
+func (q *DeadLetterQueue) read(ctx context.Context, partition int, offset int64, limit int) ([]kafka.Message, error) {
+	return q.broker.read(ctx, q.topic, partition, offset, limit)
+}
+
+func newDeadLetter(msg kafka.Message) DeadLetter {
+	letter := DeadLetter{
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Key:       string(msg.Key),
+		Value:     string(msg.Value),
+		Headers:   make(map[string]string, len(msg.Headers)),
+		Time:      msg.Time,
+	}
+	for _, h := range msg.Headers {
+		letter.Headers[h.Key] = string(h.Value)
+	}
+	letter.OriginalTopic = letter.Headers[HeaderOriginalTopic]
+	letter.Attempts, _ = strconv.Atoi(letter.Headers[HeaderAttempts])
+	letter.Error = letter.Headers[HeaderError]
+	return letter
+}
@@ -0,0 +1,101 @@
+package messaging
+
+// Event payloads. Each event type is named after the topic it is published
+// to. A payload changed incompatibly, such as by renaming, removing or
+// retyping a field, gets a new version and an upcaster from the old one;
+// testdata/events holds a sample of every version, which must keep decoding.
+
+// UserCreated is published when a user registers
+type UserCreated struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// EventType returns the event type
+func (UserCreated) EventType() string { return TopicUserCreated }
+
+// SchemaVersion returns the payload version
+func (UserCreated) SchemaVersion() int { return 1 }
+
+// UserVerificationRequested is published when a user has to confirm their
+// email address. URL carries a single-use token, so the event is only for
+// sending it to the user.
+type UserVerificationRequested struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	URL    string `json:"url"`
+}
+
+// EventType returns the event type
+func (UserVerificationRequested) EventType() string { return TopicUserVerificationRequested }
+
+// SchemaVersion returns the payload version
+func (UserVerificationRequested) SchemaVersion() int { return 1 }
+
+// FeedCreated is published when a feed item is posted
+type FeedCreated struct {
+	FeedID  string `json:"feed_id"`
+	UserID  string `json:"user_id"`
+	Caption string `json:"caption"`
+}
+
+// EventType returns the event type
+func (FeedCreated) EventType() string { return TopicFeedCreated }
+
+// SchemaVersion returns the payload version
+func (FeedCreated) SchemaVersion() int { return 1 }
+
+// FeedUpdated is published when a feed item is edited
+type FeedUpdated struct {
+	FeedID  string `json:"feed_id"`
+	UserID  string `json:"user_id"`
+	Caption string `json:"caption"`
+}
+
+// EventType returns the event type
+func (FeedUpdated) EventType() string { return TopicFeedUpdated }
+
+// SchemaVersion returns the payload version
+func (FeedUpdated) SchemaVersion() int { return 1 }
+
+// FeedDeleted is published when a feed item is deleted
+type FeedDeleted struct {
+	FeedID string `json:"feed_id"`
+	UserID string `json:"user_id"`
+}
+
+// EventType returns the event type
+func (FeedDeleted) EventType() string { return TopicFeedDeleted }
+
+// SchemaVersion returns the payload version
+func (FeedDeleted) SchemaVersion() int { return 1 }
+
+// NotificationRequested asks the notification service to notify a user.
+// Notifications with a ReferenceID aggregate with others of the same type and
+// reference; ActorName and Action, such as "liked your post", describe them.
+type NotificationRequested struct {
+	UserID        string `json:"user_id"`
+	Type          string `json:"type"`
+	Title         string `json:"title"`
+	Message       string `json:"message"`
+	ReferenceID   string `json:"reference_id,omitempty"`
+	ReferenceType string `json:"reference_type,omitempty"`
+	ActorID       string `json:"actor_id,omitempty"`
+	ActorName     string `json:"actor_name,omitempty"`
+	Action        string `json:"action,omitempty"`
+}
+
+// EventType returns the event type
+func (NotificationRequested) EventType() string { return TopicNotification }
+
+// SchemaVersion returns the payload version
+func (NotificationRequested) SchemaVersion() int { return 1 }
+
+func init() {
+	Register[UserCreated](Schemas)
+	Register[UserVerificationRequested](Schemas)
+	Register[FeedCreated](Schemas)
+	Register[FeedUpdated](Schemas)
+	Register[FeedDeleted](Schemas)
+	Register[NotificationRequested](Schemas)
+}
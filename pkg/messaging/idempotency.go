@@ -0,0 +1,235 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Metrics
+var (
+	kafkaDuplicates = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_duplicate_events_total",
+			Help: "Total number of redelivered events skipped by consumer group",
+		},
+		[]string{"group"},
+	)
+)
+
+// Values of an event's key in a store
+const (
+	eventProcessing = "processing"
+	eventProcessed  = "processed"
+)
+
+// IdempotencyStore records the events each consumer group processed
+type IdempotencyStore interface {
+	// Claim claims an event for handling. It returns false if the group
+	// already processed the event, and ErrEventInProgress if another
+	// consumer in the group holds a claim on it.
+	Claim(ctx context.Context, group, eventID string) (bool, error)
+	// Complete records a claimed event as processed
+	Complete(ctx context.Context, group, eventID string) error
+	// Release gives up the claim on an event that failed, so it can be
+	// handled again
+	Release(ctx context.Context, group, eventID string) error
+}
+
+// IdempotencyConfig holds how long processed events are remembered
+type IdempotencyConfig struct {
+	// TTL is how long a processed event is remembered; it must outlast
+	// redeliveries, including from retry topics
+	TTL time.Duration
+	// Lease is how long a claim lasts, so one left by a crashed consumer
+	// expires
+	Lease time.Duration
+}
+
+// DefaultIdempotencyConfig returns default idempotency configuration
+func DefaultIdempotencyConfig() IdempotencyConfig {
+	return IdempotencyConfig{
+		TTL:   7 * 24 * time.Hour,
+		Lease: 5 * time.Minute,
+	}
+}
+
+// Idempotent wraps handler so each event is processed once per consumer
+// group. Events the group processed before are skipped. If the store is
+// unavailable events are handled anyway, as redelivery is rarer than an
+// outage.
+func Idempotent(store IdempotencyStore, group string, logger *zap.Logger, handler MessageHandler) MessageHandler {
+	return func(ctx context.Context, event Event) error {
+		if event.ID == "" {
+			return handler(ctx, event)
+		}
+
+		claimed, err := store.Claim(ctx, group, event.ID)
+		switch {
+		case errors.Is(err, ErrEventInProgress):
+			// Retried once the other consumer is done
+			return err
+		case err != nil:
+			logger.Warn("failed to claim event, handling it anyway",
+				zap.String("group", group),
+				zap.String("event_id", event.ID),
+				zap.Error(err),
+			)
+			return handler(ctx, event)
+		case !claimed:
+			logger.Debug("skipping processed event",
+				zap.String("group", group),
+				zap.String("event_id", event.ID),
+			)
+			kafkaDuplicates.WithLabelValues(group).Inc()
+			return nil
+		}
+
+		if err := handler(ctx, event); err != nil {
+			// The claim is released even if ctx is canceled
+			if releaseErr := store.Release(context.WithoutCancel(ctx), group, event.ID); releaseErr != nil {
+				logger.Warn("failed to release event", zap.String("event_id", event.ID), zap.Error(releaseErr))
+			}
+			return err
+		}
+
+		if err := store.Complete(context.WithoutCancel(ctx), group, event.ID); err != nil {
+			logger.Warn("failed to record processed event", zap.String("event_id", event.ID), zap.Error(err))
+		}
+		return nil
+	}
+}
+
+// RedisIdempotencyStore records processed events in Redis, each expiring
+// after the TTL
+type RedisIdempotencyStore struct {
+	rdb    *redis.Client
+	config IdempotencyConfig
+}
+
+// NewRedisIdempotencyStore creates a new Redis idempotency store
+func NewRedisIdempotencyStore(rdb *redis.Client, cfg IdempotencyConfig) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{rdb: rdb, config: cfg}
+}
+
+// releaseScript deletes a claim unless the event was processed meanwhile
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func processedKey(group, eventID string) string {
+	return "processed:" + group + ":" + eventID
+}
+
+// Claim claims an event for handling
+func (s *RedisIdempotencyStore) Claim(ctx context.Context, group, eventID string) (bool, error) {
+	key := processedKey(group, eventID)
+	claimed, err := s.rdb.SetNX(ctx, key, eventProcessing, s.config.Lease).Result()
+	if err != nil || claimed {
+		return claimed, err
+	}
+
+	state, err := s.rdb.Get(ctx, key).Result()
+	switch {
+	case err == redis.Nil:
+		// The claim expired in between
+		return false, ErrEventInProgress
+	case err != nil:
+		return false, err
+	case state == eventProcessed:
+		return false, nil
+	default:
+		return false, ErrEventInProgress
+	}
+}
+
+// Complete records a claimed event as processed
+func (s *RedisIdempotencyStore) Complete(ctx context.Context, group, eventID string) error {
+	return s.rdb.Set(ctx, processedKey(group, eventID), eventProcessed, s.config.TTL).Err()
+}
+
+// Release gives up the claim on an event
+func (s *RedisIdempotencyStore) Release(ctx context.Context, group, eventID string) error {
+	return releaseScript.Run(ctx, s.rdb, []string{processedKey(group, eventID)}, eventProcessing).Err()
+}
+
+// MemoryIdempotencyStore records processed events in memory. It suits tests
+// and single-replica consumers; with more replicas each only knows its own.
+type MemoryIdempotencyStore struct {
+	mu     sync.Mutex
+	config IdempotencyConfig
+	events map[string]memoryEvent
+	// claims counts claims until expired events are next swept
+	claims int
+}
+
+// memorySweepInterval is how many claims pass between sweeps of expired
+// events
+const memorySweepInterval = 1024
+
+type memoryEvent struct {
+	state   string
+	expires time.Time
+}
+
+// NewMemoryIdempotencyStore creates a new in-memory idempotency store
+func NewMemoryIdempotencyStore(cfg IdempotencyConfig) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		config: cfg,
+		events: make(map[string]memoryEvent),
+	}
+}
+
+// Claim claims an event for handling
+func (s *MemoryIdempotencyStore) Claim(ctx context.Context, group, eventID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := processedKey(group, eventID)
+	now := time.Now()
+	if e, ok := s.events[key]; ok && now.Before(e.expires) {
+		if e.state == eventProcessed {
+			return false, nil
+		}
+		return false, ErrEventInProgress
+	}
+
+	if s.claims++; s.claims >= memorySweepInterval {
+		s.claims = 0
+		for k, e := range s.events {
+			if !now.Before(e.expires) {
+				delete(s.events, k)
+			}
+		}
+	}
+	s.events[key] = memoryEvent{state: eventProcessing, expires: now.Add(s.config.Lease)}
+	return true, nil
+}
+
+// Complete records a claimed event as processed
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, group, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[processedKey(group, eventID)] = memoryEvent{state: eventProcessed, expires: time.Now().Add(s.config.TTL)}
+	return nil
+}
+
+// Release gives up the claim on an event
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, group, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := processedKey(group, eventID)
+	if s.events[key].state == eventProcessing {
+		delete(s.events, key)
+	}
+	return nil
+}
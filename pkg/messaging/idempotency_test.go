@@ -0,0 +1,106 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// failingStore is an idempotency store that is down
+type failingStore struct{}
+
+func (failingStore) Claim(ctx context.Context, group, eventID string) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func (failingStore) Complete(ctx context.Context, group, eventID string) error {
+	return errors.New("connection refused")
+}
+
+func (failingStore) Release(ctx context.Context, group, eventID string) error {
+	return errors.New("connection refused")
+}
+
+func TestIdempotent_SkipsProcessedEvents(t *testing.T) {
+	store := NewMemoryIdempotencyStore(DefaultIdempotencyConfig())
+	calls := 0
+	handler := Idempotent(store, "notifications", zap.NewNop(), func(ctx context.Context, event Event) error {
+		calls++
+		return nil
+	})
+
+	event := Event{ID: "event-1"}
+	require.NoError(t, handler(context.Background(), event))
+	require.NoError(t, handler(context.Background(), event))
+	assert.Equal(t, 1, calls)
+
+	// Other groups process it too
+	other := Idempotent(store, "analytics", zap.NewNop(), func(ctx context.Context, event Event) error {
+		calls++
+		return nil
+	})
+	require.NoError(t, other(context.Background(), event))
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotent_RetriesFailedEvents(t *testing.T) {
+	store := NewMemoryIdempotencyStore(DefaultIdempotencyConfig())
+	calls := 0
+	handler := Idempotent(store, "notifications", zap.NewNop(), func(ctx context.Context, event Event) error {
+		calls++
+		if calls == 1 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+
+	event := Event{ID: "event-1"}
+	assert.Error(t, handler(context.Background(), event))
+	require.NoError(t, handler(context.Background(), event))
+	require.NoError(t, handler(context.Background(), event))
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotent_EventInProgress(t *testing.T) {
+	store := NewMemoryIdempotencyStore(DefaultIdempotencyConfig())
+	claimed, err := store.Claim(context.Background(), "notifications", "event-1")
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	handler := Idempotent(store, "notifications", zap.NewNop(), func(ctx context.Context, event Event) error {
+		t.Fatal("handled an event another consumer holds")
+		return nil
+	})
+	err = handler(context.Background(), Event{ID: "event-1"})
+	assert.ErrorIs(t, err, ErrEventInProgress)
+	assert.False(t, IsPermanent(err))
+}
+
+func TestIdempotent_ExpiredClaim(t *testing.T) {
+	store := NewMemoryIdempotencyStore(IdempotencyConfig{TTL: time.Hour, Lease: time.Millisecond})
+	_, err := store.Claim(context.Background(), "notifications", "event-1")
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	// The consumer holding the claim crashed
+	claimed, err := store.Claim(context.Background(), "notifications", "event-1")
+	require.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestIdempotent_StoreDown(t *testing.T) {
+	calls := 0
+	handler := Idempotent(failingStore{}, "notifications", zap.NewNop(), func(ctx context.Context, event Event) error {
+		calls++
+		return nil
+	})
+
+	require.NoError(t, handler(context.Background(), Event{ID: "event-1"}))
+	require.NoError(t, handler(context.Background(), Event{ID: "event-1"}))
+	assert.Equal(t, 2, calls)
+}
@@ -3,6 +3,11 @@ package messaging
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,6 +15,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/telemetry"
 )
 
 // Metrics
@@ -59,34 +66,57 @@ var (
 
 // Topics
 const (
-	TopicUserCreated    = "user.created"
-	TopicUserUpdated    = "user.updated"
-	TopicFeedCreated    = "feed.created"
-	TopicFeedDeleted    = "feed.deleted"
-	TopicNotification   = "notification"
-	TopicAnalyticsEvent = "analytics.event"
+	TopicUserCreated               = "user.created"
+	TopicUserUpdated               = "user.updated"
+	TopicUserVerificationRequested = "user.verification_requested"
+	TopicFeedCreated               = "feed.created"
+	TopicFeedUpdated               = "feed.updated"
+	TopicFeedDeleted               = "feed.deleted"
+	TopicNotification              = "notification"
+	TopicAnalyticsEvent            = "analytics.event"
 )
 
+// topics are the topics events are produced to
+var topics = []string{
+	TopicUserCreated,
+	TopicUserUpdated,
+	TopicUserVerificationRequested,
+	TopicFeedCreated,
+	TopicFeedUpdated,
+	TopicFeedDeleted,
+	TopicNotification,
+	TopicAnalyticsEvent,
+}
+
 // Event represents a domain event
 type Event struct {
-	ID        string                 `json:"id"`
-	Type      string                 `json:"type"`
-	Source    string                 `json:"source"`
-	Timestamp time.Time              `json:"timestamp"`
-	Data      map[string]interface{} `json:"data"`
-	Metadata  map[string]string      `json:"metadata,omitempty"`
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Source string `json:"source"`
+	// Version is the version of the data's schema; events without one are
+	// version 1
+	Version   int               `json:"version,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+	Data      json.RawMessage   `json:"data"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
 }
 
-// NewEvent creates a new event
-func NewEvent(eventType, source string, data map[string]interface{}) Event {
+// NewEvent creates a new event carrying payload
+func NewEvent[T Payload](source string, payload T) (Event, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return Event{}, fmt.Errorf("marshal %s: %w", payload.EventType(), err)
+	}
+
 	return Event{
 		ID:        uuid.New().String(),
-		Type:      eventType,
+		Type:      payload.EventType(),
 		Source:    source,
+		Version:   payload.SchemaVersion(),
 		Timestamp: time.Now().UTC(),
 		Data:      data,
 		Metadata:  make(map[string]string),
-	}
+	}, nil
 }
 
 // Config holds Kafka configuration
@@ -94,74 +124,63 @@ type Config struct {
 	Brokers       []string
 	ConsumerGroup string
 	Topics        map[string]string
+	// Broker carries the messages; nil means Kafka at Brokers
+	Broker Broker
+	// Retry is how consumers retry failed messages. The zero value means
+	// DefaultRetryConfig.
+	Retry RetryConfig
+	// Concurrency is how many messages a consumer handles at once per
+	// topic; 0 means one at a time. Messages with the same key are always
+	// handled in order.
+	Concurrency int
+	// MaxInFlight bounds the messages a consumer has fetched but not yet
+	// handled per topic, holding off fetching when reached; 0 means
+	// DefaultMaxInFlight per worker
+	MaxInFlight int
 }
 
-// Producer wraps Kafka writer for producing messages
+// DefaultMaxInFlight is the default in-flight bound per worker
+const DefaultMaxInFlight = 4
+
+// Producer wraps a broker's writer for producing messages
 type Producer struct {
-	writers map[string]*kafka.Writer
-	logger  *zap.Logger
+	writer messageWriter
+	logger *zap.Logger
 }
 
-// NewProducer creates a new Kafka producer
+// NewProducer creates a new producer
 func NewProducer(cfg Config, logger *zap.Logger) *Producer {
-	writers := make(map[string]*kafka.Writer)
-
-	// Create writers for each topic
-	topics := []string{
-		TopicUserCreated,
-		TopicUserUpdated,
-		TopicFeedCreated,
-		TopicFeedDeleted,
-		TopicNotification,
-		TopicAnalyticsEvent,
-	}
-
-	for _, topic := range topics {
-		writers[topic] = &kafka.Writer{
-			Addr:         kafka.TCP(cfg.Brokers...),
-			Topic:        topic,
-			Balancer:     &kafka.LeastBytes{},
-			BatchTimeout: 10 * time.Millisecond,
-			Async:        false,
-		}
-	}
-
 	return &Producer{
-		writers: writers,
-		logger:  logger,
+		writer: cfg.broker().writer(false),
+		logger: logger,
 	}
 }
 
-// Publish publishes a message to a topic
-func (p *Producer) Publish(ctx context.Context, topic string, key string, event Event) error {
+// Publish publishes a message to a topic. The request ID and trace context
+// in ctx go with it.
+func (p *Producer) Publish(ctx context.Context, topic string, key string, event Event) (err error) {
 	start := time.Now()
+	ctx, span := telemetry.KafkaProducerSpan(ctx, topic)
 	defer func() {
+		endSpan(span, err)
 		kafkaProduceLatency.WithLabelValues(topic).Observe(time.Since(start).Seconds())
 	}()
 
-	writer, exists := p.writers[topic]
-	if !exists {
+	if !slices.Contains(topics, topic) {
 		kafkaErrors.WithLabelValues(topic, "produce").Inc()
 		return ErrTopicNotFound
 	}
 
-	data, err := json.Marshal(event)
+	stampRequestID(ctx, &event)
+	msg, err := newMessage(key, event)
 	if err != nil {
 		kafkaErrors.WithLabelValues(topic, "produce").Inc()
 		return err
 	}
+	msg.Topic = topic
+	injectTrace(ctx, &msg)
 
-	msg := kafka.Message{
-		Key:   []byte(key),
-		Value: data,
-		Headers: []kafka.Header{
-			{Key: "event_id", Value: []byte(event.ID)},
-			{Key: "event_type", Value: []byte(event.Type)},
-			{Key: "source", Value: []byte(event.Source)},
-		},
-	}
-
-	if err := writer.WriteMessages(ctx, msg); err != nil {
+	if err := p.writer.WriteMessages(ctx, msg); err != nil {
 		kafkaErrors.WithLabelValues(topic, "produce").Inc()
 		p.logger.Error("failed to publish message",
 			zap.String("topic", topic),
@@ -180,6 +199,48 @@ func (p *Producer) Publish(ctx context.Context, topic string, key string, event
 	return nil
 }
 
+// publishMessages publishes messages to a topic in order
+func (p *Producer) publishMessages(ctx context.Context, topic string, msgs []kafka.Message) error {
+	start := time.Now()
+	defer func() {
+		kafkaProduceLatency.WithLabelValues(topic).Observe(time.Since(start).Seconds())
+	}()
+
+	if !slices.Contains(topics, topic) {
+		kafkaErrors.WithLabelValues(topic, "produce").Inc()
+		return ErrTopicNotFound
+	}
+	for i := range msgs {
+		msgs[i].Topic = topic
+	}
+
+	if err := p.writer.WriteMessages(ctx, msgs...); err != nil {
+		kafkaErrors.WithLabelValues(topic, "produce").Inc()
+		return err
+	}
+
+	kafkaMessagesProduced.WithLabelValues(topic).Add(float64(len(msgs)))
+	return nil
+}
+
+// newMessage returns the Kafka message for an event
+func newMessage(key string, event Event) (kafka.Message, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return kafka.Message{}, err
+	}
+
+	return kafka.Message{
+		Key:   []byte(key),
+		Value: data,
+		Headers: []kafka.Header{
+			{Key: "event_id", Value: []byte(event.ID)},
+			{Key: "event_type", Value: []byte(event.Type)},
+			{Key: "source", Value: []byte(event.Source)},
+		},
+	}, nil
+}
+
 // PublishAsync publishes a message asynchronously
 func (p *Producer) PublishAsync(ctx context.Context, topic string, key string, event Event) {
 	go func() {
@@ -192,31 +253,63 @@ func (p *Producer) PublishAsync(ctx context.Context, topic string, key string, e
 	}()
 }
 
-// Close closes all writers
+// Close closes the writer
 func (p *Producer) Close() error {
-	var lastErr error
-	for _, writer := range p.writers {
-		if err := writer.Close(); err != nil {
-			lastErr = err
-		}
+	if closer, ok := p.writer.(io.Closer); ok {
+		return closer.Close()
 	}
-	return lastErr
+	return nil
 }
 
-// Consumer wraps Kafka reader for consuming messages
+// Consumer wraps a broker's readers for consuming a topic and its retry
+// topics
 type Consumer struct {
-	reader  *kafka.Reader
+	topic   string
+	groupID string
+	broker  Broker
+	retry   RetryConfig
+	// readers consume the topic, then each retry topic in turn
+	readers []messageReader
+	// concurrency is how many workers handle each reader's messages
+	concurrency int
+	maxInFlight int
+	// writer moves failed messages to retry and dead-letter topics
+	writer  messageWriter
 	logger  *zap.Logger
 	handler MessageHandler
 }
 
-// MessageHandler handles incoming messages
+// MessageHandler handles incoming messages. Errors marked Permanent are not
+// retried.
 type MessageHandler func(ctx context.Context, event Event) error
 
-// NewConsumer creates a new Kafka consumer
+// NewConsumer creates a new consumer
 func NewConsumer(cfg Config, topic, groupID string, logger *zap.Logger, handler MessageHandler) *Consumer {
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:        cfg.Brokers,
+	retry := cfg.Retry
+	if retry.Attempts == 0 {
+		retry = DefaultRetryConfig()
+	}
+
+	concurrency := max(cfg.Concurrency, 1)
+	maxInFlight := cfg.MaxInFlight
+	if maxInFlight <= 0 {
+		maxInFlight = concurrency * DefaultMaxInFlight
+	}
+
+	broker := cfg.broker()
+	c := &Consumer{
+		topic:       topic,
+		groupID:     groupID,
+		broker:      broker,
+		retry:       retry,
+		concurrency: concurrency,
+		maxInFlight: maxInFlight,
+		writer:      broker.writer(true),
+		logger:      logger,
+		handler:     handler,
+	}
+
+	c.readers = append(c.readers, broker.reader(kafka.ReaderConfig{
 		GroupID:        groupID,
 		Topic:          topic,
 		MinBytes:       10e3, // 10KB
@@ -224,72 +317,80 @@ func NewConsumer(cfg Config, topic, groupID string, logger *zap.Logger, handler
 		MaxWait:        1 * time.Second,
 		StartOffset:    kafka.LastOffset,
 		CommitInterval: time.Second,
-	})
-
-	return &Consumer{
-		reader:  reader,
-		logger:  logger,
-		handler: handler,
+	}))
+	for n := 1; n <= len(retry.Delays); n++ {
+		// Retry topics only hold messages for this group, so a new group
+		// reads them from the start
+		c.readers = append(c.readers, broker.reader(kafka.ReaderConfig{
+			GroupID:        groupID,
+			Topic:          RetryTopic(topic, groupID, n),
+			MinBytes:       1,
+			MaxBytes:       10e6, // 10MB
+			MaxWait:        1 * time.Second,
+			StartOffset:    kafka.FirstOffset,
+			CommitInterval: time.Second,
+		}))
 	}
+
+	return c
 }
 
-// Start starts consuming messages
+// Topic returns the topic the consumer consumes
+func (c *Consumer) Topic() string {
+	return c.topic
+}
+
+// Start consumes the topic and its retry topics until ctx is canceled
 func (c *Consumer) Start(ctx context.Context) error {
-	c.logger.Info("starting consumer", zap.String("topic", c.reader.Config().Topic))
-
-	for {
-		select {
-		case <-ctx.Done():
-			c.logger.Info("stopping consumer")
-			return ctx.Err()
-		default:
-			msg, err := c.reader.FetchMessage(ctx)
-			if err != nil {
-				if ctx.Err() != nil {
-					return ctx.Err()
-				}
-				c.logger.Error("failed to fetch message", zap.Error(err))
-				kafkaErrors.WithLabelValues(c.reader.Config().Topic, "consume").Inc()
-				continue
-			}
-
-			start := time.Now()
-			var event Event
-			if err := json.Unmarshal(msg.Value, &event); err != nil {
-				c.logger.Error("failed to unmarshal message", zap.Error(err))
-				kafkaErrors.WithLabelValues(c.reader.Config().Topic, "unmarshal").Inc()
-				continue
-			}
-
-			if err := c.handler(ctx, event); err != nil {
-				c.logger.Error("failed to handle message",
-					zap.String("event_id", event.ID),
-					zap.Error(err),
-				)
-				kafkaErrors.WithLabelValues(c.reader.Config().Topic, "handle").Inc()
-				continue
-			}
-
-			if err := c.reader.CommitMessages(ctx, msg); err != nil {
-				c.logger.Error("failed to commit message", zap.Error(err))
-				kafkaErrors.WithLabelValues(c.reader.Config().Topic, "commit").Inc()
-				continue
-			}
-
-			kafkaMessagesConsumed.WithLabelValues(c.reader.Config().Topic).Inc()
-			kafkaConsumeLatency.WithLabelValues(c.reader.Config().Topic).Observe(time.Since(start).Seconds())
-		}
+	c.logger.Info("starting consumer", zap.String("topic", c.topic))
+
+	var wg sync.WaitGroup
+	for stage, reader := range c.readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.consume(ctx, stage, reader)
+		}()
 	}
+	wg.Wait()
+
+	c.logger.Info("stopping consumer")
+	return ctx.Err()
+}
+
+// decodeEvent unmarshals the event in a message
+func decodeEvent(msg kafka.Message) (Event, error) {
+	var event Event
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		return Event{}, fmt.Errorf("unmarshal event: %w", err)
+	}
+	return event, nil
 }
 
 // Close closes the consumer
 func (c *Consumer) Close() error {
-	return c.reader.Close()
+	var errs []error
+	for _, reader := range c.readers {
+		errs = append(errs, reader.Close())
+	}
+	if closer, ok := c.writer.(io.Closer); ok {
+		errs = append(errs, closer.Close())
+	}
+	return errors.Join(errs...)
 }
 
 // Errors
 var (
 	ErrTopicNotFound = &MessageError{Message: "topic not found"}
+	// ErrDeadLetterNotFound is returned for a dead letter that is not in the
+	// topic
+	ErrDeadLetterNotFound = &MessageError{Message: "dead letter not found"}
+	// ErrUnknownSchema is returned for an event whose type or version has no
+	// registered schema
+	ErrUnknownSchema = &MessageError{Message: "unknown event schema"}
+	// ErrEventInProgress is returned for an event another consumer in the
+	// group is handling
+	ErrEventInProgress = &MessageError{Message: "event is being handled by another consumer"}
 )
 
 // MessageError represents a messaging error
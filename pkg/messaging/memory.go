@@ -0,0 +1,263 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// MemoryBroker keeps messages in memory. Like Kafka, it splits each topic
+// into partitions by key, and every consumer group reads every message,
+// resuming from its committed offsets when its readers restart. Each
+// partition is read by one reader in a group at a time, from its first fetch
+// until it closes. It suits tests and running without Kafka; messages only
+// reach consumers in the same process, and are lost when it exits.
+type MemoryBroker struct {
+	mu         sync.Mutex
+	partitions int
+	balancer   kafka.Hash
+	topics     map[string][][]kafka.Message
+	groups     map[memoryGroupKey]*memoryGroup
+	// arrived is closed and replaced when messages are written, waking
+	// readers waiting for them
+	arrived chan struct{}
+}
+
+type memoryGroupKey struct {
+	group string
+	topic string
+}
+
+// memoryGroup is a consumer group's position in a topic
+type memoryGroup struct {
+	// committed is the offset after the last committed message in each
+	// partition
+	committed []int64
+	// next is the offset each partition is next fetched from
+	next []int64
+	// owners are the readers fetching from each partition
+	owners []*memoryReader
+}
+
+// NewMemoryBroker creates an empty in-memory broker whose topics have the
+// given number of partitions
+func NewMemoryBroker(partitions int) *MemoryBroker {
+	return &MemoryBroker{
+		partitions: max(partitions, 1),
+		topics:     make(map[string][][]kafka.Message),
+		groups:     make(map[memoryGroupKey]*memoryGroup),
+		arrived:    make(chan struct{}),
+	}
+}
+
+// topic returns the partitions of a topic, creating it if needed
+func (b *MemoryBroker) topic(name string) [][]kafka.Message {
+	partitions, ok := b.topics[name]
+	if !ok {
+		partitions = make([][]kafka.Message, b.partitions)
+		b.topics[name] = partitions
+	}
+	return partitions
+}
+
+func (b *MemoryBroker) writer(createTopics bool) messageWriter {
+	return memoryWriter{broker: b}
+}
+
+func (b *MemoryBroker) reader(cfg kafka.ReaderConfig) messageReader {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := memoryGroupKey{group: cfg.GroupID, topic: cfg.Topic}
+	if _, ok := b.groups[key]; !ok {
+		partitions := b.topic(cfg.Topic)
+		group := &memoryGroup{
+			committed: make([]int64, len(partitions)),
+			next:      make([]int64, len(partitions)),
+			owners:    make([]*memoryReader, len(partitions)),
+		}
+		if cfg.StartOffset != kafka.FirstOffset {
+			for p, msgs := range partitions {
+				group.committed[p] = int64(len(msgs))
+				group.next[p] = int64(len(msgs))
+			}
+		}
+		b.groups[key] = group
+	}
+
+	return &memoryReader{
+		broker: b,
+		key:    key,
+		config: cfg,
+		closed: make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) read(ctx context.Context, topic string, partition int, offset int64, limit int) ([]kafka.Message, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	partitions := b.topics[topic]
+	if partition < 0 || partition >= len(partitions) {
+		return nil, nil
+	}
+
+	msgs := partitions[partition]
+	var read []kafka.Message
+	for offset = max(offset, 0); offset < int64(len(msgs)) && len(read) < limit; offset++ {
+		msg := msgs[offset]
+		msg.HighWaterMark = int64(len(msgs))
+		read = append(read, msg)
+	}
+	return read, nil
+}
+
+// write appends messages to their topics
+func (b *MemoryBroker) write(msgs []kafka.Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, msg := range msgs {
+		if msg.Topic == "" {
+			return errors.New("messaging: message has no topic")
+		}
+	}
+
+	for _, msg := range msgs {
+		partitions := b.topic(msg.Topic)
+		ids := make([]int, len(partitions))
+		for i := range ids {
+			ids[i] = i
+		}
+
+		msg.Partition = b.balancer.Balance(msg, ids...)
+		msg.Offset = int64(len(partitions[msg.Partition]))
+		msg.Key = bytes.Clone(msg.Key)
+		msg.Value = bytes.Clone(msg.Value)
+		msg.Headers = slices.Clone(msg.Headers)
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		partitions[msg.Partition] = append(partitions[msg.Partition], msg)
+	}
+
+	close(b.arrived)
+	b.arrived = make(chan struct{})
+	return nil
+}
+
+// memoryWriter writes messages to a memory broker
+type memoryWriter struct {
+	broker *MemoryBroker
+}
+
+func (w memoryWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return w.broker.write(msgs)
+}
+
+// memoryReader reads a topic of a memory broker for a consumer group
+type memoryReader struct {
+	broker    *MemoryBroker
+	key       memoryGroupKey
+	config    kafka.ReaderConfig
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// FetchMessage returns the next message, waiting for one if there is none
+func (r *memoryReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	for {
+		select {
+		case <-r.closed:
+			return kafka.Message{}, io.EOF
+		default:
+		}
+
+		r.broker.mu.Lock()
+		msg, ok := r.fetch()
+		arrived := r.broker.arrived
+		r.broker.mu.Unlock()
+		if ok {
+			return msg, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return kafka.Message{}, ctx.Err()
+		case <-r.closed:
+			return kafka.Message{}, io.EOF
+		case <-arrived:
+		}
+	}
+}
+
+// fetch returns the next message in a partition the reader owns or can
+// claim. The broker must be locked.
+func (r *memoryReader) fetch() (kafka.Message, bool) {
+	partitions := r.broker.topics[r.key.topic]
+	group := r.broker.groups[r.key]
+
+	for p, msgs := range partitions {
+		if owner := group.owners[p]; owner != nil && owner != r {
+			continue
+		}
+		if group.next[p] >= int64(len(msgs)) {
+			continue
+		}
+
+		group.owners[p] = r
+		msg := msgs[group.next[p]]
+		msg.HighWaterMark = int64(len(msgs))
+		group.next[p]++
+		return msg, true
+	}
+	return kafka.Message{}, false
+}
+
+// CommitMessages commits the offsets of messages, and those before them
+func (r *memoryReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.broker.mu.Lock()
+	defer r.broker.mu.Unlock()
+
+	group := r.broker.groups[r.key]
+	for _, msg := range msgs {
+		if msg.Partition < 0 || msg.Partition >= len(group.committed) {
+			continue
+		}
+		group.committed[msg.Partition] = max(group.committed[msg.Partition], msg.Offset+1)
+	}
+	return nil
+}
+
+// Config returns the reader's configuration
+func (r *memoryReader) Config() kafka.ReaderConfig {
+	return r.config
+}
+
+// Close closes the reader. Its partitions go to the group's other readers,
+// which fetch them again from the committed offsets.
+func (r *memoryReader) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+
+		r.broker.mu.Lock()
+		defer r.broker.mu.Unlock()
+		group := r.broker.groups[r.key]
+		for p, owner := range group.owners {
+			if owner == r {
+				group.owners[p] = nil
+				group.next[p] = group.committed[p]
+			}
+		}
+	})
+	return nil
+}
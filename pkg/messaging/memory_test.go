@@ -0,0 +1,131 @@
+package messaging
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fetchN fetches n messages from reader
+func fetchN(t *testing.T, reader messageReader, n int) []kafka.Message {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msgs := make([]kafka.Message, 0, n)
+	for range n {
+		msg, err := reader.FetchMessage(ctx)
+		require.NoError(t, err)
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// assertNoMessage asserts reader has nothing to fetch
+func assertNoMessage(t *testing.T, reader messageReader) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := reader.FetchMessage(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func writeKeys(t *testing.T, broker *MemoryBroker, topic string, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		err := broker.writer(false).WriteMessages(context.Background(), kafka.Message{
+			Topic: topic,
+			Key:   []byte(key),
+			Value: []byte(key),
+		})
+		require.NoError(t, err)
+	}
+}
+
+func TestMemoryBroker_PartitionsByKey(t *testing.T) {
+	broker := NewMemoryBroker(4)
+	reader := broker.reader(kafka.ReaderConfig{Topic: "feed.created", GroupID: "a", StartOffset: kafka.FirstOffset})
+	writeKeys(t, broker, "feed.created", "x", "y", "x", "z", "x")
+
+	partitions := make(map[string]int)
+	offsets := make(map[int][]int64)
+	for _, msg := range fetchN(t, reader, 5) {
+		if p, ok := partitions[string(msg.Key)]; ok {
+			assert.Equal(t, p, msg.Partition, "key %s changed partition", msg.Key)
+		}
+		partitions[string(msg.Key)] = msg.Partition
+		offsets[msg.Partition] = append(offsets[msg.Partition], msg.Offset)
+	}
+	for p, got := range offsets {
+		for i, offset := range got {
+			assert.Equal(t, int64(i), offset, "partition %d", p)
+		}
+	}
+}
+
+func TestMemoryBroker_ConsumerGroups(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	notifications := broker.reader(kafka.ReaderConfig{Topic: "user.created", GroupID: "notifications"})
+	analytics := broker.reader(kafka.ReaderConfig{Topic: "user.created", GroupID: "analytics"})
+	// Another reader in the group only gets partitions the first leaves
+	other := broker.reader(kafka.ReaderConfig{Topic: "user.created", GroupID: "notifications"})
+
+	writeKeys(t, broker, "user.created", "a", "b")
+
+	assert.Len(t, fetchN(t, notifications, 2), 2)
+	assert.Len(t, fetchN(t, analytics, 2), 2)
+	assertNoMessage(t, other)
+}
+
+func TestMemoryBroker_StartOffset(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	writeKeys(t, broker, "feed.created", "before")
+
+	latest := broker.reader(kafka.ReaderConfig{Topic: "feed.created", GroupID: "latest", StartOffset: kafka.LastOffset})
+	earliest := broker.reader(kafka.ReaderConfig{Topic: "feed.created", GroupID: "earliest", StartOffset: kafka.FirstOffset})
+	writeKeys(t, broker, "feed.created", "after")
+
+	assert.Equal(t, "after", string(fetchN(t, latest, 1)[0].Key))
+	assert.Equal(t, "before", string(fetchN(t, earliest, 1)[0].Key))
+}
+
+func TestMemoryBroker_ResumesFromCommittedOffset(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	cfg := kafka.ReaderConfig{Topic: "feed.created", GroupID: "notifications", StartOffset: kafka.FirstOffset}
+	writeKeys(t, broker, "feed.created", "a", "b", "c")
+
+	reader := broker.reader(cfg)
+	msgs := fetchN(t, reader, 3)
+	require.NoError(t, reader.CommitMessages(context.Background(), msgs[1]))
+	require.NoError(t, reader.Close())
+
+	_, err := reader.FetchMessage(context.Background())
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Messages fetched but not committed are fetched again after a restart
+	restarted := broker.reader(cfg)
+	msg := fetchN(t, restarted, 1)[0]
+	assert.Equal(t, int64(2), msg.Offset)
+	assert.Equal(t, int64(3), msg.HighWaterMark)
+	assertNoMessage(t, restarted)
+}
+
+func TestMemoryBroker_Read(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	writeKeys(t, broker, "feed.created", "a", "b", "c")
+
+	msgs, err := broker.read(context.Background(), "feed.created", 0, 1, 5)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, "b", string(msgs[0].Key))
+
+	msgs, err = broker.read(context.Background(), "missing", 0, 0, 5)
+	require.NoError(t, err)
+	assert.Empty(t, msgs)
+}
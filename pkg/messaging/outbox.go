@@ -0,0 +1,315 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/Femi-lawal/udagram-app/pkg/database"
+	"github.com/Femi-lawal/udagram-app/pkg/telemetry"
+)
+
+// Metrics
+var (
+	outboxLag = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_lag_seconds",
+			Help: "Age of the oldest event in the outbox waiting to be published",
+		},
+	)
+
+	outboxPending = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "outbox_pending_messages",
+			Help: "Number of events in the outbox waiting to be published",
+		},
+	)
+
+	outboxRelayed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbox_messages_relayed_total",
+			Help: "Total number of outbox events published",
+		},
+		[]string{"topic"},
+	)
+
+	outboxDropped = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "outbox_messages_dropped_total",
+			Help: "Total number of outbox events dropped because they can never be published",
+		},
+		[]string{"topic", "reason"},
+	)
+)
+
+// OutboxMessage is an event waiting in the outbox to be published
+type OutboxMessage struct {
+	// ID orders events, so those with the same key are published in order
+	ID        int64     `gorm:"primaryKey;autoIncrement"`
+	Topic     string    `gorm:"type:varchar(255);not null"`
+	Key       string    `gorm:"type:varchar(255);not null"`
+	EventID   string    `gorm:"type:varchar(255);not null"`
+	Payload   string    `gorm:"type:jsonb;not null"`
+	CreatedAt time.Time `gorm:"not null;index"`
+	// TraceContext is the trace context of the transaction that wrote the
+	// event, as W3C trace context headers
+	TraceContext string `gorm:"type:jsonb;not null;default:'{}'"`
+}
+
+// TableName returns the table name for OutboxMessage
+func (OutboxMessage) TableName() string {
+	return "outbox"
+}
+
+// Enqueue writes an event to the outbox in tx, the transaction making the
+// change the event describes. The event is published once tx commits, and
+// never if it rolls back. The request ID and trace context in tx's context go
+// with it.
+func Enqueue(tx *gorm.DB, topic, key string, event Event) error {
+	if !slices.Contains(topics, topic) {
+		return ErrTopicNotFound
+	}
+
+	ctx := tx.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	stampRequestID(ctx, &event)
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	traceContext, err := json.Marshal(carrier)
+	if err != nil {
+		return fmt.Errorf("marshal trace context: %w", err)
+	}
+
+	return tx.Create(&OutboxMessage{
+		Topic:        topic,
+		Key:          key,
+		EventID:      event.ID,
+		Payload:      string(payload),
+		TraceContext: string(traceContext),
+		CreatedAt:    time.Now().UTC(),
+	}).Error
+}
+
+// OutboxConfig holds outbox relay configuration
+type OutboxConfig struct {
+	// Interval is how often the outbox is checked for events
+	Interval time.Duration
+	// BatchSize is how many events are published at once
+	BatchSize int
+}
+
+// DefaultOutboxConfig returns default outbox relay configuration
+func DefaultOutboxConfig() OutboxConfig {
+	return OutboxConfig{
+		Interval:  500 * time.Millisecond,
+		BatchSize: 100,
+	}
+}
+
+// OutboxRelay publishes the events in the outbox. Events are published at
+// least once: one published just before a crash is published again. Events
+// that can never be published, because their payload is unreadable or their
+// topic unknown, are dropped with an error log.
+type OutboxRelay struct {
+	db       *database.Client
+	producer *Producer
+	config   OutboxConfig
+	logger   *zap.Logger
+}
+
+// NewOutboxRelay creates a new outbox relay
+func NewOutboxRelay(db *database.Client, producer *Producer, cfg OutboxConfig, logger *zap.Logger) *OutboxRelay {
+	return &OutboxRelay{
+		db:       db,
+		producer: producer,
+		config:   cfg,
+		logger:   logger,
+	}
+}
+
+// Run publishes events as they arrive until ctx is canceled
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		more, err := r.relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.logger.Error("failed to relay outbox", zap.Error(err))
+		}
+
+		// A full batch means more are likely waiting
+		if more && ctx.Err() == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes the oldest batch of events of each topic and removes them
+// from the outbox, reporting whether any topic had a full batch. Topics are
+// relayed separately, so one that fails to publish does not hold back the
+// others. Replicas take turns, as two publishing at once could reorder events.
+func (r *OutboxRelay) relay(ctx context.Context) (bool, error) {
+	var more bool
+	var errs []error
+	err := r.db.Transaction(ctx, func(tx *gorm.DB) error {
+		var locked bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(hashtext(?))", "outbox").Scan(&locked).Error; err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		var pending int64
+		if err := tx.Model(&OutboxMessage{}).Count(&pending).Error; err != nil {
+			return err
+		}
+		outboxPending.Set(float64(pending))
+
+		var oldest OutboxMessage
+		if err := tx.Order("id").Limit(1).Find(&oldest).Error; err != nil {
+			return err
+		}
+		if oldest.ID == 0 {
+			outboxLag.Set(0)
+			return nil
+		}
+		outboxLag.Set(time.Since(oldest.CreatedAt).Seconds())
+
+		var pendingTopics []string
+		if err := tx.Model(&OutboxMessage{}).Distinct("topic").Order("topic").Pluck("topic", &pendingTopics).Error; err != nil {
+			return err
+		}
+
+		var removed int
+		for _, topic := range pendingTopics {
+			var batch []OutboxMessage
+			if err := tx.Where("topic = ?", topic).Order("id").Limit(r.config.BatchSize).Find(&batch).Error; err != nil {
+				return err
+			}
+
+			ids, err := r.publish(ctx, topic, batch)
+			if len(ids) > 0 {
+				if err := tx.Delete(&OutboxMessage{}, ids).Error; err != nil {
+					return err
+				}
+				removed += len(ids)
+			}
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if len(batch) == r.config.BatchSize {
+				more = true
+			}
+		}
+
+		outboxPending.Set(float64(pending - int64(removed)))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return more, errors.Join(errs...)
+}
+
+// publish publishes a batch of events to topic, in order, and returns the IDs
+// of the events to remove from the outbox: all of them once published, and
+// otherwise those that can never be published. Each event is published in a
+// span continuing the trace of the transaction that wrote it.
+func (r *OutboxRelay) publish(ctx context.Context, topic string, batch []OutboxMessage) (removed []int64, err error) {
+	if !slices.Contains(topics, topic) {
+		ids := make([]int64, len(batch))
+		for i, m := range batch {
+			r.drop(m, "unknown_topic", ErrTopicNotFound)
+			ids[i] = m.ID
+		}
+		return ids, nil
+	}
+
+	msgs := make([]kafka.Message, 0, len(batch))
+	ids := make([]int64, 0, len(batch))
+	var spans []trace.Span
+	defer func() {
+		for _, span := range spans {
+			endSpan(span, err)
+		}
+	}()
+
+	for _, m := range batch {
+		var event Event
+		if err := json.Unmarshal([]byte(m.Payload), &event); err != nil {
+			r.drop(m, "invalid_payload", err)
+			removed = append(removed, m.ID)
+			continue
+		}
+		msg, err := newMessage(m.Key, event)
+		if err != nil {
+			r.drop(m, "invalid_payload", err)
+			removed = append(removed, m.ID)
+			continue
+		}
+
+		carrier := propagation.MapCarrier{}
+		if m.TraceContext != "" {
+			// Events without one publish in a new trace
+			_ = json.Unmarshal([]byte(m.TraceContext), &carrier)
+		}
+		spanCtx, span := telemetry.KafkaProducerSpan(otel.GetTextMapPropagator().Extract(ctx, carrier), topic)
+		injectTrace(spanCtx, &msg)
+
+		msgs = append(msgs, msg)
+		ids = append(ids, m.ID)
+		spans = append(spans, span)
+	}
+	if len(msgs) == 0 {
+		return removed, nil
+	}
+
+	if err := r.producer.publishMessages(ctx, topic, msgs); err != nil {
+		return removed, fmt.Errorf("publish to %s: %w", topic, err)
+	}
+	outboxRelayed.WithLabelValues(topic).Add(float64(len(msgs)))
+	return append(removed, ids...), nil
+}
+
+// drop logs an event that can never be published, before it is removed from
+// the outbox
+func (r *OutboxRelay) drop(m OutboxMessage, reason string, err error) {
+	outboxDropped.WithLabelValues(m.Topic, reason).Inc()
+	r.logger.Error("dropping outbox event that cannot be published",
+		zap.Int64("outbox_id", m.ID),
+		zap.String("topic", m.Topic),
+		zap.String("key", m.Key),
+		zap.String("event_id", m.EventID),
+		zap.String("reason", reason),
+		zap.Error(err),
+	)
+}
@@ -0,0 +1,132 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/Femi-lawal/udagram-app/pkg/database"
+	"github.com/Femi-lawal/udagram-app/pkg/database/databasetest"
+)
+
+// failingTopicWriter fails every write to one topic
+type failingTopicWriter struct {
+	messageWriter
+	topic string
+}
+
+func (w failingTopicWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, msg := range msgs {
+		if msg.Topic == w.topic {
+			return errors.New("leader not available")
+		}
+	}
+	return w.messageWriter.WriteMessages(ctx, msgs...)
+}
+
+func newTestRelay(t *testing.T, writer messageWriter) (*OutboxRelay, *database.Client) {
+	db := databasetest.New(t, &OutboxMessage{})
+	producer := &Producer{writer: writer, logger: zap.NewNop()}
+	return NewOutboxRelay(db, producer, DefaultOutboxConfig(), zap.NewNop()), db
+}
+
+func enqueue(t *testing.T, db *database.Client, topic, key string) {
+	event, err := NewEvent("feed-service", FeedCreated{FeedID: key})
+	require.NoError(t, err)
+	require.NoError(t, db.Transaction(context.Background(), func(tx *gorm.DB) error {
+		return Enqueue(tx, topic, key, event)
+	}))
+}
+
+func publishedKeys(t *testing.T, broker *MemoryBroker, topic string) []string {
+	msgs, err := broker.read(context.Background(), topic, 0, 0, 100)
+	require.NoError(t, err)
+	keys := make([]string, len(msgs))
+	for i, msg := range msgs {
+		keys[i] = string(msg.Key)
+	}
+	return keys
+}
+
+func outboxIDs(t *testing.T, db *database.Client) []int64 {
+	var ids []int64
+	require.NoError(t, db.DB().Model(&OutboxMessage{}).Order("id").Pluck("id", &ids).Error)
+	return ids
+}
+
+func TestOutboxRelay_PublishesInOrder(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	relay, db := newTestRelay(t, broker.writer(false))
+	enqueue(t, db, TopicFeedCreated, "a")
+	enqueue(t, db, TopicUserCreated, "b")
+	enqueue(t, db, TopicFeedCreated, "c")
+
+	more, err := relay.relay(context.Background())
+	require.NoError(t, err)
+	assert.False(t, more)
+
+	assert.Equal(t, []string{"a", "c"}, publishedKeys(t, broker, TopicFeedCreated))
+	assert.Equal(t, []string{"b"}, publishedKeys(t, broker, TopicUserCreated))
+	assert.Empty(t, outboxIDs(t, db))
+}
+
+func TestOutboxRelay_DropsUnpublishableEvents(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	relay, db := newTestRelay(t, broker.writer(false))
+	enqueue(t, db, TopicFeedCreated, "a")
+	require.NoError(t, db.DB().Create(&OutboxMessage{
+		Topic:     TopicFeedCreated,
+		Key:       "bad",
+		EventID:   "bad",
+		Payload:   `{"id": 1}`,
+		CreatedAt: time.Now().UTC(),
+	}).Error)
+	require.NoError(t, db.DB().Create(&OutboxMessage{
+		Topic:     "feed.archived",
+		Key:       "unknown",
+		EventID:   "unknown",
+		Payload:   `{}`,
+		CreatedAt: time.Now().UTC(),
+	}).Error)
+	enqueue(t, db, TopicFeedCreated, "b")
+
+	_, err := relay.relay(context.Background())
+	require.NoError(t, err)
+
+	// The events behind the bad ones are not held back
+	assert.Equal(t, []string{"a", "b"}, publishedKeys(t, broker, TopicFeedCreated))
+	assert.Empty(t, outboxIDs(t, db))
+}
+
+func TestOutboxRelay_FailingTopicDoesNotBlockOthers(t *testing.T) {
+	broker := NewMemoryBroker(1)
+	relay, db := newTestRelay(t, failingTopicWriter{messageWriter: broker.writer(false), topic: TopicUserCreated})
+	relay.config.BatchSize = 2
+	enqueue(t, db, TopicUserCreated, "u1")
+	enqueue(t, db, TopicUserCreated, "u2")
+	enqueue(t, db, TopicFeedCreated, "f1")
+	enqueue(t, db, TopicUserCreated, "u3")
+	enqueue(t, db, TopicFeedCreated, "f2")
+	enqueue(t, db, TopicFeedCreated, "f3")
+
+	more, err := relay.relay(context.Background())
+	assert.ErrorContains(t, err, "publish to "+TopicUserCreated)
+	assert.True(t, more, "feed.created had a full batch")
+	_, err = relay.relay(context.Background())
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{"f1", "f2", "f3"}, publishedKeys(t, broker, TopicFeedCreated))
+	assert.Empty(t, publishedKeys(t, broker, TopicUserCreated))
+
+	// The failed events stay in the outbox for the next attempt
+	var keys []string
+	require.NoError(t, db.DB().Model(&OutboxMessage{}).Order("id").Pluck("key", &keys).Error)
+	assert.Equal(t, []string{"u1", "u2", "u3"}, keys)
+}
@@ -0,0 +1,204 @@
+package messaging
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+// Metrics
+var (
+	kafkaInFlight = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kafka_consumer_in_flight_messages",
+			Help: "Number of Kafka messages fetched but not yet handled",
+		},
+		[]string{"topic"},
+	)
+
+	kafkaLag = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "kafka_consumer_lag",
+			Help: "Number of Kafka messages in a partition after the last committed one",
+		},
+		[]string{"topic", "partition"},
+	)
+)
+
+// messageReader reads messages from a topic for a consumer group
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Config() kafka.ReaderConfig
+	Close() error
+}
+
+// consume handles the messages of one reader until ctx is canceled. Workers
+// handle messages concurrently, each message going to the worker for its
+// key so those with the same key stay in order. Offsets are committed up to
+// the last message handled with all before it handled too.
+func (c *Consumer) consume(ctx context.Context, stage int, reader messageReader) {
+	topic := reader.Config().Topic
+	offsets := newOffsetTracker()
+
+	// slots bounds the messages in flight; fetching waits for a free one
+	slots := make(chan struct{}, c.maxInFlight)
+
+	var wg sync.WaitGroup
+	workers := make([]chan kafka.Message, c.concurrency)
+	for i := range workers {
+		workers[i] = make(chan kafka.Message, c.maxInFlight)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range workers[i] {
+				c.handle(ctx, stage, reader, offsets, msg)
+				kafkaInFlight.WithLabelValues(topic).Dec()
+				<-slots
+			}
+		}()
+	}
+	defer func() {
+		for _, worker := range workers {
+			close(worker)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+
+		msg, err := reader.FetchMessage(ctx)
+		if err != nil {
+			<-slots
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Error("failed to fetch message", zap.String("topic", topic), zap.Error(err))
+			kafkaErrors.WithLabelValues(topic, "consume").Inc()
+			continue
+		}
+
+		// Messages in a retry topic wait there until due
+		if err := sleepUntil(ctx, retryAt(msg)); err != nil {
+			return
+		}
+
+		offsets.add(msg)
+		kafkaInFlight.WithLabelValues(topic).Inc()
+		workers[workerFor(msg, len(workers))] <- msg
+	}
+}
+
+// handle processes a message and commits the offsets it completes
+func (c *Consumer) handle(ctx context.Context, stage int, reader messageReader, offsets *offsetTracker, msg kafka.Message) {
+	if ctx.Err() != nil {
+		// Shutting down; queued messages are fetched again after a restart
+		return
+	}
+
+	start := time.Now()
+	if err := c.process(ctx, stage, msg); err != nil {
+		// Canceled before the message was dealt with; it is fetched again
+		// after a restart
+		return
+	}
+	kafkaMessagesConsumed.WithLabelValues(msg.Topic).Inc()
+	kafkaConsumeLatency.WithLabelValues(msg.Topic).Observe(time.Since(start).Seconds())
+
+	offsets.complete(msg, func(last kafka.Message, lag int64) {
+		if err := reader.CommitMessages(ctx, last); err != nil {
+			c.logger.Error("failed to commit message", zap.Error(err))
+			kafkaErrors.WithLabelValues(last.Topic, "commit").Inc()
+			return
+		}
+		kafkaLag.WithLabelValues(last.Topic, strconv.Itoa(last.Partition)).Set(float64(lag))
+	})
+}
+
+// workerFor returns the worker for a message: by key, or by partition for
+// messages without one
+func workerFor(msg kafka.Message, workers int) int {
+	h := fnv.New32a()
+	if len(msg.Key) > 0 {
+		h.Write(msg.Key)
+	} else {
+		h.Write([]byte(strconv.Itoa(msg.Partition)))
+	}
+	return int(h.Sum32() % uint32(workers))
+}
+
+// offsetTracker tracks the messages in flight in each partition, to find
+// how far offsets can be committed
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionOffsets
+}
+
+type partitionOffsets struct {
+	// pending are the offsets fetched and not yet committed, in order
+	pending []int64
+	// done are the handled messages waiting on earlier ones
+	done map[int64]kafka.Message
+	// highWaterMark is the offset after the last message in the partition
+	highWaterMark int64
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{partitions: make(map[int]*partitionOffsets)}
+}
+
+// add tracks a fetched message
+func (t *offsetTracker) add(msg kafka.Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	// After a rebalance the partition is read again from the committed
+	// offset, and what was in flight before no longer counts
+	if !ok || (len(p.pending) > 0 && msg.Offset <= p.pending[len(p.pending)-1]) {
+		p = &partitionOffsets{done: make(map[int64]kafka.Message)}
+		t.partitions[msg.Partition] = p
+	}
+	p.pending = append(p.pending, msg.Offset)
+	p.highWaterMark = msg.HighWaterMark
+}
+
+// complete marks a message handled and, if it completes a run of handled
+// messages at the start of its partition, calls commit with the last of them
+// and the messages left after it. Commits are made in order.
+func (t *offsetTracker) complete(msg kafka.Message, commit func(last kafka.Message, lag int64)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[msg.Partition]
+	if !ok || len(p.pending) == 0 || msg.Offset < p.pending[0] {
+		return
+	}
+	p.done[msg.Offset] = msg
+
+	var last *kafka.Message
+	for len(p.pending) > 0 {
+		next, ok := p.done[p.pending[0]]
+		if !ok {
+			break
+		}
+		delete(p.done, p.pending[0])
+		p.pending = p.pending[1:]
+		last = &next
+	}
+	if last != nil {
+		commit(*last, max(p.highWaterMark-last.Offset-1, 0))
+	}
+}
@@ -0,0 +1,282 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeReader serves a fixed list of messages and records commits
+type fakeReader struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	fetched  int
+	commits  []kafka.Message
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	r.mu.Lock()
+	if r.fetched < len(r.messages) {
+		msg := r.messages[r.fetched]
+		r.fetched++
+		r.mu.Unlock()
+		return msg, nil
+	}
+	r.mu.Unlock()
+
+	<-ctx.Done()
+	return kafka.Message{}, ctx.Err()
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commits = append(r.commits, msgs...)
+	return nil
+}
+
+func (r *fakeReader) Config() kafka.ReaderConfig {
+	return kafka.ReaderConfig{Topic: "feed.created"}
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
+
+func (r *fakeReader) fetchedCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.fetched
+}
+
+func (r *fakeReader) lastCommit() (int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.commits) == 0 {
+		return 0, false
+	}
+	return r.commits[len(r.commits)-1].Offset, true
+}
+
+// newFakeReader returns a reader of count messages in one partition, keyed
+// in turn by keys
+func newFakeReader(t *testing.T, count int, keys ...string) *fakeReader {
+	reader := &fakeReader{}
+	for i := 0; i < count; i++ {
+		event, err := NewEvent("feed-service", FeedCreated{FeedID: keys[i%len(keys)]})
+		require.NoError(t, err)
+		value, err := json.Marshal(event)
+		require.NoError(t, err)
+		reader.messages = append(reader.messages, kafka.Message{
+			Topic:         "feed.created",
+			Offset:        int64(i),
+			HighWaterMark: int64(count),
+			Key:           []byte(keys[i%len(keys)]),
+			Value:         value,
+		})
+	}
+	return reader
+}
+
+func newPoolConsumer(concurrency, maxInFlight int, handler MessageHandler) *Consumer {
+	return &Consumer{
+		topic:       "feed.created",
+		groupID:     "notifications",
+		retry:       testRetryConfig(),
+		concurrency: concurrency,
+		maxInFlight: maxInFlight,
+		writer:      &fakeWriter{},
+		logger:      zap.NewNop(),
+		handler:     handler,
+	}
+}
+
+// runConsumer consumes reader until stop is called
+func runConsumer(c *Consumer, reader messageReader) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.consume(ctx, 0, reader)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func TestConsume_HandlesKeysConcurrently(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(4)
+	release := make(chan struct{})
+
+	consumer := newPoolConsumer(4, 16, func(ctx context.Context, event Event) error {
+		started.Done()
+		<-release
+		return nil
+	})
+	// Keys chosen to land on different workers
+	keys := []string{}
+	seen := map[int]bool{}
+	for i := 0; len(keys) < 4; i++ {
+		key := string(rune('a' + i))
+		if w := workerFor(kafka.Message{Key: []byte(key)}, 4); !seen[w] {
+			seen[w] = true
+			keys = append(keys, key)
+		}
+	}
+	reader := newFakeReader(t, 4, keys...)
+	stop := runConsumer(consumer, reader)
+	defer stop()
+
+	waited := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("messages with different keys were not handled concurrently")
+	}
+	close(release)
+
+	assert.Eventually(t, func() bool {
+		offset, ok := reader.lastCommit()
+		return ok && offset == 3
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestConsume_KeepsKeyOrder(t *testing.T) {
+	var mu sync.Mutex
+	order := make(map[string][]int64)
+	consumer := newPoolConsumer(4, 8, func(ctx context.Context, event Event) error {
+		feed, err := Decode[FeedCreated](event)
+		require.NoError(t, err)
+		offset, err := strconv.ParseInt(event.Metadata["offset"], 10, 64)
+		require.NoError(t, err)
+
+		time.Sleep(time.Duration(offset%3) * time.Millisecond)
+		mu.Lock()
+		order[feed.FeedID] = append(order[feed.FeedID], offset)
+		mu.Unlock()
+		return nil
+	})
+
+	// Each event carries its offset, for the handler to record
+	reader := newFakeReader(t, 60, "a", "b", "c", "d", "e")
+	for i := range reader.messages {
+		var event Event
+		require.NoError(t, json.Unmarshal(reader.messages[i].Value, &event))
+		event.Metadata = map[string]string{"offset": strconv.FormatInt(reader.messages[i].Offset, 10)}
+		value, err := json.Marshal(event)
+		require.NoError(t, err)
+		reader.messages[i].Value = value
+	}
+
+	stop := runConsumer(consumer, reader)
+	assert.Eventually(t, func() bool {
+		offset, ok := reader.lastCommit()
+		return ok && offset == 59
+	}, 5*time.Second, 5*time.Millisecond)
+	stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for key, offsets := range order {
+		assert.IsIncreasing(t, offsets, "key %s", key)
+		assert.Len(t, offsets, 12, "key %s", key)
+	}
+}
+
+func TestConsume_AppliesBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	var handled atomic.Int32
+	consumer := newPoolConsumer(2, 3, func(ctx context.Context, event Event) error {
+		<-release
+		handled.Add(1)
+		return nil
+	})
+
+	reader := newFakeReader(t, 10, "a", "b")
+	stop := runConsumer(consumer, reader)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, reader.fetchedCount(), "fetched past the in-flight bound")
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return handled.Load() == 10
+	}, 2*time.Second, 5*time.Millisecond)
+	stop()
+}
+
+func TestOffsetTracker_CommitsContiguousOffsets(t *testing.T) {
+	tracker := newOffsetTracker()
+	msgs := make([]kafka.Message, 5)
+	for i := range msgs {
+		msgs[i] = kafka.Message{Partition: 1, Offset: int64(10 + i), HighWaterMark: 20}
+		tracker.add(msgs[i])
+	}
+
+	var commits []int64
+	var lags []int64
+	commit := func(last kafka.Message, lag int64) {
+		commits = append(commits, last.Offset)
+		lags = append(lags, lag)
+	}
+
+	tracker.complete(msgs[2], commit)
+	assert.Empty(t, commits, "committed past an unfinished message")
+
+	tracker.complete(msgs[0], commit)
+	assert.Equal(t, []int64{10}, commits)
+
+	tracker.complete(msgs[1], commit)
+	assert.Equal(t, []int64{10, 12}, commits)
+
+	tracker.complete(msgs[4], commit)
+	tracker.complete(msgs[3], commit)
+	assert.Equal(t, []int64{10, 12, 14}, commits)
+	assert.Equal(t, []int64{9, 7, 5}, lags)
+}
+
+func TestOffsetTracker_PartitionsAreIndependent(t *testing.T) {
+	tracker := newOffsetTracker()
+	a := kafka.Message{Partition: 0, Offset: 5}
+	b := kafka.Message{Partition: 1, Offset: 7}
+	tracker.add(a)
+	tracker.add(b)
+
+	var commits []kafka.Message
+	tracker.complete(b, func(last kafka.Message, lag int64) {
+		commits = append(commits, last)
+	})
+	require.Len(t, commits, 1)
+	assert.Equal(t, 1, commits[0].Partition)
+}
+
+func TestOffsetTracker_Rebalance(t *testing.T) {
+	tracker := newOffsetTracker()
+	for offset := int64(0); offset < 3; offset++ {
+		tracker.add(kafka.Message{Offset: offset})
+	}
+
+	// Read again from the committed offset after a rebalance
+	tracker.add(kafka.Message{Offset: 1})
+
+	var commits []int64
+	tracker.complete(kafka.Message{Offset: 1}, func(last kafka.Message, lag int64) {
+		commits = append(commits, last.Offset)
+	})
+	assert.Equal(t, []int64{1}, commits)
+}
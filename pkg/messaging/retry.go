@@ -0,0 +1,313 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/codes"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common/retry"
+)
+
+// Metrics
+var (
+	kafkaRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_messages_retried_total",
+			Help: "Total number of Kafka messages moved to a retry topic",
+		},
+		[]string{"topic"},
+	)
+
+	kafkaDeadLetters = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "kafka_messages_dead_lettered_total",
+			Help: "Total number of Kafka messages moved to a dead-letter topic",
+		},
+		[]string{"topic"},
+	)
+)
+
+// Headers added to messages moved to a retry or dead-letter topic, next to
+// the original headers
+const (
+	HeaderOriginalTopic     = "original_topic"
+	HeaderOriginalPartition = "original_partition"
+	HeaderOriginalOffset    = "original_offset"
+	// HeaderAttempts counts the times the message was handled
+	HeaderAttempts = "attempts"
+	// HeaderError is why the last attempt failed
+	HeaderError = "error"
+	// HeaderFailedAt is when the last attempt failed
+	HeaderFailedAt = "failed_at"
+	// HeaderRetryAt is when a message in a retry topic is due
+	HeaderRetryAt = "retry_at"
+)
+
+// maxErrorHeader bounds the error recorded with a failed message
+const maxErrorHeader = 1024
+
+// RetryConfig holds how a consumer retries messages its handler fails on.
+// A message is handled up to Attempts times in-process, then moves through
+// a retry topic per delay, each handling it up to Attempts times again, and
+// finally to the dead-letter topic.
+type RetryConfig struct {
+	// Attempts is how many times a message is handled before it moves on
+	Attempts int
+	// Backoff paces the attempts
+	Backoff retry.Config
+	// Delays are how long each retry topic holds messages before handling
+	// them again
+	Delays []time.Duration
+	// DeadLetter keeps messages that fail every retry in the dead-letter
+	// topic; without it they are logged and dropped
+	DeadLetter bool
+}
+
+// DefaultRetryConfig returns default retry configuration
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		Attempts: 3,
+		Backoff: retry.Config{
+			InitialWait: 100 * time.Millisecond,
+			MaxWait:     2 * time.Second,
+			Multiplier:  2.0,
+		},
+		Delays:     []time.Duration{30 * time.Second, 5 * time.Minute},
+		DeadLetter: true,
+	}
+}
+
+// RetryTopic returns the name of a consumer group's nth retry topic for a
+// topic, counting from 1. Each group has its own, so one group's failures
+// are not handled again by the others.
+func RetryTopic(topic, groupID string, n int) string {
+	return fmt.Sprintf("%s.%s.retry.%d", topic, groupID, n)
+}
+
+// DeadLetterTopic returns the name of a consumer group's dead-letter topic
+// for a topic
+func DeadLetterTopic(topic, groupID string) string {
+	return topic + "." + groupID + ".dlq"
+}
+
+// permanentError is a failure that handling the message again cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks a handler error as permanent, so the message goes to the
+// dead-letter topic without being retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// messageWriter writes messages to the topics they name
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// process handles a message from the topic (stage 0) or a due one from a
+// retry topic, retrying failures and then moving the message on. It returns
+// an error only if ctx is canceled before the message is dealt with, in
+// which case it must not be committed.
+func (c *Consumer) process(ctx context.Context, stage int, msg kafka.Message) error {
+	ctx, span := c.receiveSpan(ctx, msg)
+	defer span.End()
+
+	attempts, _ := strconv.Atoi(header(msg, HeaderAttempts))
+
+	event, err := decodeEvent(msg)
+	if err != nil {
+		c.logger.Error("failed to unmarshal message", zap.String("topic", msg.Topic), zap.Error(err))
+		kafkaErrors.WithLabelValues(msg.Topic, "unmarshal").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return c.forward(ctx, stage, msg, attempts, Permanent(err))
+	}
+	ctx = eventContext(ctx, event)
+
+	for attempt := 1; ; attempt++ {
+		err = c.handler(ctx, event)
+		if err == nil {
+			return nil
+		}
+		attempts++
+
+		c.logger.Warn("failed to handle message",
+			zap.String("topic", msg.Topic),
+			zap.String("event_id", event.ID),
+			zap.String("request_id", event.Metadata[MetadataRequestID]),
+			zap.Int("attempt", attempts),
+			zap.Error(err),
+		)
+		kafkaErrors.WithLabelValues(msg.Topic, "handle").Inc()
+		span.RecordError(err)
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if IsPermanent(err) || attempt >= c.retry.Attempts {
+			break
+		}
+		if err := sleep(ctx, c.retry.Backoff.Backoff(attempt)); err != nil {
+			return err
+		}
+	}
+
+	span.SetStatus(codes.Error, err.Error())
+	return c.forward(ctx, stage, msg, attempts, err)
+}
+
+// forward moves a failed message to the next retry topic or, once retries
+// are used up, to the dead-letter topic
+func (c *Consumer) forward(ctx context.Context, stage int, msg kafka.Message, attempts int, cause error) error {
+	var out kafka.Message
+	switch {
+	case !IsPermanent(cause) && stage < len(c.retry.Delays):
+		out = failedMessage(msg, RetryTopic(c.topic, c.groupID, stage+1), attempts, cause)
+		out.Headers = append(out.Headers, kafka.Header{
+			Key:   HeaderRetryAt,
+			Value: []byte(time.Now().Add(c.retry.Delays[stage]).UTC().Format(time.RFC3339Nano)),
+		})
+		kafkaRetries.WithLabelValues(c.topic).Inc()
+	case c.retry.DeadLetter:
+		out = failedMessage(msg, DeadLetterTopic(c.topic, c.groupID), attempts, cause)
+		kafkaDeadLetters.WithLabelValues(c.topic).Inc()
+	default:
+		c.logger.Error("dropping failed message",
+			zap.String("topic", msg.Topic),
+			zap.Int64("offset", msg.Offset),
+			zap.Int("attempts", attempts),
+			zap.Error(cause),
+		)
+		kafkaErrors.WithLabelValues(c.topic, "drop").Inc()
+		return nil
+	}
+
+	// The message is committed once it is forwarded, so keep trying until
+	// the write succeeds
+	for attempt := 1; ; attempt++ {
+		err := c.writer.WriteMessages(ctx, out)
+		if err == nil {
+			c.logger.Info("moved failed message",
+				zap.String("topic", msg.Topic),
+				zap.String("to", out.Topic),
+				zap.Int("attempts", attempts),
+				zap.Error(cause),
+			)
+			return nil
+		}
+
+		c.logger.Error("failed to move failed message", zap.String("to", out.Topic), zap.Error(err))
+		kafkaErrors.WithLabelValues(out.Topic, "produce").Inc()
+		if err := sleep(ctx, c.retry.Backoff.Backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// failedMessage copies msg for topic, recording where it came from and why
+// it failed
+func failedMessage(msg kafka.Message, topic string, attempts int, cause error) kafka.Message {
+	out := kafka.Message{
+		Topic:   topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: originalHeaders(msg.Headers),
+	}
+
+	if header(msg, HeaderOriginalTopic) == "" {
+		out.Headers = append(out.Headers,
+			kafka.Header{Key: HeaderOriginalTopic, Value: []byte(msg.Topic)},
+			kafka.Header{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(msg.Partition))},
+			kafka.Header{Key: HeaderOriginalOffset, Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		)
+	}
+
+	reason := cause.Error()
+	if len(reason) > maxErrorHeader {
+		reason = reason[:maxErrorHeader]
+	}
+	out.Headers = append(out.Headers,
+		kafka.Header{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+		kafka.Header{Key: HeaderError, Value: []byte(reason)},
+		kafka.Header{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+	)
+	return out
+}
+
+// originalHeaders returns headers without those recording the last failure
+func originalHeaders(headers []kafka.Header) []kafka.Header {
+	kept := make([]kafka.Header, 0, len(headers)+6)
+	for _, h := range headers {
+		switch h.Key {
+		case HeaderAttempts, HeaderError, HeaderFailedAt, HeaderRetryAt:
+			continue
+		}
+		kept = append(kept, h)
+	}
+	return kept
+}
+
+// header returns the value of the message's last header named key
+func header(msg kafka.Message, key string) string {
+	for i := len(msg.Headers) - 1; i >= 0; i-- {
+		if msg.Headers[i].Key == key {
+			return string(msg.Headers[i].Value)
+		}
+	}
+	return ""
+}
+
+// retryAt returns when a message in a retry topic is due, or the zero time
+// if it is due now
+func retryAt(msg kafka.Message) time.Time {
+	t, err := time.Parse(time.RFC3339Nano, header(msg, HeaderRetryAt))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	return sleep(ctx, time.Until(t))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,255 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common/retry"
+)
+
+// fakeWriter records the messages written to it
+type fakeWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	// failures is how many writes fail before writes succeed
+	failures int
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("broker unavailable")
+	}
+	w.messages = append(w.messages, msgs...)
+	return nil
+}
+
+func newTestConsumer(retry RetryConfig, handler MessageHandler) (*Consumer, *fakeWriter) {
+	writer := &fakeWriter{}
+	return &Consumer{
+		topic:   "feed.created",
+		groupID: "notifications",
+		retry:   retry,
+		writer:  writer,
+		logger:  zap.NewNop(),
+		handler: handler,
+	}, writer
+}
+
+func testRetryConfig() RetryConfig {
+	return RetryConfig{
+		Attempts:   3,
+		Backoff:    retry.Config{InitialWait: time.Millisecond, MaxWait: time.Millisecond, Multiplier: 1},
+		Delays:     []time.Duration{time.Minute, time.Hour},
+		DeadLetter: true,
+	}
+}
+
+func testMessage(t *testing.T) kafka.Message {
+	event, err := NewEvent("feed-service", FeedCreated{FeedID: "1", UserID: "2"})
+	require.NoError(t, err)
+	value, err := json.Marshal(event)
+	require.NoError(t, err)
+	return kafka.Message{
+		Topic:     "feed.created",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("key"),
+		Value:     value,
+		Headers:   []kafka.Header{{Key: "traceparent", Value: []byte("00-abc-def-01")}},
+	}
+}
+
+func headers(msg kafka.Message) map[string]string {
+	values := make(map[string]string)
+	for _, h := range msg.Headers {
+		values[h.Key] = string(h.Value)
+	}
+	return values
+}
+
+func TestProcess_RetriesInProcess(t *testing.T) {
+	calls := 0
+	consumer, writer := newTestConsumer(testRetryConfig(), func(ctx context.Context, event Event) error {
+		calls++
+		if calls < 3 {
+			return errors.New("database unavailable")
+		}
+		return nil
+	})
+
+	require.NoError(t, consumer.process(context.Background(), 0, testMessage(t)))
+
+	assert.Equal(t, 3, calls)
+	assert.Empty(t, writer.messages)
+}
+
+func TestProcess_ForwardsToRetryTopic(t *testing.T) {
+	calls := 0
+	consumer, writer := newTestConsumer(testRetryConfig(), func(ctx context.Context, event Event) error {
+		calls++
+		return errors.New("database unavailable")
+	})
+
+	before := time.Now()
+	require.NoError(t, consumer.process(context.Background(), 0, testMessage(t)))
+
+	assert.Equal(t, 3, calls)
+	require.Len(t, writer.messages, 1)
+	out := writer.messages[0]
+	assert.Equal(t, "feed.created.notifications.retry.1", out.Topic)
+	assert.Equal(t, []byte("key"), out.Key)
+
+	h := headers(out)
+	assert.Equal(t, "00-abc-def-01", h["traceparent"])
+	assert.Equal(t, "feed.created", h[HeaderOriginalTopic])
+	assert.Equal(t, "2", h[HeaderOriginalPartition])
+	assert.Equal(t, "42", h[HeaderOriginalOffset])
+	assert.Equal(t, "3", h[HeaderAttempts])
+	assert.Equal(t, "database unavailable", h[HeaderError])
+	assert.WithinDuration(t, before.Add(time.Minute), retryAt(out), time.Second)
+}
+
+func TestProcess_DeadLettersAfterLastRetryTopic(t *testing.T) {
+	consumer, writer := newTestConsumer(testRetryConfig(), func(ctx context.Context, event Event) error {
+		return errors.New("still unavailable")
+	})
+
+	// The message as it arrives from the second retry topic
+	msg := testMessage(t)
+	msg.Topic = RetryTopic("feed.created", "notifications", 2)
+	msg.Offset = 7
+	msg.Headers = append(msg.Headers,
+		kafka.Header{Key: HeaderOriginalTopic, Value: []byte("feed.created")},
+		kafka.Header{Key: HeaderOriginalPartition, Value: []byte("2")},
+		kafka.Header{Key: HeaderOriginalOffset, Value: []byte("42")},
+		kafka.Header{Key: HeaderAttempts, Value: []byte("6")},
+		kafka.Header{Key: HeaderError, Value: []byte("database unavailable")},
+		kafka.Header{Key: HeaderRetryAt, Value: []byte(time.Now().Format(time.RFC3339Nano))},
+	)
+
+	require.NoError(t, consumer.process(context.Background(), 2, msg))
+
+	require.Len(t, writer.messages, 1)
+	out := writer.messages[0]
+	assert.Equal(t, "feed.created.notifications.dlq", out.Topic)
+
+	h := headers(out)
+	assert.Equal(t, "feed.created", h[HeaderOriginalTopic])
+	assert.Equal(t, "42", h[HeaderOriginalOffset])
+	assert.Equal(t, "9", h[HeaderAttempts])
+	assert.Equal(t, "still unavailable", h[HeaderError])
+	assert.NotContains(t, h, HeaderRetryAt)
+
+	letter := newDeadLetter(out)
+	assert.Equal(t, "feed.created", letter.OriginalTopic)
+	assert.Equal(t, 9, letter.Attempts)
+	assert.Equal(t, "still unavailable", letter.Error)
+}
+
+func TestProcess_PermanentErrorSkipsRetries(t *testing.T) {
+	calls := 0
+	consumer, writer := newTestConsumer(testRetryConfig(), func(ctx context.Context, event Event) error {
+		calls++
+		return Permanent(errors.New("invalid event"))
+	})
+
+	require.NoError(t, consumer.process(context.Background(), 0, testMessage(t)))
+
+	assert.Equal(t, 1, calls)
+	require.Len(t, writer.messages, 1)
+	assert.Equal(t, "feed.created.notifications.dlq", writer.messages[0].Topic)
+	assert.Equal(t, "1", headers(writer.messages[0])[HeaderAttempts])
+}
+
+func TestProcess_UnmarshalFailureDeadLetters(t *testing.T) {
+	calls := 0
+	consumer, writer := newTestConsumer(testRetryConfig(), func(ctx context.Context, event Event) error {
+		calls++
+		return nil
+	})
+
+	msg := testMessage(t)
+	msg.Value = []byte("not json")
+	require.NoError(t, consumer.process(context.Background(), 0, msg))
+
+	assert.Equal(t, 0, calls)
+	require.Len(t, writer.messages, 1)
+	out := writer.messages[0]
+	assert.Equal(t, "feed.created.notifications.dlq", out.Topic)
+	assert.Equal(t, []byte("not json"), out.Value)
+	assert.Equal(t, "0", headers(out)[HeaderAttempts])
+	assert.Contains(t, headers(out)[HeaderError], "unmarshal event")
+}
+
+func TestProcess_DropsWithoutDeadLetter(t *testing.T) {
+	retry := testRetryConfig()
+	retry.Delays = nil
+	retry.DeadLetter = false
+	consumer, writer := newTestConsumer(retry, func(ctx context.Context, event Event) error {
+		return errors.New("database unavailable")
+	})
+
+	require.NoError(t, consumer.process(context.Background(), 0, testMessage(t)))
+
+	assert.Empty(t, writer.messages)
+}
+
+func TestProcess_RetriesForwardUntilWritten(t *testing.T) {
+	consumer, writer := newTestConsumer(testRetryConfig(), func(ctx context.Context, event Event) error {
+		return Permanent(errors.New("invalid event"))
+	})
+	writer.failures = 2
+
+	require.NoError(t, consumer.process(context.Background(), 0, testMessage(t)))
+
+	assert.Len(t, writer.messages, 1)
+}
+
+func TestProcess_CanceledIsNotDealtWith(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer, writer := newTestConsumer(testRetryConfig(), func(ctx context.Context, event Event) error {
+		cancel()
+		return ctx.Err()
+	})
+
+	assert.ErrorIs(t, consumer.process(ctx, 0, testMessage(t)), context.Canceled)
+	assert.Empty(t, writer.messages)
+}
+
+func TestOriginalHeaders(t *testing.T) {
+	kept := originalHeaders([]kafka.Header{
+		{Key: "traceparent", Value: []byte("00-abc-def-01")},
+		{Key: HeaderOriginalTopic, Value: []byte("feed.created")},
+		{Key: HeaderAttempts, Value: []byte("3")},
+		{Key: HeaderError, Value: []byte("failed")},
+		{Key: HeaderFailedAt, Value: []byte("2026-10-18T00:00:00Z")},
+		{Key: HeaderRetryAt, Value: []byte("2026-10-18T00:00:30Z")},
+	})
+
+	assert.Equal(t, []kafka.Header{
+		{Key: "traceparent", Value: []byte("00-abc-def-01")},
+		{Key: HeaderOriginalTopic, Value: []byte("feed.created")},
+	}, kept)
+}
+
+func TestDeadLetters_ReplayTopic(t *testing.T) {
+	consumer, _ := newTestConsumer(testRetryConfig(), nil)
+	queue := consumer.DeadLetters()
+	assert.Equal(t, "feed.created.notifications.dlq", queue.Topic())
+	assert.Equal(t, "feed.created.notifications.retry.1", queue.replayTopic)
+
+	consumer, _ = newTestConsumer(RetryConfig{Attempts: 1, DeadLetter: true}, nil)
+	assert.Equal(t, "feed.created", consumer.DeadLetters().replayTopic)
+}
@@ -0,0 +1,179 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Payload is the typed data of an event
+type Payload interface {
+	// EventType returns the type of the events carrying the payload
+	EventType() string
+	// SchemaVersion returns the version of the payload's schema
+	SchemaVersion() int
+}
+
+// Upcaster converts an event's data from one schema version to the next
+type Upcaster func(data map[string]interface{}) (map[string]interface{}, error)
+
+// schemaKey identifies a version of an event type's schema
+type schemaKey struct {
+	eventType string
+	version   int
+}
+
+// Registry maps event types and versions to payload types, and holds the
+// upcasters that bring older versions up to date
+type Registry struct {
+	mu        sync.RWMutex
+	types     map[schemaKey]reflect.Type
+	latest    map[string]int
+	upcasters map[schemaKey]Upcaster
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		types:     make(map[schemaKey]reflect.Type),
+		latest:    make(map[string]int),
+		upcasters: make(map[schemaKey]Upcaster),
+	}
+}
+
+// Schemas is the registry of the events in events.go
+var Schemas = NewRegistry()
+
+// Register registers payload type T for its event type and version. It
+// panics if the version is already registered.
+func Register[T Payload](r *Registry) {
+	var payload T
+	key := schemaKey{eventType: payload.EventType(), version: payload.SchemaVersion()}
+	if key.version < 1 {
+		panic(fmt.Sprintf("messaging: %s schema version must be at least 1", key.eventType))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.types[key]; exists {
+		panic(fmt.Sprintf("messaging: %s v%d registered twice", key.eventType, key.version))
+	}
+	r.types[key] = reflect.TypeOf(payload)
+	r.latest[key.eventType] = max(r.latest[key.eventType], key.version)
+}
+
+// RegisterUpcaster registers the upcaster from version from of an event
+// type to version from+1
+func (r *Registry) RegisterUpcaster(eventType string, from int, upcaster Upcaster) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.upcasters[schemaKey{eventType: eventType, version: from}] = upcaster
+}
+
+// Latest returns the latest registered version of an event type, or 0 if
+// there is none
+func (r *Registry) Latest(eventType string) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.latest[eventType]
+}
+
+// Upcast converts an event's data from its version to version to. Events
+// without a version predate versioning and are version 1.
+func (r *Registry) Upcast(eventType string, version int, data json.RawMessage, to int) (json.RawMessage, error) {
+	version = max(version, 1)
+	if version > to {
+		return nil, fmt.Errorf("%w: %s v%d is newer than v%d", ErrUnknownSchema, eventType, version, to)
+	}
+	if version == to {
+		return data, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("unmarshal %s v%d: %w", eventType, version, err)
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for ; version < to; version++ {
+		upcaster, ok := r.upcasters[schemaKey{eventType: eventType, version: version}]
+		if !ok {
+			return nil, fmt.Errorf("%w: no upcaster from %s v%d", ErrUnknownSchema, eventType, version)
+		}
+		var err error
+		if fields, err = upcaster(fields); err != nil {
+			return nil, fmt.Errorf("upcast %s v%d: %w", eventType, version, err)
+		}
+	}
+
+	return json.Marshal(fields)
+}
+
+// Decode decodes an event's data into the latest payload type of its event
+// type
+func (r *Registry) Decode(event Event) (Payload, error) {
+	latest := r.Latest(event.Type)
+	r.mu.RLock()
+	t, ok := r.types[schemaKey{eventType: event.Type, version: latest}]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSchema, event.Type)
+	}
+
+	data, err := r.Upcast(event.Type, event.Version, event.Data, latest)
+	if err != nil {
+		return nil, err
+	}
+	payload := reflect.New(t)
+	if err := json.Unmarshal(data, payload.Interface()); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", event.Type, err)
+	}
+	return payload.Elem().Interface().(Payload), nil
+}
+
+// Decode decodes an event's data into payload type T, upcasting it from an
+// older version
+func Decode[T Payload](event Event) (T, error) {
+	return DecodeWith[T](Schemas, event)
+}
+
+// DecodeWith is Decode with the upcasters of registry r
+func DecodeWith[T Payload](r *Registry, event Event) (T, error) {
+	var payload T
+	if event.Type != payload.EventType() {
+		return payload, fmt.Errorf("%w: event type %s, want %s", ErrUnknownSchema, event.Type, payload.EventType())
+	}
+
+	data, err := r.Upcast(event.Type, event.Version, event.Data, payload.SchemaVersion())
+	if err != nil {
+		return payload, err
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return payload, fmt.Errorf("decode %s: %w", event.Type, err)
+	}
+	return payload, nil
+}
+
+// Handle returns a handler that decodes events into payload type T for fn.
+// Events that do not decode are not retried.
+func Handle[T Payload](fn func(ctx context.Context, event Event, payload T) error) MessageHandler {
+	return func(ctx context.Context, event Event) error {
+		payload, err := Decode[T](event)
+		if err != nil {
+			return Permanent(err)
+		}
+		return fn(ctx, event, payload)
+	}
+}
+
+// Publish publishes an event carrying payload
+func Publish[T Payload](ctx context.Context, p *Producer, topic, key, source string, payload T) error {
+	event, err := NewEvent(source, payload)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, topic, key, event)
+}
@@ -0,0 +1,203 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// samplePattern matches the samples in testdata/events, named
+// <event type>.v<version>.json
+var samplePattern = regexp.MustCompile(`^(.+)\.v(\d+)\.json$`)
+
+// loadSamples returns the sample data of every event type and version
+func loadSamples(t *testing.T) map[schemaKey]json.RawMessage {
+	files, err := filepath.Glob(filepath.Join("testdata", "events", "*.json"))
+	require.NoError(t, err)
+
+	samples := make(map[schemaKey]json.RawMessage)
+	for _, file := range files {
+		match := samplePattern.FindStringSubmatch(filepath.Base(file))
+		require.NotNil(t, match, "sample %s must be named <event type>.v<version>.json", file)
+		version, err := strconv.Atoi(match[2])
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		samples[schemaKey{eventType: match[1], version: version}] = data
+	}
+	return samples
+}
+
+// TestSchemas_SamplesCoverEveryVersion fails when a schema version is added
+// without a sample, so its compatibility is checked from then on
+func TestSchemas_SamplesCoverEveryVersion(t *testing.T) {
+	samples := loadSamples(t)
+
+	for eventType, latest := range Schemas.latest {
+		for version := 1; version <= latest; version++ {
+			assert.Contains(t, samples, schemaKey{eventType: eventType, version: version},
+				"add testdata/events/%s.v%d.json", eventType, version)
+		}
+	}
+	for key := range samples {
+		assert.NotZero(t, Schemas.Latest(key.eventType), "no schema registered for sample %s", key.eventType)
+	}
+}
+
+// TestSchemas_SamplesStillDecode fails when a schema changes incompatibly:
+// when a field that events carry is renamed, removed or retyped without a
+// new version and an upcaster
+func TestSchemas_SamplesStillDecode(t *testing.T) {
+	for key, sample := range loadSamples(t) {
+		t.Run(fmt.Sprintf("%s.v%d", key.eventType, key.version), func(t *testing.T) {
+			latest := Schemas.Latest(key.eventType)
+			require.NotZero(t, latest)
+
+			data, err := Schemas.Upcast(key.eventType, key.version, sample, latest)
+			require.NoError(t, err)
+
+			payload := reflect.New(Schemas.types[schemaKey{eventType: key.eventType, version: latest}])
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			require.NoError(t, decoder.Decode(payload.Interface()), "%s v%d no longer decodes", key.eventType, key.version)
+
+			// Every field survives the round trip
+			encoded, err := json.Marshal(payload.Interface())
+			require.NoError(t, err)
+			var want, got map[string]interface{}
+			require.NoError(t, json.Unmarshal(data, &want))
+			require.NoError(t, json.Unmarshal(encoded, &got))
+			for field, value := range want {
+				assert.Equal(t, value, got[field], "field %s", field)
+			}
+
+			// A field added to the latest version must be optional, as
+			// events published before it do not carry it
+			if key.version == latest {
+				for field := range got {
+					assert.Contains(t, want, field, "field %s was added without a new version; make it omitempty or add it to the sample", field)
+				}
+			}
+		})
+	}
+}
+
+// Test payloads for a schema that renamed a field in version 2
+type testRenamedV2 struct {
+	Name string `json:"name"`
+}
+
+func (testRenamedV2) EventType() string { return "test.renamed" }
+
+func (testRenamedV2) SchemaVersion() int { return 2 }
+
+func newTestRegistry() *Registry {
+	r := NewRegistry()
+	Register[testRenamedV2](r)
+	r.RegisterUpcaster("test.renamed", 1, func(data map[string]interface{}) (map[string]interface{}, error) {
+		data["name"] = data["title"]
+		delete(data, "title")
+		return data, nil
+	})
+	return r
+}
+
+func TestDecodeWith_Upcasts(t *testing.T) {
+	r := newTestRegistry()
+
+	for _, version := range []int{0, 1} {
+		payload, err := DecodeWith[testRenamedV2](r, Event{
+			Type:    "test.renamed",
+			Version: version,
+			Data:    json.RawMessage(`{"title":"Alice"}`),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Alice", payload.Name)
+	}
+
+	payload, err := DecodeWith[testRenamedV2](r, Event{
+		Type:    "test.renamed",
+		Version: 2,
+		Data:    json.RawMessage(`{"name":"Bob"}`),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Bob", payload.Name)
+}
+
+func TestDecodeWith_UnknownSchemas(t *testing.T) {
+	r := newTestRegistry()
+
+	_, err := DecodeWith[testRenamedV2](r, Event{Type: "test.renamed", Version: 3, Data: json.RawMessage(`{}`)})
+	assert.ErrorIs(t, err, ErrUnknownSchema)
+
+	_, err = DecodeWith[testRenamedV2](r, Event{Type: "user.created", Data: json.RawMessage(`{}`)})
+	assert.ErrorIs(t, err, ErrUnknownSchema)
+
+	r = NewRegistry()
+	Register[testRenamedV2](r)
+	_, err = DecodeWith[testRenamedV2](r, Event{Type: "test.renamed", Version: 1, Data: json.RawMessage(`{}`)})
+	assert.ErrorIs(t, err, ErrUnknownSchema)
+}
+
+func TestRegistry_Decode(t *testing.T) {
+	r := newTestRegistry()
+
+	payload, err := r.Decode(Event{Type: "test.renamed", Version: 1, Data: json.RawMessage(`{"title":"Alice"}`)})
+	require.NoError(t, err)
+	assert.Equal(t, testRenamedV2{Name: "Alice"}, payload)
+
+	_, err = r.Decode(Event{Type: "test.unknown", Data: json.RawMessage(`{}`)})
+	assert.ErrorIs(t, err, ErrUnknownSchema)
+}
+
+func TestRegister_PanicsOnDuplicate(t *testing.T) {
+	r := NewRegistry()
+	Register[testRenamedV2](r)
+	assert.Panics(t, func() { Register[testRenamedV2](r) })
+}
+
+func TestNewEvent(t *testing.T) {
+	event, err := NewEvent("auth-service", UserCreated{UserID: "1", Email: "alice@example.com"})
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, event.ID)
+	assert.Equal(t, TopicUserCreated, event.Type)
+	assert.Equal(t, 1, event.Version)
+	assert.JSONEq(t, `{"user_id":"1","email":"alice@example.com"}`, string(event.Data))
+
+	user, err := Decode[UserCreated](event)
+	require.NoError(t, err)
+	assert.Equal(t, UserCreated{UserID: "1", Email: "alice@example.com"}, user)
+}
+
+func TestHandle(t *testing.T) {
+	var got FeedCreated
+	handler := Handle(func(ctx context.Context, event Event, feed FeedCreated) error {
+		got = feed
+		return nil
+	})
+
+	event, err := NewEvent("feed-service", FeedCreated{FeedID: "1", UserID: "2", Caption: "Sunset"})
+	require.NoError(t, err)
+	require.NoError(t, handler(context.Background(), event))
+	assert.Equal(t, FeedCreated{FeedID: "1", UserID: "2", Caption: "Sunset"}, got)
+
+	// Events that do not decode are not retried
+	err = handler(context.Background(), Event{Type: TopicFeedCreated, Data: json.RawMessage(`{"feed_id":1}`)})
+	assert.True(t, IsPermanent(err))
+	err = handler(context.Background(), Event{Type: TopicUserCreated, Data: json.RawMessage(`{}`)})
+	assert.True(t, IsPermanent(err))
+	assert.True(t, errors.Is(err, ErrUnknownSchema))
+}
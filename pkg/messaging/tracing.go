@@ -0,0 +1,111 @@
+package messaging
+
+import (
+	"context"
+	"maps"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common/requestid"
+	"github.com/Femi-lawal/udagram-app/pkg/telemetry"
+)
+
+// MetadataRequestID is the event metadata holding the ID of the request
+// that led to the event
+const MetadataRequestID = "request_id"
+
+// headerCarrier carries trace context in message headers, such as the W3C
+// traceparent and baggage headers
+type headerCarrier struct {
+	headers *[]kafka.Header
+}
+
+func (c headerCarrier) Get(key string) string {
+	for i := len(*c.headers) - 1; i >= 0; i-- {
+		if (*c.headers)[i].Key == key {
+			return string((*c.headers)[i].Value)
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, h := range *c.headers {
+		if h.Key == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, len(*c.headers))
+	for i, h := range *c.headers {
+		keys[i] = h.Key
+	}
+	return keys
+}
+
+// injectTrace writes the trace context in ctx to the message's headers
+func injectTrace(ctx context.Context, msg *kafka.Message) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{headers: &msg.Headers})
+}
+
+// extractTrace returns ctx with the trace context in the message's headers
+func extractTrace(ctx context.Context, msg kafka.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier{headers: &msg.Headers})
+}
+
+// stampRequestID records the request ID in ctx in the event's metadata,
+// unless it has one already
+func stampRequestID(ctx context.Context, event *Event) {
+	requestID := requestid.FromContext(ctx)
+	if requestID == "" || event.Metadata[MetadataRequestID] != "" {
+		return
+	}
+	// The caller's map is left as it was
+	metadata := make(map[string]string, len(event.Metadata)+1)
+	maps.Copy(metadata, event.Metadata)
+	metadata[MetadataRequestID] = requestID
+	event.Metadata = metadata
+}
+
+// receiveSpan starts the span for handling a message, continuing the trace
+// it was published in
+func (c *Consumer) receiveSpan(ctx context.Context, msg kafka.Message) (context.Context, trace.Span) {
+	ctx, span := telemetry.KafkaConsumerSpan(extractTrace(ctx, msg), c.topic)
+	span.SetAttributes(
+		attribute.String("messaging.kafka.consumer.group", c.groupID),
+		attribute.String("messaging.kafka.source.topic", msg.Topic),
+		attribute.Int("messaging.kafka.partition", msg.Partition),
+		attribute.Int64("messaging.kafka.message.offset", msg.Offset),
+	)
+	return ctx, span
+}
+
+// eventContext returns ctx for handling an event: carrying the ID of the
+// request that led to it, so events the handler publishes carry it too
+func eventContext(ctx context.Context, event Event) context.Context {
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("messaging.message.id", event.ID),
+		attribute.String("messaging.event.type", event.Type),
+	)
+	if requestID := event.Metadata[MetadataRequestID]; requestID != "" {
+		ctx = requestid.NewContext(ctx, requestID)
+	}
+	return ctx
+}
+
+// endSpan ends a span, recording err if it is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
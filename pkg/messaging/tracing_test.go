@@ -0,0 +1,157 @@
+package messaging
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common/requestid"
+	"github.com/Femi-lawal/udagram-app/pkg/telemetry"
+)
+
+// recordSpans installs a tracer provider recording spans for the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+	return recorder
+}
+
+func TestHeaderCarrier(t *testing.T) {
+	headers := []kafka.Header{{Key: "traceparent", Value: []byte("old")}, {Key: "event_id", Value: []byte("1")}}
+	carrier := headerCarrier{headers: &headers}
+
+	carrier.Set("traceparent", "new")
+	carrier.Set("baggage", "user=1")
+
+	assert.Equal(t, "new", carrier.Get("traceparent"))
+	assert.Equal(t, "user=1", carrier.Get("baggage"))
+	assert.Equal(t, "", carrier.Get("missing"))
+	assert.Equal(t, []string{"traceparent", "event_id", "baggage"}, carrier.Keys())
+}
+
+func TestPublish_PropagatesContext(t *testing.T) {
+	recorder := recordSpans(t)
+	broker := NewMemoryBroker(1)
+	producer := NewProducer(Config{Broker: broker}, zap.NewNop())
+
+	member, err := baggage.NewMember("tenant", "udagram")
+	require.NoError(t, err)
+	bag, err := baggage.New(member)
+	require.NoError(t, err)
+
+	ctx := requestid.NewContext(baggage.ContextWithBaggage(context.Background(), bag), "req-123")
+	ctx, span := telemetry.StartSpan(ctx, "POST /api/v1/auth/register")
+	require.NoError(t, Publish(ctx, producer, TopicUserCreated, "user-1", "auth-service", UserCreated{UserID: "user-1"}))
+	span.End()
+
+	msgs, err := broker.read(context.Background(), TopicUserCreated, 0, 0, 1)
+	require.NoError(t, err)
+	require.Len(t, msgs, 1)
+
+	event, err := decodeEvent(msgs[0])
+	require.NoError(t, err)
+	assert.Equal(t, "req-123", event.Metadata[MetadataRequestID])
+
+	received := extractTrace(context.Background(), msgs[0])
+	assert.Equal(t, span.SpanContext().TraceID(), trace.SpanContextFromContext(received).TraceID())
+	assert.Equal(t, "udagram", baggage.FromContext(received).Member("tenant").Value())
+
+	// The message's parent is the producer span, a child of the request's
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "kafka.produce", spans[0].Name())
+	assert.Equal(t, trace.SpanKindProducer, spans[0].SpanKind())
+	assert.Equal(t, span.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, spans[0].SpanContext().SpanID(), trace.SpanContextFromContext(received).SpanID())
+}
+
+// A registration request traced to the welcome notification: the request's
+// trace and ID carry through user.created to the notification it leads to
+func TestConsumer_ContinuesTrace(t *testing.T) {
+	recorder := recordSpans(t)
+	broker := NewMemoryBroker(1)
+	cfg := Config{Broker: broker, Retry: RetryConfig{Attempts: 1}}
+	logger := zap.NewNop()
+	producer := NewProducer(cfg, logger)
+
+	welcome := NewConsumer(cfg, TopicUserCreated, "notification-group", logger,
+		Handle(func(ctx context.Context, event Event, user UserCreated) error {
+			return Publish(ctx, producer, TopicNotification, user.UserID, "notification-service",
+				NotificationRequested{UserID: user.UserID, Type: "welcome"})
+		}),
+	)
+	defer welcome.Close()
+
+	type delivery struct {
+		ctx   context.Context
+		event Event
+	}
+	delivered := make(chan delivery, 1)
+	notifications := NewConsumer(cfg, TopicNotification, "notification-group", logger,
+		func(ctx context.Context, event Event) error {
+			delivered <- delivery{ctx: ctx, event: event}
+			return nil
+		},
+	)
+	defer notifications.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, consumer := range []*Consumer{welcome, notifications} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = consumer.Start(ctx)
+		}()
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	reqCtx, span := telemetry.StartSpan(requestid.NewContext(context.Background(), "req-123"), "POST /api/v1/auth/register")
+	require.NoError(t, Publish(reqCtx, producer, TopicUserCreated, "user-1", "auth-service", UserCreated{UserID: "user-1"}))
+	span.End()
+
+	var got delivery
+	select {
+	case got = <-delivered:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no welcome notification was delivered")
+	}
+
+	assert.Equal(t, "req-123", got.event.Metadata[MetadataRequestID])
+	assert.Equal(t, "req-123", requestid.FromContext(got.ctx))
+	assert.Equal(t, span.SpanContext().TraceID(), trace.SpanContextFromContext(got.ctx).TraceID())
+
+	var consumed int
+	for _, s := range recorder.Started() {
+		if s.Name() == "kafka.consume" {
+			consumed++
+			assert.Equal(t, trace.SpanKindConsumer, s.SpanKind())
+			assert.Equal(t, span.SpanContext().TraceID(), s.Parent().TraceID())
+		}
+	}
+	assert.Equal(t, 2, consumed)
+}
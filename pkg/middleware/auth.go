@@ -24,12 +24,21 @@ type JWTConfig struct {
 	Audience      string
 	AccessExpiry  time.Duration
 	RefreshExpiry time.Duration
+	// QueryParam, when set, names a query parameter that may carry the token
+	// on WebSocket upgrades and event streams, since browsers cannot set
+	// headers on those requests. It is removed from the URL once read.
+	QueryParam string
 }
 
 // JWTMiddleware creates a JWT authentication middleware
 func JWTMiddleware(config JWTConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			if token := queryToken(c, config.QueryParam); token != "" {
+				authHeader = "Bearer " + token
+			}
+		}
 		if authHeader == "" {
 			common.UnauthorizedResponse(c, "missing authorization header")
 			c.Abort()
@@ -86,6 +95,47 @@ func JWTMiddleware(config JWTConfig) gin.HandlerFunc {
 	}
 }
 
+// queryToken returns the token in the named query parameter of a streaming
+// request and removes it from the URL so that it is neither logged nor
+// forwarded
+func queryToken(c *gin.Context, param string) string {
+	if param == "" || !IsStreamRequest(c.Request) {
+		return ""
+	}
+
+	query := c.Request.URL.Query()
+	token := query.Get(param)
+	if token == "" {
+		return ""
+	}
+	query.Del(param)
+	c.Request.URL.RawQuery = query.Encode()
+	return token
+}
+
+// IsStreamRequest reports whether the request asks for a protocol upgrade,
+// such as WebSocket, or a server-sent event stream
+func IsStreamRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" && headerHasToken(r.Header, "Connection", "upgrade") {
+		return true
+	}
+	return headerHasToken(r.Header, "Accept", "text/event-stream")
+}
+
+// headerHasToken reports whether a comma-separated header lists token,
+// ignoring case and parameters
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, field := range strings.Split(value, ",") {
+			field, _, _ = strings.Cut(field, ";")
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // OptionalJWTMiddleware validates JWT if present but doesn't require it
 func OptionalJWTMiddleware(config JWTConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -58,6 +58,45 @@ func TestJWTMiddleware_MissingToken(t *testing.T) {
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
 }
 
+func TestJWTMiddleware_QueryToken(t *testing.T) {
+	config := JWTConfig{
+		Secret:       "test-secret",
+		Issuer:       "udagram",
+		AccessExpiry: time.Hour,
+		QueryParam:   "access_token",
+	}
+
+	token, err := GenerateAccessToken(config, "user-123", "test@example.com")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		allowed bool
+	}{
+		{"websocket", http.Header{"Connection": {"keep-alive, Upgrade"}, "Upgrade": {"websocket"}}, true},
+		{"event stream", http.Header{"Accept": {"text/event-stream"}}, true},
+		{"plain request", http.Header{"Accept": {"application/json"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest("GET", "/stream?access_token="+token+"&topic=feed", nil)
+			c.Request.Header = tt.header
+
+			JWTMiddleware(config)(c)
+
+			assert.Equal(t, !tt.allowed, c.IsAborted())
+			if tt.allowed {
+				assert.Equal(t, "user-123", c.GetString("user_id"))
+				assert.Equal(t, "topic=feed", c.Request.URL.RawQuery)
+			}
+		})
+	}
+}
+
 func TestJWTMiddleware_InvalidToken(t *testing.T) {
 	config := JWTConfig{
 		Secret:       "test-secret",
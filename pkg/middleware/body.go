@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+)
+
+// strictJSONKey marks requests whose JSON bodies are decoded strictly
+const strictJSONKey = "strict_json"
+
+// BodyLimitConfig holds request body configuration
+type BodyLimitConfig struct {
+	// MaxBytes applies to every route without an override
+	MaxBytes int64
+	// Routes overrides MaxBytes per route, keyed by "METHOD /route/pattern" as
+	// registered with gin. A zero or negative value disables the limit.
+	Routes map[string]int64
+	// ContentTypes lists the media types accepted for request bodies. Empty
+	// accepts any content type.
+	ContentTypes []string
+	// Strict makes BindJSON reject unknown fields and duplicate keys
+	Strict bool
+}
+
+// DefaultBodyLimitConfig returns default body limit configuration for JSON APIs
+func DefaultBodyLimitConfig() BodyLimitConfig {
+	return BodyLimitConfig{
+		MaxBytes:     64 << 10,
+		ContentTypes: []string{"application/json"},
+		Strict:       true,
+	}
+}
+
+// limitFor returns the body limit for the matched route
+func (cfg BodyLimitConfig) limitFor(c *gin.Context) int64 {
+	if limit, ok := cfg.Routes[c.Request.Method+" "+c.FullPath()]; ok {
+		return limit
+	}
+	return cfg.MaxBytes
+}
+
+// BodyLimitMiddleware caps request body size and enforces the request content
+// type. Bodies that declare a length over the limit are rejected up front;
+// bodies sent without a length fail with 413 when read past the limit.
+func BodyLimitMiddleware(cfg BodyLimitConfig) gin.HandlerFunc {
+	allowed := make(map[string]bool, len(cfg.ContentTypes))
+	for _, contentType := range cfg.ContentTypes {
+		allowed[strings.ToLower(contentType)] = true
+	}
+
+	return func(c *gin.Context) {
+		if cfg.Strict {
+			c.Set(strictJSONKey, true)
+		}
+
+		if c.Request.Body == nil || c.Request.Body == http.NoBody || c.Request.ContentLength == 0 {
+			c.Next()
+			return
+		}
+
+		if len(allowed) > 0 {
+			mediaType, _, err := mime.ParseMediaType(c.GetHeader("Content-Type"))
+			if err != nil || !allowed[mediaType] {
+				common.UnsupportedMediaTypeResponse(c, fmt.Sprintf("content type must be %s", strings.Join(cfg.ContentTypes, " or ")))
+				c.Abort()
+				return
+			}
+		}
+
+		limit := cfg.limitFor(c)
+		if limit > 0 {
+			if c.Request.ContentLength > limit {
+				common.PayloadTooLargeResponse(c, fmt.Sprintf("request body must not exceed %d bytes", limit))
+				c.Abort()
+				return
+			}
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+
+		c.Next()
+	}
+}
+
+// BindJSON decodes the request body into obj and validates it. On failure it
+// writes a 400 or 413 response and returns false. Requests that passed
+// through BodyLimitMiddleware in strict mode also reject unknown fields,
+// duplicate keys and trailing data.
+func BindJSON(c *gin.Context, obj interface{}) bool {
+	if err := decodeJSON(c, obj); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			common.PayloadTooLargeResponse(c, fmt.Sprintf("request body must not exceed %d bytes", maxBytesErr.Limit))
+		} else {
+			common.BadRequestResponse(c, err.Error())
+		}
+		return false
+	}
+
+	if err := binding.Validator.ValidateStruct(obj); err != nil {
+		common.BadRequestResponse(c, validationMessage(obj, err))
+		return false
+	}
+
+	return true
+}
+
+func decodeJSON(c *gin.Context, obj interface{}) error {
+	if c.Request.Body == nil {
+		return errors.New("request body is required")
+	}
+
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return errors.New("request body is required")
+	}
+
+	if !c.GetBool(strictJSONKey) {
+		return jsonError(json.Unmarshal(data, obj))
+	}
+
+	if err := checkDuplicateKeys(json.NewDecoder(bytes.NewReader(data))); err != nil {
+		return jsonError(err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(obj); err != nil {
+		return jsonError(err)
+	}
+	if dec.More() {
+		return errors.New("request body must contain a single JSON value")
+	}
+
+	return nil
+}
+
+// checkDuplicateKeys walks the next JSON value and fails on any object that
+// repeats a key, which encoding/json would otherwise resolve silently
+func checkDuplicateKeys(dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return nil
+	}
+
+	switch delim {
+	case '{':
+		keys := make(map[string]bool)
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key := tok.(string)
+			if keys[key] {
+				return fmt.Errorf("duplicate key %q", key)
+			}
+			keys[key] = true
+
+			if err := checkDuplicateKeys(dec); err != nil {
+				return err
+			}
+		}
+	case '[':
+		for dec.More() {
+			if err := checkDuplicateKeys(dec); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Consume the closing delimiter
+	_, err = dec.Token()
+	return err
+}
+
+// jsonError turns decoding errors into messages safe to return to clients
+func jsonError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return err
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return errors.New("malformed JSON")
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("invalid value for %s", typeErr.Field)
+	case strings.HasPrefix(err.Error(), "json: unknown field"):
+		return errors.New(strings.TrimPrefix(err.Error(), "json: "))
+	case strings.HasPrefix(err.Error(), "duplicate key"):
+		return err
+	default:
+		return errors.New("invalid request body")
+	}
+}
+
+// validationMessage describes the first validation failure using the JSON
+// field name
+func validationMessage(obj interface{}, err error) string {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) || len(validationErrs) == 0 {
+		return "invalid request body"
+	}
+
+	fe := validationErrs[0]
+	field := jsonFieldName(obj, fe.StructField())
+
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", field)
+	case "max":
+		return fmt.Sprintf("%s must be at most %s", field, boundDescription(fe))
+	case "min":
+		return fmt.Sprintf("%s must be at least %s", field, boundDescription(fe))
+	case "email":
+		return fmt.Sprintf("%s must be a valid email address", field)
+	default:
+		return fmt.Sprintf("%s is invalid", field)
+	}
+}
+
+// boundDescription describes a min or max bound in the field's terms: a length
+// for strings, an item count for collections and a value for numbers
+func boundDescription(fe validator.FieldError) string {
+	switch fe.Kind() {
+	case reflect.String:
+		return fe.Param() + " characters"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return fe.Param() + " items"
+	default:
+		return fe.Param()
+	}
+}
+
+func jsonFieldName(obj interface{}, structField string) string {
+	t := reflect.TypeOf(obj)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return structField
+	}
+
+	f, ok := t.FieldByName(structField)
+	if !ok {
+		return structField
+	}
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return structField
+	}
+	return name
+}
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCreateRequest struct {
+	Caption string `json:"caption" binding:"required,max=10"`
+	URL     string `json:"url"`
+}
+
+func newBodyLimitRouter(cfg BodyLimitConfig) *gin.Engine {
+	router := gin.New()
+	router.Use(BodyLimitMiddleware(cfg))
+	handler := func(c *gin.Context) {
+		var req testCreateRequest
+		if !BindJSON(c, &req) {
+			return
+		}
+		c.JSON(http.StatusCreated, req)
+	}
+	router.POST("/items", handler)
+	router.POST("/uploads", handler)
+	return router
+}
+
+func postJSON(router http.Handler, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestBindJSON_Valid(t *testing.T) {
+	router := newBodyLimitRouter(DefaultBodyLimitConfig())
+
+	w := postJSON(router, "/items", `{"caption":"hello","url":"a.jpg"}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"caption":"hello","url":"a.jpg"}`, w.Body.String())
+}
+
+func TestBindJSON_StrictMode(t *testing.T) {
+	router := newBodyLimitRouter(DefaultBodyLimitConfig())
+
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{"unknown field", `{"caption":"hi","admin":true}`, `unknown field \"admin\"`},
+		{"duplicate key", `{"caption":"hi","caption":"bye"}`, `duplicate key \"caption\"`},
+		{"nested duplicate key", `{"caption":"hi","url":"a","x":[{"a":1,"a":2}]}`, `duplicate key \"a\"`},
+		{"trailing data", `{"caption":"hi"}{"caption":"bye"}`, "single JSON value"},
+		{"malformed", `{"caption":`, "malformed JSON"},
+		{"wrong type", `{"caption":5}`, "invalid value for caption"},
+		{"empty", ``, "request body is required"},
+		{"missing field", `{"url":"a.jpg"}`, "caption is required"},
+		{"too long", `{"caption":"this caption is too long"}`, "caption must be at most 10 characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postJSON(router, "/items", tt.body)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.message)
+		})
+	}
+}
+
+func TestValidationMessage_Bounds(t *testing.T) {
+	type bounded struct {
+		Name   string            `json:"name" binding:"omitempty,min=2,max=5"`
+		Tags   []string          `json:"tags" binding:"omitempty,max=2"`
+		Params map[string]string `json:"params" binding:"omitempty,max=1"`
+		Count  int               `json:"count" binding:"min=1,max=10"`
+	}
+	type fixed struct {
+		Slots [3]int `json:"slots" binding:"max=2"`
+	}
+
+	tests := []struct {
+		name    string
+		req     any
+		message string
+	}{
+		{"string too short", &bounded{Name: "a", Count: 1}, "name must be at least 2 characters"},
+		{"string too long", &bounded{Name: "abcdef", Count: 1}, "name must be at most 5 characters"},
+		{"slice", &bounded{Tags: []string{"a", "b", "c"}, Count: 1}, "tags must be at most 2 items"},
+		{"map", &bounded{Params: map[string]string{"a": "1", "b": "2"}, Count: 1}, "params must be at most 1 items"},
+		{"array", &fixed{}, "slots must be at most 2 items"},
+		{"number too small", &bounded{Count: 0}, "count must be at least 1"},
+		{"number too large", &bounded{Count: 11}, "count must be at most 10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := binding.Validator.ValidateStruct(tt.req)
+			assert.Equal(t, tt.message, validationMessage(tt.req, err))
+		})
+	}
+}
+
+func TestBindJSON_LenientMode(t *testing.T) {
+	cfg := DefaultBodyLimitConfig()
+	cfg.Strict = false
+	router := newBodyLimitRouter(cfg)
+
+	w := postJSON(router, "/items", `{"caption":"hi","admin":true}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+}
+
+func TestBodyLimitMiddleware_ContentLengthTooLarge(t *testing.T) {
+	cfg := DefaultBodyLimitConfig()
+	cfg.MaxBytes = 16
+	router := newBodyLimitRouter(cfg)
+
+	w := postJSON(router, "/items", `{"caption":"hello","url":"a.jpg"}`)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "PAYLOAD_TOO_LARGE")
+}
+
+func TestBodyLimitMiddleware_ChunkedTooLarge(t *testing.T) {
+	cfg := DefaultBodyLimitConfig()
+	cfg.MaxBytes = 16
+	router := newBodyLimitRouter(cfg)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/items", io.NopCloser(strings.NewReader(`{"caption":"hello","url":"a.jpg"}`)))
+	req.ContentLength = -1
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestBodyLimitMiddleware_PerRouteLimits(t *testing.T) {
+	cfg := DefaultBodyLimitConfig()
+	cfg.MaxBytes = 16
+	cfg.Routes = map[string]int64{"POST /uploads": 1 << 10}
+	router := newBodyLimitRouter(cfg)
+
+	body := `{"caption":"hello","url":"a.jpg"}`
+	assert.Equal(t, http.StatusRequestEntityTooLarge, postJSON(router, "/items", body).Code)
+	assert.Equal(t, http.StatusCreated, postJSON(router, "/uploads", body).Code)
+}
+
+func TestBodyLimitMiddleware_ContentType(t *testing.T) {
+	router := newBodyLimitRouter(DefaultBodyLimitConfig())
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/items", strings.NewReader(`caption=hello`))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/items", strings.NewReader(`{"caption":"hello"}`))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code, "missing content type")
+}
@@ -1,30 +1,85 @@
 package middleware
 
 import (
+	"math"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sony/gobreaker"
+	"go.uber.org/zap"
 )
 
-// CircuitBreakerMiddleware wraps HTTP calls with circuit breaker pattern
-func CircuitBreakerMiddleware(name string, maxRequests uint32, interval, timeout time.Duration) gin.HandlerFunc {
-	settings := gobreaker.Settings{
-		Name:        name,
-		MaxRequests: maxRequests,
-		Interval:    interval,
-		Timeout:     timeout,
+// Metrics
+var (
+	circuitBreakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "circuit_breaker_state",
+			Help: "Circuit breaker state (0=closed, 1=half-open, 2=open)",
+		},
+		[]string{"name"},
+	)
+)
+
+// CircuitBreakerConfig holds circuit breaker configuration
+type CircuitBreakerConfig struct {
+	Name         string
+	MaxRequests  uint32
+	Interval     time.Duration
+	Timeout      time.Duration
+	MinRequests  uint32
+	FailureRatio float64
+}
+
+// DefaultCircuitBreakerConfig returns default circuit breaker configuration
+func DefaultCircuitBreakerConfig(name string) CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		Name:         name,
+		MaxRequests:  1,
+		Interval:     time.Minute,
+		Timeout:      30 * time.Second,
+		MinRequests:  3,
+		FailureRatio: 0.6,
+	}
+}
+
+// Settings builds gobreaker settings that log state changes and export the
+// breaker state as a gauge
+func (cfg CircuitBreakerConfig) Settings(logger *zap.Logger) gobreaker.Settings {
+	circuitBreakerState.WithLabelValues(cfg.Name).Set(float64(gobreaker.StateClosed))
+
+	return gobreaker.Settings{
+		Name:        cfg.Name,
+		MaxRequests: cfg.MaxRequests,
+		Interval:    cfg.Interval,
+		Timeout:     cfg.Timeout,
 		ReadyToTrip: func(counts gobreaker.Counts) bool {
 			failureRatio := float64(counts.TotalFailures) / float64(counts.Requests)
-			return counts.Requests >= 3 && failureRatio >= 0.6
+			return counts.Requests >= cfg.MinRequests && failureRatio >= cfg.FailureRatio
 		},
 		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			// Log state changes
+			circuitBreakerState.WithLabelValues(name).Set(float64(to))
+
+			fields := []zap.Field{
+				zap.String("breaker", name),
+				zap.String("from", from.String()),
+				zap.String("to", to.String()),
+			}
+			if to == gobreaker.StateOpen {
+				logger.Warn("circuit breaker opened", fields...)
+			} else {
+				logger.Info("circuit breaker state changed", fields...)
+			}
 		},
 	}
+}
 
-	cb := gobreaker.NewCircuitBreaker(settings)
+// CircuitBreakerMiddleware wraps HTTP calls with circuit breaker pattern
+func CircuitBreakerMiddleware(cfg CircuitBreakerConfig, logger *zap.Logger) gin.HandlerFunc {
+	cb := gobreaker.NewCircuitBreaker(cfg.Settings(logger))
 
 	return func(c *gin.Context) {
 		_, err := cb.Execute(func() (interface{}, error) {
@@ -59,22 +114,82 @@ func (e *circuitError) Error() string {
 	return "circuit breaker triggered"
 }
 
-// RetryMiddleware implements retry logic for transient failures
-type RetryConfig struct {
-	MaxRetries  int
-	InitialWait time.Duration
-	MaxWait     time.Duration
-	Multiplier  float64
+// IsIdempotentMethod reports whether requests with the method can safely be
+// retried
+func IsIdempotentMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryBudget caps retries to a fraction of recent requests so that retries
+// cannot multiply load on a struggling upstream. Every request deposits Ratio
+// tokens, every retry withdraws one, and MinPerSecond tokens are added each
+// second so low-traffic callers can still retry.
+type RetryBudget struct {
+	mu           sync.Mutex
+	ratio        float64
+	minPerSecond float64
+	maxTokens    float64
+	tokens       float64
+	last         time.Time
+}
+
+// RetryBudgetConfig holds retry budget configuration
+type RetryBudgetConfig struct {
+	Ratio        float64
+	MinPerSecond float64
+}
+
+// DefaultRetryBudgetConfig returns default retry budget configuration
+func DefaultRetryBudgetConfig() RetryBudgetConfig {
+	return RetryBudgetConfig{
+		Ratio:        0.2,
+		MinPerSecond: 10,
+	}
+}
+
+// NewRetryBudget creates a new retry budget
+func NewRetryBudget(cfg RetryBudgetConfig) *RetryBudget {
+	maxTokens := math.Max(cfg.MinPerSecond*10, 10)
+	return &RetryBudget{
+		ratio:        cfg.Ratio,
+		minPerSecond: cfg.MinPerSecond,
+		maxTokens:    maxTokens,
+		tokens:       maxTokens,
+		last:         time.Now(),
+	}
+}
+
+// Deposit records a request against the budget
+func (b *RetryBudget) Deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens = math.Min(b.tokens+b.ratio, b.maxTokens)
 }
 
-// DefaultRetryConfig returns default retry configuration
-func DefaultRetryConfig() RetryConfig {
-	return RetryConfig{
-		MaxRetries:  3,
-		InitialWait: 100 * time.Millisecond,
-		MaxWait:     2 * time.Second,
-		Multiplier:  2.0,
+// Withdraw reports whether a retry is allowed, consuming budget if so
+func (b *RetryBudget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
 	}
+	b.tokens--
+	return true
+}
+
+func (b *RetryBudget) refill() {
+	now := time.Now()
+	b.tokens = math.Min(b.tokens+now.Sub(b.last).Seconds()*b.minPerSecond, b.maxTokens)
+	b.last = now
 }
 
 // HealthCheck returns a simple health check handler
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sony/gobreaker"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestCircuitBreakerMiddleware_OpensAfterFailures(t *testing.T) {
+	cfg := DefaultCircuitBreakerConfig("test-middleware")
+	router := gin.New()
+	router.Use(CircuitBreakerMiddleware(cfg, zap.NewNop()))
+	calls := 0
+	router.GET("/", func(c *gin.Context) {
+		calls++
+		c.Status(http.StatusInternalServerError)
+	})
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, float64(gobreaker.StateOpen), testutil.ToFloat64(circuitBreakerState.WithLabelValues("test-middleware")))
+}
+
+func TestCircuitBreakerSettings_StateGauge(t *testing.T) {
+	cfg := DefaultCircuitBreakerConfig("test-gauge")
+	cfg.Timeout = 10 * time.Millisecond
+	cb := gobreaker.NewCircuitBreaker(cfg.Settings(zap.NewNop()))
+	assert.Equal(t, float64(gobreaker.StateClosed), testutil.ToFloat64(circuitBreakerState.WithLabelValues("test-gauge")))
+
+	for i := 0; i < 3; i++ {
+		_, _ = cb.Execute(func() (interface{}, error) { return nil, assert.AnError })
+	}
+	assert.Equal(t, float64(gobreaker.StateOpen), testutil.ToFloat64(circuitBreakerState.WithLabelValues("test-gauge")))
+
+	time.Sleep(20 * time.Millisecond)
+	_, _ = cb.Execute(func() (interface{}, error) { return nil, nil })
+	assert.Equal(t, float64(gobreaker.StateClosed), testutil.ToFloat64(circuitBreakerState.WithLabelValues("test-gauge")))
+}
+
+func TestIsIdempotentMethod(t *testing.T) {
+	assert.True(t, IsIdempotentMethod(http.MethodGet))
+	assert.True(t, IsIdempotentMethod(http.MethodPut))
+	assert.True(t, IsIdempotentMethod(http.MethodDelete))
+	assert.False(t, IsIdempotentMethod(http.MethodPost))
+	assert.False(t, IsIdempotentMethod(http.MethodPatch))
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := NewRetryBudget(RetryBudgetConfig{Ratio: 0.5, MinPerSecond: 0})
+
+	// Starts with a full bucket of 10 tokens
+	for i := 0; i < 10; i++ {
+		assert.True(t, budget.Withdraw())
+	}
+	assert.False(t, budget.Withdraw())
+
+	// Two requests earn one retry
+	budget.Deposit()
+	assert.False(t, budget.Withdraw())
+	budget.Deposit()
+	assert.True(t, budget.Withdraw())
+}
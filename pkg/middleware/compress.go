@@ -0,0 +1,316 @@
+package middleware
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics
+var (
+	httpResponsesCompressed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_responses_compressed_total",
+			Help: "Total number of compressed HTTP responses by encoding",
+		},
+		[]string{"encoding"},
+	)
+)
+
+// Content encodings
+const (
+	EncodingZstd = "zstd"
+	EncodingGzip = "gzip"
+)
+
+// uncompressedSizeKey holds the response size before compression, for the
+// metrics middleware
+const uncompressedSizeKey = "uncompressed_size"
+
+// CompressionConfig holds response compression configuration
+type CompressionConfig struct {
+	// MinSize is the smallest response worth compressing, in bytes
+	MinSize int
+	// ContentTypes lists the media types to compress
+	ContentTypes []string
+	// Encodings lists the supported encodings in order of preference, used
+	// when the client accepts several equally
+	Encodings []string
+	// GzipLevel is the gzip compression level
+	GzipLevel int
+}
+
+// DefaultCompressionConfig returns default compression configuration
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		MinSize: 1024,
+		ContentTypes: []string{
+			"application/json",
+			"application/problem+json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+			"text/css",
+			"text/csv",
+			"text/html",
+			"text/plain",
+			"text/xml",
+		},
+		Encodings: []string{EncodingZstd, EncodingGzip},
+		GzipLevel: gzip.DefaultCompression,
+	}
+}
+
+// encoder is a resettable compressing writer
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// CompressionMiddleware compresses responses with the best encoding the
+// client accepts. Responses smaller than MinSize, of other content types,
+// already encoded or marked no-transform are sent as is. Strong ETags are
+// weakened on compressed responses since the bytes no longer match.
+func CompressionMiddleware(cfg CompressionConfig) gin.HandlerFunc {
+	pools := map[string]*sync.Pool{
+		EncodingGzip: {New: func() any {
+			w, _ := gzip.NewWriterLevel(io.Discard, cfg.GzipLevel)
+			return w
+		}},
+		EncodingZstd: {New: func() any {
+			w, _ := zstd.NewWriter(io.Discard,
+				zstd.WithEncoderConcurrency(1),
+				zstd.WithWindowSize(1<<20),
+			)
+			return w
+		}},
+	}
+
+	return func(c *gin.Context) {
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), cfg.Encodings)
+		if c.Request.Method == http.MethodHead {
+			encoding = ""
+		}
+
+		original := c.Writer
+		cw := &compressWriter{ResponseWriter: original, cfg: &cfg, pools: pools, encoding: encoding}
+		c.Writer = cw
+		defer func() { c.Writer = original }()
+
+		c.Next()
+
+		cw.close()
+		if cw.encoder != nil {
+			c.Set(uncompressedSizeKey, cw.rawSize)
+		}
+	}
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// to compress it: once MinSize bytes are written, or the response ends or is
+// flushed.
+type compressWriter struct {
+	gin.ResponseWriter
+
+	cfg      *CompressionConfig
+	pools    map[string]*sync.Pool
+	encoding string
+
+	buf     []byte
+	decided bool
+	written bool
+	encoder encoder
+	rawSize int
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	w.written = true
+	if w.decided {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	w.written = true
+	w.rawSize += len(data)
+
+	if !w.decided {
+		w.buf = append(w.buf, data...)
+		if len(w.buf) < w.cfg.MinSize {
+			return len(data), nil
+		}
+		if err := w.decide(); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *compressWriter) Written() bool {
+	return w.written
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		_ = w.decide()
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// Unwrap returns the underlying writer, so that http.ResponseController can
+// reach it
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide picks between compressing and passing the response through, then
+// writes the headers and whatever was held back
+func (w *compressWriter) decide() error {
+	w.decided = true
+
+	header := w.Header()
+	compressible := bodyAllowedForStatus(w.Status()) &&
+		header.Get("Content-Encoding") == "" &&
+		!strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform") &&
+		w.allowedContentType(header.Get("Content-Type"))
+
+	if compressible {
+		addVary(header, "Accept-Encoding")
+	}
+
+	if compressible && w.encoding != "" && len(w.buf) >= w.cfg.MinSize {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		w.encoder = w.pools[w.encoding].Get().(encoder)
+		w.encoder.Reset(w.ResponseWriter)
+		httpResponsesCompressed.WithLabelValues(w.encoding).Inc()
+	}
+
+	if w.written {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	buf := w.buf
+	w.buf = nil
+	if w.encoder != nil {
+		_, err := w.encoder.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends anything held back and finishes the compressed stream
+func (w *compressWriter) close() {
+	if !w.decided {
+		_ = w.decide()
+	}
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+		w.encoder.Reset(io.Discard)
+		w.pools[w.encoding].Put(w.encoder)
+	}
+}
+
+func (w *compressWriter) allowedContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(w.cfg.ContentTypes, mediaType)
+}
+
+// negotiateEncoding returns the supported encoding with the highest quality
+// in an Accept-Encoding header, or "" if the client accepts none of them
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		if name == "*" {
+			wildcard = q
+		} else {
+			qualities[name] = q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supported {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// addVary adds value to the Vary header unless it is already listed
+func addVary(header http.Header, value string) {
+	for _, v := range header.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+// bodyAllowedForStatus reports whether a response with status has a body
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
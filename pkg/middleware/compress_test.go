@@ -0,0 +1,185 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var largeJSON = `{"items":"` + strings.Repeat("feed item ", 500) + `"}`
+
+func newCompressionRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(CompressionMiddleware(DefaultCompressionConfig()))
+	router.GET("/large", func(c *gin.Context) {
+		c.Header("ETag", `"abc"`)
+		c.Data(http.StatusOK, "application/json; charset=utf-8", []byte(largeJSON))
+	})
+	router.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	router.GET("/image", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", []byte(largeJSON))
+	})
+	router.GET("/encoded", func(c *gin.Context) {
+		c.Header("Content-Encoding", "br")
+		c.Data(http.StatusOK, "application/json", []byte(largeJSON))
+	})
+	router.GET("/no-transform", func(c *gin.Context) {
+		c.Header("Cache-Control", "no-transform")
+		c.Data(http.StatusOK, "application/json", []byte(largeJSON))
+	})
+	router.GET("/chunks", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain")
+		for i := 0; i < 500; i++ {
+			_, _ = c.Writer.WriteString("chunk ")
+		}
+	})
+	router.DELETE("/large", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+func serveCompressed(router *gin.Engine, method, path, acceptEncoding string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCompressionMiddleware_Gzip(t *testing.T) {
+	w := serveCompressed(newCompressionRouter(), "GET", "/large", "gzip, deflate")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+	assert.Less(t, w.Body.Len(), len(largeJSON))
+
+	r, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, largeJSON, string(body))
+}
+
+func TestCompressionMiddleware_Zstd(t *testing.T) {
+	w := serveCompressed(newCompressionRouter(), "GET", "/large", "gzip, zstd")
+	assert.Equal(t, "zstd", w.Header().Get("Content-Encoding"))
+
+	r, err := zstd.NewReader(w.Body)
+	require.NoError(t, err)
+	defer r.Close()
+	body, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, largeJSON, string(body))
+}
+
+func TestCompressionMiddleware_WriteInChunks(t *testing.T) {
+	w := serveCompressed(newCompressionRouter(), "GET", "/chunks", "gzip")
+	require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	r, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("chunk ", 500), string(body))
+}
+
+func TestCompressionMiddleware_PassThrough(t *testing.T) {
+	router := newCompressionRouter()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		acceptEncoding string
+		vary           bool
+	}{
+		{"no accept-encoding", "GET", "/large", "", true},
+		{"unsupported encoding", "GET", "/large", "br", true},
+		{"refused encodings", "GET", "/large", "gzip;q=0, *;q=0", true},
+		{"below min size", "GET", "/small", "gzip", true},
+		{"content type", "GET", "/image", "gzip", false},
+		{"already encoded", "GET", "/encoded", "gzip", false},
+		{"no-transform", "GET", "/no-transform", "gzip", false},
+		{"no body", "DELETE", "/large", "gzip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveCompressed(router, tt.method, tt.path, tt.acceptEncoding)
+			assert.NotEqual(t, "gzip", w.Header().Get("Content-Encoding"))
+			if tt.vary {
+				assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+			} else {
+				assert.Empty(t, w.Header().Get("Vary"))
+			}
+		})
+	}
+
+	w := serveCompressed(router, "GET", "/large", "")
+	assert.Equal(t, largeJSON, w.Body.String())
+	assert.Equal(t, `"abc"`, w.Header().Get("ETag"))
+}
+
+func TestCompressionMiddleware_Sizes(t *testing.T) {
+	var sent, uncompressed int
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Next()
+		sent = c.Writer.Size()
+		uncompressed = c.GetInt(uncompressedSizeKey)
+	})
+	router.Use(CompressionMiddleware(DefaultCompressionConfig()))
+	router.GET("/large", func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", []byte(largeJSON))
+	})
+
+	w := serveCompressed(router, "GET", "/large", "gzip")
+	assert.Equal(t, w.Body.Len(), sent)
+	assert.Equal(t, len(largeJSON), uncompressed)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{EncodingZstd, EncodingGzip}
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", EncodingGzip},
+		{"gzip, zstd", EncodingZstd},
+		{"zstd;q=0.5, gzip", EncodingGzip},
+		{"GZIP;q=0.8", EncodingGzip},
+		{"*", EncodingZstd},
+		{"zstd;q=0, *", EncodingGzip},
+		{"identity", ""},
+		{"gzip;q=bad", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, negotiateEncoding(tt.header, supported), tt.header)
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	header := http.Header{}
+	header.Set("Vary", "Origin")
+	addVary(header, "Accept-Encoding")
+	addVary(header, "accept-encoding")
+	assert.Equal(t, []string{"Origin", "Accept-Encoding"}, header.Values("Vary"))
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ETagMiddleware adds a strong ETag to successful GET responses and answers
+// requests whose If-None-Match matches it with 304 Not Modified. Responses
+// are buffered to hash them, so it is meant for routes returning small JSON
+// documents, not for streams or file downloads.
+func ETagMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		bw := NewBufferedWriter(original)
+		c.Writer = bw
+		defer func() { c.Writer = original }()
+
+		c.Next()
+
+		c.Writer = original
+		WriteConditional(c, bw.Status(), bw.Body())
+	}
+}
+
+// BufferedWriter holds a response in memory so that it can be inspected
+// before it is sent. Headers are written straight to the underlying writer.
+type BufferedWriter struct {
+	gin.ResponseWriter
+
+	body    bytes.Buffer
+	status  int
+	written bool
+}
+
+// NewBufferedWriter creates a BufferedWriter on top of w
+func NewBufferedWriter(w gin.ResponseWriter) *BufferedWriter {
+	return &BufferedWriter{ResponseWriter: w, status: w.Status()}
+}
+
+func (w *BufferedWriter) WriteHeader(code int) {
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+func (w *BufferedWriter) WriteHeaderNow() {
+	w.written = true
+}
+
+func (w *BufferedWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *BufferedWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *BufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *BufferedWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *BufferedWriter) Written() bool {
+	return w.written
+}
+
+// Flush is a no-op; the response is sent once it is complete
+func (w *BufferedWriter) Flush() {}
+
+// Body returns the buffered response body
+func (w *BufferedWriter) Body() []byte {
+	return w.body.Bytes()
+}
+
+// ETag returns a strong entity tag for body
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WriteConditional writes a complete response. A 200 response gets an ETag
+// unless it already has one, and is replaced by 304 Not Modified when the
+// request's If-None-Match matches it.
+func WriteConditional(c *gin.Context, status int, body []byte) {
+	if status == http.StatusOK && c.Request.Method == http.MethodGet {
+		header := c.Writer.Header()
+		etag := header.Get("ETag")
+		if etag == "" {
+			etag = ETag(body)
+			header.Set("ETag", etag)
+		}
+
+		if etagMatches(c.GetHeader("If-None-Match"), etag) {
+			header.Del("Content-Length")
+			header.Del("Content-Type")
+			c.Writer.WriteHeader(http.StatusNotModified)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+	}
+
+	c.Writer.WriteHeader(status)
+	if len(body) == 0 {
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	_, _ = c.Writer.Write(body)
+}
+
+// etagMatches implements the weak comparison If-None-Match calls for
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
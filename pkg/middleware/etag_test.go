@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newETagRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(ETagMiddleware())
+	router.GET("/item", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"id": "1"})
+	})
+	router.GET("/tagged", func(c *gin.Context) {
+		c.Header("ETag", `"v2"`)
+		c.JSON(http.StatusOK, gin.H{"id": "1"})
+	})
+	router.GET("/missing", func(c *gin.Context) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	})
+	router.POST("/item", func(c *gin.Context) {
+		c.JSON(http.StatusCreated, gin.H{"id": "2"})
+	})
+	return router
+}
+
+func serveETag(router *gin.Engine, method, path, ifNoneMatch string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, path, nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestETagMiddleware(t *testing.T) {
+	router := newETagRouter()
+
+	w := serveETag(router, "GET", "/item", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":"1"}`, w.Body.String())
+	etag := w.Header().Get("ETag")
+	assert.Equal(t, ETag(w.Body.Bytes()), etag)
+
+	// The same body always gets the same tag
+	assert.Equal(t, etag, serveETag(router, "GET", "/item", "").Header().Get("ETag"))
+
+	w = serveETag(router, "GET", "/item", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+
+	assert.Equal(t, http.StatusOK, serveETag(router, "GET", "/item", `"stale"`).Code)
+}
+
+func TestETagMiddleware_IfNoneMatchForms(t *testing.T) {
+	router := newETagRouter()
+	etag := serveETag(router, "GET", "/item", "").Header().Get("ETag")
+
+	assert.Equal(t, http.StatusNotModified, serveETag(router, "GET", "/item", `"stale", `+etag).Code)
+	assert.Equal(t, http.StatusNotModified, serveETag(router, "GET", "/item", "W/"+etag).Code)
+	assert.Equal(t, http.StatusNotModified, serveETag(router, "GET", "/item", "*").Code)
+}
+
+func TestETagMiddleware_KeepsHandlerETag(t *testing.T) {
+	router := newETagRouter()
+
+	w := serveETag(router, "GET", "/tagged", "")
+	assert.Equal(t, `"v2"`, w.Header().Get("ETag"))
+	assert.Equal(t, http.StatusNotModified, serveETag(router, "GET", "/tagged", `"v2"`).Code)
+}
+
+func TestETagMiddleware_SkipsOtherResponses(t *testing.T) {
+	router := newETagRouter()
+
+	w := serveETag(router, "GET", "/missing", "*")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+
+	w = serveETag(router, "POST", "/item", "*")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("ETag"))
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -10,6 +12,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/Femi-lawal/udagram-app/pkg/common"
+	"github.com/Femi-lawal/udagram-app/pkg/common/requestid"
 )
 
 // Prometheus metrics
@@ -43,7 +46,16 @@ var (
 	httpResponseSize = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "http_response_size_bytes",
-			Help:    "HTTP response size in bytes",
+			Help:    "HTTP response size in bytes as sent, after any compression",
+			Buckets: prometheus.ExponentialBuckets(100, 10, 8),
+		},
+		[]string{"method", "path"},
+	)
+
+	httpResponseUncompressedSize = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_response_uncompressed_size_bytes",
+			Help:    "HTTP response size in bytes before compression",
 			Buckets: prometheus.ExponentialBuckets(100, 10, 8),
 		},
 		[]string{"method", "path"},
@@ -57,7 +69,8 @@ var (
 	)
 )
 
-// RequestIDMiddleware adds a unique request ID to each request
+// RequestIDMiddleware adds a unique request ID to each request, and to its
+// context for work it leads to elsewhere, such as the events it publishes
 func RequestIDMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		requestID := c.GetHeader("X-Request-ID")
@@ -66,6 +79,7 @@ func RequestIDMiddleware() gin.HandlerFunc {
 		}
 		c.Set("request_id", requestID)
 		c.Header("X-Request-ID", requestID)
+		c.Request = c.Request.WithContext(requestid.NewContext(c.Request.Context(), requestID))
 		c.Next()
 	}
 }
@@ -75,10 +89,12 @@ func LoggerMiddleware(logger *zap.Logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
-		query := c.Request.URL.RawQuery
 
 		c.Next()
 
+		// Read after the handlers, which strip credentials such as stream
+		// tokens from the query
+		query := c.Request.URL.RawQuery
 		latency := time.Since(start)
 		status := c.Writer.Status()
 
@@ -133,13 +149,27 @@ func MetricsMiddleware() gin.HandlerFunc {
 		c.Next()
 
 		// Record metrics
-		status := c.Writer.Status()
-		httpRequestsTotal.WithLabelValues(c.Request.Method, path, statusLabel(status)).Inc()
+		label := statusLabel(c.Writer.Status())
+		if clientClosed(c) {
+			label = "499"
+		}
+		httpRequestsTotal.WithLabelValues(c.Request.Method, path, label).Inc()
 		httpRequestDuration.WithLabelValues(c.Request.Method, path).Observe(time.Since(start).Seconds())
-		httpResponseSize.WithLabelValues(c.Request.Method, path).Observe(float64(c.Writer.Size()))
+		size := c.Writer.Size()
+		httpResponseSize.WithLabelValues(c.Request.Method, path).Observe(float64(size))
+		if uncompressed, ok := c.Get(uncompressedSizeKey); ok {
+			size = uncompressed.(int)
+		}
+		httpResponseUncompressedSize.WithLabelValues(c.Request.Method, path).Observe(float64(size))
 	}
 }
 
+// clientClosed reports whether the client went away before a response was
+// written
+func clientClosed(c *gin.Context) bool {
+	return !c.Writer.Written() && errors.Is(c.Request.Context().Err(), context.Canceled)
+}
+
 func statusLabel(status int) string {
 	switch {
 	case status >= 500:
@@ -226,29 +256,3 @@ func CORSMiddleware(allowedOrigins []string) gin.HandlerFunc {
 		c.Next()
 	}
 }
-
-// TimeoutMiddleware adds request timeout handling
-func TimeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.Request = c.Request.WithContext(c.Request.Context())
-
-		done := make(chan struct{})
-		go func() {
-			c.Next()
-			close(done)
-		}()
-
-		select {
-		case <-done:
-			return
-		case <-time.After(timeout):
-			c.AbortWithStatusJSON(504, gin.H{
-				"success": false,
-				"error": gin.H{
-					"code":    "TIMEOUT",
-					"message": "request timeout",
-				},
-			})
-		}
-	}
-}
@@ -16,6 +16,8 @@ type RateLimiter struct {
 	rate     rate.Limit
 	burst    int
 	cleanup  time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 type visitor struct {
@@ -30,6 +32,7 @@ func NewRateLimiter(requestsPerSecond float64, burst int, cleanupInterval time.D
 		rate:     rate.Limit(requestsPerSecond),
 		burst:    burst,
 		cleanup:  cleanupInterval,
+		stop:     make(chan struct{}),
 	}
 
 	go rl.cleanupVisitors()
@@ -52,9 +55,23 @@ func (rl *RateLimiter) getVisitor(ip string) *rate.Limiter {
 	return v.limiter
 }
 
+// Stop ends the background cleanup of idle visitors
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() {
+		close(rl.stop)
+	})
+}
+
 func (rl *RateLimiter) cleanupVisitors() {
+	ticker := time.NewTicker(rl.cleanup)
+	defer ticker.Stop()
+
 	for {
-		time.Sleep(rl.cleanup)
+		select {
+		case <-rl.stop:
+			return
+		case <-ticker.C:
+		}
 
 		rl.mu.Lock()
 		for ip, v := range rl.visitors {
@@ -84,11 +101,13 @@ func RateLimitMiddleware(rl *RateLimiter) gin.HandlerFunc {
 
 // UserRateLimiter manages rate limiting per user
 type UserRateLimiter struct {
-	users   map[string]*visitor
-	mu      sync.RWMutex
-	rate    rate.Limit
-	burst   int
-	cleanup time.Duration
+	users    map[string]*visitor
+	mu       sync.RWMutex
+	rate     rate.Limit
+	burst    int
+	cleanup  time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewUserRateLimiter creates a new user-based rate limiter
@@ -98,6 +117,7 @@ func NewUserRateLimiter(requestsPerSecond float64, burst int, cleanupInterval ti
 		rate:    rate.Limit(requestsPerSecond),
 		burst:   burst,
 		cleanup: cleanupInterval,
+		stop:    make(chan struct{}),
 	}
 
 	go rl.cleanupUsers()
@@ -120,9 +140,29 @@ func (rl *UserRateLimiter) getUser(userID string) *rate.Limiter {
 	return v.limiter
 }
 
+// Allow reports whether key may make a request now. It limits handlers that
+// key on something other than the authenticated user, such as an API client.
+func (rl *UserRateLimiter) Allow(key string) bool {
+	return rl.getUser(key).Allow()
+}
+
+// Stop ends the background cleanup of idle users
+func (rl *UserRateLimiter) Stop() {
+	rl.stopOnce.Do(func() {
+		close(rl.stop)
+	})
+}
+
 func (rl *UserRateLimiter) cleanupUsers() {
+	ticker := time.NewTicker(rl.cleanup)
+	defer ticker.Stop()
+
 	for {
-		time.Sleep(rl.cleanup)
+		select {
+		case <-rl.stop:
+			return
+		case <-ticker.C:
+		}
 
 		rl.mu.Lock()
 		for id, v := range rl.users {
@@ -89,3 +89,15 @@ func TestUserRateLimiter(t *testing.T) {
 	assert.NotNil(t, limiter)
 	assert.NotNil(t, limiter.users)
 }
+
+func TestUserRateLimiter_Allow(t *testing.T) {
+	limiter := NewUserRateLimiter(1, 2, time.Minute)
+	defer limiter.Stop()
+
+	assert.True(t, limiter.Allow("service:feed"))
+	assert.True(t, limiter.Allow("service:feed"))
+	assert.False(t, limiter.Allow("service:feed"))
+
+	// Each key has its own limit
+	assert.True(t, limiter.Allow("user:123"))
+}
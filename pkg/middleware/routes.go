@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+)
+
+// RouteInfo describes a route registered on a service
+type RouteInfo struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// RoutesHandler lists the routes registered on the router so that the
+// gateway can check its route table against the service at startup
+func RoutesHandler(router *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		registered := router.Routes()
+		routes := make([]RouteInfo, 0, len(registered))
+		for _, route := range registered {
+			routes = append(routes, RouteInfo{Method: route.Method, Path: route.Path})
+		}
+
+		common.SuccessResponse(c, routes)
+	}
+}
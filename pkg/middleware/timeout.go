@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+)
+
+// Metrics
+var (
+	httpRequestTimeouts = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_request_timeouts_total",
+			Help: "Total number of requests that exceeded their timeout",
+		},
+		[]string{"method", "path"},
+	)
+)
+
+// TimeoutConfig holds request timeout configuration
+type TimeoutConfig struct {
+	// Timeout applies to every route without an override
+	Timeout time.Duration
+	// Routes overrides Timeout per route, keyed by "METHOD /route/pattern" as
+	// registered with gin. A zero or negative value disables the timeout,
+	// which streaming routes need because their responses cannot be buffered.
+	Routes map[string]time.Duration
+}
+
+// DefaultTimeoutConfig returns default timeout configuration
+func DefaultTimeoutConfig() TimeoutConfig {
+	return TimeoutConfig{
+		Timeout: 10 * time.Second,
+	}
+}
+
+// timeoutFor returns the timeout for the matched route
+func (cfg TimeoutConfig) timeoutFor(c *gin.Context) time.Duration {
+	if timeout, ok := cfg.Routes[c.Request.Method+" "+c.FullPath()]; ok {
+		return timeout
+	}
+	return cfg.Timeout
+}
+
+// TimeoutMiddleware bounds request handling time. The request context gets a
+// deadline so handlers and their downstream calls can stop early, and the
+// response is buffered so that either the handler's response or a 504 is
+// written, never both. The 504 is sent as soon as the deadline passes, but the
+// middleware waits for the handler to return before releasing the gin context,
+// so handlers should honour their context.
+func TimeoutMiddleware(cfg TimeoutConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		timeout := cfg.timeoutFor(c)
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		// The handler goroutine may still be changing the context when the
+		// deadline passes, so the metric labels are read up front
+		method, route := c.Request.Method, c.FullPath()
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		original := c.Writer
+		tw := newTimeoutWriter(original)
+		c.Writer = tw
+
+		// inTime is set before the handler result is sent, so it is safe to
+		// read once done has been received from
+		var inTime bool
+		done := make(chan any, 1)
+		go func() {
+			defer func() {
+				inTime = ctx.Err() == nil
+				done <- recover()
+			}()
+			c.Next()
+		}()
+
+		var p any
+		finished := false
+		select {
+		case p = <-done:
+			finished = true
+		case <-ctx.Done():
+		}
+
+		// A handler that returns because its deadline passed still times out.
+		// The 504 is sent at the deadline; the gin context is only released
+		// once the handler returns, as gin reuses it for later requests.
+		if (!finished || !inTime) && errors.Is(ctx.Err(), context.DeadlineExceeded) && tw.timeout() {
+			httpRequestTimeouts.WithLabelValues(method, route).Inc()
+		}
+		if !finished {
+			p = <-done
+		}
+
+		c.Writer = original
+		if p != nil {
+			// Let the recovery middleware handle handler panics
+			panic(p)
+		}
+		tw.flush()
+	}
+}
+
+// timeoutWriter buffers a handler's response until it completes. Once the
+// request times out, further writes are discarded.
+type timeoutWriter struct {
+	gin.ResponseWriter
+
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	written  bool
+	timedOut bool
+}
+
+func newTimeoutWriter(w gin.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{
+		ResponseWriter: w,
+		header:         w.Header().Clone(),
+		status:         w.Status(),
+	}
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if code > 0 && !w.written {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.written = true
+}
+
+func (w *timeoutWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.written = true
+	return w.body.Write(data)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.written
+}
+
+// Flush is a no-op; the response is sent when the handler returns
+func (w *timeoutWriter) Flush() {}
+
+// Unwrap returns the underlying writer, so that http.ResponseController can
+// reach it
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack is not supported on buffered responses
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("timeout middleware does not support hijacking")
+}
+
+// timeout sends a 504 through the underlying writer and discards anything the
+// handler writes afterwards. It reports false if the response was already
+// claimed.
+func (w *timeoutWriter) timeout() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timedOut {
+		return false
+	}
+	w.timedOut = true
+
+	// The response is complete on the wire once flushed, so the client does
+	// not wait on the handler
+	body, _ := json.Marshal(common.Response{
+		Success: false,
+		Error:   &common.ErrorInfo{Code: "TIMEOUT", Message: "request timeout"},
+	})
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(http.StatusGatewayTimeout)
+	_, _ = w.ResponseWriter.Write(body)
+	w.ResponseWriter.Flush()
+	return true
+}
+
+// flush copies the buffered response to the underlying writer
+func (w *timeoutWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timedOut {
+		return
+	}
+
+	dst := w.ResponseWriter.Header()
+	for key := range dst {
+		if _, ok := w.header[key]; !ok {
+			dst.Del(key)
+		}
+	}
+	for key, values := range w.header {
+		dst[key] = values
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if !w.written {
+		return
+	}
+	if w.body.Len() == 0 {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	_, _ = w.ResponseWriter.Write(w.body.Bytes())
+}
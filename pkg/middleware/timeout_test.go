@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTimeoutRouter(cfg TimeoutConfig) *gin.Engine {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Header("X-Request-ID", "req-123")
+		c.Next()
+	})
+	router.Use(TimeoutMiddleware(cfg))
+	return router
+}
+
+func TestTimeoutMiddleware_PassesThroughFastResponses(t *testing.T) {
+	router := newTimeoutRouter(TimeoutConfig{Timeout: time.Second})
+	router.GET("/", func(c *gin.Context) {
+		_, hasDeadline := c.Request.Context().Deadline()
+		assert.True(t, hasDeadline)
+
+		c.Header("X-Custom", "value")
+		c.JSON(http.StatusCreated, gin.H{"ok": true})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"ok":true}`, w.Body.String())
+	assert.Equal(t, "value", w.Header().Get("X-Custom"))
+	assert.Equal(t, "req-123", w.Header().Get("X-Request-ID"))
+}
+
+func TestTimeoutMiddleware_EmptyResponse(t *testing.T) {
+	router := newTimeoutRouter(TimeoutConfig{Timeout: time.Second})
+	router.DELETE("/", func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/", nil))
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Empty(t, w.Body.String())
+}
+
+func TestTimeoutMiddleware_NoRoute(t *testing.T) {
+	router := newTimeoutRouter(TimeoutConfig{Timeout: time.Second})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "404 page not found", w.Body.String())
+}
+
+func TestTimeoutMiddleware_CancelsSlowHandler(t *testing.T) {
+	var canceled atomic.Bool
+	router := newTimeoutRouter(TimeoutConfig{Timeout: 20 * time.Millisecond})
+	router.GET("/", func(c *gin.Context) {
+		select {
+		case <-c.Request.Context().Done():
+			canceled.Store(true)
+		case <-time.After(time.Second):
+		}
+		c.JSON(http.StatusOK, gin.H{"late": true})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "TIMEOUT")
+	assert.NotContains(t, w.Body.String(), "late")
+	assert.Equal(t, "req-123", w.Header().Get("X-Request-ID"))
+	assert.True(t, canceled.Load(), "handler should observe the deadline")
+}
+
+func TestTimeoutMiddleware_HandlerIgnoringContext(t *testing.T) {
+	router := newTimeoutRouter(TimeoutConfig{Timeout: 10 * time.Millisecond})
+	router.GET("/", func(c *gin.Context) {
+		time.Sleep(30 * time.Millisecond)
+		c.Header("X-Late", "true")
+		c.String(http.StatusOK, "late")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.NotContains(t, w.Body.String(), "late")
+	assert.Empty(t, w.Header().Get("X-Late"))
+}
+
+func TestTimeoutMiddleware_RespondsAtDeadline(t *testing.T) {
+	router := newTimeoutRouter(TimeoutConfig{Timeout: 20 * time.Millisecond})
+	release := make(chan struct{})
+	router.GET("/", func(c *gin.Context) {
+		<-release
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+	defer close(release)
+
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	// The handler is still running
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	assert.Contains(t, string(body), "TIMEOUT")
+}
+
+func TestTimeoutMiddleware_HandlerReplacingRequestAfterDeadline(t *testing.T) {
+	router := newTimeoutRouter(TimeoutConfig{Timeout: 10 * time.Millisecond})
+	router.GET("/", func(c *gin.Context) {
+		// Run with -race: the middleware must not read c.Request once the
+		// deadline has passed
+		for c.Request.Context().Err() == nil {
+			c.Request = c.Request.WithContext(c.Request.Context())
+			time.Sleep(time.Millisecond)
+		}
+		for i := 0; i < 20; i++ {
+			c.Request = c.Request.WithContext(c.Request.Context())
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
+
+func TestTimeoutMiddleware_ResponseControllerReachesWriter(t *testing.T) {
+	router := newTimeoutRouter(TimeoutConfig{Timeout: time.Second})
+	router.GET("/", func(c *gin.Context) {
+		// Deadlines are only supported by the server's own writer
+		err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(time.Second))
+		assert.NoError(t, err)
+		c.Status(http.StatusOK)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestTimeoutMiddleware_PerRouteTimeouts(t *testing.T) {
+	router := newTimeoutRouter(TimeoutConfig{
+		Timeout: 10 * time.Millisecond,
+		Routes: map[string]time.Duration{
+			"GET /slow/:id": time.Second,
+			"GET /stream":   0,
+		},
+	})
+	router.GET("/slow/:id", func(c *gin.Context) {
+		time.Sleep(30 * time.Millisecond)
+		c.String(http.StatusOK, "ok")
+	})
+	router.GET("/stream", func(c *gin.Context) {
+		_, hasDeadline := c.Request.Context().Deadline()
+		assert.False(t, hasDeadline)
+		c.String(http.StatusOK, "ok")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/slow/42", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stream", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestTimeoutMiddleware_PropagatesPanics(t *testing.T) {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("request_id", "req-123")
+		c.Next()
+	})
+	router.Use(RecoveryMiddleware(zap.NewNop()))
+	router.Use(TimeoutMiddleware(TimeoutConfig{Timeout: time.Second}))
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), "partial")
+}
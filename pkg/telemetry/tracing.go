@@ -33,6 +33,13 @@ type Provider struct {
 
 // NewProvider creates a new telemetry provider
 func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
+	// Set propagator. Trace context is passed on even when this service
+	// does not export spans, so traces stay whole across it.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
 	if !cfg.Enabled {
 		return &Provider{
 			tracer: otel.Tracer(cfg.ServiceName),
@@ -87,12 +94,6 @@ func NewProvider(ctx context.Context, cfg Config) (*Provider, error) {
 	// Set global tracer provider
 	otel.SetTracerProvider(tp)
 
-	// Set propagator
-	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	))
-
 	return &Provider{
 		tracerProvider: tp,
 		tracer:         tp.Tracer(cfg.ServiceName),
@@ -192,6 +193,7 @@ func HTTPClientSpan(ctx context.Context, method, url string) (context.Context, t
 // KafkaProducerSpan starts a Kafka producer span
 func KafkaProducerSpan(ctx context.Context, topic string) (context.Context, trace.Span) {
 	return StartSpan(ctx, "kafka.produce",
+		trace.WithSpanKind(trace.SpanKindProducer),
 		trace.WithAttributes(
 			attribute.String("messaging.system", "kafka"),
 			attribute.String("messaging.destination", topic),
@@ -203,6 +205,7 @@ func KafkaProducerSpan(ctx context.Context, topic string) (context.Context, trac
 // KafkaConsumerSpan starts a Kafka consumer span
 func KafkaConsumerSpan(ctx context.Context, topic string) (context.Context, trace.Span) {
 	return StartSpan(ctx, "kafka.consume",
+		trace.WithSpanKind(trace.SpanKindConsumer),
 		trace.WithAttributes(
 			attribute.String("messaging.system", "kafka"),
 			attribute.String("messaging.destination", topic),
@@ -211,6 +214,17 @@ func KafkaConsumerSpan(ctx context.Context, topic string) (context.Context, trac
 	)
 }
 
+// HTTPServerSpan starts an HTTP server span
+func HTTPServerSpan(ctx context.Context, method, route string) (context.Context, trace.Span) {
+	return StartSpan(ctx, method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethod(method),
+			semconv.HTTPRoute(route),
+		),
+	)
+}
+
 // MeasureDuration measures the duration of an operation and adds it to the span
 func MeasureDuration(ctx context.Context, start time.Time, name string) {
 	duration := time.Since(start)
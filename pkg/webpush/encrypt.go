@@ -0,0 +1,146 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// recordSize is the record size of encrypted payloads. Payloads always fit
+// a single record.
+const recordSize = 4096
+
+// MaxPayloadSize is the largest payload that can be sent. Push services
+// accept 4096 byte bodies, which hold the 86 byte header, the 16 byte
+// authentication tag and the padding delimiter besides the payload.
+const MaxPayloadSize = recordSize - 86 - 16 - 1
+
+// ErrPayloadTooLarge is returned for payloads over MaxPayloadSize
+var ErrPayloadTooLarge = errors.New("webpush: payload too large")
+
+// Keys are the subscription keys a browser hands out with its endpoint
+type Keys struct {
+	// P256dh is the user agent's P-256 public key, base64url encoded
+	P256dh string `json:"p256dh"`
+	// Auth is the 16 byte authentication secret, base64url encoded
+	Auth string `json:"auth"`
+}
+
+// Subscription is where to push messages for one browser
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     Keys   `json:"keys"`
+}
+
+// decodeKeys parses and checks subscription keys
+func decodeKeys(keys Keys) (*ecdh.PublicKey, []byte, error) {
+	raw, err := decodeBase64(keys.P256dh)
+	if err != nil {
+		return nil, nil, fmt.Errorf("webpush: invalid p256dh key: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("webpush: invalid p256dh key: %w", err)
+	}
+
+	authSecret, err := decodeBase64(keys.Auth)
+	if err != nil || len(authSecret) != 16 {
+		return nil, nil, errors.New("webpush: auth secret must be 16 bytes")
+	}
+	return uaPublic, authSecret, nil
+}
+
+// ValidateKeys reports whether subscription keys can be encrypted to
+func ValidateKeys(keys Keys) error {
+	_, _, err := decodeKeys(keys)
+	return err
+}
+
+// Encrypt encrypts a payload for the subscription keys with the aes128gcm
+// content encoding of RFC 8291
+func Encrypt(keys Keys, payload []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt(keys, payload, salt, asPrivate)
+}
+
+// encrypt encrypts with the given salt and application server key
+func encrypt(keys Keys, payload, salt []byte, asPrivate *ecdh.PrivateKey) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	uaPublic, authSecret, err := decodeKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	ecdhSecret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	// Combine the shared secret with the auth secret (RFC 8291 section 3.3)
+	keyInfo := "WebPush: info\x00" + string(uaPublic.Bytes()) + string(asPublic)
+	ikm, err := hkdf.Key(sha256.New, ecdhSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	// Derive the content encryption key and nonce (RFC 8188 section 2.2)
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt, record size, key ID length and the key ID, which is the
+	// application server's public key
+	body := make([]byte, 0, 21+len(asPublic)+len(payload)+1+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, recordSize)
+	body = append(body, byte(len(asPublic)))
+	body = append(body, asPublic...)
+
+	// A single record ends with the 0x02 padding delimiter
+	plaintext := append(append(make([]byte, 0, len(payload)+1), payload...), 0x02)
+	return gcm.Seal(body, nonce, plaintext, nil), nil
+}
+
+// decodeBase64 decodes base64url, with or without padding, as browsers are
+// not consistent about it
+func decodeBase64(value string) ([]byte, error) {
+	if data, err := base64.RawURLEncoding.DecodeString(value); err == nil {
+		return data, nil
+	}
+	return base64.URLEncoding.DecodeString(value)
+}
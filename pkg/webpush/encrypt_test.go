@@ -0,0 +1,137 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustDecode(t *testing.T, value string) []byte {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	require.NoError(t, err)
+	return data
+}
+
+// testSubscriber is a user agent that can decrypt what is pushed to it
+type testSubscriber struct {
+	private *ecdh.PrivateKey
+	auth    []byte
+}
+
+func newTestSubscriber(t *testing.T) *testSubscriber {
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	auth := make([]byte, 16)
+	_, err = rand.Read(auth)
+	require.NoError(t, err)
+	return &testSubscriber{private: private, auth: auth}
+}
+
+func (s *testSubscriber) keys() Keys {
+	return Keys{
+		P256dh: base64.RawURLEncoding.EncodeToString(s.private.PublicKey().Bytes()),
+		Auth:   base64.RawURLEncoding.EncodeToString(s.auth),
+	}
+}
+
+// decrypt reverses Encrypt as a browser would
+func (s *testSubscriber) decrypt(t *testing.T, body []byte) []byte {
+	require.Greater(t, len(body), 21)
+	salt := body[:16]
+	assert.Equal(t, uint32(recordSize), binary.BigEndian.Uint32(body[16:20]))
+	idLen := int(body[20])
+	asPublic, err := ecdh.P256().NewPublicKey(body[21 : 21+idLen])
+	require.NoError(t, err)
+
+	ecdhSecret, err := s.private.ECDH(asPublic)
+	require.NoError(t, err)
+	keyInfo := "WebPush: info\x00" + string(s.private.PublicKey().Bytes()) + string(asPublic.Bytes())
+	ikm, err := hkdf.Key(sha256.New, ecdhSecret, s.auth, keyInfo, 32)
+	require.NoError(t, err)
+	cek, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	require.NoError(t, err)
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(cek)
+	require.NoError(t, err)
+	gcm, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	plaintext, err := gcm.Open(nil, nonce, body[21+idLen:], nil)
+	require.NoError(t, err)
+
+	require.NotEmpty(t, plaintext)
+	assert.Equal(t, byte(0x02), plaintext[len(plaintext)-1])
+	return plaintext[:len(plaintext)-1]
+}
+
+// TestEncrypt_RFC8291 checks the example in RFC 8291 appendix A
+func TestEncrypt_RFC8291(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(mustDecode(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	require.NoError(t, err)
+
+	keys := Keys{
+		P256dh: "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+		Auth:   "BTBZMqHH6r4Tts7J_aSIgg",
+	}
+	body, err := encrypt(keys, []byte("When I grow up, I want to be a watermelon"), mustDecode(t, "DGv6ra1nlYgDCS1FRnbzlw"), asPrivate)
+	require.NoError(t, err)
+
+	assert.Equal(t,
+		"DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN",
+		base64.RawURLEncoding.EncodeToString(body),
+	)
+}
+
+func TestEncrypt_RoundTrip(t *testing.T) {
+	subscriber := newTestSubscriber(t)
+
+	body, err := Encrypt(subscriber.keys(), []byte(`{"title":"hello"}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"title":"hello"}`, string(subscriber.decrypt(t, body)))
+
+	// Every message has its own salt and key
+	again, err := Encrypt(subscriber.keys(), []byte(`{"title":"hello"}`))
+	require.NoError(t, err)
+	assert.NotEqual(t, body, again)
+}
+
+func TestEncrypt_PayloadLimit(t *testing.T) {
+	subscriber := newTestSubscriber(t)
+
+	body, err := Encrypt(subscriber.keys(), make([]byte, MaxPayloadSize))
+	require.NoError(t, err)
+	assert.Equal(t, 4096, len(body))
+
+	_, err = Encrypt(subscriber.keys(), make([]byte, MaxPayloadSize+1))
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
+
+func TestValidateKeys(t *testing.T) {
+	valid := newTestSubscriber(t).keys()
+	assert.NoError(t, ValidateKeys(valid))
+
+	// Padded base64url is accepted too
+	padded := valid
+	padded.Auth = base64.URLEncoding.EncodeToString(mustDecode(t, valid.Auth))
+	assert.NoError(t, ValidateKeys(padded))
+
+	tests := []Keys{
+		{P256dh: "not-base64!", Auth: valid.Auth},
+		{P256dh: base64.RawURLEncoding.EncodeToString(make([]byte, 65)), Auth: valid.Auth},
+		{P256dh: valid.P256dh, Auth: base64.RawURLEncoding.EncodeToString(make([]byte, 8))},
+		{P256dh: valid.P256dh},
+	}
+	for _, keys := range tests {
+		assert.Error(t, ValidateKeys(keys), keys)
+	}
+}
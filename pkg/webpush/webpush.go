@@ -0,0 +1,276 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics
+var (
+	pushSent = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webpush_messages_total",
+			Help: "Total number of push messages by result",
+		},
+		[]string{"result"},
+	)
+
+	pushLatency = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "webpush_request_duration_seconds",
+			Help:    "Push service request duration in seconds",
+			Buckets: []float64{.01, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+	)
+)
+
+// Urgency hints how soon a message should reach the device (RFC 8030
+// section 5.3)
+type Urgency string
+
+// Urgencies
+const (
+	UrgencyVeryLow Urgency = "very-low"
+	UrgencyLow     Urgency = "low"
+	UrgencyNormal  Urgency = "normal"
+	UrgencyHigh    Urgency = "high"
+)
+
+// vapidExpiry is how long a VAPID token is valid for. Push services reject
+// tokens valid for more than 24 hours.
+const vapidExpiry = 12 * time.Hour
+
+// Config holds Web Push configuration
+type Config struct {
+	// PrivateKey is the VAPID private key: the raw P-256 scalar, base64url
+	// encoded
+	PrivateKey string
+	// Subject is a mailto: or https: contact for the push service operator
+	Subject string
+	// TTL is how long the push service keeps messages for offline devices
+	TTL time.Duration
+	// Timeout bounds a request to a push service
+	Timeout time.Duration
+}
+
+// DefaultConfig returns default Web Push configuration
+func DefaultConfig() Config {
+	return Config{
+		TTL:     24 * time.Hour,
+		Timeout: 10 * time.Second,
+	}
+}
+
+// Options tune a single message
+type Options struct {
+	// TTL overrides Config.TTL
+	TTL time.Duration
+	// Urgency defaults to normal
+	Urgency Urgency
+	// Topic replaces a pending message with the same topic
+	Topic string
+}
+
+// Error is a push service response that rejected a message
+type Error struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("webpush: push service returned %d: %s", e.StatusCode, e.Body)
+}
+
+// IsGone reports whether err means the subscription no longer exists and
+// should be removed
+func IsGone(err error) bool {
+	var pushErr *Error
+	return errors.As(err, &pushErr) &&
+		(pushErr.StatusCode == http.StatusNotFound || pushErr.StatusCode == http.StatusGone)
+}
+
+// Client sends push messages
+type Client struct {
+	cfg        Config
+	key        *ecdsa.PrivateKey
+	publicKey  string
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]vapidToken
+}
+
+type vapidToken struct {
+	value   string
+	expires time.Time
+}
+
+// NewClient creates a new Web Push client
+func NewClient(cfg Config) (*Client, error) {
+	key, publicKey, err := parsePrivateKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Subject == "" {
+		return nil, errors.New("webpush: VAPID subject is required")
+	}
+
+	return &Client{
+		cfg:        cfg,
+		key:        key,
+		publicKey:  base64.RawURLEncoding.EncodeToString(publicKey),
+		httpClient: &http.Client{Timeout: cfg.Timeout},
+		tokens:     make(map[string]vapidToken),
+	}, nil
+}
+
+// PublicKey returns the VAPID public key that browsers subscribe with, as the
+// applicationServerKey
+func (c *Client) PublicKey() string {
+	return c.publicKey
+}
+
+// Send encrypts and pushes a payload to a subscription
+func (c *Client) Send(ctx context.Context, sub Subscription, payload []byte, opts Options) error {
+	endpoint, err := url.Parse(sub.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		pushSent.WithLabelValues("invalid").Inc()
+		return fmt.Errorf("webpush: invalid endpoint %q", sub.Endpoint)
+	}
+
+	body, err := Encrypt(sub.Keys, payload)
+	if err != nil {
+		pushSent.WithLabelValues("invalid").Inc()
+		return err
+	}
+
+	token, err := c.token(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return err
+	}
+
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = c.cfg.TTL
+	}
+	urgency := opts.Urgency
+	if urgency == "" {
+		urgency = UrgencyNormal
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("vapid t=%s, k=%s", token, c.publicKey))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	req.Header.Set("Urgency", string(urgency))
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	pushLatency.Observe(time.Since(start).Seconds())
+	if err != nil {
+		pushSent.WithLabelValues("failed").Inc()
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		pushSent.WithLabelValues("sent").Inc()
+		return nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	pushErr := &Error{StatusCode: resp.StatusCode, Body: string(detail)}
+	if IsGone(pushErr) {
+		pushSent.WithLabelValues("gone").Inc()
+	} else {
+		pushSent.WithLabelValues("failed").Inc()
+	}
+	return pushErr
+}
+
+// token returns a VAPID token for the push service origin, reusing tokens
+// until they near expiry
+func (c *Client) token(audience string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if cached, ok := c.tokens[audience]; ok && now.Before(cached.expires.Add(-vapidExpiry/2)) {
+		return cached.value, nil
+	}
+
+	expires := now.Add(vapidExpiry)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.RegisteredClaims{
+		Audience:  jwt.ClaimStrings{audience},
+		ExpiresAt: jwt.NewNumericDate(expires),
+		Subject:   c.cfg.Subject,
+	}).SignedString(c.key)
+	if err != nil {
+		return "", fmt.Errorf("webpush: sign VAPID token: %w", err)
+	}
+
+	c.tokens[audience] = vapidToken{value: token, expires: expires}
+	return token, nil
+}
+
+// parsePrivateKey parses a base64url VAPID private key, returning it with
+// its uncompressed public key
+func parsePrivateKey(value string) (*ecdsa.PrivateKey, []byte, error) {
+	raw, err := decodeBase64(value)
+	if err != nil {
+		return nil, nil, fmt.Errorf("webpush: invalid VAPID private key: %w", err)
+	}
+	ecdhKey, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("webpush: invalid VAPID private key: %w", err)
+	}
+
+	// Round-trip through PKCS #8 for the ECDSA form of the key
+	der, err := x509.MarshalPKCS8PrivateKey(ecdhKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("webpush: VAPID private key is not an ECDSA key")
+	}
+	return key, ecdhKey.PublicKey().Bytes(), nil
+}
+
+// GenerateVAPIDKeys returns a new base64url VAPID key pair
+func GenerateVAPIDKeys() (privateKey, publicKey string, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key.Bytes()),
+		base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
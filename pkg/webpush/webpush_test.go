@@ -0,0 +1,160 @@
+package webpush
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubPushService is a local push endpoint that records what it receives
+type stubPushService struct {
+	*httptest.Server
+	status int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newStubPushService(t *testing.T, status int) *stubPushService {
+	stub := &stubPushService{status: status}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		stub.mu.Lock()
+		stub.requests = append(stub.requests, r)
+		stub.bodies = append(stub.bodies, body)
+		stub.mu.Unlock()
+		w.WriteHeader(stub.status)
+	}))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+func newTestClient(t *testing.T) *Client {
+	privateKey, _, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+
+	cfg := DefaultConfig()
+	cfg.PrivateKey = privateKey
+	cfg.Subject = "mailto:ops@udagram.app"
+	client, err := NewClient(cfg)
+	require.NoError(t, err)
+	return client
+}
+
+// vapidPublicKey returns the ECDSA form of a base64url VAPID public key
+func vapidPublicKey(t *testing.T, value string) *ecdsa.PublicKey {
+	ecdhKey, err := ecdh.P256().NewPublicKey(mustDecode(t, value))
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(ecdhKey)
+	require.NoError(t, err)
+	key, err := x509.ParsePKIXPublicKey(der)
+	require.NoError(t, err)
+	return key.(*ecdsa.PublicKey)
+}
+
+func TestClient_Send(t *testing.T) {
+	stub := newStubPushService(t, http.StatusCreated)
+	client := newTestClient(t)
+	subscriber := newTestSubscriber(t)
+
+	sub := Subscription{Endpoint: stub.URL + "/push/abc", Keys: subscriber.keys()}
+	err := client.Send(context.Background(), sub, []byte(`{"title":"hi"}`), Options{Urgency: UrgencyHigh, Topic: "welcome"})
+	require.NoError(t, err)
+
+	require.Len(t, stub.requests, 1)
+	r := stub.requests[0]
+	assert.Equal(t, "/push/abc", r.URL.Path)
+	assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+	assert.Equal(t, "86400", r.Header.Get("TTL"))
+	assert.Equal(t, "high", r.Header.Get("Urgency"))
+	assert.Equal(t, "welcome", r.Header.Get("Topic"))
+	assert.Equal(t, `{"title":"hi"}`, string(subscriber.decrypt(t, stub.bodies[0])))
+
+	// The VAPID token is signed for the push service's origin
+	auth := r.Header.Get("Authorization")
+	require.True(t, strings.HasPrefix(auth, "vapid t="), auth)
+	token, key, ok := strings.Cut(strings.TrimPrefix(auth, "vapid t="), ", k=")
+	require.True(t, ok)
+	assert.Equal(t, client.PublicKey(), key)
+
+	claims := &jwt.RegisteredClaims{}
+	_, err = jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return vapidPublicKey(t, key), nil
+	}, jwt.WithValidMethods([]string{"ES256"}), jwt.WithAudience(stub.URL))
+	require.NoError(t, err)
+	assert.Equal(t, "mailto:ops@udagram.app", claims.Subject)
+	assert.WithinDuration(t, time.Now().Add(vapidExpiry), claims.ExpiresAt.Time, time.Minute)
+}
+
+func TestClient_SendReusesToken(t *testing.T) {
+	stub := newStubPushService(t, http.StatusCreated)
+	client := newTestClient(t)
+	sub := Subscription{Endpoint: stub.URL, Keys: newTestSubscriber(t).keys()}
+
+	require.NoError(t, client.Send(context.Background(), sub, []byte("a"), Options{}))
+	require.NoError(t, client.Send(context.Background(), sub, []byte("b"), Options{}))
+	assert.Equal(t, stub.requests[0].Header.Get("Authorization"), stub.requests[1].Header.Get("Authorization"))
+}
+
+func TestClient_SendErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		gone   bool
+	}{
+		{http.StatusGone, true},
+		{http.StatusNotFound, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+	}
+
+	client := newTestClient(t)
+	for _, tt := range tests {
+		stub := newStubPushService(t, tt.status)
+		sub := Subscription{Endpoint: stub.URL, Keys: newTestSubscriber(t).keys()}
+
+		err := client.Send(context.Background(), sub, []byte("hi"), Options{})
+		var pushErr *Error
+		require.ErrorAs(t, err, &pushErr, tt.status)
+		assert.Equal(t, tt.status, pushErr.StatusCode)
+		assert.Equal(t, tt.gone, IsGone(err), tt.status)
+	}
+}
+
+func TestClient_SendInvalidSubscription(t *testing.T) {
+	client := newTestClient(t)
+	keys := newTestSubscriber(t).keys()
+
+	err := client.Send(context.Background(), Subscription{Endpoint: "not a url", Keys: keys}, []byte("hi"), Options{})
+	assert.Error(t, err)
+
+	err = client.Send(context.Background(), Subscription{Endpoint: "https://push.example.com", Keys: Keys{P256dh: "x"}}, []byte("hi"), Options{})
+	assert.Error(t, err)
+}
+
+func TestNewClient_Invalid(t *testing.T) {
+	privateKey, publicKey, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+
+	_, err = NewClient(Config{PrivateKey: "invalid", Subject: "mailto:ops@udagram.app"})
+	assert.Error(t, err)
+
+	_, err = NewClient(Config{PrivateKey: privateKey})
+	assert.Error(t, err)
+
+	client, err := NewClient(Config{PrivateKey: privateKey, Subject: "mailto:ops@udagram.app"})
+	require.NoError(t, err)
+	assert.Equal(t, publicKey, client.PublicKey())
+}
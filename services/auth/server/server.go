@@ -0,0 +1,623 @@
+// Package server implements the auth service's API.
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"github.com/Femi-lawal/udagram-app/pkg/cache"
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+	"github.com/Femi-lawal/udagram-app/pkg/database"
+	"github.com/Femi-lawal/udagram-app/pkg/messaging"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+// User model
+type User struct {
+	ID           string    `gorm:"primaryKey;type:uuid" json:"id"`
+	Email        string    `gorm:"uniqueIndex;not null" json:"email"`
+	PasswordHash string    `gorm:"not null" json:"-"`
+	FirstName    string    `json:"first_name,omitempty"`
+	LastName     string    `json:"last_name,omitempty"`
+	AvatarURL    string    `json:"avatar_url,omitempty"`
+	IsActive     bool      `gorm:"default:true" json:"is_active"`
+	IsVerified   bool      `gorm:"default:false" json:"is_verified"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// TableName returns the table name for User
+func (User) TableName() string {
+	return "users"
+}
+
+// Short returns a safe version of user
+func (u *User) Short() map[string]interface{} {
+	return map[string]interface{}{
+		"id":          u.ID,
+		"email":       u.Email,
+		"first_name":  u.FirstName,
+		"last_name":   u.LastName,
+		"avatar_url":  u.AvatarURL,
+		"is_verified": u.IsVerified,
+		"created_at":  u.CreatedAt,
+	}
+}
+
+// RefreshToken model
+type RefreshToken struct {
+	ID        string    `gorm:"primaryKey;type:uuid"`
+	UserID    string    `gorm:"index;not null"`
+	Token     string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// EmailVerification is a pending email address verification. Only a hash of
+// the token is stored; the token itself is in the verification link.
+type EmailVerification struct {
+	ID        string    `gorm:"primaryKey;type:uuid"`
+	UserID    string    `gorm:"type:uuid;index;not null"`
+	TokenHash string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// TableName returns the table name for EmailVerification
+func (EmailVerification) TableName() string {
+	return "email_verifications"
+}
+
+// verificationExpiry is how long a verification link works
+const verificationExpiry = 48 * time.Hour
+
+// maxPasswordBytes is the longest password bcrypt can hash. The binding's
+// max counts characters, so multi-byte passwords are checked separately.
+const maxPasswordBytes = 72
+
+// Request/Response types
+type RegisterRequest struct {
+	Email     string `json:"email" binding:"required,email,max=254"`
+	Password  string `json:"password" binding:"required,min=8,max=72"`
+	FirstName string `json:"first_name" binding:"max=100"`
+	LastName  string `json:"last_name" binding:"max=100"`
+}
+
+// LegacyRegisterRequest is the v0 registration body. The frontend also sends
+// a username, which accounts do not have, so it is accepted and ignored.
+type LegacyRegisterRequest struct {
+	RegisterRequest
+	Username string `json:"username" binding:"max=100"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required,email,max=254"`
+	Password string `json:"password" binding:"required,max=72"`
+}
+
+type TokenResponse struct {
+	AccessToken  string                 `json:"access_token"`
+	RefreshToken string                 `json:"refresh_token"`
+	TokenType    string                 `json:"token_type"`
+	ExpiresIn    int64                  `json:"expires_in"`
+	User         map[string]interface{} `json:"user"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required,max=64"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `form:"token" binding:"required,max=64"`
+}
+
+// AuthService handles authentication
+type AuthService struct {
+	db        *database.Client
+	cache     *cache.Client
+	producer  *messaging.Producer
+	logger    *zap.Logger
+	jwtConfig middleware.JWTConfig
+	// verificationURL is the verification link without its token
+	verificationURL string
+}
+
+// NewAuthService creates an auth service. Events are written to db's outbox;
+// verificationURL is the email verification link without its token.
+func NewAuthService(db *database.Client, cacheClient *cache.Client, producer *messaging.Producer, logger *zap.Logger, jwtConfig middleware.JWTConfig, verificationURL string) *AuthService {
+	return &AuthService{
+		db:              db,
+		cache:           cacheClient,
+		producer:        producer,
+		logger:          logger,
+		jwtConfig:       jwtConfig,
+		verificationURL: verificationURL,
+	}
+}
+
+// RegisterRoutes registers the auth API routes
+func (s *AuthService) RegisterRoutes(router *gin.Engine) {
+	// Auth routes
+	api := router.Group("/api/v1/auth")
+	{
+		api.POST("/register", s.Register)
+		api.POST("/login", s.Login)
+		api.POST("/refresh", s.RefreshTokenHandler)
+		api.POST("/logout", s.Logout)
+		api.GET("/verification", s.Verify)
+		api.GET("/verify-email", s.VerifyEmail)
+	}
+
+	// Protected auth routes (require JWT)
+	apiProtected := router.Group("/api/v1/auth")
+	apiProtected.Use(middleware.JWTMiddleware(s.jwtConfig))
+	{
+		apiProtected.GET("/validate", s.ValidateToken)
+	}
+
+	// User routes
+	users := router.Group("/api/v1/users")
+	users.Use(middleware.JWTMiddleware(s.jwtConfig))
+	{
+		users.GET("/me", s.GetCurrentUser)
+		users.PUT("/me", s.UpdateCurrentUser)
+		users.GET("/:id", s.GetUser)
+	}
+
+	// Legacy v0 routes
+	v0 := router.Group("/api/v0/users")
+	{
+		v0.POST("/auth", s.LegacyRegister)
+		v0.POST("/auth/login", s.Login)
+		v0.GET("/auth/verification", s.Verify)
+		v0.GET("/:id", s.GetUser)
+	}
+}
+
+// Register handles user registration
+func (s *AuthService) Register(c *gin.Context) {
+	var req RegisterRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	s.register(c, req)
+}
+
+// LegacyRegister handles legacy v0 registration
+func (s *AuthService) LegacyRegister(c *gin.Context) {
+	var req LegacyRegisterRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+	s.register(c, req.RegisterRequest)
+}
+
+func (s *AuthService) register(c *gin.Context, req RegisterRequest) {
+	if len(req.Password) > maxPasswordBytes {
+		common.BadRequestResponse(c, fmt.Sprintf("password must be at most %d bytes", maxPasswordBytes))
+		return
+	}
+
+	// Check if user exists
+	var existingUser User
+	if err := s.db.DB().Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+		common.ConflictResponse(c, "user already exists")
+		return
+	}
+
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), 12)
+	if err != nil {
+		s.logger.Error("failed to hash password", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	// Create user
+	user := User{
+		ID:           uuid.New().String(),
+		Email:        req.Email,
+		PasswordHash: string(hashedPassword),
+		FirstName:    req.FirstName,
+		LastName:     req.LastName,
+		IsActive:     true,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+
+	// The user.created and verification events commit with the user, so
+	// they are never lost
+	err = s.db.Transaction(c.Request.Context(), func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		event, err := messaging.NewEvent("auth-service", messaging.UserCreated{
+			UserID: user.ID,
+			Email:  user.Email,
+		})
+		if err != nil {
+			return err
+		}
+		if err := messaging.Enqueue(tx, messaging.TopicUserCreated, user.ID, event); err != nil {
+			return err
+		}
+		return s.requestVerification(tx, &user)
+	})
+	if err != nil {
+		s.logger.Error("failed to create user", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	// Generate tokens
+	accessToken, err := middleware.GenerateAccessToken(s.jwtConfig, user.ID, user.Email)
+	if err != nil {
+		s.logger.Error("failed to generate access token", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	refreshToken, err := s.createRefreshToken(c.Request.Context(), user.ID)
+	if err != nil {
+		s.logger.Error("failed to generate refresh token", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.CreatedResponse(c, TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.jwtConfig.AccessExpiry.Seconds()),
+		User:         user.Short(),
+	})
+}
+
+// Login handles user login
+func (s *AuthService) Login(c *gin.Context) {
+	var req LoginRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	// Find user
+	var user User
+	if err := s.db.DB().Where("email = ?", req.Email).First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			common.UnauthorizedResponse(c, "invalid credentials")
+			return
+		}
+		s.logger.Error("failed to find user", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	// Check if user is active
+	if !user.IsActive {
+		common.UnauthorizedResponse(c, "account is disabled")
+		return
+	}
+
+	// Verify password
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		common.UnauthorizedResponse(c, "invalid credentials")
+		return
+	}
+
+	// Generate tokens
+	accessToken, err := middleware.GenerateAccessToken(s.jwtConfig, user.ID, user.Email)
+	if err != nil {
+		s.logger.Error("failed to generate access token", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	refreshToken, err := s.createRefreshToken(c.Request.Context(), user.ID)
+	if err != nil {
+		s.logger.Error("failed to generate refresh token", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.jwtConfig.AccessExpiry.Seconds()),
+		User:         user.Short(),
+	})
+}
+
+// RefreshTokenHandler handles token refresh
+func (s *AuthService) RefreshTokenHandler(c *gin.Context) {
+	var req RefreshRequest
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	// Find refresh token
+	var storedToken RefreshToken
+	if err := s.db.DB().Where("token = ?", req.RefreshToken).First(&storedToken).Error; err != nil {
+		common.UnauthorizedResponse(c, "invalid refresh token")
+		return
+	}
+
+	// Check expiration
+	if time.Now().After(storedToken.ExpiresAt) {
+		s.db.DB().Delete(&storedToken)
+		common.UnauthorizedResponse(c, "refresh token expired")
+		return
+	}
+
+	// Find user
+	var user User
+	if err := s.db.DB().First(&user, "id = ?", storedToken.UserID).Error; err != nil {
+		common.UnauthorizedResponse(c, "user not found")
+		return
+	}
+
+	// Delete old refresh token
+	s.db.DB().Delete(&storedToken)
+
+	// Generate new tokens
+	accessToken, err := middleware.GenerateAccessToken(s.jwtConfig, user.ID, user.Email)
+	if err != nil {
+		s.logger.Error("failed to generate access token", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	newRefreshToken, err := s.createRefreshToken(c.Request.Context(), user.ID)
+	if err != nil {
+		s.logger.Error("failed to generate refresh token", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, TokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: newRefreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.jwtConfig.AccessExpiry.Seconds()),
+		User:         user.Short(),
+	})
+}
+
+// Logout handles user logout
+func (s *AuthService) Logout(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.NoContentResponse(c)
+		return
+	}
+
+	// Delete refresh token
+	s.db.DB().Where("token = ?", req.RefreshToken).Delete(&RefreshToken{})
+
+	// Invalidate session in cache if available
+	if s.cache != nil {
+		userID := c.GetHeader("X-User-ID")
+		if userID != "" {
+			if err := s.cache.DeleteSession(c.Request.Context(), userID); err != nil {
+				s.logger.Error("failed to delete session", zap.Error(err))
+			}
+		}
+	}
+
+	common.NoContentResponse(c)
+}
+
+// Verify verifies the JWT token (legacy, no auth required)
+func (s *AuthService) Verify(c *gin.Context) {
+	common.SuccessResponse(c, gin.H{
+		"auth":    true,
+		"message": "authenticated",
+	})
+}
+
+// requestVerification issues a verification token for the user's email
+// address and asks for the link to be sent to them
+func (s *AuthService) requestVerification(tx *gorm.DB, user *User) error {
+	token, err := newVerificationToken()
+	if err != nil {
+		return err
+	}
+
+	verification := EmailVerification{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		TokenHash: hashVerificationToken(token),
+		ExpiresAt: time.Now().Add(verificationExpiry),
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Create(&verification).Error; err != nil {
+		return err
+	}
+
+	event, err := messaging.NewEvent("auth-service", messaging.UserVerificationRequested{
+		UserID: user.ID,
+		Email:  user.Email,
+		URL:    s.verificationURL + "?token=" + token,
+	})
+	if err != nil {
+		return err
+	}
+	return messaging.Enqueue(tx, messaging.TopicUserVerificationRequested, user.ID, event)
+}
+
+// newVerificationToken returns a random URL-safe token
+func newVerificationToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashVerificationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyEmail marks the user's email address verified, given the token from
+// their verification link. Tokens work once.
+func (s *AuthService) VerifyEmail(c *gin.Context) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		common.BadRequestResponse(c, "token is required")
+		return
+	}
+
+	var user User
+	err := s.db.Transaction(c.Request.Context(), func(tx *gorm.DB) error {
+		var verification EmailVerification
+		if err := tx.Where("token_hash = ?", hashVerificationToken(req.Token)).First(&verification).Error; err != nil {
+			return err
+		}
+		if time.Now().After(verification.ExpiresAt) {
+			return gorm.ErrRecordNotFound
+		}
+
+		if err := tx.First(&user, "id = ?", verification.UserID).Error; err != nil {
+			return err
+		}
+		user.IsVerified = true
+		user.UpdatedAt = time.Now()
+		if err := tx.Model(&user).Select("is_verified", "updated_at").Updates(&user).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&EmailVerification{}).Error
+	})
+	if err != nil {
+		if err == gorm.ErrRecordNotFound {
+			common.BadRequestResponse(c, "invalid or expired verification token")
+			return
+		}
+		s.logger.Error("failed to verify email", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, user.Short())
+}
+
+// ValidateToken validates the JWT token and returns user info (requires auth)
+func (s *AuthService) ValidateToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		common.UnauthorizedResponse(c, "not authenticated")
+		return
+	}
+
+	email, _ := c.Get("email")
+
+	common.SuccessResponse(c, gin.H{
+		"valid":   true,
+		"user_id": userID,
+		"email":   email,
+	})
+}
+
+// GetCurrentUser gets the current authenticated user
+func (s *AuthService) GetCurrentUser(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		common.UnauthorizedResponse(c, "not authenticated")
+		return
+	}
+
+	var user User
+	if err := s.db.DB().First(&user, "id = ?", userID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			common.NotFoundResponse(c, "user not found")
+			return
+		}
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, user.Short())
+}
+
+// UpdateCurrentUser updates the current authenticated user
+func (s *AuthService) UpdateCurrentUser(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		common.UnauthorizedResponse(c, "not authenticated")
+		return
+	}
+
+	var updates struct {
+		FirstName string `json:"first_name" binding:"max=100"`
+		LastName  string `json:"last_name" binding:"max=100"`
+		AvatarURL string `json:"avatar_url" binding:"max=2048"`
+	}
+
+	if !middleware.BindJSON(c, &updates) {
+		return
+	}
+
+	var user User
+	if err := s.db.DB().First(&user, "id = ?", userID).Error; err != nil {
+		common.NotFoundResponse(c, "user not found")
+		return
+	}
+
+	user.FirstName = updates.FirstName
+	user.LastName = updates.LastName
+	user.AvatarURL = updates.AvatarURL
+	user.UpdatedAt = time.Now()
+
+	if err := s.db.DB().Save(&user).Error; err != nil {
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, user.Short())
+}
+
+// GetUser gets a user by ID
+func (s *AuthService) GetUser(c *gin.Context) {
+	id := c.Param("id")
+
+	var user User
+	if err := s.db.DB().First(&user, "id = ?", id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			common.NotFoundResponse(c, "user not found")
+			return
+		}
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, user.Short())
+}
+
+func (s *AuthService) createRefreshToken(ctx context.Context, userID string) (string, error) {
+	token := uuid.New().String()
+
+	refreshToken := RefreshToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(s.jwtConfig.RefreshExpiry),
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.db.DB().Create(&refreshToken).Error; err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/database"
+	"github.com/Femi-lawal/udagram-app/pkg/database/databasetest"
+	"github.com/Femi-lawal/udagram-app/pkg/messaging"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newTestRouter(t *testing.T) (*gin.Engine, *database.Client) {
+	db := databasetest.New(t, &User{}, &RefreshToken{}, &EmailVerification{}, &messaging.OutboxMessage{})
+	authService := &AuthService{
+		db:              db,
+		logger:          zap.NewNop(),
+		verificationURL: "https://udagram.example.com/api/v1/auth/verify-email",
+		jwtConfig: middleware.JWTConfig{
+			Secret:        "test-secret",
+			Issuer:        "udagram",
+			Audience:      "udagram-users",
+			AccessExpiry:  time.Minute,
+			RefreshExpiry: time.Hour,
+		},
+	}
+
+	router := gin.New()
+	router.Use(middleware.BodyLimitMiddleware(middleware.DefaultBodyLimitConfig()))
+	authService.RegisterRoutes(router)
+	return router, db
+}
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		status  int
+		message string
+	}{
+		{
+			name:   "frontend signup",
+			path:   "/api/v0/users/auth",
+			body:   `{"email":"jane@example.com","username":"jane","password":"correct horse"}`,
+			status: http.StatusCreated,
+		},
+		{
+			name:    "v1 rejects unknown fields",
+			path:    "/api/v1/auth/register",
+			body:    `{"email":"jane@example.com","username":"jane","password":"correct horse"}`,
+			status:  http.StatusBadRequest,
+			message: `unknown field \"username\"`,
+		},
+		{
+			name:    "password over 72 bytes",
+			path:    "/api/v1/auth/register",
+			body:    `{"email":"jane@example.com","password":"` + strings.Repeat("密", 30) + `"}`,
+			status:  http.StatusBadRequest,
+			message: "password must be at most 72 bytes",
+		},
+		{
+			name:    "legacy password over 72 bytes",
+			path:    "/api/v0/users/auth",
+			body:    `{"email":"jane@example.com","username":"jane","password":"` + strings.Repeat("密", 30) + `"}`,
+			status:  http.StatusBadRequest,
+			message: "password must be at most 72 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, db := newTestRouter(t)
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.message)
+
+			var users, verifications int64
+			require.NoError(t, db.DB().Model(&User{}).Count(&users).Error)
+			require.NoError(t, db.DB().Model(&EmailVerification{}).Count(&verifications).Error)
+			var topics []string
+			require.NoError(t, db.DB().Model(&messaging.OutboxMessage{}).Order("id").Pluck("topic", &topics).Error)
+			if tt.status == http.StatusCreated {
+				assert.Equal(t, int64(1), users)
+				assert.Equal(t, int64(1), verifications)
+				assert.Equal(t, []string{messaging.TopicUserCreated, messaging.TopicUserVerificationRequested}, topics,
+					"user.created and the verification request are written to the outbox")
+			} else {
+				assert.Zero(t, users)
+				assert.Zero(t, verifications)
+				assert.Empty(t, topics)
+			}
+		})
+	}
+}
+
+// register signs a user up and returns the token from their verification
+// link
+func register(t *testing.T, router *gin.Engine, db *database.Client) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/register",
+		strings.NewReader(`{"email":"jane@example.com","password":"correct horse"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var outbox messaging.OutboxMessage
+	require.NoError(t, db.DB().Where("topic = ?", messaging.TopicUserVerificationRequested).First(&outbox).Error)
+	var event messaging.Event
+	require.NoError(t, json.Unmarshal([]byte(outbox.Payload), &event))
+	verification, err := messaging.Decode[messaging.UserVerificationRequested](event)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", verification.Email)
+
+	link, err := url.Parse(verification.URL)
+	require.NoError(t, err)
+	assert.Equal(t, "/api/v1/auth/verify-email", link.Path)
+	return link.Query().Get("token")
+}
+
+func verifyEmail(router *gin.Engine, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/verify-email?token="+url.QueryEscape(token), nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestVerifyEmail(t *testing.T) {
+	router, db := newTestRouter(t)
+	token := register(t, router, db)
+	require.NotEmpty(t, token)
+
+	// Only a hash of the token is stored
+	var verification EmailVerification
+	require.NoError(t, db.DB().First(&verification).Error)
+	assert.NotContains(t, verification.TokenHash, token)
+
+	w := verifyEmail(router, "not-the-token")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = verifyEmail(router, token)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"is_verified":true`)
+
+	var user User
+	require.NoError(t, db.DB().First(&user).Error)
+	assert.True(t, user.IsVerified)
+
+	// Tokens work once
+	w = verifyEmail(router, token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestVerifyEmail_Expired(t *testing.T) {
+	router, db := newTestRouter(t)
+	token := register(t, router, db)
+
+	require.NoError(t, db.DB().Model(&EmailVerification{}).
+		Where("1 = 1").
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+	w := verifyEmail(router, token)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid or expired verification token")
+
+	var user User
+	require.NoError(t, db.DB().First(&user).Error)
+	assert.False(t, user.IsVerified)
+}
+
+func TestVerifyEmail_MissingToken(t *testing.T) {
+	router, _ := newTestRouter(t)
+
+	w := verifyEmail(router, "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
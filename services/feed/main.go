@@ -46,13 +46,13 @@ func (FeedItem) TableName() string {
 
 // CreateFeedRequest represents create feed request
 type CreateFeedRequest struct {
-	Caption string `json:"caption" binding:"required"`
-	URL     string `json:"url" binding:"required"`
+	Caption string `json:"caption" binding:"required,max=2000"`
+	URL     string `json:"url" binding:"required,max=2048"`
 }
 
 // UpdateFeedRequest represents update feed request
 type UpdateFeedRequest struct {
-	Caption string `json:"caption"`
+	Caption string `json:"caption" binding:"max=2000"`
 }
 
 // FeedResponse represents paginated feed response
@@ -116,7 +116,7 @@ func main() {
 	}()
 
 	// Run migrations
-	if err := db.Migrate(&FeedItem{}); err != nil {
+	if err := db.Migrate(&FeedItem{}, &messaging.OutboxMessage{}); err != nil {
 		logger.Fatal("failed to run migrations", zap.Error(err))
 	}
 
@@ -136,13 +136,32 @@ func main() {
 		logger.Warn("failed to connect to Redis, continuing without cache", zap.Error(err))
 	}
 
-	// Initialize Kafka producer
-	var producer *messaging.Producer
+	// Initialize Kafka producer. MESSAGING_BROKER=memory keeps events in
+	// this process instead, which only suits running the service on its own:
+	// other services never see its events, nor it theirs.
 	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
-	if kafkaBrokers != "" {
-		producer = messaging.NewProducer(messaging.Config{
-			Brokers: []string{kafkaBrokers},
-		}, logger)
+	messagingConfig := messaging.Config{
+		Brokers: []string{kafkaBrokers},
+	}
+	if getEnv("MESSAGING_BROKER", "kafka") == "memory" {
+		messagingConfig.Broker = messaging.NewMemoryBroker(1)
+		logger.Warn("using the in-memory message broker: events are not exchanged with other services")
+	}
+
+	var producer *messaging.Producer
+	if kafkaBrokers != "" || messagingConfig.Broker != nil {
+		producer = messaging.NewProducer(messagingConfig, logger)
+	}
+
+	// Events are written to the outbox with the changes they describe and
+	// published from there
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	if producer != nil {
+		outboxConfig := messaging.DefaultOutboxConfig()
+		outboxConfig.Interval = getEnvDuration("OUTBOX_INTERVAL", outboxConfig.Interval)
+		outboxConfig.BatchSize = getEnvInt("OUTBOX_BATCH_SIZE", outboxConfig.BatchSize)
+		go messaging.NewOutboxRelay(db, producer, outboxConfig, logger).Run(relayCtx)
 	}
 
 	// Initialize S3 client
@@ -174,19 +193,33 @@ func main() {
 	router := gin.New()
 	router.Use(gin.Recovery())
 	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.TracingMiddleware())
 	router.Use(middleware.LoggerMiddleware(logger))
 	router.Use(middleware.MetricsMiddleware())
 
+	compression := middleware.DefaultCompressionConfig()
+	compression.MinSize = getEnvInt("COMPRESSION_MIN_BYTES", compression.MinSize)
+	router.Use(middleware.CompressionMiddleware(compression))
+
+	router.Use(middleware.TimeoutMiddleware(middleware.TimeoutConfig{
+		Timeout: getEnvDuration("REQUEST_TIMEOUT", middleware.DefaultTimeoutConfig().Timeout),
+	}))
+
+	bodyLimit := middleware.DefaultBodyLimitConfig()
+	bodyLimit.MaxBytes = int64(getEnvInt("MAX_BODY_BYTES", int(bodyLimit.MaxBytes)))
+	router.Use(middleware.BodyLimitMiddleware(bodyLimit))
+
 	// Health endpoints
 	router.GET("/health", middleware.HealthCheck())
 	router.GET("/ready", middleware.ReadinessCheck(db.HealthCheck()))
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/routes", middleware.RoutesHandler(router))
 
 	// Feed routes
 	api := router.Group("/api/v1/feed")
 	{
-		api.GET("", feedService.GetFeed)
-		api.GET("/:id", feedService.GetFeedItem)
+		api.GET("", middleware.ETagMiddleware(), feedService.GetFeed)
+		api.GET("/:id", middleware.ETagMiddleware(), feedService.GetFeedItem)
 		api.POST("", feedService.CreateFeedItem)
 		api.PUT("/:id", feedService.UpdateFeedItem)
 		api.DELETE("/:id", feedService.DeleteFeedItem)
@@ -198,8 +231,8 @@ func main() {
 	// Legacy v0 routes
 	v0 := router.Group("/api/v0/feed")
 	{
-		v0.GET("", feedService.GetFeed)
-		v0.GET("/:id", feedService.GetFeedItem)
+		v0.GET("", middleware.ETagMiddleware(), feedService.GetFeed)
+		v0.GET("/:id", middleware.ETagMiddleware(), feedService.GetFeedItem)
 		v0.POST("", feedService.CreateFeedItem)
 		v0.GET("/signed-url/:filename", feedService.GetSignedURL)
 	}
@@ -341,8 +374,7 @@ func (s *FeedService) CreateFeedItem(c *gin.Context) {
 	}
 
 	var req CreateFeedRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		common.BadRequestResponse(c, "invalid request body")
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 
@@ -355,7 +387,22 @@ func (s *FeedService) CreateFeedItem(c *gin.Context) {
 		UpdatedAt: time.Now(),
 	}
 
-	if err := s.db.DB().Create(&item).Error; err != nil {
+	// The feed.created event commits with the item, so it is never lost
+	err := s.db.Transaction(c.Request.Context(), func(tx *gorm.DB) error {
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+		event, err := messaging.NewEvent("feed-service", messaging.FeedCreated{
+			FeedID:  item.ID,
+			UserID:  item.UserID,
+			Caption: item.Caption,
+		})
+		if err != nil {
+			return err
+		}
+		return messaging.Enqueue(tx, messaging.TopicFeedCreated, item.ID, event)
+	})
+	if err != nil {
 		s.logger.Error("failed to create feed item", zap.Error(err))
 		common.ErrorResponse(c, common.ErrInternalServer)
 		return
@@ -371,16 +418,6 @@ func (s *FeedService) CreateFeedItem(c *gin.Context) {
 		s.invalidateFeedCache(c.Request.Context())
 	}
 
-	// Publish event
-	if s.producer != nil {
-		event := messaging.NewEvent("feed.created", "feed-service", map[string]interface{}{
-			"feed_id": item.ID,
-			"user_id": item.UserID,
-			"caption": item.Caption,
-		})
-		s.producer.PublishAsync(c.Request.Context(), messaging.TopicFeedCreated, item.ID, event)
-	}
-
 	common.CreatedResponse(c, item)
 }
 
@@ -406,15 +443,29 @@ func (s *FeedService) UpdateFeedItem(c *gin.Context) {
 	}
 
 	var req UpdateFeedRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		common.BadRequestResponse(c, "invalid request body")
+	if !middleware.BindJSON(c, &req) {
 		return
 	}
 
 	item.Caption = req.Caption
 	item.UpdatedAt = time.Now()
 
-	if err := s.db.DB().Save(&item).Error; err != nil {
+	err := s.db.Transaction(c.Request.Context(), func(tx *gorm.DB) error {
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		event, err := messaging.NewEvent("feed-service", messaging.FeedUpdated{
+			FeedID:  item.ID,
+			UserID:  item.UserID,
+			Caption: item.Caption,
+		})
+		if err != nil {
+			return err
+		}
+		return messaging.Enqueue(tx, messaging.TopicFeedUpdated, item.ID, event)
+	})
+	if err != nil {
+		s.logger.Error("failed to update feed item", zap.Error(err))
 		common.ErrorResponse(c, common.ErrInternalServer)
 		return
 	}
@@ -448,7 +499,21 @@ func (s *FeedService) DeleteFeedItem(c *gin.Context) {
 		return
 	}
 
-	if err := s.db.DB().Delete(&item).Error; err != nil {
+	err := s.db.Transaction(c.Request.Context(), func(tx *gorm.DB) error {
+		if err := tx.Delete(&item).Error; err != nil {
+			return err
+		}
+		event, err := messaging.NewEvent("feed-service", messaging.FeedDeleted{
+			FeedID: item.ID,
+			UserID: item.UserID,
+		})
+		if err != nil {
+			return err
+		}
+		return messaging.Enqueue(tx, messaging.TopicFeedDeleted, item.ID, event)
+	})
+	if err != nil {
+		s.logger.Error("failed to delete feed item", zap.Error(err))
 		common.ErrorResponse(c, common.ErrInternalServer)
 		return
 	}
@@ -458,15 +523,6 @@ func (s *FeedService) DeleteFeedItem(c *gin.Context) {
 		s.invalidateFeedCache(c.Request.Context())
 	}
 
-	// Publish event
-	if s.producer != nil {
-		event := messaging.NewEvent("feed.deleted", "feed-service", map[string]interface{}{
-			"feed_id": item.ID,
-			"user_id": item.UserID,
-		})
-		s.producer.PublishAsync(c.Request.Context(), messaging.TopicFeedDeleted, item.ID, event)
-	}
-
 	common.NoContentResponse(c)
 }
 
@@ -613,3 +669,14 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		result, err := time.ParseDuration(value)
+		if err != nil {
+			return defaultValue
+		}
+		return result
+	}
+	return defaultValue
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+)
+
+// Load balancing strategies
+const (
+	StrategyRoundRobin       = "round_robin"
+	StrategyLeastConnections = "least_conn"
+	StrategyConsistentHash   = "consistent_hash"
+)
+
+// balancer selects an endpoint from the currently available set
+type balancer interface {
+	pick(endpoints []*endpoint, key string) *endpoint
+}
+
+// newBalancer returns the balancer for the named strategy
+func newBalancer(strategy string) (balancer, error) {
+	switch strategy {
+	case "", StrategyRoundRobin:
+		return &roundRobinBalancer{}, nil
+	case StrategyLeastConnections:
+		return &leastConnBalancer{}, nil
+	case StrategyConsistentHash:
+		return &consistentHashBalancer{fallback: &roundRobinBalancer{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy %q", strategy)
+	}
+}
+
+// roundRobinBalancer cycles through endpoints in order
+type roundRobinBalancer struct {
+	next atomic.Uint64
+}
+
+func (b *roundRobinBalancer) pick(endpoints []*endpoint, _ string) *endpoint {
+	if len(endpoints) == 0 {
+		return nil
+	}
+	n := b.next.Add(1) - 1
+	return endpoints[n%uint64(len(endpoints))]
+}
+
+// leastConnBalancer picks the endpoint with the fewest in-flight requests,
+// breaking ties in order so load spreads evenly when idle
+type leastConnBalancer struct {
+	next atomic.Uint64
+}
+
+func (b *leastConnBalancer) pick(endpoints []*endpoint, _ string) *endpoint {
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	offset := int(b.next.Add(1) % uint64(len(endpoints)))
+	var best *endpoint
+	var bestInFlight int64
+	for i := range endpoints {
+		ep := endpoints[(offset+i)%len(endpoints)]
+		inFlight := ep.inFlight.Load()
+		if best == nil || inFlight < bestInFlight {
+			best = ep
+			bestInFlight = inFlight
+		}
+	}
+	return best
+}
+
+// consistentHashBalancer maps a key (the user ID) to the same endpoint for as
+// long as that endpoint is available, using rendezvous hashing so that adding
+// or removing an endpoint only moves the keys that belonged to it.
+// Requests without a key fall back to round robin.
+type consistentHashBalancer struct {
+	fallback balancer
+}
+
+func (b *consistentHashBalancer) pick(endpoints []*endpoint, key string) *endpoint {
+	if key == "" {
+		return b.fallback.pick(endpoints, key)
+	}
+
+	var best *endpoint
+	var bestScore uint64
+	for _, ep := range endpoints {
+		score := rendezvousScore(key, ep.id)
+		if best == nil || score > bestScore {
+			best = ep
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func rendezvousScore(key, endpointID string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write([]byte(endpointID))
+
+	// Finalize with a 64-bit mixer so similar inputs spread evenly
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
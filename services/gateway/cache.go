@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/cache"
+	"github.com/Femi-lawal/udagram-app/pkg/messaging"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+// Metrics
+var (
+	responseCacheResults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_response_cache_total",
+			Help: "Total number of cacheable requests by route and result (hit, miss, bypass)",
+		},
+		[]string{"route", "result"},
+	)
+
+	responseCacheInvalidations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_response_cache_invalidations_total",
+			Help: "Total number of response cache invalidations by event",
+		},
+		[]string{"event"},
+	)
+)
+
+// Response cache backends
+const (
+	ResponseCacheMemory = "memory"
+	ResponseCacheRedis  = "redis"
+	ResponseCacheOff    = "off"
+)
+
+// cacheInvalidationTopics are the events routes can invalidate their cached
+// responses on
+var cacheInvalidationTopics = []string{
+	messaging.TopicFeedCreated,
+	messaging.TopicFeedUpdated,
+	messaging.TopicFeedDeleted,
+	messaging.TopicUserUpdated,
+}
+
+// cachedHeaders are the upstream headers stored with a cached response.
+// Everything else is per request or added by the gateway itself.
+var cachedHeaders = []string{"Content-Type", "Content-Language", "ETag", "Last-Modified"}
+
+// RouteCache configures HTTP caching for a GET route
+type RouteCache struct {
+	// Control is sent as the Cache-Control header
+	Control string `yaml:"control"`
+	// TTL enables caching anonymous responses at the gateway
+	TTL time.Duration `yaml:"ttl"`
+	// InvalidateOn lists the events that drop the route's cached responses
+	InvalidateOn []string `yaml:"invalidate_on"`
+}
+
+// validate checks the cache settings of route
+func (rc *RouteCache) validate(route Route) []error {
+	var errs []error
+	name := route.Path
+
+	if !slices.Contains(route.Methods, http.MethodGet) {
+		errs = append(errs, fmt.Errorf("route %q: cache applies to GET routes only", name))
+	}
+	if rc.TTL < 0 {
+		errs = append(errs, fmt.Errorf("route %q: cache ttl must not be negative", name))
+	}
+	if rc.TTL > 0 && route.Auth {
+		errs = append(errs, fmt.Errorf("route %q: responses of routes with auth are never cached", name))
+	}
+	for _, topic := range rc.InvalidateOn {
+		if !slices.Contains(cacheInvalidationTopics, topic) {
+			errs = append(errs, fmt.Errorf("route %q: unknown cache invalidation event %q", name, topic))
+		}
+	}
+
+	return errs
+}
+
+// cachedResponse is a stored upstream response
+type cachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// responseStore holds cached responses. Entries are tagged with the events
+// that invalidate them.
+type responseStore interface {
+	Get(ctx context.Context, key string) (*cachedResponse, error)
+	Set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration, tags []string) error
+	Purge(ctx context.Context, tag string) error
+	Close() error
+}
+
+// newResponseStore creates the configured response store. The gateway falls
+// back to memory when Redis is unavailable, since each replica can still
+// serve from its own cache.
+func newResponseStore(config *Config, logger *zap.Logger) responseStore {
+	switch config.ResponseCache {
+	case ResponseCacheOff:
+		return nil
+	case ResponseCacheRedis:
+		client, err := cache.NewClient(config.Redis, logger)
+		if err == nil {
+			return &redisResponseStore{client: client}
+		}
+		logger.Warn("failed to connect to Redis, caching responses in memory", zap.Error(err))
+	}
+
+	return newMemoryResponseStore(config.ResponseCacheMaxEntries)
+}
+
+// memoryResponseStore is a bounded in-process response store
+type memoryResponseStore struct {
+	mu         sync.Mutex
+	entries    map[string]memoryEntry
+	tags       map[string]map[string]struct{}
+	maxEntries int
+}
+
+type memoryEntry struct {
+	resp    *cachedResponse
+	expires time.Time
+}
+
+func newMemoryResponseStore(maxEntries int) *memoryResponseStore {
+	return &memoryResponseStore{
+		entries:    make(map[string]memoryEntry),
+		tags:       make(map[string]map[string]struct{}),
+		maxEntries: maxEntries,
+	}
+}
+
+func (s *memoryResponseStore) Get(ctx context.Context, key string) (*cachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if time.Now().After(entry.expires) {
+		delete(s.entries, key)
+		return nil, nil
+	}
+	return entry.resp, nil
+}
+
+func (s *memoryResponseStore) Set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration, tags []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.entries[key]; !exists && len(s.entries) >= s.maxEntries {
+		s.evictExpired()
+		if len(s.entries) >= s.maxEntries {
+			return nil
+		}
+	}
+
+	s.entries[key] = memoryEntry{resp: resp, expires: time.Now().Add(ttl)}
+	for _, tag := range tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]struct{})
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+	return nil
+}
+
+func (s *memoryResponseStore) Purge(ctx context.Context, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.tags[tag] {
+		delete(s.entries, key)
+	}
+	delete(s.tags, tag)
+	return nil
+}
+
+func (s *memoryResponseStore) Close() error {
+	return nil
+}
+
+// evictExpired drops expired entries and the tag references to them
+func (s *memoryResponseStore) evictExpired() {
+	now := time.Now()
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+	for tag, keys := range s.tags {
+		for key := range keys {
+			if _, ok := s.entries[key]; !ok {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
+
+// redisResponseStore shares cached responses between gateway replicas. Each
+// tag is a set of the keys to delete when it is purged.
+type redisResponseStore struct {
+	client *cache.Client
+}
+
+func (s *redisResponseStore) Get(ctx context.Context, key string) (*cachedResponse, error) {
+	var resp cachedResponse
+	found, err := s.client.GetJSON(ctx, "gateway:response:"+key, &resp)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (s *redisResponseStore) Set(ctx context.Context, key string, resp *cachedResponse, ttl time.Duration, tags []string) error {
+	if err := s.client.SetJSON(ctx, "gateway:response:"+key, resp, ttl); err != nil {
+		return err
+	}
+
+	for _, tag := range tags {
+		tagKey := "gateway:response-tag:" + tag
+		if err := s.client.SAdd(ctx, tagKey, "gateway:response:"+key); err != nil {
+			return err
+		}
+		// Keep the set around as long as the longest-lived of its entries
+		if current, err := s.client.TTL(ctx, tagKey); err == nil && current >= ttl {
+			continue
+		}
+		if err := s.client.Expire(ctx, tagKey, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *redisResponseStore) Purge(ctx context.Context, tag string) error {
+	tagKey := "gateway:response-tag:" + tag
+	keys, err := s.client.SMembers(ctx, tagKey)
+	if err != nil {
+		return err
+	}
+	return s.client.Delete(ctx, append(keys, tagKey)...)
+}
+
+func (s *redisResponseStore) Close() error {
+	return s.client.Close()
+}
+
+// cacheHandler applies the route's caching settings: it adds Cache-Control
+// and an ETag, answers matching conditional requests with 304, and serves
+// anonymous requests from the response store when the route has a TTL.
+func (g *Gateway) cacheHandler(route Route) gin.HandlerFunc {
+	rc := route.Cache
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet {
+			c.Next()
+			return
+		}
+
+		name := c.FullPath()
+		cacheable := g.responses != nil && rc.TTL > 0 && isAnonymous(c.Request)
+		key := c.Request.URL.RequestURI()
+
+		if cacheable {
+			resp, err := g.responses.Get(c.Request.Context(), key)
+			if err != nil {
+				g.logger.Warn("response cache lookup failed", zap.String("key", key), zap.Error(err))
+			}
+			if resp != nil {
+				responseCacheResults.WithLabelValues(name, "hit").Inc()
+				header := c.Writer.Header()
+				for k, v := range resp.Header {
+					header[k] = v
+				}
+				header.Set("X-Cache", "HIT")
+				setCacheControl(header, rc.Control)
+				middleware.WriteConditional(c, resp.Status, resp.Body)
+				c.Abort()
+				return
+			}
+
+			// Store an identity-encoded body that suits every client
+			c.Request.Header.Del("Accept-Encoding")
+		}
+
+		original := c.Writer
+		bw := middleware.NewBufferedWriter(original)
+		c.Writer = bw
+		defer func() { c.Writer = original }()
+
+		c.Next()
+
+		c.Writer = original
+		status, body := bw.Status(), bw.Body()
+		header := original.Header()
+		if status == http.StatusOK && header.Get("ETag") == "" {
+			header.Set("ETag", middleware.ETag(body))
+		}
+
+		if cacheable {
+			responseCacheResults.WithLabelValues(name, "miss").Inc()
+			header.Set("X-Cache", "MISS")
+			if status == http.StatusOK && header.Get("Set-Cookie") == "" && !isPrivate(header.Get("Cache-Control")) {
+				g.storeResponse(c.Request.Context(), key, status, header, body, rc)
+			}
+		} else {
+			responseCacheResults.WithLabelValues(name, "bypass").Inc()
+		}
+
+		setCacheControl(header, rc.Control)
+		middleware.WriteConditional(c, status, body)
+	}
+}
+
+// storeResponse saves an upstream response with the headers worth replaying
+func (g *Gateway) storeResponse(ctx context.Context, key string, status int, header http.Header, body []byte, rc *RouteCache) {
+	resp := &cachedResponse{Status: status, Header: make(http.Header), Body: body}
+	for _, k := range cachedHeaders {
+		if v := header.Values(k); len(v) > 0 {
+			resp.Header[k] = v
+		}
+	}
+
+	if err := g.responses.Set(ctx, key, resp, rc.TTL, rc.InvalidateOn); err != nil {
+		g.logger.Warn("failed to cache response", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// setCacheControl overrides the upstream Cache-Control when the route sets one
+func setCacheControl(header http.Header, control string) {
+	if control != "" {
+		header.Set("Cache-Control", control)
+	}
+}
+
+// isAnonymous reports whether the request carries no credentials, so that its
+// response is the same for every client
+func isAnonymous(r *http.Request) bool {
+	return r.Header.Get("Authorization") == "" && r.Header.Get("Cookie") == ""
+}
+
+// isPrivate reports whether an upstream Cache-Control forbids shared caching
+func isPrivate(control string) bool {
+	for _, directive := range strings.Split(control, ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "private", "no-store", "no-cache":
+			return true
+		}
+	}
+	return false
+}
+
+// invalidateResponses drops the cached responses tagged with topic
+func (g *Gateway) invalidateResponses(ctx context.Context, topic string) error {
+	if g.responses == nil {
+		return nil
+	}
+
+	responseCacheInvalidations.WithLabelValues(topic).Inc()
+	return g.responses.Purge(ctx, topic)
+}
+
+// consumeInvalidations purges cached responses as invalidation events arrive
+// until ctx is done. Every replica uses its own consumer group so that each
+// one sees every event.
+func (g *Gateway) consumeInvalidations(ctx context.Context, brokers, groupID string) {
+	if g.responses == nil || brokers == "" {
+		return
+	}
+
+	// Groups are per replica, so retry and dead-letter topics would pile up
+	// with every deploy; a failed purge is retried in-process and then
+	// dropped, leaving the entry to expire
+	retry := messaging.DefaultRetryConfig()
+	retry.Delays = nil
+	retry.DeadLetter = false
+
+	for _, topic := range cacheInvalidationTopics {
+		go func() {
+			consumer := messaging.NewConsumer(
+				messaging.Config{Brokers: []string{brokers}, Retry: retry},
+				topic,
+				groupID,
+				g.logger,
+				func(ctx context.Context, event messaging.Event) error {
+					return g.invalidateResponses(ctx, topic)
+				},
+			)
+			defer consumer.Close()
+
+			if err := consumer.Start(ctx); err != nil && err != context.Canceled {
+				g.logger.Error("cache invalidation consumer error", zap.String("topic", topic), zap.Error(err))
+			}
+		}()
+	}
+}
+
+// cacheGroupID returns the consumer group for this replica's invalidations
+func cacheGroupID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "local"
+	}
+	return "gateway-cache-" + hostname
+}
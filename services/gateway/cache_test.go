@@ -0,0 +1,216 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const cacheTestConfig = `
+routes:
+  - path: /api/v1/feed
+    methods: [GET]
+    upstream: feed
+    cache:
+      control: public, max-age=30
+      ttl: 1m
+      invalidate_on: [feed.created]
+  - path: /api/v1/feed/:id
+    methods: [GET]
+    upstream: feed
+    cache:
+      control: no-cache
+`
+
+// newCacheTestGateway returns a gateway in front of a backend that counts
+// requests and answers with the count
+func newCacheTestGateway(t *testing.T) (*Gateway, *atomic.Int32) {
+	var hits atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Backend", "feed")
+		fmt.Fprintf(w, `{"hits":%d}`, n)
+	}))
+	t.Cleanup(backend.Close)
+
+	dynamic, err := parseDynamicConfig([]byte(cacheTestConfig))
+	require.NoError(t, err)
+	return newTestGateway(t, backend.URL, dynamic), &hits
+}
+
+func serveCached(gateway *Gateway, path string, header http.Header) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", path, nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	gateway.ServeHTTP(w, req)
+	return w
+}
+
+func TestGateway_ResponseCache(t *testing.T) {
+	gateway, hits := newCacheTestGateway(t)
+
+	w := serveCached(gateway, "/api/v1/feed?page=1", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "public, max-age=30", w.Header().Get("Cache-Control"))
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	w = serveCached(gateway, "/api/v1/feed?page=1", nil)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.JSONEq(t, `{"hits":1}`, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Header().Get("X-Backend"))
+	assert.NotEmpty(t, w.Header().Get("X-Request-ID"))
+
+	// The query is part of the key
+	w = serveCached(gateway, "/api/v1/feed?page=2", nil)
+	assert.JSONEq(t, `{"hits":2}`, w.Body.String())
+	assert.Equal(t, int32(2), hits.Load())
+}
+
+func TestGateway_ResponseCacheConditional(t *testing.T) {
+	gateway, hits := newCacheTestGateway(t)
+
+	etag := serveCached(gateway, "/api/v1/feed", nil).Header().Get("ETag")
+
+	w := serveCached(gateway, "/api/v1/feed", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, int32(1), hits.Load())
+
+	// Routes without a ttl still get ETags, computed from each response
+	w = serveCached(gateway, "/api/v1/feed/1", nil)
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.Empty(t, w.Header().Get("X-Cache"))
+	w = serveCached(gateway, "/api/v1/feed/1", http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"hits":3}`, w.Body.String())
+}
+
+func TestGateway_ResponseCacheSkipsCredentials(t *testing.T) {
+	gateway, hits := newCacheTestGateway(t)
+
+	serveCached(gateway, "/api/v1/feed", nil)
+	w := serveCached(gateway, "/api/v1/feed", http.Header{"Authorization": {"Bearer token"}})
+	assert.Empty(t, w.Header().Get("X-Cache"))
+	assert.JSONEq(t, `{"hits":2}`, w.Body.String())
+
+	serveCached(gateway, "/api/v1/feed", http.Header{"Cookie": {"session=1"}})
+	assert.Equal(t, int32(3), hits.Load())
+}
+
+func TestGateway_ResponseCacheInvalidation(t *testing.T) {
+	gateway, hits := newCacheTestGateway(t)
+
+	serveCached(gateway, "/api/v1/feed", nil)
+	serveCached(gateway, "/api/v1/feed", nil)
+	assert.Equal(t, int32(1), hits.Load())
+
+	require.NoError(t, gateway.invalidateResponses(context.Background(), "feed.deleted"))
+	serveCached(gateway, "/api/v1/feed", nil)
+	assert.Equal(t, int32(1), hits.Load())
+
+	require.NoError(t, gateway.invalidateResponses(context.Background(), "feed.created"))
+	w := serveCached(gateway, "/api/v1/feed", nil)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, int32(2), hits.Load())
+}
+
+func TestGateway_ResponseCacheCompressed(t *testing.T) {
+	page := `{"items":"` + strings.Repeat("feed item ", 200) + `"}`
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(page))
+	}))
+	defer backend.Close()
+
+	dynamic, err := parseDynamicConfig([]byte(cacheTestConfig))
+	require.NoError(t, err)
+	gateway := newTestGateway(t, backend.URL, dynamic)
+
+	gzipped := http.Header{"Accept-Encoding": {"gzip"}}
+	serveCached(gateway, "/api/v1/feed", gzipped)
+	w := serveCached(gateway, "/api/v1/feed", gzipped)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+	r, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	body, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(body))
+
+	// The weakened ETag of the compressed response still validates
+	etag := w.Header().Get("ETag")
+	assert.True(t, strings.HasPrefix(etag, "W/"))
+	w = serveCached(gateway, "/api/v1/feed", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = serveCached(gateway, "/api/v1/feed", nil)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, page, w.Body.String())
+}
+
+func TestMemoryResponseStore(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryResponseStore(2)
+	resp := &cachedResponse{Status: http.StatusOK}
+
+	require.NoError(t, store.Set(ctx, "a", resp, time.Minute, []string{"feed.created"}))
+	require.NoError(t, store.Set(ctx, "b", resp, time.Millisecond, nil))
+
+	// Full: c is only stored once b has expired
+	require.NoError(t, store.Set(ctx, "c", resp, time.Minute, nil))
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, store.Set(ctx, "c", resp, time.Minute, nil))
+
+	got, err := store.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+	got, err = store.Get(ctx, "c")
+	require.NoError(t, err)
+	assert.Same(t, resp, got)
+
+	require.NoError(t, store.Purge(ctx, "feed.created"))
+	got, err = store.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestParseDynamicConfig_InvalidCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		route string
+		err   string
+	}{
+		{"not GET", "methods: [POST]\n    cache: {control: no-store}", "GET routes only"},
+		{"negative ttl", "methods: [GET]\n    cache: {ttl: -1s}", "ttl must not be negative"},
+		{"auth", "methods: [GET]\n    auth: true\n    cache: {ttl: 1s}", "never cached"},
+		{"unknown event", "methods: [GET]\n    cache: {ttl: 1s, invalidate_on: [feed.liked]}", `unknown cache invalidation event "feed.liked"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseDynamicConfig([]byte("routes:\n  - path: /a\n    upstream: feed\n    " + tt.route + "\n"))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
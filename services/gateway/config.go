@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed gateway.yaml
+var defaultDynamicConfig []byte
+
+// DynamicConfig is the part of the gateway configuration that can be reloaded
+// without a restart. Unset fields fall back to the environment.
+type DynamicConfig struct {
+	AllowedOrigins []string                    `yaml:"allowed_origins"`
+	RateLimit      *RateLimitPolicy            `yaml:"rate_limit"`
+	Upstreams      map[string]UpstreamOverride `yaml:"upstreams"`
+	RateLimits     map[string]RateLimitPolicy  `yaml:"rate_limits"`
+	Routes         []Route                     `yaml:"routes"`
+}
+
+// UpstreamOverride replaces the environment settings for an upstream
+type UpstreamOverride struct {
+	Endpoints []string      `yaml:"endpoints"`
+	Strategy  string        `yaml:"strategy"`
+	Timeout   time.Duration `yaml:"timeout"`
+}
+
+// RateLimitPolicy is a named rate limit that routes can opt into
+type RateLimitPolicy struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+	Key               string  `yaml:"key"`
+}
+
+// Route maps a path and its methods to an upstream
+type Route struct {
+	Path          string        `yaml:"path"`
+	Methods       []string      `yaml:"methods"`
+	Upstream      string        `yaml:"upstream"`
+	Auth          bool          `yaml:"auth"`
+	RateLimit     string        `yaml:"rate_limit"`
+	Timeout       time.Duration `yaml:"timeout"`
+	Cache         *RouteCache   `yaml:"cache"`
+	Stream        bool          `yaml:"stream"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+// loadDynamicConfig reads the dynamic configuration from path, or the
+// embedded default when path is empty
+func loadDynamicConfig(path string) (*DynamicConfig, error) {
+	data := defaultDynamicConfig
+	if path != "" {
+		var err error
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read gateway config: %w", err)
+		}
+	}
+
+	return parseDynamicConfig(data)
+}
+
+// parseDynamicConfig decodes and validates the dynamic configuration
+func parseDynamicConfig(data []byte) (*DynamicConfig, error) {
+	var cfg DynamicConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("parse gateway config: %w", err)
+	}
+
+	for i := range cfg.Routes {
+		for j, method := range cfg.Routes[i].Methods {
+			cfg.Routes[i].Methods[j] = strings.ToUpper(method)
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// validate checks the configuration for errors that would otherwise only show
+// up as misrouted requests
+func (cfg *DynamicConfig) validate() error {
+	var errs []error
+
+	if cfg.RateLimit != nil {
+		if err := cfg.RateLimit.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limit: %w", err))
+		}
+		if cfg.RateLimit.Key == rateLimitKeyUser {
+			errs = append(errs, errors.New("rate_limit: the global rate limit is per IP"))
+		}
+	}
+
+	for name, upstream := range cfg.Upstreams {
+		if !isKnownUpstream(name) {
+			errs = append(errs, fmt.Errorf("upstream %q: unknown upstream", name))
+		}
+		if upstream.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("upstream %q: timeout must not be negative", name))
+		}
+		if len(upstream.Endpoints) > 0 {
+			if _, err := newResolver(upstream.Endpoints); err != nil {
+				errs = append(errs, fmt.Errorf("upstream %q: %w", name, err))
+			}
+		}
+		if upstream.Strategy != "" {
+			if _, err := newBalancer(upstream.Strategy); err != nil {
+				errs = append(errs, fmt.Errorf("upstream %q: %w", name, err))
+			}
+		}
+	}
+
+	for name, policy := range cfg.RateLimits {
+		if err := policy.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("rate limit %q: %w", name, err))
+		}
+	}
+
+	errs = append(errs, validateRoutes(cfg.Routes, cfg.RateLimits)...)
+
+	return errors.Join(errs...)
+}
+
+func (p RateLimitPolicy) validate() error {
+	if p.RequestsPerSecond <= 0 || p.Burst <= 0 {
+		return errors.New("requests_per_second and burst must be positive")
+	}
+	if p.Key != "" && p.Key != rateLimitKeyIP && p.Key != rateLimitKeyUser {
+		return fmt.Errorf("unknown key %q", p.Key)
+	}
+	return nil
+}
+
+func isKnownUpstream(name string) bool {
+	switch name {
+	case upstreamAuth, upstreamFeed, upstreamNotification:
+		return true
+	default:
+		return false
+	}
+}
+
+// effectiveSettings is the resolved view of the dynamic configuration, with
+// environment fallbacks applied
+type effectiveSettings struct {
+	allowedOrigins []string
+	rateLimit      RateLimitPolicy
+	upstreams      map[string]UpstreamConfig
+	rateLimits     map[string]RateLimitPolicy
+	routes         []Route
+}
+
+// resolve applies the dynamic configuration on top of the environment
+func (cfg *DynamicConfig) resolve(config *Config, services ServiceConfig) effectiveSettings {
+	settings := effectiveSettings{
+		allowedOrigins: config.AllowedOrigins,
+		rateLimit:      RateLimitPolicy{RequestsPerSecond: config.RateLimit, Burst: config.RateBurst},
+		upstreams: map[string]UpstreamConfig{
+			upstreamAuth:         services.Auth,
+			upstreamFeed:         services.Feed,
+			upstreamNotification: services.Notification,
+		},
+		rateLimits: cfg.RateLimits,
+		routes:     cfg.Routes,
+	}
+
+	if len(cfg.AllowedOrigins) > 0 {
+		settings.allowedOrigins = cfg.AllowedOrigins
+	}
+	if cfg.RateLimit != nil {
+		settings.rateLimit = *cfg.RateLimit
+	}
+
+	for name, override := range cfg.Upstreams {
+		upstream := settings.upstreams[name]
+		if len(override.Endpoints) > 0 {
+			upstream.Endpoints = override.Endpoints
+		}
+		if override.Strategy != "" {
+			upstream.Strategy = override.Strategy
+		}
+		if override.Timeout > 0 {
+			upstream.Timeout = override.Timeout
+		}
+		settings.upstreams[name] = upstream
+	}
+
+	return settings
+}
+
+// diffSettings describes what changed between two configurations
+func diffSettings(prev, next effectiveSettings) []string {
+	var changes []string
+
+	if !slices.Equal(prev.allowedOrigins, next.allowedOrigins) {
+		changes = append(changes, fmt.Sprintf("allowed_origins: %v -> %v", prev.allowedOrigins, next.allowedOrigins))
+	}
+	if prev.rateLimit != next.rateLimit {
+		changes = append(changes, fmt.Sprintf("rate_limit: %s -> %s", prev.rateLimit, next.rateLimit))
+	}
+
+	for _, name := range sortedKeys(prev.upstreams, next.upstreams) {
+		before, after := prev.upstreams[name], next.upstreams[name]
+		if !slices.Equal(before.Endpoints, after.Endpoints) {
+			changes = append(changes, fmt.Sprintf("upstream %s endpoints: %v -> %v", name, before.Endpoints, after.Endpoints))
+		}
+		if before.Strategy != after.Strategy {
+			changes = append(changes, fmt.Sprintf("upstream %s strategy: %q -> %q", name, before.Strategy, after.Strategy))
+		}
+		if before.Timeout != after.Timeout {
+			changes = append(changes, fmt.Sprintf("upstream %s timeout: %s -> %s", name, before.Timeout, after.Timeout))
+		}
+	}
+
+	for _, name := range sortedKeys(prev.rateLimits, next.rateLimits) {
+		before, hadBefore := prev.rateLimits[name]
+		after, hasAfter := next.rateLimits[name]
+		switch {
+		case !hadBefore:
+			changes = append(changes, fmt.Sprintf("rate limit %s added: %s", name, after))
+		case !hasAfter:
+			changes = append(changes, fmt.Sprintf("rate limit %s removed", name))
+		case before != after:
+			changes = append(changes, fmt.Sprintf("rate limit %s: %s -> %s", name, before, after))
+		}
+	}
+
+	oldRoutes, newRoutes := routesByKey(prev.routes), routesByKey(next.routes)
+	for _, key := range sortedKeys(oldRoutes, newRoutes) {
+		before, hadBefore := oldRoutes[key]
+		after, hasAfter := newRoutes[key]
+		switch {
+		case !hadBefore:
+			changes = append(changes, fmt.Sprintf("route added: %s -> %s", key, after))
+		case !hasAfter:
+			changes = append(changes, fmt.Sprintf("route removed: %s", key))
+		case !reflect.DeepEqual(before, after):
+			changes = append(changes, fmt.Sprintf("route changed: %s: %s -> %s", key, before, after))
+		}
+	}
+
+	return changes
+}
+
+func (p RateLimitPolicy) String() string {
+	key := p.Key
+	if key == "" {
+		key = rateLimitKeyIP
+	}
+	return fmt.Sprintf("%g/s burst %d per %s", p.RequestsPerSecond, p.Burst, key)
+}
+
+func (r Route) String() string {
+	s := r.Upstream
+	if r.Auth {
+		s += " auth"
+	}
+	if r.RateLimit != "" {
+		s += " rate_limit=" + r.RateLimit
+	}
+	if r.Timeout > 0 {
+		s += " timeout=" + r.Timeout.String()
+	}
+	if r.Cache != nil {
+		s += fmt.Sprintf(" cache=%q/%s", r.Cache.Control, r.Cache.TTL)
+	}
+	if r.Stream {
+		s += " stream"
+	}
+	if r.FlushInterval > 0 {
+		s += " flush_interval=" + r.FlushInterval.String()
+	}
+	return s
+}
+
+// routesByKey indexes routes by "METHOD path", ignoring the method grouping
+// used in the file
+func routesByKey(routes []Route) map[string]Route {
+	byKey := make(map[string]Route)
+	for _, route := range routes {
+		for _, method := range route.Methods {
+			r := route
+			r.Methods = nil
+			byKey[method+" "+route.Path] = r
+		}
+	}
+	return byKey
+}
+
+func sortedKeys[V any](maps ...map[string]V) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range maps {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -4,11 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,26 +16,61 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 
+	"github.com/Femi-lawal/udagram-app/pkg/cache"
 	"github.com/Femi-lawal/udagram-app/pkg/common"
+	"github.com/Femi-lawal/udagram-app/pkg/common/retry"
 	"github.com/Femi-lawal/udagram-app/pkg/middleware"
 	"github.com/Femi-lawal/udagram-app/pkg/telemetry"
 )
 
+// Upstream names
+const (
+	upstreamAuth         = "auth"
+	upstreamFeed         = "feed"
+	upstreamNotification = "notification"
+)
+
+// UpstreamConfig holds the endpoints, load balancing and timeout settings for
+// a backend service
+type UpstreamConfig struct {
+	Endpoints         []string
+	Strategy          string
+	Timeout           time.Duration
+	DiscoveryInterval time.Duration
+	HealthCheck       HealthCheckConfig
+	Outlier           OutlierConfig
+	CircuitBreaker    middleware.CircuitBreakerConfig
+	Retry             retry.Config
+	RetryBudget       middleware.RetryBudgetConfig
+}
+
 // ServiceConfig holds service endpoint configuration
 type ServiceConfig struct {
-	AuthServiceURL         string
-	FeedServiceURL         string
-	NotificationServiceURL string
+	Auth         UpstreamConfig
+	Feed         UpstreamConfig
+	Notification UpstreamConfig
+	Transport    TransportConfig
 }
 
 // Gateway handles API routing and middleware
 type Gateway struct {
-	router      *gin.Engine
-	logger      *zap.Logger
-	config      *Config
-	services    ServiceConfig
-	rateLimiter *middleware.RateLimiter
-	telemetry   *telemetry.Provider
+	logger    *zap.Logger
+	config    *Config
+	services  ServiceConfig
+	transport http.RoundTripper
+	responses responseStore
+	telemetry *telemetry.Provider
+
+	// streams is canceled on shutdown to end long-lived streams, which would
+	// otherwise hold the server open
+	streams     context.Context
+	stopStreams context.CancelFunc
+
+	// state holds the routes, proxies and limiters built from the reloadable
+	// configuration; mu serializes reloads
+	state   atomic.Pointer[gatewayState]
+	mu      sync.Mutex
+	started bool
 }
 
 // Config holds gateway configuration
@@ -47,6 +82,17 @@ type Config struct {
 	AllowedOrigins []string
 	RateLimit      float64
 	RateBurst      int
+	RequestTimeout time.Duration
+	MaxBodyBytes   int64
+	Compression    middleware.CompressionConfig
+	ConfigFile     string
+	RouteCheck     string
+
+	// Response caching
+	ResponseCache           string
+	ResponseCacheMaxEntries int
+	Redis                   cache.Config
+	KafkaBrokers            string
 }
 
 func main() {
@@ -72,24 +118,68 @@ func main() {
 		logger.Warn("failed to initialize telemetry", zap.Error(err))
 	}
 
-	// Service URLs
-	services := ServiceConfig{
-		AuthServiceURL:         getEnv("AUTH_SERVICE_URL", "http://auth:8081"),
-		FeedServiceURL:         getEnv("FEED_SERVICE_URL", "http://feed:8082"),
-		NotificationServiceURL: getEnv("NOTIFICATION_SERVICE_URL", "http://notification:8083"),
+	// Upstream services
+	services := loadServiceConfig()
+
+	// Routes and other reloadable settings
+	dynamic, err := loadDynamicConfig(config.ConfigFile)
+	if err != nil {
+		logger.Fatal("invalid gateway config", zap.Error(err))
 	}
 
 	// Create gateway
-	gateway := NewGateway(config, services, logger, tp)
+	gateway, err := NewGateway(config, services, dynamic, logger, tp)
+	if err != nil {
+		logger.Fatal("failed to create gateway", zap.Error(err))
+	}
+	gateway.Start()
+
+	// Make sure every route is served by its upstream
+	if config.RouteCheck != RouteCheckOff {
+		checkCtx, checkCancel := context.WithTimeout(context.Background(), 15*time.Second)
+		err := gateway.checkUpstreamRoutes(checkCtx, gateway.state.Load())
+		checkCancel()
+		if err != nil {
+			if config.RouteCheck == RouteCheckStrict {
+				logger.Fatal("route table does not match upstreams", zap.Error(err))
+			}
+			logger.Warn("route table does not match upstreams", zap.Error(err))
+		}
+	}
+
+	// Drop cached responses when the data behind them changes
+	consumerCtx, stopConsumers := context.WithCancel(context.Background())
+	defer stopConsumers()
+	gateway.consumeInvalidations(consumerCtx, config.KafkaBrokers, cacheGroupID())
+
+	// Reload the config on file changes and SIGHUP
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if config.ConfigFile == "" {
+		logger.Warn("GATEWAY_CONFIG_FILE is not set, using the built-in config; hot reload is disabled")
+	}
+	if err := gateway.Watch(watchCtx); err != nil {
+		logger.Error("failed to watch gateway config", zap.Error(err))
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			logger.Info("received SIGHUP, reloading gateway config")
+			_ = gateway.Reload()
+		}
+	}()
 
 	// Start server
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", config.Port),
-		Handler:      gateway.router,
+		Handler:      gateway,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	srv.RegisterOnShutdown(gateway.CloseStreams)
 
 	// Graceful shutdown
 	go func() {
@@ -113,6 +203,10 @@ func main() {
 		logger.Fatal("server forced to shutdown", zap.Error(err))
 	}
 
+	stopWatch()
+	stopConsumers()
+	gateway.Close()
+
 	if tp != nil {
 		if err := tp.Shutdown(ctx); err != nil {
 			logger.Error("failed to shutdown telemetry", zap.Error(err))
@@ -123,119 +217,108 @@ func main() {
 }
 
 // NewGateway creates a new gateway instance
-func NewGateway(config *Config, services ServiceConfig, logger *zap.Logger, tp *telemetry.Provider) *Gateway {
+func NewGateway(config *Config, services ServiceConfig, dynamic *DynamicConfig, logger *zap.Logger, tp *telemetry.Provider) (*Gateway, error) {
 	// Set Gin mode
 	if config.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	router := gin.New()
+	gateway := &Gateway{
+		logger:    logger,
+		config:    config,
+		services:  services,
+		transport: newTransport(services.Transport),
+		responses: newResponseStore(config, logger),
+		telemetry: tp,
+	}
+	gateway.streams, gateway.stopStreams = context.WithCancel(context.Background())
+
+	// Build one reverse proxy per upstream, sharing a tuned transport
+	st, err := gateway.buildState(dynamic.resolve(config, services), nil)
+	if err != nil {
+		return nil, err
+	}
+	gateway.state.Store(st)
 
-	// Create rate limiter
-	rateLimiter := middleware.NewRateLimiter(config.RateLimit, config.RateBurst, time.Minute)
+	return gateway, nil
+}
 
-	gateway := &Gateway{
-		router:      router,
-		logger:      logger,
-		config:      config,
-		services:    services,
-		rateLimiter: rateLimiter,
-		telemetry:   tp,
+// Start begins background upstream discovery and health checking
+func (g *Gateway) Start() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.started = true
+	for _, proxy := range g.state.Load().proxies {
+		proxy.Start()
 	}
+}
 
-	gateway.setupMiddleware()
-	gateway.setupRoutes()
+// Close stops background upstream work
+func (g *Gateway) Close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
 
-	return gateway
+	g.state.Load().release(nil)
+	if g.responses != nil {
+		_ = g.responses.Close()
+	}
 }
 
-func (g *Gateway) setupMiddleware() {
+func (g *Gateway) setupMiddleware(st *gatewayState) {
 	// Recovery middleware
-	g.router.Use(middleware.RecoveryMiddleware(g.logger))
+	st.router.Use(middleware.RecoveryMiddleware(g.logger))
 
 	// Request ID
-	g.router.Use(middleware.RequestIDMiddleware())
+	st.router.Use(middleware.RequestIDMiddleware())
+
+	// Tracing, continued upstream
+	st.router.Use(middleware.TracingMiddleware())
 
 	// Logging
-	g.router.Use(middleware.LoggerMiddleware(g.logger))
+	st.router.Use(middleware.LoggerMiddleware(g.logger))
 
 	// Metrics
-	g.router.Use(middleware.MetricsMiddleware())
+	st.router.Use(middleware.MetricsMiddleware())
+
+	// Response compression; upstream responses that are already encoded
+	// pass through untouched
+	st.router.Use(middleware.CompressionMiddleware(g.config.Compression))
+
+	// Request timeout, above the per-upstream timeouts so that upstream
+	// failures surface as gateway errors first
+	st.router.Use(middleware.TimeoutMiddleware(middleware.TimeoutConfig{
+		Timeout: g.config.RequestTimeout,
+		Routes:  routeTimeouts(st.settings.routes),
+	}))
+
+	// Request body size; content checks are left to the services
+	st.router.Use(middleware.BodyLimitMiddleware(middleware.BodyLimitConfig{
+		MaxBytes: g.config.MaxBodyBytes,
+		Routes:   routeBodyLimits(st.settings.routes),
+	}))
 
 	// Security headers
-	g.router.Use(middleware.SecurityHeadersMiddleware())
+	st.router.Use(middleware.SecurityHeadersMiddleware())
 
 	// CORS
-	g.router.Use(middleware.CORSMiddleware(g.config.AllowedOrigins))
+	st.router.Use(middleware.CORSMiddleware(st.settings.allowedOrigins))
 
 	// Rate limiting
-	g.router.Use(middleware.RateLimitMiddleware(g.rateLimiter))
+	st.router.Use(st.limiters[globalRateLimit].handler)
 }
 
-func (g *Gateway) setupRoutes() {
+func (g *Gateway) setupRoutes(st *gatewayState) error {
 	// Health endpoints
-	g.router.GET("/health", middleware.HealthCheck())
-	g.router.GET("/ready", middleware.ReadinessCheck())
-	g.router.GET("/live", middleware.LivenessCheck())
+	st.router.GET("/health", middleware.HealthCheck())
+	st.router.GET("/ready", middleware.ReadinessCheck())
+	st.router.GET("/live", middleware.LivenessCheck())
 
 	// Metrics endpoint
-	g.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
-
-	// API v1 routes
-	v1 := g.router.Group("/api/v1")
-	{
-		// Auth routes (public)
-		auth := v1.Group("/auth")
-		{
-			auth.POST("/register", g.proxyToAuth)
-			auth.POST("/login", g.proxyToAuth)
-			auth.POST("/refresh", g.proxyToAuth)
-			auth.POST("/logout", g.proxyToAuth)
-		}
-
-		// User routes (protected)
-		users := v1.Group("/users")
-		users.Use(g.jwtMiddleware())
-		{
-			users.GET("/:id", g.proxyToAuth)
-			users.GET("/me", g.proxyToAuth)
-			users.PUT("/me", g.proxyToAuth)
-		}
+	st.router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
-		// Feed routes
-		feed := v1.Group("/feed")
-		{
-			// Public routes
-			feed.GET("", g.proxyToFeed)
-			feed.GET("/:id", g.proxyToFeed)
-
-			// Protected routes
-			protected := feed.Group("")
-			protected.Use(g.jwtMiddleware())
-			{
-				protected.POST("", g.proxyToFeed)
-				protected.PUT("/:id", g.proxyToFeed)
-				protected.DELETE("/:id", g.proxyToFeed)
-				protected.GET("/signed-url/:filename", g.proxyToFeed)
-			}
-		}
-
-		// Notification routes (protected)
-		notifications := v1.Group("/notifications")
-		notifications.Use(g.jwtMiddleware())
-		{
-			notifications.GET("", g.proxyToNotification)
-			notifications.POST("/send", g.proxyToNotification)
-			notifications.PUT("/:id/read", g.proxyToNotification)
-		}
-	}
-
-	// Legacy API v0 routes (backwards compatibility)
-	v0 := g.router.Group("/api/v0")
-	{
-		v0.Any("/users/*path", g.proxyToAuth)
-		v0.Any("/feed/*path", g.proxyToFeed)
-	}
+	// API routes from the gateway config
+	return g.registerRoutes(st)
 }
 
 func (g *Gateway) jwtMiddleware() gin.HandlerFunc {
@@ -243,73 +326,17 @@ func (g *Gateway) jwtMiddleware() gin.HandlerFunc {
 		Secret:   g.config.JWTSecret,
 		Issuer:   g.config.JWTIssuer,
 		Audience: "udagram-users",
+		// Browsers cannot set headers on WebSocket and EventSource requests
+		QueryParam: streamTokenParam,
 	})
 }
 
-func (g *Gateway) proxyToAuth(c *gin.Context) {
-	g.proxyRequest(c, g.services.AuthServiceURL)
-}
-
-func (g *Gateway) proxyToFeed(c *gin.Context) {
-	g.proxyRequest(c, g.services.FeedServiceURL)
-}
-
-func (g *Gateway) proxyToNotification(c *gin.Context) {
-	g.proxyRequest(c, g.services.NotificationServiceURL)
-}
-
-func (g *Gateway) proxyRequest(c *gin.Context, targetURL string) {
-	target, err := url.Parse(targetURL)
-	if err != nil {
-		g.logger.Error("failed to parse target URL", zap.Error(err))
-		common.ErrorResponse(c, common.ErrInternalServer)
-		return
-	}
-
-	proxy := httputil.NewSingleHostReverseProxy(target)
-
-	// Modify the request
-	originalDirector := proxy.Director
-	proxy.Director = func(req *http.Request) {
-		originalDirector(req)
-
-		// Forward headers
-		req.Header.Set("X-Forwarded-Host", req.Host)
-		req.Header.Set("X-Forwarded-Proto", "http")
-
-		// Forward request ID
-		if requestID, exists := c.Get("request_id"); exists {
-			req.Header.Set("X-Request-ID", requestID.(string))
-		}
-
-		// Forward user context if authenticated
-		if userID, exists := c.Get("user_id"); exists {
-			req.Header.Set("X-User-ID", userID.(string))
-		}
-		if email, exists := c.Get("email"); exists {
-			req.Header.Set("X-User-Email", email.(string))
-		}
-
-		// Set the target path
-		req.URL.Path = c.Request.URL.Path
-		req.URL.RawQuery = c.Request.URL.RawQuery
-	}
-
-	// Handle errors
-	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		g.logger.Error("proxy error",
-			zap.String("target", targetURL),
-			zap.Error(err),
-		)
-		common.ServiceUnavailableResponse(c, "service unavailable")
-	}
-
-	proxy.ServeHTTP(c.Writer, c.Request)
-}
-
 func loadConfig() *Config {
 	allowedOrigins := strings.Split(getEnv("ALLOWED_ORIGINS", "*"), ",")
 
+	compression := middleware.DefaultCompressionConfig()
+	compression.MinSize = getEnvInt("COMPRESSION_MIN_BYTES", compression.MinSize)
+
 	return &Config{
 		Port:           getEnvInt("PORT", 8080),
 		Environment:    getEnv("ENVIRONMENT", "development"),
@@ -318,9 +345,85 @@ func loadConfig() *Config {
 		AllowedOrigins: allowedOrigins,
 		RateLimit:      100,
 		RateBurst:      200,
+		RequestTimeout: getEnvDuration("REQUEST_TIMEOUT", 20*time.Second),
+		MaxBodyBytes:   int64(getEnvInt("MAX_BODY_BYTES", 1<<20)),
+		Compression:    compression,
+		ConfigFile:     getEnv("GATEWAY_CONFIG_FILE", ""),
+		RouteCheck:     getEnv("GATEWAY_ROUTE_CHECK", RouteCheckStrict),
+
+		ResponseCache:           getEnv("RESPONSE_CACHE", ResponseCacheMemory),
+		ResponseCacheMaxEntries: getEnvInt("RESPONSE_CACHE_MAX_ENTRIES", 10000),
+		Redis: cache.Config{
+			Host:         getEnv("REDIS_HOST", "localhost"),
+			Port:         getEnvInt("REDIS_PORT", 6379),
+			Password:     getEnv("REDIS_PASSWORD", ""),
+			DB:           0,
+			PoolSize:     10,
+			MinIdleConns: 5,
+			DialTimeout:  5 * time.Second,
+			ReadTimeout:  3 * time.Second,
+			WriteTimeout: 3 * time.Second,
+		},
+		// Without Kafka, cached responses only expire by TTL
+		KafkaBrokers: getEnv("KAFKA_BROKERS", ""),
+	}
+}
+
+func loadServiceConfig() ServiceConfig {
+	transport := DefaultTransportConfig()
+	transport.MaxIdleConnsPerHost = getEnvInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", transport.MaxIdleConnsPerHost)
+	transport.MaxConnsPerHost = getEnvInt("UPSTREAM_MAX_CONNS_PER_HOST", transport.MaxConnsPerHost)
+	transport.IdleConnTimeout = getEnvDuration("UPSTREAM_IDLE_CONN_TIMEOUT", transport.IdleConnTimeout)
+	transport.DialTimeout = getEnvDuration("UPSTREAM_DIAL_TIMEOUT", transport.DialTimeout)
+	transport.ResponseHeaderTimeout = getEnvDuration("UPSTREAM_RESPONSE_HEADER_TIMEOUT", transport.ResponseHeaderTimeout)
+
+	healthCheck := DefaultHealthCheckConfig()
+	healthCheck.Path = getEnv("UPSTREAM_HEALTH_CHECK_PATH", healthCheck.Path)
+	healthCheck.Interval = getEnvDuration("UPSTREAM_HEALTH_CHECK_INTERVAL", healthCheck.Interval)
+	healthCheck.Timeout = getEnvDuration("UPSTREAM_HEALTH_CHECK_TIMEOUT", healthCheck.Timeout)
+
+	outlier := DefaultOutlierConfig()
+	outlier.ConsecutiveFailures = getEnvInt("UPSTREAM_OUTLIER_CONSECUTIVE_FAILURES", outlier.ConsecutiveFailures)
+	outlier.BaseEjectionTime = getEnvDuration("UPSTREAM_OUTLIER_EJECTION_TIME", outlier.BaseEjectionTime)
+
+	breaker := middleware.DefaultCircuitBreakerConfig("")
+	breaker.Timeout = getEnvDuration("UPSTREAM_BREAKER_TIMEOUT", breaker.Timeout)
+	breaker.MinRequests = uint32(getEnvInt("UPSTREAM_BREAKER_MIN_REQUESTS", int(breaker.MinRequests)))
+
+	retryConfig := retry.DefaultConfig()
+	retryConfig.MaxRetries = getEnvInt("UPSTREAM_RETRY_MAX", retryConfig.MaxRetries)
+	retryConfig.InitialWait = getEnvDuration("UPSTREAM_RETRY_INITIAL_WAIT", retryConfig.InitialWait)
+	retryConfig.MaxWait = getEnvDuration("UPSTREAM_RETRY_MAX_WAIT", retryConfig.MaxWait)
+
+	defaults := UpstreamConfig{
+		Strategy:          getEnv("UPSTREAM_LB_STRATEGY", StrategyRoundRobin),
+		Timeout:           getEnvDuration("UPSTREAM_TIMEOUT", 15*time.Second),
+		DiscoveryInterval: getEnvDuration("UPSTREAM_DISCOVERY_INTERVAL", 30*time.Second),
+		HealthCheck:       healthCheck,
+		Outlier:           outlier,
+		CircuitBreaker:    breaker,
+		Retry:             retryConfig,
+		RetryBudget:       middleware.DefaultRetryBudgetConfig(),
+	}
+
+	return ServiceConfig{
+		Auth:         loadUpstreamConfig("AUTH_SERVICE", "http://auth:8081", defaults),
+		Feed:         loadUpstreamConfig("FEED_SERVICE", "http://feed:8082", defaults),
+		Notification: loadUpstreamConfig("NOTIFICATION_SERVICE", "http://notification:8083", defaults),
+		Transport:    transport,
 	}
 }
 
+// loadUpstreamConfig reads <PREFIX>_URL (a comma-separated endpoint list or a
+// dns:// / dns+srv:// discovery URL) and per-upstream overrides
+func loadUpstreamConfig(prefix, defaultURL string, defaults UpstreamConfig) UpstreamConfig {
+	cfg := defaults
+	cfg.Endpoints = strings.Split(getEnv(prefix+"_URL", defaultURL), ",")
+	cfg.Strategy = getEnv(prefix+"_LB_STRATEGY", defaults.Strategy)
+	cfg.Timeout = getEnvDuration(prefix+"_TIMEOUT", defaults.Timeout)
+	return cfg
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -338,3 +441,14 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		result, err := time.ParseDuration(value)
+		if err != nil {
+			return defaultValue
+		}
+		return result
+	}
+	return defaultValue
+}
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Metrics
+var (
+	upstreamEndpointHealthy = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_upstream_endpoint_healthy",
+			Help: "Whether an upstream endpoint is currently receiving traffic (1) or not (0)",
+		},
+		[]string{"upstream", "endpoint"},
+	)
+
+	upstreamEjections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_upstream_ejections_total",
+			Help: "Total number of upstream endpoints ejected by outlier detection",
+		},
+		[]string{"upstream"},
+	)
+)
+
+// Discovery URL schemes
+const (
+	discoverySchemeDNS = "dns"
+	discoverySchemeSRV = "dns+srv"
+)
+
+var errNoAvailableEndpoint = errors.New("no available upstream endpoint")
+
+// HealthCheckConfig configures active health checking of upstream endpoints
+type HealthCheckConfig struct {
+	Path               string
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+// DefaultHealthCheckConfig returns default health check configuration
+func DefaultHealthCheckConfig() HealthCheckConfig {
+	return HealthCheckConfig{
+		Path:               "/ready",
+		Interval:           10 * time.Second,
+		Timeout:            2 * time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}
+}
+
+// OutlierConfig configures passive ejection of endpoints that keep failing
+type OutlierConfig struct {
+	ConsecutiveFailures int
+	BaseEjectionTime    time.Duration
+	MaxEjectionTime     time.Duration
+	MaxEjectionPercent  int
+}
+
+// DefaultOutlierConfig returns default outlier detection configuration
+func DefaultOutlierConfig() OutlierConfig {
+	return OutlierConfig{
+		ConsecutiveFailures: 5,
+		BaseEjectionTime:    30 * time.Second,
+		MaxEjectionTime:     5 * time.Minute,
+		MaxEjectionPercent:  50,
+	}
+}
+
+// endpoint is a single instance of an upstream service
+type endpoint struct {
+	id       string
+	url      *url.URL
+	inFlight atomic.Int64
+	healthy  atomic.Bool
+
+	mu                  sync.Mutex
+	checkSuccesses      int
+	checkFailures       int
+	consecutiveFailures int
+	ejections           int
+	ejectedUntil        time.Time
+}
+
+func newEndpoint(u *url.URL) *endpoint {
+	ep := &endpoint{
+		id:  u.Scheme + "://" + u.Host + u.EscapedPath(),
+		url: u,
+	}
+	// Endpoints receive traffic until a health check says otherwise
+	ep.healthy.Store(true)
+	return ep
+}
+
+func (ep *endpoint) available(now time.Time) bool {
+	if !ep.healthy.Load() {
+		return false
+	}
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return !now.Before(ep.ejectedUntil)
+}
+
+func (ep *endpoint) ejected(now time.Time) bool {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return now.Before(ep.ejectedUntil)
+}
+
+// upstreamPool tracks the endpoints of one upstream service and decides which
+// of them receives each request
+type upstreamPool struct {
+	name              string
+	balancer          balancer
+	resolver          resolver
+	discoveryInterval time.Duration
+	healthCheck       HealthCheckConfig
+	outlier           OutlierConfig
+	client            *http.Client
+	logger            *zap.Logger
+
+	mu        sync.RWMutex
+	endpoints []*endpoint
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// newUpstreamPool creates a pool and resolves its initial endpoints
+func newUpstreamPool(name string, cfg UpstreamConfig, transport http.RoundTripper, logger *zap.Logger) (*upstreamPool, error) {
+	bal, err := newBalancer(cfg.Strategy)
+	if err != nil {
+		return nil, fmt.Errorf("%s upstream: %w", name, err)
+	}
+
+	res, err := newResolver(cfg.Endpoints)
+	if err != nil {
+		return nil, fmt.Errorf("%s upstream: %w", name, err)
+	}
+
+	p := &upstreamPool{
+		name:              name,
+		balancer:          bal,
+		resolver:          res,
+		discoveryInterval: cfg.DiscoveryInterval,
+		healthCheck:       cfg.HealthCheck,
+		outlier:           cfg.Outlier,
+		client:            &http.Client{Transport: transport, Timeout: cfg.HealthCheck.Timeout},
+		logger:            logger.With(zap.String("upstream", name)),
+		stop:              make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.refresh(ctx); err != nil {
+		if !res.dynamic() {
+			return nil, fmt.Errorf("%s upstream: %w", name, err)
+		}
+		// DNS may not be ready yet; keep retrying in the background
+		p.logger.Warn("initial endpoint discovery failed", zap.Error(err))
+	}
+
+	return p, nil
+}
+
+// Start begins background discovery refreshes and active health checks
+func (p *upstreamPool) Start() {
+	if p.resolver.dynamic() && p.discoveryInterval > 0 {
+		p.wg.Add(1)
+		go p.loop(p.discoveryInterval, func(ctx context.Context) {
+			if err := p.refresh(ctx); err != nil {
+				p.logger.Warn("endpoint discovery failed", zap.Error(err))
+			}
+		})
+	}
+
+	if p.healthCheck.Interval > 0 && p.healthCheck.Path != "" {
+		p.wg.Add(1)
+		go p.loop(p.healthCheck.Interval, p.checkHealth)
+	}
+}
+
+// Close stops background work
+func (p *upstreamPool) Close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	p.wg.Wait()
+}
+
+func (p *upstreamPool) loop(interval time.Duration, fn func(ctx context.Context)) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			fn(ctx)
+			cancel()
+		}
+	}
+}
+
+// pick selects an endpoint for a request; key is used by consistent hashing
+func (p *upstreamPool) pick(key string) (*endpoint, error) {
+	now := time.Now()
+
+	p.mu.RLock()
+	all := p.endpoints
+	p.mu.RUnlock()
+
+	available := make([]*endpoint, 0, len(all))
+	for _, ep := range all {
+		if ep.available(now) {
+			available = append(available, ep)
+		}
+	}
+
+	ep := p.balancer.pick(available, key)
+	if ep == nil {
+		return nil, errNoAvailableEndpoint
+	}
+	return ep, nil
+}
+
+// snapshot returns the current endpoints
+func (p *upstreamPool) snapshot() []*endpoint {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.endpoints
+}
+
+// refresh re-resolves endpoints, keeping state for endpoints that remain
+func (p *upstreamPool) refresh(ctx context.Context) error {
+	urls, err := p.resolver.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if len(urls) == 0 {
+		return errors.New("discovery returned no endpoints")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	existing := make(map[string]*endpoint, len(p.endpoints))
+	for _, ep := range p.endpoints {
+		existing[ep.id] = ep
+	}
+
+	endpoints := make([]*endpoint, 0, len(urls))
+	for _, u := range urls {
+		ep := newEndpoint(u)
+		if old, ok := existing[ep.id]; ok {
+			ep = old
+			delete(existing, ep.id)
+		} else {
+			p.logger.Info("upstream endpoint added", zap.String("endpoint", ep.id))
+			upstreamEndpointHealthy.WithLabelValues(p.name, ep.id).Set(1)
+		}
+		endpoints = append(endpoints, ep)
+	}
+
+	for id := range existing {
+		p.logger.Info("upstream endpoint removed", zap.String("endpoint", id))
+		upstreamEndpointHealthy.DeleteLabelValues(p.name, id)
+	}
+
+	p.endpoints = endpoints
+	return nil
+}
+
+// checkHealth probes every endpoint's health path concurrently
+func (p *upstreamPool) checkHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, ep := range p.snapshot() {
+		wg.Add(1)
+		go func(ep *endpoint) {
+			defer wg.Done()
+			p.recordHealthCheck(ep, p.probe(ctx, ep))
+		}(ep)
+	}
+	wg.Wait()
+}
+
+func (p *upstreamPool) probe(ctx context.Context, ep *endpoint) error {
+	// The health path is relative to the endpoint's base path, as the paths
+	// of proxied requests are
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.url.JoinPath(p.healthCheck.Path).String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p *upstreamPool) recordHealthCheck(ep *endpoint, err error) {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+
+	if err == nil {
+		ep.checkFailures = 0
+		ep.checkSuccesses++
+		if !ep.healthy.Load() && ep.checkSuccesses >= p.healthCheck.HealthyThreshold {
+			ep.healthy.Store(true)
+			p.reportAvailable(ep, !time.Now().Before(ep.ejectedUntil))
+			p.logger.Info("upstream endpoint healthy", zap.String("endpoint", ep.id))
+		}
+		return
+	}
+
+	ep.checkSuccesses = 0
+	ep.checkFailures++
+	if ep.healthy.Load() && ep.checkFailures >= p.healthCheck.UnhealthyThreshold {
+		ep.healthy.Store(false)
+		p.reportAvailable(ep, false)
+		p.logger.Warn("upstream endpoint unhealthy",
+			zap.String("endpoint", ep.id),
+			zap.Error(err),
+		)
+	}
+}
+
+// recordResult feeds a request outcome into passive outlier detection
+func (p *upstreamPool) recordResult(ep *endpoint, failed bool) {
+	if p.outlier.ConsecutiveFailures <= 0 {
+		return
+	}
+
+	ep.mu.Lock()
+	if !failed {
+		ep.consecutiveFailures = 0
+		ep.mu.Unlock()
+		return
+	}
+
+	ep.consecutiveFailures++
+	trip := ep.consecutiveFailures >= p.outlier.ConsecutiveFailures
+	ep.mu.Unlock()
+
+	if trip {
+		p.eject(ep)
+	}
+}
+
+func (p *upstreamPool) eject(ep *endpoint) {
+	now := time.Now()
+
+	// Never eject more than the allowed share of the pool
+	endpoints := p.snapshot()
+	ejected := 0
+	for _, other := range endpoints {
+		if other != ep && other.ejected(now) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(endpoints)*p.outlier.MaxEjectionPercent {
+		return
+	}
+
+	ep.mu.Lock()
+	if now.Before(ep.ejectedUntil) {
+		ep.mu.Unlock()
+		return
+	}
+	ep.ejections++
+	duration := p.outlier.BaseEjectionTime * time.Duration(ep.ejections)
+	if p.outlier.MaxEjectionTime > 0 && duration > p.outlier.MaxEjectionTime {
+		duration = p.outlier.MaxEjectionTime
+	}
+	ep.ejectedUntil = now.Add(duration)
+	ep.consecutiveFailures = 0
+	ep.mu.Unlock()
+
+	upstreamEjections.WithLabelValues(p.name).Inc()
+	p.reportAvailable(ep, false)
+	p.logger.Warn("upstream endpoint ejected",
+		zap.String("endpoint", ep.id),
+		zap.Duration("duration", duration),
+	)
+
+	// The endpoint takes traffic again once the ejection expires, if it is
+	// still healthy
+	time.AfterFunc(duration, func() {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		p.reportAvailable(ep, ep.available(time.Now()))
+	})
+}
+
+// reportAvailable updates the endpoint's availability gauge, unless the
+// endpoint has left the pool
+func (p *upstreamPool) reportAvailable(ep *endpoint, available bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if !slices.Contains(p.endpoints, ep) {
+		return
+	}
+	value := 0.0
+	if available {
+		value = 1
+	}
+	upstreamEndpointHealthy.WithLabelValues(p.name, ep.id).Set(value)
+}
+
+// joinURLPath prefixes the request path with the endpoint's base path,
+// keeping the escaped form when either path has one
+func joinURLPath(base, req *url.URL) (path, rawPath string) {
+	if base.Path == "" && base.RawPath == "" {
+		return req.Path, req.RawPath
+	}
+	if base.RawPath == "" && req.RawPath == "" {
+		return singleJoiningSlash(base.Path, req.Path), ""
+	}
+	return singleJoiningSlash(base.Path, req.Path), singleJoiningSlash(base.EscapedPath(), req.EscapedPath())
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// poolTransport routes each round trip to an endpoint chosen by the pool
+type poolTransport struct {
+	pool *upstreamPool
+	base http.RoundTripper
+}
+
+func (t *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ep, err := t.pool.pick(req.Header.Get("X-User-ID"))
+	if err != nil {
+		return nil, err
+	}
+
+	out := new(http.Request)
+	*out = *req
+	u := *req.URL
+	u.Scheme = ep.url.Scheme
+	u.Host = ep.url.Host
+	u.Path, u.RawPath = joinURLPath(ep.url, req.URL)
+	out.URL = &u
+
+	ep.inFlight.Add(1)
+	resp, err := t.base.RoundTrip(out)
+	if err != nil {
+		ep.inFlight.Add(-1)
+		// A client hanging up says nothing about the endpoint
+		if !errors.Is(err, context.Canceled) {
+			t.pool.recordResult(ep, true)
+		}
+		return nil, err
+	}
+
+	t.pool.recordResult(ep, resp.StatusCode >= 500)
+	body := &inFlightBody{ReadCloser: resp.Body, endpoint: ep}
+	if conn, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		// The proxy writes to the upgraded connection through the body
+		resp.Body = &inFlightConn{inFlightBody: body, Writer: conn}
+	} else {
+		resp.Body = body
+	}
+	return resp, nil
+}
+
+// inFlightBody keeps an endpoint's in-flight count until the response body
+// has been consumed
+type inFlightBody struct {
+	io.ReadCloser
+	endpoint *endpoint
+	once     sync.Once
+}
+
+func (b *inFlightBody) Close() error {
+	b.once.Do(func() {
+		b.endpoint.inFlight.Add(-1)
+	})
+	return b.ReadCloser.Close()
+}
+
+// inFlightConn is an inFlightBody for an upgraded connection, which stays in
+// flight until either side closes it
+type inFlightConn struct {
+	*inFlightBody
+	io.Writer
+}
+
+// resolver turns upstream configuration into endpoint URLs
+type resolver interface {
+	resolve(ctx context.Context) ([]*url.URL, error)
+	dynamic() bool
+}
+
+// newResolver builds a resolver from the configured endpoints: either a list
+// of static URLs, or a single dns://host:port (A/AAAA records) or
+// dns+srv://_service._proto.name (SRV records) discovery URL. Discovered
+// endpoints use http unless the discovery URL sets ?scheme=https.
+func newResolver(endpoints []string) (resolver, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no endpoints configured")
+	}
+
+	if len(endpoints) == 1 {
+		u, err := url.Parse(strings.TrimSpace(endpoints[0]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q: %w", endpoints[0], err)
+		}
+
+		scheme := u.Query().Get("scheme")
+		if scheme == "" {
+			scheme = "http"
+		}
+
+		switch u.Scheme {
+		case discoverySchemeDNS:
+			host, port, err := net.SplitHostPort(u.Host)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DNS discovery URL %q: %w", endpoints[0], err)
+			}
+			return &dnsResolver{host: host, port: port, scheme: scheme, lookup: net.DefaultResolver.LookupHost}, nil
+		case discoverySchemeSRV:
+			return &srvResolver{name: u.Host, scheme: scheme, lookup: net.DefaultResolver.LookupSRV}, nil
+		}
+	}
+
+	urls := make([]*url.URL, 0, len(endpoints))
+	for _, raw := range endpoints {
+		u, err := url.Parse(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q: %w", raw, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint %q: scheme and host are required", raw)
+		}
+		urls = append(urls, u)
+	}
+
+	return &staticResolver{urls: urls}, nil
+}
+
+type staticResolver struct {
+	urls []*url.URL
+}
+
+func (r *staticResolver) resolve(context.Context) ([]*url.URL, error) {
+	return r.urls, nil
+}
+
+func (r *staticResolver) dynamic() bool {
+	return false
+}
+
+type dnsResolver struct {
+	host   string
+	port   string
+	scheme string
+	lookup func(ctx context.Context, host string) ([]string, error)
+}
+
+func (r *dnsResolver) resolve(ctx context.Context) ([]*url.URL, error) {
+	addrs, err := r.lookup(ctx, r.host)
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s: %w", r.host, err)
+	}
+
+	urls := make([]*url.URL, 0, len(addrs))
+	for _, addr := range addrs {
+		urls = append(urls, &url.URL{Scheme: r.scheme, Host: net.JoinHostPort(addr, r.port)})
+	}
+	return urls, nil
+}
+
+func (r *dnsResolver) dynamic() bool {
+	return true
+}
+
+type srvResolver struct {
+	name   string
+	scheme string
+	lookup func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+func (r *srvResolver) resolve(ctx context.Context) ([]*url.URL, error) {
+	_, records, err := r.lookup(ctx, "", "", r.name)
+	if err != nil {
+		return nil, fmt.Errorf("lookup SRV %s: %w", r.name, err)
+	}
+
+	urls := make([]*url.URL, 0, len(records))
+	for _, srv := range records {
+		host := strings.TrimSuffix(srv.Target, ".")
+		urls = append(urls, &url.URL{Scheme: r.scheme, Host: net.JoinHostPort(host, strconv.Itoa(int(srv.Port)))})
+	}
+	return urls, nil
+}
+
+func (r *srvResolver) dynamic() bool {
+	return true
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func testEndpoints(n int) []*endpoint {
+	endpoints := make([]*endpoint, n)
+	for i := range endpoints {
+		endpoints[i] = newEndpoint(&url.URL{Scheme: "http", Host: fmt.Sprintf("10.0.0.%d:8080", i+1)})
+	}
+	return endpoints
+}
+
+func newTestPool(t *testing.T, cfg UpstreamConfig) *upstreamPool {
+	pool, err := newUpstreamPool("test", cfg, http.DefaultTransport, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func TestRoundRobinBalancer(t *testing.T) {
+	endpoints := testEndpoints(3)
+	b, err := newBalancer(StrategyRoundRobin)
+	require.NoError(t, err)
+
+	counts := make(map[string]int)
+	for i := 0; i < 9; i++ {
+		counts[b.pick(endpoints, "").id]++
+	}
+
+	for _, ep := range endpoints {
+		assert.Equal(t, 3, counts[ep.id])
+	}
+}
+
+func TestLeastConnBalancer(t *testing.T) {
+	endpoints := testEndpoints(3)
+	endpoints[0].inFlight.Store(5)
+	endpoints[1].inFlight.Store(1)
+	endpoints[2].inFlight.Store(3)
+
+	b, err := newBalancer(StrategyLeastConnections)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, endpoints[1], b.pick(endpoints, ""))
+	}
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	endpoints := testEndpoints(4)
+	b, err := newBalancer(StrategyConsistentHash)
+	require.NoError(t, err)
+
+	// The same user always lands on the same endpoint
+	first := b.pick(endpoints, "user-42")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, b.pick(endpoints, "user-42"))
+	}
+
+	// Users spread across endpoints
+	seen := make(map[string]bool)
+	for i := 0; i < 200; i++ {
+		seen[b.pick(endpoints, fmt.Sprintf("user-%d", i)).id] = true
+	}
+	assert.Len(t, seen, 4)
+
+	// Removing an endpoint only moves the users that were on it
+	remaining := make([]*endpoint, 0, 3)
+	for _, ep := range endpoints {
+		if ep != first {
+			remaining = append(remaining, ep)
+		}
+	}
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		before := b.pick(endpoints, key)
+		if before != first {
+			assert.Equal(t, before, b.pick(remaining, key))
+		}
+	}
+}
+
+func TestNewBalancer_UnknownStrategy(t *testing.T) {
+	_, err := newBalancer("random")
+	assert.Error(t, err)
+}
+
+func TestNewResolver_Static(t *testing.T) {
+	res, err := newResolver([]string{"http://auth-1:8081", " http://auth-2:8081"})
+	require.NoError(t, err)
+	assert.False(t, res.dynamic())
+
+	urls, err := res.resolve(context.Background())
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Equal(t, "auth-2:8081", urls[1].Host)
+}
+
+func TestNewResolver_Invalid(t *testing.T) {
+	_, err := newResolver(nil)
+	assert.Error(t, err)
+
+	_, err = newResolver([]string{"auth:8081"})
+	assert.Error(t, err)
+
+	_, err = newResolver([]string{"dns://auth"})
+	assert.Error(t, err)
+}
+
+func TestDNSResolver(t *testing.T) {
+	res, err := newResolver([]string{"dns://auth.default.svc:8081"})
+	require.NoError(t, err)
+	require.True(t, res.dynamic())
+
+	dns := res.(*dnsResolver)
+	dns.lookup = func(ctx context.Context, host string) ([]string, error) {
+		assert.Equal(t, "auth.default.svc", host)
+		return []string{"10.1.0.1", "10.1.0.2"}, nil
+	}
+
+	urls, err := res.resolve(context.Background())
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Equal(t, "http://10.1.0.1:8081", urls[0].String())
+}
+
+func TestSRVResolver(t *testing.T) {
+	res, err := newResolver([]string{"dns+srv://_http._tcp.feed.default.svc?scheme=https"})
+	require.NoError(t, err)
+
+	srv := res.(*srvResolver)
+	srv.lookup = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		assert.Equal(t, "_http._tcp.feed.default.svc", name)
+		return "", []*net.SRV{{Target: "feed-0.feed.default.svc.", Port: 8082}}, nil
+	}
+
+	urls, err := res.resolve(context.Background())
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	assert.Equal(t, "https://feed-0.feed.default.svc:8082", urls[0].String())
+}
+
+func TestUpstreamPool_RefreshKeepsEndpointState(t *testing.T) {
+	pool := newTestPool(t, UpstreamConfig{Endpoints: []string{"dns://auth:8081"}})
+
+	addrs := []string{"10.1.0.1", "10.1.0.2"}
+	pool.resolver.(*dnsResolver).lookup = func(ctx context.Context, host string) ([]string, error) {
+		return addrs, nil
+	}
+	require.NoError(t, pool.refresh(context.Background()))
+	require.Len(t, pool.snapshot(), 2)
+
+	kept := pool.snapshot()[1]
+	kept.inFlight.Store(7)
+
+	addrs = []string{"10.1.0.2", "10.1.0.3"}
+	require.NoError(t, pool.refresh(context.Background()))
+
+	endpoints := pool.snapshot()
+	require.Len(t, endpoints, 2)
+	assert.Same(t, kept, endpoints[0])
+	assert.Equal(t, "http://10.1.0.3:8081", endpoints[1].id)
+}
+
+func TestUpstreamPool_ActiveHealthCheck(t *testing.T) {
+	var ready atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready" && ready.Load() {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer backend.Close()
+
+	healthCheck := DefaultHealthCheckConfig()
+	healthCheck.UnhealthyThreshold = 2
+	healthCheck.HealthyThreshold = 1
+	pool := newTestPool(t, UpstreamConfig{Endpoints: []string{backend.URL}, HealthCheck: healthCheck})
+
+	ctx := context.Background()
+	pool.checkHealth(ctx)
+	_, err := pool.pick("")
+	assert.NoError(t, err, "one failure is below the unhealthy threshold")
+
+	pool.checkHealth(ctx)
+	_, err = pool.pick("")
+	assert.ErrorIs(t, err, errNoAvailableEndpoint)
+
+	ready.Store(true)
+	pool.checkHealth(ctx)
+	_, err = pool.pick("")
+	assert.NoError(t, err)
+}
+
+func TestUpstreamPool_HealthCheckKeepsBasePath(t *testing.T) {
+	var probed string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probed = r.URL.Path
+	}))
+	defer backend.Close()
+
+	pool := newTestPool(t, UpstreamConfig{Endpoints: []string{backend.URL + "/auth"}, HealthCheck: DefaultHealthCheckConfig()})
+	require.NoError(t, pool.probe(context.Background(), pool.snapshot()[0]))
+	assert.Equal(t, "/auth"+DefaultHealthCheckConfig().Path, probed)
+}
+
+func TestUpstreamPool_EndpointIDIncludesBasePath(t *testing.T) {
+	pool := newTestPool(t, UpstreamConfig{Endpoints: []string{"http://10.0.0.1:8080/auth", "http://10.0.0.1:8080/feed"}})
+
+	endpoints := pool.snapshot()
+	require.Len(t, endpoints, 2)
+	assert.Equal(t, "http://10.0.0.1:8080/auth", endpoints[0].id)
+	assert.Equal(t, "http://10.0.0.1:8080/feed", endpoints[1].id)
+}
+
+func TestPoolTransport_KeepsBasePath(t *testing.T) {
+	var proxied, rawQuery string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.EscapedPath()
+		rawQuery = r.URL.RawQuery
+	}))
+	defer backend.Close()
+
+	pool := newTestPool(t, UpstreamConfig{Endpoints: []string{backend.URL + "/api"}})
+	client := &http.Client{Transport: &poolTransport{pool: pool, base: http.DefaultTransport}}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/api/v0/feed", want: "/api/api/v0/feed"},
+		{path: "/users/a%2Fb", want: "/api/users/a%2Fb"},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get("http://test" + tt.path)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, tt.want, proxied)
+	}
+
+	resp, err := client.Get("http://test/ready?probe=1")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, "/api/ready", proxied)
+	assert.Equal(t, "probe=1", rawQuery)
+}
+
+func TestUpstreamPool_OutlierEjection(t *testing.T) {
+	outlier := DefaultOutlierConfig()
+	outlier.ConsecutiveFailures = 3
+	pool := newTestPool(t, UpstreamConfig{
+		Endpoints: []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"},
+		Outlier:   outlier,
+	})
+	bad, good := pool.snapshot()[0], pool.snapshot()[1]
+
+	pool.recordResult(bad, true)
+	pool.recordResult(bad, true)
+	pool.recordResult(bad, false)
+	pool.recordResult(bad, true)
+	assert.False(t, bad.ejected(time.Now()), "successes reset the failure streak")
+
+	pool.recordResult(bad, true)
+	pool.recordResult(bad, true)
+	assert.True(t, bad.ejected(time.Now()))
+
+	for i := 0; i < 5; i++ {
+		ep, err := pool.pick("")
+		require.NoError(t, err)
+		assert.Equal(t, good, ep)
+	}
+
+	// The last healthy half of the pool is never ejected
+	for i := 0; i < 3; i++ {
+		pool.recordResult(good, true)
+	}
+	assert.False(t, good.ejected(time.Now()))
+}
+
+func TestUpstreamPool_EjectionReportsUnavailable(t *testing.T) {
+	outlier := DefaultOutlierConfig()
+	outlier.ConsecutiveFailures = 1
+	outlier.BaseEjectionTime = 50 * time.Millisecond
+	pool := newTestPool(t, UpstreamConfig{
+		Endpoints: []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"},
+		Outlier:   outlier,
+	})
+	ep := pool.snapshot()[0]
+	gauge := upstreamEndpointHealthy.WithLabelValues(pool.name, ep.id)
+
+	pool.recordResult(ep, true)
+	assert.Equal(t, 0.0, testutil.ToFloat64(gauge))
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(gauge) == 1
+	}, time.Second, 10*time.Millisecond, "the endpoint is available again once the ejection expires")
+}
+
+func TestPoolTransport_PassiveFailures(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer good.Close()
+
+	outlier := DefaultOutlierConfig()
+	outlier.ConsecutiveFailures = 2
+	pool := newTestPool(t, UpstreamConfig{Endpoints: []string{bad.URL, good.URL}, Outlier: outlier})
+	client := &http.Client{Transport: &poolTransport{pool: pool, base: http.DefaultTransport}}
+
+	statuses := make(map[int]int)
+	for i := 0; i < 10; i++ {
+		resp, err := client.Get("http://test/")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		statuses[resp.StatusCode]++
+	}
+
+	// Round robin sends two requests to the bad endpoint before it is ejected
+	assert.Equal(t, 2, statuses[http.StatusInternalServerError])
+	assert.Equal(t, 8, statuses[http.StatusOK])
+
+	for _, ep := range pool.snapshot() {
+		assert.Zero(t, ep.inFlight.Load())
+	}
+}
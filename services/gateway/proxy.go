@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+)
+
+// TransportConfig holds connection pool tuning shared by all upstream proxies
+type TransportConfig struct {
+	MaxIdleConns          int
+	MaxIdleConnsPerHost   int
+	MaxConnsPerHost       int
+	IdleConnTimeout       time.Duration
+	DialTimeout           time.Duration
+	KeepAlive             time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+}
+
+// DefaultTransportConfig returns default upstream transport configuration
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		MaxIdleConns:          512,
+		MaxIdleConnsPerHost:   128,
+		MaxConnsPerHost:       0,
+		IdleConnTimeout:       90 * time.Second,
+		DialTimeout:           5 * time.Second,
+		KeepAlive:             30 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: 0,
+	}
+}
+
+// newTransport creates the HTTP transport used for upstream connections.
+// HTTP/2 is negotiated via ALPN for TLS upstreams; plain HTTP upstreams stay on
+// HTTP/1.1 with keep-alive connection reuse.
+func newTransport(cfg TransportConfig) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// forwardedContext carries per-request values from the gin context to the
+// shared reverse proxy
+type forwardedContext struct {
+	requestID string
+	userID    string
+	email     string
+}
+
+type forwardedContextKey struct{}
+
+// upstreamProxy is a reverse proxy to a backend service, built once at startup
+type upstreamProxy struct {
+	name    string
+	pool    *upstreamPool
+	timeout time.Duration
+	proxy   *httputil.ReverseProxy
+	logger  *zap.Logger
+}
+
+// newUpstreamProxy creates a reverse proxy that balances across the
+// upstream's endpoints
+func newUpstreamProxy(name string, cfg UpstreamConfig, transport http.RoundTripper, logger *zap.Logger) (*upstreamProxy, error) {
+	pool, err := newUpstreamPool(name, cfg, transport, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &upstreamProxy{
+		name:    name,
+		pool:    pool,
+		timeout: cfg.Timeout,
+		logger:  logger.With(zap.String("upstream", name)),
+	}
+
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite:      p.rewrite,
+		Transport:    newResilientTransport(name, cfg, &poolTransport{pool: pool, base: transport}, logger),
+		ErrorHandler: p.handleError,
+	}
+
+	return p, nil
+}
+
+// Start begins endpoint discovery and health checking
+func (p *upstreamProxy) Start() {
+	p.pool.Start()
+}
+
+// Close stops endpoint discovery and health checking
+func (p *upstreamProxy) Close() {
+	p.pool.Close()
+}
+
+// rewrite prepares the outbound request and forwards request-scoped headers.
+// The host is a placeholder; poolTransport replaces it with the endpoint
+// chosen for each round trip.
+func (p *upstreamProxy) rewrite(pr *httputil.ProxyRequest) {
+	pr.Out.URL.Scheme = "http"
+	pr.Out.URL.Host = p.name
+	pr.Out.Host = ""
+	pr.SetXForwarded()
+
+	// Never trust identity headers or internal credentials supplied by the
+	// client
+	pr.Out.Header.Del("X-User-ID")
+	pr.Out.Header.Del("X-User-Email")
+	pr.Out.Header.Del("X-Internal-Token")
+
+	// Upstream spans join the gateway's trace
+	otel.GetTextMapPropagator().Inject(pr.In.Context(), propagation.HeaderCarrier(pr.Out.Header))
+
+	fwd, ok := pr.In.Context().Value(forwardedContextKey{}).(forwardedContext)
+	if !ok {
+		return
+	}
+
+	if fwd.requestID != "" {
+		pr.Out.Header.Set("X-Request-ID", fwd.requestID)
+	}
+	if fwd.userID != "" {
+		pr.Out.Header.Set("X-User-ID", fwd.userID)
+	}
+	if fwd.email != "" {
+		pr.Out.Header.Set("X-User-Email", fwd.email)
+	}
+}
+
+// handleError writes a gateway error response when the upstream call fails
+func (p *upstreamProxy) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(context.Cause(r.Context()), errStreamStartTimeout):
+		p.logger.Warn("upstream stream timeout", zap.String("path", r.URL.Path))
+		common.WriteErrorJSON(w, http.StatusGatewayTimeout, "GATEWAY_TIMEOUT", "upstream request timed out")
+	case errors.Is(context.Cause(r.Context()), errGatewayShutdown):
+		common.WriteErrorJSON(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "gateway is shutting down")
+	case errors.Is(err, context.Canceled) && r.Context().Err() != nil:
+		// The client went away; there is nobody to respond to, and the
+		// metrics record it as a client closed request
+		p.logger.Debug("client canceled request", zap.String("path", r.URL.Path))
+	case errors.Is(err, context.Canceled):
+		p.logger.Warn("upstream request canceled", zap.String("path", r.URL.Path))
+		common.WriteErrorJSON(w, http.StatusBadGateway, "BAD_GATEWAY", "upstream request canceled")
+	case errors.As(err, &maxBytesErr):
+		common.WriteErrorJSON(w, http.StatusRequestEntityTooLarge, "PAYLOAD_TOO_LARGE", "request body too large")
+	case isBreakerOpen(err):
+		p.logger.Warn("upstream circuit open", zap.String("path", r.URL.Path))
+		common.WriteErrorJSON(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "service temporarily unavailable")
+	case errors.Is(err, context.DeadlineExceeded):
+		p.logger.Warn("upstream timeout",
+			zap.String("path", r.URL.Path),
+			zap.Duration("timeout", p.timeout),
+		)
+		common.WriteErrorJSON(w, http.StatusGatewayTimeout, "GATEWAY_TIMEOUT", "upstream request timed out")
+	default:
+		p.logger.Error("proxy error",
+			zap.String("path", r.URL.Path),
+			zap.Error(err),
+		)
+		common.WriteErrorJSON(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE", "service unavailable")
+	}
+}
+
+// serve proxies the current request to the upstream
+func (p *upstreamProxy) serve(c *gin.Context) {
+	ctx := forwardContext(c)
+
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	p.proxy.ServeHTTP(c.Writer, c.Request.WithContext(ctx))
+}
+
+// forwardContext returns the request context carrying the values that
+// rewrite forwards upstream
+func forwardContext(c *gin.Context) context.Context {
+	fwd := forwardedContext{
+		requestID: c.GetString("request_id"),
+		userID:    c.GetString("user_id"),
+		email:     c.GetString("email"),
+	}
+	return context.WithValue(c.Request.Context(), forwardedContextKey{}, fwd)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func newTestProxyRouter(t testing.TB, backendURL string, timeout time.Duration) *gin.Engine {
+	proxy, err := newUpstreamProxy("test", UpstreamConfig{Endpoints: []string{backendURL}, Timeout: timeout},
+		newTransport(DefaultTransportConfig()), zap.NewNop())
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Any("/*path", func(c *gin.Context) {
+		c.Set("request_id", "req-123")
+		if c.GetHeader("Authorization") != "" {
+			c.Set("user_id", "user-123")
+			c.Set("email", "test@example.com")
+		}
+		proxy.serve(c)
+	})
+	return router
+}
+
+func TestUpstreamProxy_ForwardsHeaders(t *testing.T) {
+	var received http.Header
+	var receivedPath string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		receivedPath = r.URL.RequestURI()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	router := newTestProxyRouter(t, backend.URL, time.Second)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/feed?page=2", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/api/v1/feed?page=2", receivedPath)
+	assert.Equal(t, "req-123", received.Get("X-Request-ID"))
+	assert.Equal(t, "user-123", received.Get("X-User-ID"))
+	assert.Equal(t, "test@example.com", received.Get("X-User-Email"))
+	assert.NotEmpty(t, received.Get("X-Forwarded-For"))
+}
+
+func TestUpstreamProxy_StripsSpoofedIdentity(t *testing.T) {
+	var received http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	router := newTestProxyRouter(t, backend.URL, time.Second)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/v1/feed", nil)
+	req.Header.Set("X-User-ID", "someone-else")
+	req.Header.Set("X-Internal-Token", "guessed-token")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, received.Get("X-User-ID"))
+	assert.Empty(t, received.Get("X-Internal-Token"))
+}
+
+func TestUpstreamProxy_Timeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer backend.Close()
+
+	router := newTestProxyRouter(t, backend.URL, 50*time.Millisecond)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Contains(t, w.Body.String(), "GATEWAY_TIMEOUT")
+}
+
+func TestUpstreamProxy_Unavailable(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backendURL := backend.URL
+	backend.Close()
+
+	router := newTestProxyRouter(t, backendURL, time.Second)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "SERVICE_UNAVAILABLE")
+}
+
+func TestUpstreamProxy_BodyTooLarge(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer backend.Close()
+
+	proxy, err := newUpstreamProxy("test", UpstreamConfig{Endpoints: []string{backend.URL}, Timeout: time.Second},
+		newTransport(DefaultTransportConfig()), zap.NewNop())
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(middleware.BodyLimitMiddleware(middleware.BodyLimitConfig{MaxBytes: 16}))
+	router.POST("/*path", proxy.serve)
+
+	// Without a content length the limit is only hit while streaming upstream
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/api/v1/feed", io.NopCloser(strings.NewReader(strings.Repeat("x", 1024))))
+	req.ContentLength = -1
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "PAYLOAD_TOO_LARGE")
+}
+
+func TestUpstreamProxy_Canceled(t *testing.T) {
+	proxy, err := newUpstreamProxy("test", UpstreamConfig{Endpoints: []string{"http://localhost"}},
+		http.DefaultTransport, zap.NewNop())
+	require.NoError(t, err)
+
+	// Nothing is written to a client that went away
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	proxy.handleError(w, httptest.NewRequest("GET", "/", nil).WithContext(ctx), context.Canceled)
+	assert.Empty(t, w.Header())
+	assert.Empty(t, w.Body.String())
+
+	// The upstream call was canceled for some other reason
+	w = httptest.NewRecorder()
+	proxy.handleError(w, httptest.NewRequest("GET", "/", nil), context.Canceled)
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Contains(t, w.Body.String(), "BAD_GATEWAY")
+}
+
+func TestNewUpstreamProxy_InvalidURL(t *testing.T) {
+	_, err := newUpstreamProxy("test", UpstreamConfig{Endpoints: []string{"not-a-url"}}, http.DefaultTransport, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func benchmarkBackend(b *testing.B) *httptest.Server {
+	body := []byte(`{"success":true,"data":{"items":[]}}`)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+}
+
+// BenchmarkProxy_PerRequest measures the previous behaviour of parsing the
+// target and building a new reverse proxy for every request
+func BenchmarkProxy_PerRequest(b *testing.B) {
+	backend := benchmarkBackend(b)
+	defer backend.Close()
+
+	router := gin.New()
+	router.GET("/*path", func(c *gin.Context) {
+		target, _ := url.Parse(backend.URL)
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.ServeHTTP(c.Writer, c.Request)
+	})
+	gateway := httptest.NewServer(router)
+	defer gateway.Close()
+
+	runProxyBenchmark(b, gateway.URL)
+}
+
+// BenchmarkProxy_Reused measures a proxy built once with the tuned transport
+func BenchmarkProxy_Reused(b *testing.B) {
+	backend := benchmarkBackend(b)
+	defer backend.Close()
+
+	gateway := httptest.NewServer(newTestProxyRouter(b, backend.URL, 5*time.Second))
+	defer gateway.Close()
+
+	runProxyBenchmark(b, gateway.URL)
+}
+
+func runProxyBenchmark(b *testing.B, gatewayURL string) {
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 64}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			resp, err := client.Get(gatewayURL + "/api/v1/feed")
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+	})
+}
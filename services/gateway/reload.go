@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+// Metrics
+var (
+	configReloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_config_reloads_total",
+			Help: "Total number of gateway config reloads by result (success, failure)",
+		},
+		[]string{"result"},
+	)
+)
+
+// reloadDebounce coalesces the burst of events editors and ConfigMap updates
+// produce for a single change
+const reloadDebounce = 250 * time.Millisecond
+
+// globalRateLimit is the limiter key for the gateway-wide rate limit
+const globalRateLimit = ""
+
+// gatewayState is an immutable snapshot of everything built from the
+// reloadable configuration. Each request is served entirely by the snapshot
+// that was current when it arrived, so a reload never disturbs in-flight
+// requests.
+type gatewayState struct {
+	settings effectiveSettings
+	router   *gin.Engine
+	proxies  map[string]*upstreamProxy
+	limiters map[string]*rateLimiter
+}
+
+// rateLimiter is a rate limit policy and the middleware enforcing it
+type rateLimiter struct {
+	policy  RateLimitPolicy
+	handler gin.HandlerFunc
+	stop    func()
+}
+
+func newRateLimiter(policy RateLimitPolicy) *rateLimiter {
+	if policy.Key == rateLimitKeyUser {
+		limiter := middleware.NewUserRateLimiter(policy.RequestsPerSecond, policy.Burst, time.Minute)
+		return &rateLimiter{policy: policy, handler: middleware.UserRateLimitMiddleware(limiter), stop: limiter.Stop}
+	}
+
+	limiter := middleware.NewRateLimiter(policy.RequestsPerSecond, policy.Burst, time.Minute)
+	return &rateLimiter{policy: policy, handler: middleware.RateLimitMiddleware(limiter), stop: limiter.Stop}
+}
+
+// release stops the proxies and rate limiters of s that next does not reuse
+func (s *gatewayState) release(next *gatewayState) {
+	for name, proxy := range s.proxies {
+		if next == nil || next.proxies[name] != proxy {
+			proxy.Close()
+		}
+	}
+	for name, limiter := range s.limiters {
+		if next == nil || next.limiters[name] != limiter {
+			limiter.stop()
+		}
+	}
+}
+
+// buildState creates a new snapshot for settings. Proxies and rate limiters
+// whose settings are unchanged are carried over from prev so that endpoint
+// health, circuit breakers and rate limit buckets survive the reload.
+func (g *Gateway) buildState(settings effectiveSettings, prev *gatewayState) (*gatewayState, error) {
+	st := &gatewayState{
+		settings: settings,
+		router:   gin.New(),
+		proxies:  make(map[string]*upstreamProxy),
+		limiters: make(map[string]*rateLimiter),
+	}
+
+	for name, upstream := range settings.upstreams {
+		if prev != nil && prev.proxies[name] != nil && reflect.DeepEqual(prev.settings.upstreams[name], upstream) {
+			st.proxies[name] = prev.proxies[name]
+			continue
+		}
+
+		proxy, err := newUpstreamProxy(name, upstream, g.transport, g.logger)
+		if err != nil {
+			st.release(prev)
+			return nil, err
+		}
+		st.proxies[name] = proxy
+	}
+
+	policies := map[string]RateLimitPolicy{globalRateLimit: settings.rateLimit}
+	for name, policy := range settings.rateLimits {
+		policies[name] = policy
+	}
+	for name, policy := range policies {
+		if prev != nil && prev.limiters[name] != nil && prev.limiters[name].policy == policy {
+			st.limiters[name] = prev.limiters[name]
+			continue
+		}
+		st.limiters[name] = newRateLimiter(policy)
+	}
+
+	g.setupMiddleware(st)
+	if err := g.setupRoutes(st); err != nil {
+		st.release(prev)
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// ServeHTTP serves the request with the current configuration
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.state.Load().router.ServeHTTP(w, r)
+}
+
+// Reload re-reads the config file and swaps in the new configuration. An
+// invalid configuration is rejected and the current one stays in effect.
+func (g *Gateway) Reload() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.reload(); err != nil {
+		configReloads.WithLabelValues("failure").Inc()
+		g.logger.Error("gateway config reload failed, keeping current config",
+			zap.String("file", g.config.ConfigFile),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	configReloads.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (g *Gateway) reload() error {
+	dynamic, err := loadDynamicConfig(g.config.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	prev := g.state.Load()
+	settings := dynamic.resolve(g.config, g.services)
+	changes := diffSettings(prev.settings, settings)
+	if len(changes) == 0 {
+		g.logger.Info("gateway config unchanged")
+		return nil
+	}
+
+	st, err := g.buildState(settings, prev)
+	if err != nil {
+		return err
+	}
+
+	if g.config.RouteCheck == RouteCheckStrict {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		err := g.checkUpstreamRoutes(ctx, st)
+		cancel()
+		if err != nil {
+			st.release(prev)
+			return err
+		}
+	}
+
+	g.state.Store(st)
+	if g.started {
+		for name, proxy := range st.proxies {
+			if prev.proxies[name] != proxy {
+				proxy.Start()
+			}
+		}
+	}
+	prev.release(st)
+
+	g.logger.Info("gateway config reloaded", zap.Strings("changes", changes))
+	return nil
+}
+
+// Watch reloads the configuration when the config file changes until ctx is
+// done. It watches the file's directory so that files replaced by rename,
+// as editors and Kubernetes ConfigMap updates do, are picked up.
+func (g *Gateway) Watch(ctx context.Context) error {
+	if g.config.ConfigFile == "" {
+		return nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	file := filepath.Clean(g.config.ConfigFile)
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		_ = watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		var debounce <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// ConfigMap volumes swap a ..data symlink rather than the file
+				name := filepath.Clean(event.Name)
+				if (name == file || filepath.Base(name) == "..data") && event.Op != fsnotify.Chmod {
+					debounce = time.After(reloadDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				g.logger.Warn("config watcher error", zap.Error(err))
+			case <-debounce:
+				debounce = nil
+				_ = g.Reload()
+			}
+		}
+	}()
+
+	return nil
+}
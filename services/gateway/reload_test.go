@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const reloadTestConfig = `
+routes:
+  - path: /api/v1/feed
+    methods: [GET]
+    upstream: feed
+`
+
+// newReloadableGateway writes config to a temp file and creates a gateway
+// reading from it
+func newReloadableGateway(t *testing.T, backendURL, config string) (*Gateway, string) {
+	path := filepath.Join(t.TempDir(), "gateway.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(config), 0o644))
+
+	dynamic, err := loadDynamicConfig(path)
+	require.NoError(t, err)
+
+	gateway := newTestGateway(t, backendURL, dynamic)
+	gateway.config.ConfigFile = path
+	gateway.config.RouteCheck = RouteCheckOff
+	return gateway, path
+}
+
+func serveGateway(gateway *Gateway, method, path string) int {
+	w := httptest.NewRecorder()
+	gateway.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	return w.Code
+}
+
+func TestGateway_Reload(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	gateway, path := newReloadableGateway(t, backend.URL, reloadTestConfig)
+	assert.Equal(t, http.StatusOK, serveGateway(gateway, "GET", "/api/v1/feed"))
+	assert.Equal(t, http.StatusNotFound, serveGateway(gateway, "GET", "/api/v1/notifications"))
+
+	require.NoError(t, os.WriteFile(path, []byte(reloadTestConfig+`
+  - path: /api/v1/notifications
+    methods: [GET]
+    upstream: notification
+`), 0o644))
+	require.NoError(t, gateway.Reload())
+
+	assert.Equal(t, http.StatusOK, serveGateway(gateway, "GET", "/api/v1/feed"))
+	assert.Equal(t, http.StatusOK, serveGateway(gateway, "GET", "/api/v1/notifications"))
+}
+
+func TestGateway_ReloadInvalidKeepsConfig(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	gateway, path := newReloadableGateway(t, backend.URL, reloadTestConfig)
+	before := gateway.state.Load()
+
+	require.NoError(t, os.WriteFile(path, []byte("routes:\n  - path: /a\n    methods: [GET]\n    upstream: billing\n"), 0o644))
+	assert.Error(t, gateway.Reload())
+
+	// gin rejects the conflicting parameter names only when routes are built
+	require.NoError(t, os.WriteFile(path, []byte(reloadTestConfig+`
+  - path: /api/v1/feed/:id
+    methods: [GET]
+    upstream: feed
+  - path: /api/v1/feed/:itemID/comments
+    methods: [GET]
+    upstream: feed
+`), 0o644))
+	assert.Error(t, gateway.Reload())
+
+	assert.Same(t, before, gateway.state.Load())
+	assert.Equal(t, http.StatusOK, serveGateway(gateway, "GET", "/api/v1/feed"))
+}
+
+func TestGateway_ReloadReusesUnchangedUpstreams(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	gateway, path := newReloadableGateway(t, backend.URL, reloadTestConfig)
+	before := gateway.state.Load()
+
+	require.NoError(t, os.WriteFile(path, []byte(reloadTestConfig+`
+upstreams:
+  feed:
+    endpoints: [`+other.URL+`]
+`), 0o644))
+	require.NoError(t, gateway.Reload())
+
+	after := gateway.state.Load()
+	assert.NotSame(t, before.proxies[upstreamFeed], after.proxies[upstreamFeed])
+	assert.Same(t, before.proxies[upstreamAuth], after.proxies[upstreamAuth])
+	assert.Same(t, before.limiters[globalRateLimit], after.limiters[globalRateLimit])
+}
+
+func TestGateway_ReloadKeepsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	gateway, path := newReloadableGateway(t, slow.URL, reloadTestConfig)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		gateway.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/feed", nil))
+		done <- w
+	}()
+	<-started
+
+	require.NoError(t, os.WriteFile(path, []byte(reloadTestConfig+`
+upstreams:
+  feed:
+    endpoints: [`+fast.URL+`]
+`), 0o644))
+	require.NoError(t, gateway.Reload())
+
+	close(release)
+	w := <-done
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "slow", w.Body.String())
+
+	w = httptest.NewRecorder()
+	gateway.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/feed", nil))
+	assert.Equal(t, "fast", w.Body.String())
+}
+
+func TestGateway_Watch(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	gateway, path := newReloadableGateway(t, backend.URL, reloadTestConfig)
+	require.NoError(t, gateway.Watch(t.Context()))
+
+	require.NoError(t, os.WriteFile(path, []byte(reloadTestConfig+`
+  - path: /api/v1/notifications
+    methods: [GET]
+    upstream: notification
+`), 0o644))
+
+	assert.Eventually(t, func() bool {
+		return serveGateway(gateway, "GET", "/api/v1/notifications") == http.StatusOK
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestDiffSettings(t *testing.T) {
+	config := &Config{AllowedOrigins: []string{"*"}, RateLimit: 100, RateBurst: 200}
+	services := ServiceConfig{Feed: UpstreamConfig{Endpoints: []string{"http://feed:8080"}, Timeout: time.Second}}
+
+	old, err := parseDynamicConfig([]byte(`
+rate_limits:
+  writes: {requests_per_second: 5, burst: 20}
+routes:
+  - path: /a
+    methods: [GET, POST]
+    upstream: feed
+`))
+	require.NoError(t, err)
+	next, err := parseDynamicConfig([]byte(`
+allowed_origins: [https://udagram.example]
+upstreams:
+  feed:
+    endpoints: [http://feed-v2:8080]
+routes:
+  - path: /a
+    methods: [GET]
+    upstream: feed
+    auth: true
+  - path: /b
+    methods: [GET]
+    upstream: feed
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"allowed_origins: [*] -> [https://udagram.example]",
+		"upstream feed endpoints: [http://feed:8080] -> [http://feed-v2:8080]",
+		"rate limit writes removed",
+		"route changed: GET /a: feed -> feed auth",
+		"route added: GET /b -> feed",
+		"route removed: POST /a",
+	}, diffSettings(old.resolve(config, services), next.resolve(config, services)))
+
+	assert.Empty(t, diffSettings(old.resolve(config, services), old.resolve(config, services)))
+}
+
+func TestParseDynamicConfig_InvalidOverrides(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{"unknown upstream", "upstreams:\n  billing: {endpoints: [http://billing]}\n", `upstream "billing": unknown upstream`},
+		{"bad strategy", "upstreams:\n  feed: {strategy: fastest}\n", `upstream "feed"`},
+		{"negative upstream timeout", "upstreams:\n  feed: {timeout: -1s}\n", "must not be negative"},
+		{"per-user global rate limit", "rate_limit: {requests_per_second: 1, burst: 1, key: user}\n", "global rate limit is per IP"},
+		{"bad global rate limit", "rate_limit: {requests_per_second: 1, burst: 0}\n", "must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseDynamicConfig([]byte(tt.config + reloadTestConfig))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sony/gobreaker"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common/retry"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+// Metrics
+var (
+	upstreamRetries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_upstream_retries_total",
+			Help: "Total number of upstream retries by outcome (retried, budget_exhausted)",
+		},
+		[]string{"upstream", "outcome"},
+	)
+)
+
+// maxReplayBodyBytes is the largest request body buffered so the request can
+// be retried; requests with larger bodies are sent once
+const maxReplayBodyBytes = 64 << 10
+
+// resilientTransport wraps upstream round trips with a circuit breaker and
+// bounded retries. Only idempotent requests whose body can be replayed are
+// retried, and only after connection errors or 502/503/504 responses.
+type resilientTransport struct {
+	name    string
+	breaker *gobreaker.TwoStepCircuitBreaker
+	retry   retry.Config
+	budget  *middleware.RetryBudget
+	next    http.RoundTripper
+	logger  *zap.Logger
+}
+
+func newResilientTransport(name string, cfg UpstreamConfig, next http.RoundTripper, logger *zap.Logger) *resilientTransport {
+	breakerConfig := cfg.CircuitBreaker
+	breakerConfig.Name = name
+
+	return &resilientTransport{
+		name:    name,
+		breaker: gobreaker.NewTwoStepCircuitBreaker(breakerConfig.Settings(logger)),
+		retry:   cfg.Retry,
+		budget:  middleware.NewRetryBudget(cfg.RetryBudget),
+		next:    next,
+		logger:  logger.With(zap.String("upstream", name)),
+	}
+}
+
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.budget.Deposit()
+	retryable := t.retry.MaxRetries > 0 && middleware.IsIdempotentMethod(req.Method)
+	if retryable {
+		var err error
+		if req, err = bufferBody(req); err != nil {
+			return nil, err
+		}
+		retryable = canReplayBody(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if req.Body != nil && req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				req = req.Clone(req.Context())
+				req.Body = body
+			}
+		}
+
+		resp, err := t.roundTrip(req)
+		if !retryable || attempt >= t.retry.MaxRetries || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		if !t.budget.Withdraw() {
+			upstreamRetries.WithLabelValues(t.name, "budget_exhausted").Inc()
+			return resp, err
+		}
+
+		if resp != nil {
+			drainAndClose(resp.Body)
+		}
+
+		wait := t.retry.Backoff(attempt + 1)
+		t.logger.Debug("retrying upstream request",
+			zap.String("method", req.Method),
+			zap.String("path", req.URL.Path),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("backoff", wait),
+			zap.Error(err),
+		)
+		upstreamRetries.WithLabelValues(t.name, "retried").Inc()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// roundTrip performs a single attempt through the circuit breaker
+func (t *resilientTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	switch {
+	case err != nil:
+		// A client hanging up says nothing about the upstream
+		done(errors.Is(err, context.Canceled))
+	default:
+		done(resp.StatusCode < 500)
+	}
+	return resp, err
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		// Fail fast while the breaker is open
+		return !isBreakerOpen(err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+func canReplayBody(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// bufferBody reads a request body of up to maxReplayBodyBytes into memory so
+// it can be replayed. Larger bodies are left to stream as they were.
+func bufferBody(req *http.Request) (*http.Request, error) {
+	if canReplayBody(req) || req.ContentLength > maxReplayBodyBytes {
+		return req, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, maxReplayBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	if len(buf) > maxReplayBodyBytes {
+		// Send what was read followed by the rest of the body
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return req, nil
+	}
+
+	_ = req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return req, nil
+}
+
+func drainAndClose(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, 64<<10))
+	_ = body.Close()
+}
+
+// isBreakerOpen reports whether err was returned because the breaker rejected
+// the request
+func isBreakerOpen(err error) bool {
+	return errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common/retry"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+func testResilienceConfig(backendURL string) UpstreamConfig {
+	retry := retry.DefaultConfig()
+	retry.InitialWait = time.Millisecond
+	retry.MaxWait = 5 * time.Millisecond
+
+	return UpstreamConfig{
+		Endpoints:      []string{backendURL},
+		CircuitBreaker: middleware.DefaultCircuitBreakerConfig(""),
+		Retry:          retry,
+		RetryBudget:    middleware.DefaultRetryBudgetConfig(),
+	}
+}
+
+func newTestResilientClient(t *testing.T, cfg UpstreamConfig) *http.Client {
+	pool := newTestPool(t, cfg)
+	return &http.Client{
+		Transport: newResilientTransport("test", cfg, &poolTransport{pool: pool, base: http.DefaultTransport}, zap.NewNop()),
+	}
+}
+
+// flakyBackend fails the first n requests with 503
+func flakyBackend(n int32) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= n {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	return server, &calls
+}
+
+func TestResilientTransport_RetriesIdempotentRequests(t *testing.T) {
+	backend, calls := flakyBackend(2)
+	defer backend.Close()
+
+	client := newTestResilientClient(t, testResilienceConfig(backend.URL))
+
+	resp, err := client.Get("http://test/")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+func TestResilientTransport_ReplaysBody(t *testing.T) {
+	var bodies []string
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer backend.Close()
+
+	client := newTestResilientClient(t, testResilienceConfig(backend.URL))
+
+	req, err := http.NewRequest(http.MethodPut, "http://test/", strings.NewReader(`{"caption":"hi"}`))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{`{"caption":"hi"}`, `{"caption":"hi"}`}, bodies)
+}
+
+func TestResilientTransport_BuffersProxiedBody(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		calls int32
+	}{
+		{"small body is replayed", `{"caption":"hi"}`, 2},
+		{"large body is sent once", strings.Repeat("x", maxReplayBodyBytes+1), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			var calls atomic.Int32
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				if calls.Add(1) == 1 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			}))
+			defer backend.Close()
+
+			client := newTestResilientClient(t, testResilienceConfig(backend.URL))
+
+			// Proxied requests have no GetBody and may not have a length
+			req, err := http.NewRequest(http.MethodPut, "http://test/", io.NopCloser(strings.NewReader(tt.body)))
+			require.NoError(t, err)
+			req.ContentLength = -1
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+
+			assert.Equal(t, tt.calls, calls.Load())
+			for _, body := range bodies {
+				assert.Equal(t, tt.body, body)
+			}
+		})
+	}
+}
+
+func TestResilientTransport_DoesNotRetryPost(t *testing.T) {
+	backend, calls := flakyBackend(1)
+	defer backend.Close()
+
+	client := newTestResilientClient(t, testResilienceConfig(backend.URL))
+
+	resp, err := client.Post("http://test/", "application/json", strings.NewReader(`{}`))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestResilientTransport_RetryBudgetExhausted(t *testing.T) {
+	backend, calls := flakyBackend(100)
+	defer backend.Close()
+
+	cfg := testResilienceConfig(backend.URL)
+	cfg.CircuitBreaker.MinRequests = 1000
+	cfg.RetryBudget = middleware.RetryBudgetConfig{Ratio: 0, MinPerSecond: 0}
+	client := newTestResilientClient(t, cfg)
+
+	// The bucket starts with 10 tokens and nothing refills it
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get("http://test/")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	assert.Equal(t, int32(15), calls.Load())
+}
+
+func TestResilientTransport_BreakerOpens(t *testing.T) {
+	backend, calls := flakyBackend(100)
+	defer backend.Close()
+
+	cfg := testResilienceConfig(backend.URL)
+	cfg.Retry.MaxRetries = 0
+	client := newTestResilientClient(t, cfg)
+
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get("http://test/")
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+	}
+
+	_, err := client.Get("http://test/")
+	require.Error(t, err)
+	assert.True(t, isBreakerOpen(err))
+	assert.Equal(t, int32(3), calls.Load(), "open breaker fails fast without calling the upstream")
+}
+
+func TestUpstreamProxy_BreakerOpenResponse(t *testing.T) {
+	backend, _ := flakyBackend(100)
+	defer backend.Close()
+
+	cfg := testResilienceConfig(backend.URL)
+	cfg.Retry.MaxRetries = 0
+	proxy, err := newUpstreamProxy("test", cfg, http.DefaultTransport, zap.NewNop())
+	require.NoError(t, err)
+	t.Cleanup(proxy.Close)
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < 4; i++ {
+		w = httptest.NewRecorder()
+		proxy.proxy.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "service temporarily unavailable")
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+// methodAny matches every HTTP method
+const methodAny = "ANY"
+
+// Rate limit keys
+const (
+	rateLimitKeyIP   = "ip"
+	rateLimitKeyUser = "user"
+)
+
+// Route check modes
+const (
+	RouteCheckStrict = "strict"
+	RouteCheckWarn   = "warn"
+	RouteCheckOff    = "off"
+)
+
+var validMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+	methodAny:          true,
+}
+
+// anyMethods are the methods gin registers for Any
+var anyMethods = []string{
+	http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodHead,
+	http.MethodOptions, http.MethodDelete, http.MethodConnect, http.MethodTrace,
+}
+
+// validateRoutes checks routes against the known upstreams and rate limit
+// policies
+func validateRoutes(routes []Route, policies map[string]RateLimitPolicy) []error {
+	var errs []error
+
+	if len(routes) == 0 {
+		errs = append(errs, errors.New("no routes"))
+	}
+
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		name := route.Path
+		if !strings.HasPrefix(route.Path, "/") {
+			errs = append(errs, fmt.Errorf("route %q: path must start with /", name))
+		}
+
+		if !isKnownUpstream(route.Upstream) {
+			errs = append(errs, fmt.Errorf("route %q: unknown upstream %q", name, route.Upstream))
+		}
+
+		if route.RateLimit != "" {
+			policy, ok := policies[route.RateLimit]
+			switch {
+			case !ok:
+				errs = append(errs, fmt.Errorf("route %q: unknown rate limit %q", name, route.RateLimit))
+			case policy.Key == rateLimitKeyUser && !route.Auth:
+				errs = append(errs, fmt.Errorf("route %q: rate limit %q is per user and requires auth", name, route.RateLimit))
+			}
+		}
+
+		if route.Timeout < 0 {
+			errs = append(errs, fmt.Errorf("route %q: timeout must not be negative", name))
+		}
+
+		if route.Cache != nil {
+			errs = append(errs, route.Cache.validate(route)...)
+		}
+
+		if route.Stream && route.Cache != nil {
+			errs = append(errs, fmt.Errorf("route %q: stream routes are never cached", name))
+		}
+		if route.FlushInterval != 0 && !route.Stream {
+			errs = append(errs, fmt.Errorf("route %q: flush_interval applies to stream routes only", name))
+		}
+		if route.FlushInterval < 0 {
+			errs = append(errs, fmt.Errorf("route %q: flush_interval must not be negative", name))
+		}
+
+		if len(route.Methods) == 0 {
+			errs = append(errs, fmt.Errorf("route %q: no methods", name))
+		}
+		for _, method := range route.Methods {
+			if !validMethods[method] {
+				errs = append(errs, fmt.Errorf("route %q: unsupported method %q", name, method))
+				continue
+			}
+			for _, m := range expandMethods(method) {
+				key := m + " " + route.Path
+				if seen[key] {
+					errs = append(errs, fmt.Errorf("route %q: %s is declared more than once", name, m))
+				}
+				seen[key] = true
+			}
+		}
+	}
+
+	return errs
+}
+
+// routeTimeouts returns the per-route timeouts keyed for
+// middleware.TimeoutConfig. Stream routes have none; their timeout only
+// bounds the wait for the upstream to respond.
+func routeTimeouts(routes []Route) map[string]time.Duration {
+	timeouts := make(map[string]time.Duration)
+	for _, route := range routes {
+		timeout := route.Timeout
+		switch {
+		case route.Stream:
+			timeout = 0
+		case timeout == 0:
+			continue
+		}
+		for _, method := range route.Methods {
+			for _, m := range expandMethods(method) {
+				timeouts[m+" "+route.Path] = timeout
+			}
+		}
+	}
+	return timeouts
+}
+
+// routeBodyLimits returns the per-route body limits keyed for
+// middleware.BodyLimitConfig. Stream routes are exempt, since a stream's
+// request body can be as long-lived as its response.
+func routeBodyLimits(routes []Route) map[string]int64 {
+	limits := make(map[string]int64)
+	for _, route := range routes {
+		if !route.Stream {
+			continue
+		}
+		for _, method := range route.Methods {
+			for _, m := range expandMethods(method) {
+				limits[m+" "+route.Path] = 0
+			}
+		}
+	}
+	return limits
+}
+
+func expandMethods(method string) []string {
+	if method == methodAny {
+		return anyMethods
+	}
+	return []string{method}
+}
+
+// registerRoutes adds the configured routes to the state's router
+func (g *Gateway) registerRoutes(st *gatewayState) (err error) {
+	// gin panics on conflicting routes; report them as configuration errors
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("register routes: %v", r)
+		}
+	}()
+
+	jwt := g.jwtMiddleware()
+	for _, route := range st.settings.routes {
+		var handlers []gin.HandlerFunc
+		if route.Auth {
+			handlers = append(handlers, jwt)
+		}
+		if route.RateLimit != "" {
+			handlers = append(handlers, st.limiters[route.RateLimit].handler)
+		}
+		if route.Cache != nil {
+			handlers = append(handlers, g.cacheHandler(route))
+		}
+
+		proxy := st.proxies[route.Upstream]
+		if route.Stream {
+			handlers = append(handlers, g.streamHandler(route, proxy))
+		} else {
+			handlers = append(handlers, proxy.serve)
+		}
+
+		for _, method := range route.Methods {
+			if method == methodAny {
+				st.router.Any(route.Path, handlers...)
+			} else {
+				st.router.Handle(method, route.Path, handlers...)
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkUpstreamRoutes asks each upstream for its routes and reports gateway
+// routes that no upstream route serves. Upstreams that cannot be reached are
+// logged and skipped, since they may simply not be up yet.
+func (g *Gateway) checkUpstreamRoutes(ctx context.Context, st *gatewayState) error {
+	client := &http.Client{Transport: g.transport, Timeout: 5 * time.Second}
+
+	upstreamRoutes := make(map[string][]middleware.RouteInfo)
+	var errs []error
+	for _, route := range st.settings.routes {
+		routes, fetched := upstreamRoutes[route.Upstream]
+		if !fetched {
+			var err error
+			routes, err = fetchUpstreamRoutes(ctx, client, st, route.Upstream)
+			if err != nil {
+				g.logger.Warn("could not fetch upstream routes",
+					zap.String("upstream", route.Upstream),
+					zap.Error(err),
+				)
+			}
+			upstreamRoutes[route.Upstream] = routes
+		}
+		if routes == nil {
+			continue
+		}
+
+		for _, method := range route.Methods {
+			if !upstreamServes(routes, method, route.Path) {
+				errs = append(errs, fmt.Errorf("%s %s: not served by upstream %q", method, route.Path, route.Upstream))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func fetchUpstreamRoutes(ctx context.Context, client *http.Client, st *gatewayState, upstream string) ([]middleware.RouteInfo, error) {
+	proxy, ok := st.proxies[upstream]
+	if !ok {
+		return nil, fmt.Errorf("unknown upstream %q", upstream)
+	}
+
+	ep, err := proxy.pool.pick("")
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.url.JoinPath("/routes").String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data []middleware.RouteInfo `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode routes: %w", err)
+	}
+
+	return body.Data, nil
+}
+
+// upstreamServes reports whether an upstream route handles the gateway route.
+// Parameter names may differ between the two. A gateway catch-all matches
+// any upstream route under its prefix.
+func upstreamServes(routes []middleware.RouteInfo, method, path string) bool {
+	prefix, _, catchAll := strings.Cut(path, "/*")
+	for _, route := range routes {
+		if method != methodAny && route.Method != method {
+			continue
+		}
+		if catchAll {
+			if route.Path == prefix || strings.HasPrefix(route.Path, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if normalizeRoutePath(route.Path) == normalizeRoutePath(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeRoutePath replaces parameter names so that "/feed/:id" and
+// "/feed/:itemID" compare equal
+func normalizeRoutePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			segments[i] = ":"
+		case strings.HasPrefix(segment, "*"):
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, "/")
+}
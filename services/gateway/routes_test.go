@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+func newTestGateway(t *testing.T, backendURL string, dynamic *DynamicConfig) *Gateway {
+	upstream := testResilienceConfig(backendURL)
+	upstream.Timeout = time.Second
+	services := ServiceConfig{
+		Auth:         upstream,
+		Feed:         upstream,
+		Notification: upstream,
+		Transport:    DefaultTransportConfig(),
+	}
+	config := &Config{
+		JWTSecret:      "test-secret",
+		JWTIssuer:      "udagram",
+		AllowedOrigins: []string{"*"},
+		RateLimit:      1000,
+		RateBurst:      1000,
+		RequestTimeout: 5 * time.Second,
+		MaxBodyBytes:   1 << 20,
+		Compression:    middleware.DefaultCompressionConfig(),
+
+		ResponseCache:           ResponseCacheMemory,
+		ResponseCacheMaxEntries: 100,
+	}
+
+	gateway, err := NewGateway(config, services, dynamic, zap.NewNop(), nil)
+	require.NoError(t, err)
+	t.Cleanup(gateway.Close)
+	return gateway
+}
+
+func TestDefaultDynamicConfig(t *testing.T) {
+	table, err := loadDynamicConfig("")
+	require.NoError(t, err)
+
+	declared := make(map[string]bool)
+	for _, route := range table.Routes {
+		for _, method := range route.Methods {
+			declared[method+" "+route.Path] = true
+		}
+	}
+
+	assert.True(t, declared["POST /api/v1/feed/:id/like"])
+	assert.True(t, declared["POST /api/v1/feed/:id/unlike"])
+	assert.True(t, declared["GET /api/v1/auth/validate"])
+	assert.True(t, declared["GET /api/v1/auth/verification"])
+	assert.True(t, declared["PUT /api/v1/notifications/:id/read"])
+	assert.True(t, declared["PUT /api/v1/notifications/preferences"])
+	assert.False(t, declared["PATCH /api/v1/notifications/:id"])
+}
+
+func TestParseDynamicConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		table string
+		err   string
+	}{
+		{"unknown field", "routes:\n  - path: /a\n    methods: [GET]\n    upstream: feed\n    auth_required: true\n", "field auth_required not found"},
+		{"no routes", "routes: []\n", "no routes"},
+		{"relative path", "routes:\n  - path: a\n    methods: [GET]\n    upstream: feed\n", "must start with /"},
+		{"unknown upstream", "routes:\n  - path: /a\n    methods: [GET]\n    upstream: billing\n", `unknown upstream "billing"`},
+		{"bad method", "routes:\n  - path: /a\n    methods: [FETCH]\n    upstream: feed\n", `unsupported method "FETCH"`},
+		{"no methods", "routes:\n  - path: /a\n    upstream: feed\n", "no methods"},
+		{"duplicate", "routes:\n  - path: /a\n    methods: [GET]\n    upstream: feed\n  - path: /a\n    methods: [ANY]\n    upstream: auth\n", "GET is declared more than once"},
+		{"unknown rate limit", "routes:\n  - path: /a\n    methods: [GET]\n    upstream: feed\n    rate_limit: strict\n", `unknown rate limit "strict"`},
+		{"user rate limit without auth", "rate_limits:\n  writes: {requests_per_second: 1, burst: 1, key: user}\nroutes:\n  - path: /a\n    methods: [GET]\n    upstream: feed\n    rate_limit: writes\n", "requires auth"},
+		{"bad rate limit", "rate_limits:\n  writes: {requests_per_second: 0, burst: 1}\nroutes:\n  - path: /a\n    methods: [GET]\n    upstream: feed\n", "must be positive"},
+		{"negative timeout", "routes:\n  - path: /a\n    methods: [GET]\n    upstream: feed\n    timeout: -1s\n", "must not be negative"},
+		{"cached stream", "routes:\n  - path: /a\n    methods: [GET]\n    upstream: feed\n    stream: true\n    cache: {control: no-cache}\n", "never cached"},
+		{"flush interval without stream", "routes:\n  - path: /a\n    methods: [GET]\n    upstream: feed\n    flush_interval: 1s\n", "stream routes only"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseDynamicConfig([]byte(tt.table))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	table, err := parseDynamicConfig([]byte("routes:\n  - path: /a\n    methods: [get, POST]\n    upstream: feed\n    timeout: 2s\n  - path: /b\n    methods: [GET]\n    upstream: feed\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]time.Duration{
+		"GET /a":  2 * time.Second,
+		"POST /a": 2 * time.Second,
+	}, routeTimeouts(table.Routes))
+}
+
+func TestGateway_RoutesFromTable(t *testing.T) {
+	var hits []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, r.Method+" "+r.URL.Path)
+	}))
+	defer backend.Close()
+
+	table, err := parseDynamicConfig([]byte(`
+rate_limits:
+  tight:
+    requests_per_second: 0.001
+    burst: 1
+routes:
+  - path: /api/v1/feed/:id
+    methods: [GET]
+    upstream: feed
+  - path: /api/v1/feed/:id/like
+    methods: [POST]
+    upstream: feed
+    auth: true
+  - path: /api/v1/auth/login
+    methods: [POST]
+    upstream: auth
+    rate_limit: tight
+`))
+	require.NoError(t, err)
+	gateway := newTestGateway(t, backend.URL, table)
+
+	serve := func(method, path string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, nil)
+		gateway.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("GET", "/api/v1/feed/42"))
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/api/v1/feed/42/like"))
+	assert.Equal(t, http.StatusOK, serve("POST", "/api/v1/auth/login"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("POST", "/api/v1/auth/login"))
+	assert.Equal(t, http.StatusNotFound, serve("PUT", "/api/v1/notifications/1/read"))
+
+	assert.Equal(t, []string{"GET /api/v1/feed/42", "POST /api/v1/auth/login"}, hits)
+}
+
+func TestGateway_RouteConflict(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	table, err := parseDynamicConfig([]byte(`
+routes:
+  - path: /api/v1/feed/:id
+    methods: [GET]
+    upstream: feed
+  - path: /api/v1/feed/:itemID/comments
+    methods: [GET]
+    upstream: feed
+`))
+	require.NoError(t, err)
+
+	upstream := testResilienceConfig(backend.URL)
+	_, err = NewGateway(&Config{}, ServiceConfig{Auth: upstream, Feed: upstream, Notification: upstream}, table, zap.NewNop(), nil)
+	assert.Error(t, err)
+}
+
+func TestGateway_CheckUpstreamRoutes(t *testing.T) {
+	backendRouter := gin.New()
+	backendRouter.GET("/routes", middleware.RoutesHandler(backendRouter))
+	backendRouter.GET("/api/v1/feed/:itemID", func(c *gin.Context) {})
+	backendRouter.GET("/api/v0/feed", func(c *gin.Context) {})
+	backend := httptest.NewServer(backendRouter)
+	defer backend.Close()
+
+	table, err := parseDynamicConfig([]byte(`
+routes:
+  - path: /api/v1/feed/:id
+    methods: [GET]
+    upstream: feed
+  - path: /api/v0/feed/*path
+    methods: [ANY]
+    upstream: feed
+`))
+	require.NoError(t, err)
+	gateway := newTestGateway(t, backend.URL, table)
+	assert.NoError(t, gateway.checkUpstreamRoutes(context.Background(), gateway.state.Load()))
+
+	table.Routes = append(table.Routes, Route{Path: "/api/v1/feed/:id/like", Methods: []string{"POST"}, Upstream: upstreamFeed})
+	gateway = newTestGateway(t, backend.URL, table)
+	err = gateway.checkUpstreamRoutes(context.Background(), gateway.state.Load())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `POST /api/v1/feed/:id/like: not served by upstream "feed"`)
+}
+
+func TestGateway_CheckUpstreamRoutes_Unreachable(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backendURL := backend.URL
+	backend.Close()
+
+	table, err := parseDynamicConfig([]byte("routes:\n  - path: /a\n    methods: [GET]\n    upstream: feed\n"))
+	require.NoError(t, err)
+	gateway := newTestGateway(t, backendURL, table)
+
+	assert.NoError(t, gateway.checkUpstreamRoutes(context.Background(), gateway.state.Load()))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+// Metrics
+var (
+	activeStreams = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gateway_active_streams",
+			Help: "Number of open streaming connections by upstream and type",
+		},
+		[]string{"upstream", "type"},
+	)
+
+	streamsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gateway_streams_total",
+			Help: "Total number of streaming connections by upstream and type",
+		},
+		[]string{"upstream", "type"},
+	)
+)
+
+// Stream types
+const (
+	streamWebSocket = "websocket"
+	streamUpgrade   = "upgrade"
+	streamSSE       = "sse"
+	streamHTTP      = "http"
+)
+
+// streamTokenParam is the query parameter that may carry the JWT on stream
+// routes
+const streamTokenParam = "access_token"
+
+var (
+	errStreamStartTimeout = errors.New("upstream did not start the stream in time")
+	errGatewayShutdown    = errors.New("gateway is shutting down")
+)
+
+type streamStartKey struct{}
+
+// streamHandler proxies long-lived responses: protocol upgrades such as
+// WebSocket, and server-sent event streams. The server's read and write
+// deadlines are lifted for the connection and every write is flushed, or
+// batched per the route's flush_interval. The route or upstream timeout only
+// bounds the wait for the upstream's response headers.
+func (g *Gateway) streamHandler(route Route, p *upstreamProxy) gin.HandlerFunc {
+	proxy := *p.proxy
+	proxy.FlushInterval = -1
+	if route.FlushInterval > 0 {
+		proxy.FlushInterval = route.FlushInterval
+	}
+	proxy.ModifyResponse = streamStarted
+
+	timeout := p.timeout
+	if route.Timeout > 0 {
+		timeout = route.Timeout
+	}
+
+	return func(c *gin.Context) {
+		rc := http.NewResponseController(c.Writer)
+		if err := errors.Join(rc.SetReadDeadline(time.Time{}), rc.SetWriteDeadline(time.Time{})); err != nil {
+			p.logger.Warn("could not lift stream deadlines",
+				zap.String("path", c.Request.URL.Path),
+				zap.Error(err),
+			)
+		}
+
+		ctx, cancel := context.WithCancelCause(forwardContext(c))
+		defer cancel(nil)
+
+		stop := context.AfterFunc(g.streams, func() {
+			cancel(errGatewayShutdown)
+		})
+		defer stop()
+
+		if timeout > 0 {
+			timer := time.AfterFunc(timeout, func() {
+				cancel(errStreamStartTimeout)
+			})
+			defer timer.Stop()
+			ctx = context.WithValue(ctx, streamStartKey{}, timer)
+		}
+
+		kind := streamType(c.Request)
+		streamsTotal.WithLabelValues(p.name, kind).Inc()
+		activeStreams.WithLabelValues(p.name, kind).Inc()
+		defer activeStreams.WithLabelValues(p.name, kind).Dec()
+
+		proxy.ServeHTTP(c.Writer, c.Request.WithContext(ctx))
+	}
+}
+
+// streamStarted stops the start timeout once the upstream has responded
+func streamStarted(resp *http.Response) error {
+	if timer, ok := resp.Request.Context().Value(streamStartKey{}).(*time.Timer); ok {
+		timer.Stop()
+	}
+	return nil
+}
+
+// streamType classifies a request on a stream route for metrics
+func streamType(r *http.Request) string {
+	if !middleware.IsStreamRequest(r) {
+		return streamHTTP
+	}
+
+	upgrade := r.Header.Get("Upgrade")
+	switch {
+	case upgrade == "":
+		return streamSSE
+	case strings.EqualFold(upgrade, "websocket"):
+		return streamWebSocket
+	default:
+		return streamUpgrade
+	}
+}
+
+// CloseStreams ends open streams so that a graceful shutdown does not wait on
+// them. Upgraded connections are closed along with their upstream side.
+func (g *Gateway) CloseStreams() {
+	g.stopStreams()
+}
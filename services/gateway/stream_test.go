@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+const streamTestConfig = `
+routes:
+  - path: /api/v1/notifications/stream
+    methods: [GET]
+    upstream: notification
+    auth: true
+    stream: true
+    timeout: 100ms
+`
+
+// newStreamTestServer serves a stream gateway in front of backend with a
+// server write timeout shorter than the streams in the tests
+func newStreamTestServer(t *testing.T, backend http.HandlerFunc) (*Gateway, *httptest.Server) {
+	upstream := httptest.NewServer(backend)
+	t.Cleanup(upstream.Close)
+
+	dynamic, err := parseDynamicConfig([]byte(streamTestConfig))
+	require.NoError(t, err)
+	gateway := newTestGateway(t, upstream.URL, dynamic)
+
+	srv := httptest.NewUnstartedServer(gateway)
+	srv.Config.ReadTimeout = 200 * time.Millisecond
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return gateway, srv
+}
+
+func streamToken(t *testing.T) string {
+	token, err := middleware.GenerateAccessToken(middleware.JWTConfig{
+		Secret:       "test-secret",
+		Issuer:       "udagram",
+		AccessExpiry: time.Hour,
+	}, "user-123", "test@example.com")
+	require.NoError(t, err)
+	return token
+}
+
+func openEventStream(t *testing.T, url string) *http.Response {
+	req, err := http.NewRequest("GET", url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestGateway_EventStream(t *testing.T) {
+	release := make(chan struct{})
+	_, srv := newStreamTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: hello %s\n\n", r.Header.Get("X-User-ID"))
+		http.NewResponseController(w).Flush()
+
+		<-release
+		// Outlive the gateway's write timeout and the route timeout
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, "data: bye\n\n")
+	})
+
+	active := activeStreams.WithLabelValues(upstreamNotification, streamSSE)
+	before := testutil.ToFloat64(active)
+
+	resp := openEventStream(t, srv.URL+"/api/v1/notifications/stream?access_token="+streamToken(t))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	// The first event arrives while the upstream is still streaming
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: hello user-123\n", line)
+	assert.Equal(t, before+1, testutil.ToFloat64(active))
+
+	close(release)
+	_, _ = reader.ReadString('\n')
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "data: bye\n", line)
+}
+
+func TestGateway_EventStreamRequiresAuth(t *testing.T) {
+	_, srv := newStreamTestServer(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	resp := openEventStream(t, srv.URL+"/api/v1/notifications/stream")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp = openEventStream(t, srv.URL+"/api/v1/notifications/stream?access_token=invalid")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestGateway_EventStreamStartTimeout(t *testing.T) {
+	_, srv := newStreamTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	resp := openEventStream(t, srv.URL+"/api/v1/notifications/stream?access_token="+streamToken(t))
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+}
+
+func TestGateway_CloseStreams(t *testing.T) {
+	gateway, srv := newStreamTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		http.NewResponseController(w).Flush()
+		<-r.Context().Done()
+	})
+
+	resp := openEventStream(t, srv.URL+"/api/v1/notifications/stream?access_token="+streamToken(t))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	gateway.CloseStreams()
+	_, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.Error(t, err)
+}
+
+func TestGateway_Upgrade(t *testing.T) {
+	_, srv := newStreamTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		greeting := fmt.Sprintf("hello %s %q\n", r.Header.Get("X-User-ID"), r.URL.RawQuery)
+
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.WriteString(greeting)
+		rw.Flush()
+
+		// Echo lines until the client hangs up
+		for {
+			line, err := rw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			rw.WriteString(line)
+			rw.Flush()
+		}
+	})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET /api/v1/notifications/stream?access_token=%s HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n", streamToken(t))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// The token is not forwarded
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "hello user-123 \"\"\n", line)
+
+	// The connection outlives the gateway's timeouts
+	time.Sleep(300 * time.Millisecond)
+	fmt.Fprint(conn, "ping\n")
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "ping\n", line)
+}
+
+func TestStreamRoutes(t *testing.T) {
+	table, err := parseDynamicConfig([]byte(streamTestConfig + "  - path: /api/v1/feed\n    methods: [POST]\n    upstream: feed\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]time.Duration{
+		"GET /api/v1/notifications/stream": 0,
+	}, routeTimeouts(table.Routes))
+	assert.Equal(t, map[string]int64{
+		"GET /api/v1/notifications/stream": 0,
+	}, routeBodyLimits(table.Routes))
+}
+
+func TestStreamType(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		want   string
+	}{
+		{http.Header{"Connection": {"Upgrade"}, "Upgrade": {"WebSocket"}}, streamWebSocket},
+		{http.Header{"Connection": {"upgrade"}, "Upgrade": {"h2c"}}, streamUpgrade},
+		{http.Header{"Accept": {"text/event-stream"}}, streamSSE},
+		{http.Header{"Upgrade": {"websocket"}}, streamHTTP},
+		{http.Header{}, streamHTTP},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header = tt.header
+		assert.Equal(t, tt.want, streamType(r), tt.header)
+	}
+}
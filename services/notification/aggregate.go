@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Metrics
+var (
+	notificationEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notification_events_total",
+			Help: "Total number of notification events by outcome",
+		},
+		[]string{"result"},
+	)
+)
+
+// Event outcomes
+const (
+	// eventCreated stored a new in-app notification
+	eventCreated = "created"
+	// eventAggregated folded into an existing notification
+	eventAggregated = "aggregated"
+	// eventRepeated came from an actor the notification already counts
+	eventRepeated = "repeated"
+	// eventDuplicate was handled before
+	eventDuplicate = "duplicate"
+	// eventRecorded was not stored in-app, as the user opted out
+	eventRecorded = "recorded"
+)
+
+// AggregationConfig holds aggregation and deduplication configuration
+type AggregationConfig struct {
+	// Window is how recent an unread notification must be for events of the
+	// same type and reference to fold into it
+	Window time.Duration
+	// EventRetention is how long handled event IDs are remembered
+	EventRetention time.Duration
+}
+
+// DefaultAggregationConfig returns default aggregation configuration
+func DefaultAggregationConfig() AggregationConfig {
+	return AggregationConfig{
+		Window:         time.Hour,
+		EventRetention: 7 * 24 * time.Hour,
+	}
+}
+
+// origin describes the event behind a notification
+type origin struct {
+	// EventID identifies the event, so a redelivered one is skipped
+	EventID string
+	// ActorID and ActorName are who caused the event, such as who liked a post
+	ActorID   string
+	ActorName string
+	// Action is what the actor did, such as "liked your post"
+	Action string
+}
+
+// ProcessedEvent is an event the service handled
+type ProcessedEvent struct {
+	EventID   string    `gorm:"primaryKey;type:varchar(255)"`
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// TableName returns the table name for ProcessedEvent
+func (ProcessedEvent) TableName() string {
+	return "processed_events"
+}
+
+// NotificationActor is an actor counted in an aggregated notification
+type NotificationActor struct {
+	NotificationID string `gorm:"primaryKey;type:uuid"`
+	ActorID        string `gorm:"primaryKey;type:uuid"`
+	CreatedAt      time.Time
+}
+
+// TableName returns the table name for NotificationActor
+func (NotificationActor) TableName() string {
+	return "notification_actors"
+}
+
+// groupKey returns the key notifications aggregate by, or "" for
+// notifications without a reference, which are never aggregated
+func groupKey(n *Notification) string {
+	if n.ReferenceID == nil {
+		return ""
+	}
+	return n.Type + ":" + n.ReferenceType + ":" + *n.ReferenceID
+}
+
+// aggregateMessage describes a notification from count actors, such as
+// "Alice and 12 others liked your post". Without an actor name and action
+// the event's own message is kept.
+func aggregateMessage(message string, o origin, count int) string {
+	if o.ActorName == "" || o.Action == "" {
+		return message
+	}
+
+	switch {
+	case count <= 1 && message != "":
+		return message
+	case count <= 1:
+		return o.ActorName + " " + o.Action
+	case count == 2:
+		return fmt.Sprintf("%s and 1 other %s", o.ActorName, o.Action)
+	default:
+		return fmt.Sprintf("%s and %d others %s", o.ActorName, count-1, o.Action)
+	}
+}
+
+// store records the event and, when inApp, stores its notification. An
+// event for the same type and reference as one of the user's recent unread
+// notifications updates that notification in place. It returns the
+// outcome; n is left holding the stored notification.
+func (s *NotificationService) store(ctx context.Context, n *Notification, o origin, inApp bool) (string, error) {
+	var result string
+	err := s.db.Transaction(ctx, func(tx *gorm.DB) error {
+		if o.EventID != "" {
+			recorded := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&ProcessedEvent{EventID: o.EventID})
+			if recorded.Error != nil {
+				return recorded.Error
+			}
+			if recorded.RowsAffected == 0 {
+				result = eventDuplicate
+				return nil
+			}
+		}
+		if !inApp {
+			result = eventRecorded
+			return nil
+		}
+
+		var err error
+		result, err = s.aggregate(tx, n, o)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("store notification: %w", err)
+	}
+
+	if result == eventCreated || result == eventAggregated {
+		s.invalidateCache(ctx, n.UserID)
+	}
+	return result, nil
+}
+
+// aggregate folds n into the user's latest unread notification in its group
+// within the window, or creates it
+func (s *NotificationService) aggregate(tx *gorm.DB, n *Notification, o origin) (string, error) {
+	n.GroupKey = groupKey(n)
+	if n.GroupKey == "" {
+		n.Message = aggregateMessage(n.Message, o, 1)
+		return eventCreated, create(tx, n)
+	}
+
+	// Concurrent events for the group wait for each other, so they fold into
+	// one notification
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", n.UserID+"|"+n.GroupKey).Error; err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	var existing Notification
+	found := tx.
+		Where("user_id = ? AND group_key = ? AND status <> ? AND created_at > ?",
+			n.UserID, n.GroupKey, StatusRead, now.Add(-s.aggregation.Window)).
+		Order("created_at DESC").
+		Limit(1).
+		Find(&existing)
+	if found.Error != nil {
+		return "", found.Error
+	}
+
+	if found.RowsAffected == 0 {
+		n.Message = aggregateMessage(n.Message, o, 1)
+		if err := create(tx, n); err != nil {
+			return "", err
+		}
+		if o.ActorID != "" {
+			if err := tx.Create(&NotificationActor{NotificationID: n.ID, ActorID: o.ActorID}).Error; err != nil {
+				return "", err
+			}
+		}
+		return eventCreated, nil
+	}
+
+	if o.ActorID != "" {
+		added := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&NotificationActor{NotificationID: existing.ID, ActorID: o.ActorID})
+		if added.Error != nil {
+			return "", added.Error
+		}
+		if added.RowsAffected == 0 {
+			*n = existing
+			return eventRepeated, nil
+		}
+	}
+
+	// The update moves the notification to the top, as a new one would be
+	existing.ActorCount++
+	existing.Title = n.Title
+	existing.Message = aggregateMessage(n.Message, o, existing.ActorCount)
+	existing.SentAt = &now
+	existing.CreatedAt = now
+	existing.UpdatedAt = now
+	err := tx.Model(&existing).
+		Select("title", "message", "actor_count", "sent_at", "created_at", "updated_at").
+		Updates(&existing).Error
+	if err != nil {
+		return "", err
+	}
+
+	*n = existing
+	return eventAggregated, nil
+}
+
+// pruneEvents forgets handled events older than the retention, hourly until
+// ctx is canceled
+func (s *NotificationService) pruneEvents(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		cutoff := time.Now().Add(-s.aggregation.EventRetention)
+		result := s.db.WithContext(ctx).Where("created_at < ?", cutoff).Delete(&ProcessedEvent{})
+		if result.Error != nil && ctx.Err() == nil {
+			s.logger.Warn("failed to prune processed events", zap.Error(result.Error))
+		} else if result.RowsAffected > 0 {
+			s.logger.Debug("pruned processed events", zap.Int64("count", result.RowsAffected))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
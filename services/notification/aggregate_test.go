@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregateMessage(t *testing.T) {
+	liked := origin{ActorName: "Alice", Action: "liked your post"}
+
+	tests := []struct {
+		name    string
+		message string
+		origin  origin
+		count   int
+		want    string
+	}{
+		{name: "one actor", origin: liked, count: 1, want: "Alice liked your post"},
+		{name: "one actor keeps the event's message", message: "Alice liked your beach photo", origin: liked, count: 1, want: "Alice liked your beach photo"},
+		{name: "no count", origin: liked, count: 0, want: "Alice liked your post"},
+		{name: "two actors", origin: liked, count: 2, want: "Alice and 1 other liked your post"},
+		{name: "many actors", origin: liked, count: 13, want: "Alice and 12 others liked your post"},
+		{name: "many actors replace the event's message", message: "Alice liked your beach photo", origin: liked, count: 3, want: "Alice and 2 others liked your post"},
+		{name: "no actor name", message: "Someone liked your post", origin: origin{Action: "liked your post"}, count: 3, want: "Someone liked your post"},
+		{name: "no action", message: "Alice did something", origin: origin{ActorName: "Alice"}, count: 3, want: "Alice did something"},
+		{name: "no actor or message", count: 2, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, aggregateMessage(tt.message, tt.origin, tt.count))
+		})
+	}
+}
+
+func TestGroupKey(t *testing.T) {
+	reference := "6f0c5f7e-2d1b-4c55-9d0e-9a4b6a1c2f3d"
+
+	assert.Empty(t, groupKey(&Notification{Type: "feed_liked"}))
+	assert.Equal(t, "feed_liked:feed_item:"+reference,
+		groupKey(&Notification{Type: "feed_liked", ReferenceType: "feed_item", ReferenceID: &reference}))
+}
+
+func TestStore_Aggregates(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	userID := uuid.New().String()
+	reference := uuid.New().String()
+	like := func(actorID, actorName string) (*Notification, origin) {
+		n := &Notification{
+			UserID:        userID,
+			Type:          "feed_liked",
+			Title:         "New like",
+			ReferenceID:   &reference,
+			ReferenceType: "feed_item",
+		}
+		o := origin{
+			EventID:   uuid.New().String(),
+			ActorID:   actorID,
+			ActorName: actorName,
+			Action:    "liked your post",
+		}
+		return n, o
+	}
+	alice, bob := uuid.New().String(), uuid.New().String()
+
+	n, o := like(alice, "Alice")
+	result, err := s.store(ctx, n, o, true)
+	require.NoError(t, err)
+	assert.Equal(t, eventCreated, result)
+	assert.Equal(t, "Alice liked your post", n.Message)
+	first := n.ID
+
+	// The same event again is skipped
+	result, err = s.store(ctx, &Notification{UserID: userID, Type: "feed_liked", Title: "New like", ReferenceID: &reference}, o, true)
+	require.NoError(t, err)
+	assert.Equal(t, eventDuplicate, result)
+
+	n, o = like(bob, "Bob")
+	result, err = s.store(ctx, n, o, true)
+	require.NoError(t, err)
+	assert.Equal(t, eventAggregated, result)
+	assert.Equal(t, first, n.ID)
+	assert.Equal(t, 2, n.ActorCount)
+	assert.Equal(t, "Bob and 1 other liked your post", n.Message)
+
+	// An actor already counted is not counted twice
+	n, o = like(alice, "Alice")
+	result, err = s.store(ctx, n, o, true)
+	require.NoError(t, err)
+	assert.Equal(t, eventRepeated, result)
+	assert.Equal(t, 2, n.ActorCount)
+
+	// Once read, the next like starts a new notification
+	require.NoError(t, s.db.DB().Model(&Notification{}).Where("id = ?", first).Update("status", StatusRead).Error)
+	n, o = like(uuid.New().String(), "Carol")
+	result, err = s.store(ctx, n, o, true)
+	require.NoError(t, err)
+	assert.Equal(t, eventCreated, result)
+	assert.NotEqual(t, first, n.ID)
+	assert.Equal(t, "Carol liked your post", n.Message)
+
+	// Users who opted out of in-app only have the event recorded
+	n, o = like(uuid.New().String(), "Dave")
+	result, err = s.store(ctx, n, o, false)
+	require.NoError(t, err)
+	assert.Equal(t, eventRecorded, result)
+
+	var stored int64
+	require.NoError(t, s.db.DB().Model(&Notification{}).Count(&stored).Error)
+	assert.Equal(t, int64(2), stored)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+	"github.com/Femi-lawal/udagram-app/pkg/messaging"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+// maxDeadLetters bounds the dead letters listed at once
+const maxDeadLetters = 100
+
+// deadLetterQueue returns the dead-letter queue of the consumer of the
+// :topic parameter, responding 404 when there is none
+func (s *NotificationService) deadLetterQueue(c *gin.Context) (*messaging.DeadLetterQueue, bool) {
+	queue, ok := s.deadLetters[c.Param("topic")]
+	if !ok {
+		common.NotFoundResponse(c, "no consumer for topic "+c.Param("topic"))
+		return nil, false
+	}
+	return queue, true
+}
+
+// ListDeadLetterQueues lists the dead-letter topics by the topic their
+// messages came from
+func (s *NotificationService) ListDeadLetterQueues(c *gin.Context) {
+	queues := make([]gin.H, 0, len(s.deadLetters))
+	for topic, queue := range s.deadLetters {
+		queues = append(queues, gin.H{
+			"topic":             topic,
+			"dead_letter_topic": queue.Topic(),
+		})
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i]["topic"].(string) < queues[j]["topic"].(string)
+	})
+
+	common.SuccessResponse(c, queues)
+}
+
+// GetDeadLetters lists the dead letters in a partition of a topic's
+// dead-letter topic, from an offset on
+func (s *NotificationService) GetDeadLetters(c *gin.Context) {
+	queue, ok := s.deadLetterQueue(c)
+	if !ok {
+		return
+	}
+
+	partition, err := strconv.Atoi(c.DefaultQuery("partition", "0"))
+	if err != nil || partition < 0 {
+		common.BadRequestResponse(c, "partition must be a non-negative integer")
+		return
+	}
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil || offset < 0 {
+		common.BadRequestResponse(c, "offset must be a non-negative integer")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > maxDeadLetters {
+		common.BadRequestResponse(c, fmt.Sprintf("limit must be between 1 and %d", maxDeadLetters))
+		return
+	}
+
+	letters, err := queue.List(c.Request.Context(), partition, offset, limit)
+	if err != nil {
+		s.logger.Error("failed to list dead letters", zap.String("topic", queue.Topic()), zap.Error(err))
+		common.ErrorResponse(c, common.ErrServiceUnavailable)
+		return
+	}
+
+	next := offset
+	if len(letters) > 0 {
+		next = letters[len(letters)-1].Offset + 1
+	}
+	common.SuccessResponse(c, gin.H{
+		"dead_letter_topic": queue.Topic(),
+		"dead_letters":      letters,
+		"next_offset":       next,
+	})
+}
+
+// ReplayDeadLetter sends a dead letter back to its consumer
+func (s *NotificationService) ReplayDeadLetter(c *gin.Context) {
+	queue, ok := s.deadLetterQueue(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Partition *int   `json:"partition" binding:"required,min=0"`
+		Offset    *int64 `json:"offset" binding:"required,min=0"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	err := queue.Replay(c.Request.Context(), *req.Partition, *req.Offset)
+	if errors.Is(err, messaging.ErrDeadLetterNotFound) {
+		common.NotFoundResponse(c, "dead letter not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error("failed to replay dead letter", zap.String("topic", queue.Topic()), zap.Error(err))
+		common.ErrorResponse(c, common.ErrServiceUnavailable)
+		return
+	}
+
+	s.logger.Info("replayed dead letter",
+		zap.String("topic", queue.Topic()),
+		zap.Int("partition", *req.Partition),
+		zap.Int64("offset", *req.Offset),
+		zap.String("service", c.GetString("service")),
+	)
+	common.SuccessResponse(c, gin.H{"status": "replayed"})
+}
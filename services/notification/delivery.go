@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// Metrics
+var (
+	deliveries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notification_deliveries_total",
+			Help: "Total number of notification deliveries by channel and result",
+		},
+		[]string{"channel", "result"},
+	)
+)
+
+// Delivery results
+const (
+	deliverySent          = "sent"
+	deliveryFailed        = "failed"
+	deliveryOptedOut      = "opted_out"
+	deliveryQuietHours    = "quiet_hours"
+	deliveryUnavailable   = "unavailable"
+	deliveryUndeliverable = "undeliverable"
+	deliveryAggregated    = "aggregated"
+)
+
+// errUndeliverable is returned by notifiers that cannot reach the user, such
+// as when there is no address to send to. It is not a failure.
+var errUndeliverable = errors.New("undeliverable")
+
+// Notifier delivers notifications on a channel other than in-app
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification, prefs *Preferences) error
+}
+
+// notifierChannels are the channels delivered through a Notifier, in order
+var notifierChannels = []string{ChannelEmail, ChannelPush, ChannelDigest}
+
+// deliver routes a notification to the channels the user chose for its type.
+// In-app notifications are stored and pushed to the user's open streams;
+// email and push of all but urgent types are held back during the user's
+// quiet hours and go out in their next digest instead. Events handled
+// before are skipped, and events folded into an existing notification only
+// update it in-app and count toward the digest.
+func (s *NotificationService) deliver(ctx context.Context, n *Notification, o origin) error {
+	prefs, err := s.preferences(ctx, n.UserID)
+	if err != nil {
+		// Rather the default channels than losing the notification
+		s.logger.Warn("failed to load notification preferences", zap.Error(err))
+		prefs = DefaultPreferences()
+	}
+	channels := prefs.channels(n.Type)
+
+	result, err := s.store(ctx, n, o, channels.InApp)
+	if err != nil {
+		if channels.InApp {
+			deliveries.WithLabelValues(ChannelInApp, deliveryFailed).Inc()
+		}
+		return err
+	}
+	notificationEvents.WithLabelValues(result).Inc()
+
+	switch result {
+	case eventDuplicate, eventRepeated:
+		s.logger.Debug("skipping notification event",
+			zap.String("event_id", o.EventID),
+			zap.String("result", result),
+		)
+		return nil
+	case eventRecorded:
+		deliveries.WithLabelValues(ChannelInApp, deliveryOptedOut).Inc()
+	default:
+		deliveries.WithLabelValues(ChannelInApp, deliverySent).Inc()
+
+		if err := s.hub.publish(ctx, *n); err != nil {
+			// The notification is stored; streams pick it up when they resume
+			s.logger.Warn("failed to publish notification", zap.Error(err))
+		}
+	}
+
+	quiet := prefs.quiet(time.Now()) && !notificationTypes[n.Type].Urgent
+	if quiet && result != eventAggregated && (channels.Email || channels.Push) {
+		channels.Digest = true
+	}
+	for _, channel := range notifierChannels {
+		switch {
+		case !channels.enabled(channel):
+			deliveries.WithLabelValues(channel, deliveryOptedOut).Inc()
+		case result == eventAggregated && channel != ChannelDigest:
+			// The first event of the group already went out
+			deliveries.WithLabelValues(channel, deliveryAggregated).Inc()
+		case quiet && channel != ChannelDigest:
+			deliveries.WithLabelValues(channel, deliveryQuietHours).Inc()
+		case s.notifiers[channel] == nil:
+			deliveries.WithLabelValues(channel, deliveryUnavailable).Inc()
+		default:
+			// The notification was accepted, so a failing channel is not
+			// retried by redelivering the event to every channel
+			err := s.notifiers[channel].Notify(ctx, n, prefs)
+			if errors.Is(err, errUndeliverable) {
+				deliveries.WithLabelValues(channel, deliveryUndeliverable).Inc()
+				s.logger.Debug("notification undeliverable",
+					zap.String("channel", channel),
+					zap.String("user_id", n.UserID),
+					zap.Error(err),
+				)
+				continue
+			}
+			if err != nil {
+				deliveries.WithLabelValues(channel, deliveryFailed).Inc()
+				s.logger.Warn("failed to send notification",
+					zap.String("channel", channel),
+					zap.String("user_id", n.UserID),
+					zap.Error(err),
+				)
+				continue
+			}
+			deliveries.WithLabelValues(channel, deliverySent).Inc()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingNotifier records the notifications it is asked to send
+type recordingNotifier struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, n *Notification, prefs *Preferences) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, *n)
+	return nil
+}
+
+func (r *recordingNotifier) notifications() []Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Notification(nil), r.sent...)
+}
+
+// setQuietHours gives the user quiet hours from an hour ago to an hour from
+// now, or a window that has just ended when quiet is false
+func setQuietHours(t *testing.T, s *NotificationService, userID string, quiet bool) {
+	t.Helper()
+
+	now := time.Now().UTC()
+	start, end := now.Add(-time.Hour), now.Add(time.Hour)
+	if !quiet {
+		start, end = now.Add(-2*time.Hour), now.Add(-time.Hour)
+	}
+	startClock, endClock := start.Format(clockLayout), end.Format(clockLayout)
+	require.NoError(t, s.db.DB().Create(&NotificationSettings{
+		UserID:          userID,
+		Locale:          defaultLocale,
+		TimeZone:        "UTC",
+		QuietHoursStart: &startClock,
+		QuietHoursEnd:   &endClock,
+		DigestFrequency: DigestDaily,
+		DigestTime:      "08:00",
+		DigestDay:       "monday",
+		DigestChannel:   ChannelEmail,
+	}).Error)
+}
+
+func TestDeliver_QuietHours(t *testing.T) {
+	tests := []struct {
+		name   string
+		quiet  bool
+		sent   int
+		queued int
+	}{
+		{name: "outside quiet hours", quiet: false, sent: 1},
+		{name: "during quiet hours", quiet: true, queued: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			push := &recordingNotifier{}
+			s.notifiers[ChannelPush] = push
+			s.notifiers[ChannelDigest] = &digestNotifier{db: s.db}
+
+			userID := uuid.New().String()
+			setQuietHours(t, s, userID, tt.quiet)
+
+			n := &Notification{UserID: userID, Type: "feed_liked", Title: "New like"}
+			require.NoError(t, s.deliver(context.Background(), n, origin{EventID: uuid.New().String()}))
+
+			// Quiet hours hold back push, not in-app
+			var stored int64
+			require.NoError(t, s.db.DB().Model(&Notification{}).Where("user_id = ?", userID).Count(&stored).Error)
+			assert.Equal(t, int64(1), stored)
+			assert.Len(t, push.notifications(), tt.sent)
+
+			// What was held back goes out with the next digest
+			var items []DigestItem
+			require.NoError(t, s.db.DB().Where("user_id = ?", userID).Find(&items).Error)
+			require.Len(t, items, tt.queued)
+			if tt.queued > 0 {
+				assert.Equal(t, n.ID, *items[0].NotificationID)
+			}
+		})
+	}
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Femi-lawal/udagram-app/pkg/database"
+)
+
+// Metrics
+var (
+	digestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notification_digests_total",
+			Help: "Total number of digests by result",
+		},
+		[]string{"result"},
+	)
+
+	digestItemsQueued = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "notification_digest_items_queued_total",
+			Help: "Total number of notifications queued for a digest",
+		},
+	)
+)
+
+// Digest frequencies
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Digest statuses
+const (
+	digestPending = "pending"
+	digestSent    = "sent"
+	digestFailed  = "failed"
+	digestEmpty   = "empty"
+)
+
+// digestLease names the scheduler lease in scheduler_leases
+const digestLease = "notification-digest"
+
+// DigestSchedule is when and how a user gets their digest
+type DigestSchedule struct {
+	// Frequency is daily or weekly
+	Frequency string `json:"frequency"`
+	// Time is the local time the digest goes out, as HH:MM
+	Time string `json:"time"`
+	// Day is the weekday weekly digests go out on, such as monday
+	Day string `json:"day"`
+	// Channel is email or push
+	Channel string `json:"channel"`
+}
+
+// DefaultDigestSchedule returns the schedule of users who have not set one:
+// a daily email at 08:00
+func DefaultDigestSchedule() DigestSchedule {
+	return DigestSchedule{
+		Frequency: DigestDaily,
+		Time:      "08:00",
+		Day:       "monday",
+		Channel:   ChannelEmail,
+	}
+}
+
+// normalize fills in defaults and checks the schedule
+func (d DigestSchedule) normalize() (*DigestSchedule, error) {
+	defaults := DefaultDigestSchedule()
+	if d.Frequency == "" {
+		d.Frequency = defaults.Frequency
+	}
+	if d.Time == "" {
+		d.Time = defaults.Time
+	}
+	if d.Day == "" {
+		d.Day = defaults.Day
+	}
+	if d.Channel == "" {
+		d.Channel = defaults.Channel
+	}
+
+	if d.Frequency != DigestDaily && d.Frequency != DigestWeekly {
+		return nil, errors.New("digest.frequency must be daily or weekly")
+	}
+	minutes, err := parseClock(d.Time)
+	if err != nil {
+		return nil, errors.New("digest.time must be a time as HH:MM")
+	}
+	d.Time = fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+	d.Day = strings.ToLower(d.Day)
+	if _, ok := parseWeekday(d.Day); !ok {
+		return nil, errors.New("digest.day must be a weekday such as monday")
+	}
+	if d.Channel != ChannelEmail && d.Channel != ChannelPush {
+		return nil, errors.New("digest.channel must be email or push")
+	}
+	return &d, nil
+}
+
+// due returns the latest time at or before now that a digest was scheduled
+// for, in loc
+func (d DigestSchedule) due(now time.Time, loc *time.Location) time.Time {
+	minutes, err := parseClock(d.Time)
+	if err != nil {
+		minutes, _ = parseClock(DefaultDigestSchedule().Time)
+	}
+
+	local := now.In(loc)
+	due := time.Date(local.Year(), local.Month(), local.Day(), minutes/60, minutes%60, 0, 0, loc)
+	if due.After(local) {
+		due = due.AddDate(0, 0, -1)
+	}
+	if d.Frequency == DigestWeekly {
+		weekday, ok := parseWeekday(d.Day)
+		if !ok {
+			weekday = time.Monday
+		}
+		for due.Weekday() != weekday {
+			due = due.AddDate(0, 0, -1)
+		}
+	}
+	return due.UTC()
+}
+
+func parseWeekday(day string) (time.Weekday, bool) {
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if strings.EqualFold(weekday.String(), day) {
+			return weekday, true
+		}
+	}
+	return 0, false
+}
+
+// DigestItem is a notification waiting for the user's next digest
+type DigestItem struct {
+	ID             string  `gorm:"primaryKey;type:uuid"`
+	UserID         string  `gorm:"type:uuid;not null;index"`
+	DigestID       *string `gorm:"type:uuid;index"`
+	NotificationID *string `gorm:"type:uuid"`
+	Type           string  `gorm:"type:varchar(50);not null"`
+	Title          string  `gorm:"type:varchar(255);not null"`
+	CreatedAt      time.Time
+}
+
+// TableName returns the table name for DigestItem
+func (DigestItem) TableName() string {
+	return "digest_items"
+}
+
+// Digest is one scheduled summary for a user. The unique user and schedule
+// time make claiming a digest idempotent.
+type Digest struct {
+	ID           string    `gorm:"primaryKey;type:uuid"`
+	UserID       string    `gorm:"type:uuid;not null;uniqueIndex:idx_digests_user_scheduled"`
+	ScheduledFor time.Time `gorm:"not null;uniqueIndex:idx_digests_user_scheduled"`
+	Status       string    `gorm:"type:varchar(20);not null;index"`
+	Attempts     int       `gorm:"not null"`
+	Summary      string
+	SentAt       *time.Time
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// TableName returns the table name for Digest
+func (Digest) TableName() string {
+	return "digests"
+}
+
+// SchedulerLease is held by the replica running a scheduled job
+type SchedulerLease struct {
+	Name      string    `gorm:"primaryKey;type:varchar(100)"`
+	Holder    string    `gorm:"type:varchar(255);not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// TableName returns the table name for SchedulerLease
+func (SchedulerLease) TableName() string {
+	return "scheduler_leases"
+}
+
+// digestNotifier queues notifications for the user's next digest
+type digestNotifier struct {
+	db *database.Client
+}
+
+// Notify queues the notification
+func (d *digestNotifier) Notify(ctx context.Context, n *Notification, prefs *Preferences) error {
+	item := DigestItem{
+		ID:     uuid.New().String(),
+		UserID: n.UserID,
+		Type:   n.Type,
+		Title:  n.Title,
+	}
+	if n.ID != "" {
+		item.NotificationID = &n.ID
+	}
+	if err := d.db.WithContext(ctx).Create(&item).Error; err != nil {
+		return fmt.Errorf("queue digest item: %w", err)
+	}
+	digestItemsQueued.Inc()
+	return nil
+}
+
+// DigestConfig holds digest scheduler configuration
+type DigestConfig struct {
+	// Interval is how often due digests are looked for
+	Interval time.Duration
+	// BatchSize is how many users and digests are loaded at a time
+	BatchSize int
+	// MaxAttempts is how often sending a digest is tried before it fails
+	MaxAttempts int
+}
+
+// DefaultDigestConfig returns default digest scheduler configuration
+func DefaultDigestConfig() DigestConfig {
+	return DigestConfig{
+		Interval:    time.Minute,
+		BatchSize:   500,
+		MaxAttempts: 3,
+	}
+}
+
+// digestScheduler sends digests when they are due. Replicas take turns
+// through a lease in Postgres, and each digest is claimed once per user and
+// schedule time, so a digest goes out once across restarts and replicas.
+type digestScheduler struct {
+	svc    *NotificationService
+	cfg    DigestConfig
+	holder string
+	logger *zap.Logger
+}
+
+func newDigestScheduler(svc *NotificationService, cfg DigestConfig, logger *zap.Logger) *digestScheduler {
+	host, _ := os.Hostname()
+	return &digestScheduler{
+		svc:    svc,
+		cfg:    cfg,
+		holder: host + "/" + uuid.New().String(),
+		logger: logger,
+	}
+}
+
+// Run sends due digests until ctx is canceled
+func (d *digestScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.runOnce(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			d.logger.Error("digest run failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce claims and sends the digests due at now, if this replica holds the
+// lease
+func (d *digestScheduler) runOnce(ctx context.Context, now time.Time) error {
+	held, err := d.acquireLease(ctx, now)
+	if err != nil {
+		return fmt.Errorf("acquire lease: %w", err)
+	}
+	if !held {
+		return nil
+	}
+
+	if err := d.claimDue(ctx, now); err != nil {
+		return err
+	}
+	return d.sendPending(ctx)
+}
+
+// acquireLease takes or renews the scheduler lease. It lasts a few
+// intervals so another replica takes over if this one stops renewing it.
+func (d *digestScheduler) acquireLease(ctx context.Context, now time.Time) (bool, error) {
+	lease := SchedulerLease{
+		Name:      digestLease,
+		Holder:    d.holder,
+		ExpiresAt: now.Add(3 * d.cfg.Interval).UTC(),
+	}
+	result := d.svc.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"holder", "expires_at"}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{
+				SQL:  "scheduler_leases.holder = ? OR scheduler_leases.expires_at < ?",
+				Vars: []interface{}{d.holder, now.UTC()},
+			},
+		}},
+	}).Create(&lease)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// claimDue creates a digest for each user with queued items from before
+// their latest schedule time. It pages through every user with queued
+// items, so users whose items are not due yet do not hold up the others.
+func (d *digestScheduler) claimDue(ctx context.Context, now time.Time) error {
+	after := ""
+	for {
+		var userIDs []string
+		err := d.svc.db.WithContext(ctx).Model(&DigestItem{}).
+			Where("digest_id IS NULL AND user_id > ?", after).
+			Distinct("user_id").
+			Order("user_id").
+			Limit(d.cfg.BatchSize).
+			Pluck("user_id", &userIDs).Error
+		if err != nil {
+			return fmt.Errorf("find queued digest items: %w", err)
+		}
+
+		for _, userID := range userIDs {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			d.claimUser(ctx, userID, now)
+		}
+
+		if len(userIDs) < d.cfg.BatchSize {
+			return nil
+		}
+		after = userIDs[len(userIDs)-1]
+	}
+}
+
+// claimUser claims the user's digest if any of their queued items is due
+func (d *digestScheduler) claimUser(ctx context.Context, userID string, now time.Time) {
+	logger := d.logger.With(zap.String("user_id", userID))
+
+	prefs, err := d.svc.preferences(ctx, userID)
+	if err != nil {
+		logger.Warn("failed to load preferences for digest", zap.Error(err))
+		return
+	}
+	loc := prefs.location
+	if loc == nil {
+		loc = time.UTC
+	}
+	due := prefs.Digest.due(now, loc)
+
+	var items []DigestItem
+	err = d.svc.db.WithContext(ctx).
+		Select("id").
+		Where("user_id = ? AND digest_id IS NULL AND created_at < ?", userID, due).
+		Limit(1).
+		Find(&items).Error
+	if err != nil {
+		logger.Warn("failed to find due digest items", zap.Error(err))
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+
+	err = d.claim(ctx, userID, due)
+	if err != nil && !errors.Is(err, errNothingDue) {
+		logger.Warn("failed to claim digest", zap.Error(err))
+	}
+}
+
+// claim moves the user's items queued before due into the digest for due.
+// A digest already claimed for due is left alone; later items wait for the
+// next one.
+func (d *digestScheduler) claim(ctx context.Context, userID string, due time.Time) error {
+	return d.svc.db.Transaction(ctx, func(tx *gorm.DB) error {
+		digest := Digest{
+			ID:           uuid.New().String(),
+			UserID:       userID,
+			ScheduledFor: due,
+			Status:       digestPending,
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&digest)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		claimed := tx.Model(&DigestItem{}).
+			Where("user_id = ? AND digest_id IS NULL AND created_at < ?", userID, due).
+			Update("digest_id", digest.ID)
+		if claimed.Error != nil {
+			return claimed.Error
+		}
+		if claimed.RowsAffected == 0 {
+			// Nothing was queued before the schedule time; roll back so the
+			// digest can still be claimed once there is
+			return errNothingDue
+		}
+		return nil
+	})
+}
+
+var errNothingDue = errors.New("nothing due")
+
+// sendPending sends claimed digests that have not gone out yet
+func (d *digestScheduler) sendPending(ctx context.Context) error {
+	var digests []Digest
+	err := d.svc.db.WithContext(ctx).
+		Where("status = ?", digestPending).
+		Order("scheduled_for").
+		Limit(d.cfg.BatchSize).
+		Find(&digests).Error
+	if err != nil {
+		return fmt.Errorf("find pending digests: %w", err)
+	}
+
+	for i := range digests {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		d.send(ctx, &digests[i])
+	}
+	return nil
+}
+
+// send renders and delivers one digest, recording the outcome
+func (d *digestScheduler) send(ctx context.Context, digest *Digest) {
+	logger := d.logger.With(zap.String("user_id", digest.UserID), zap.String("digest_id", digest.ID))
+
+	status, err := d.deliver(ctx, digest)
+	digest.Attempts++
+	switch {
+	case err == nil:
+		now := time.Now().UTC()
+		digest.SentAt = &now
+	case errors.Is(err, errUndeliverable):
+		logger.Info("digest undeliverable", zap.Error(err))
+	default:
+		logger.Warn("failed to send digest", zap.Int("attempt", digest.Attempts), zap.Error(err))
+		status = digestPending
+		if digest.Attempts >= d.cfg.MaxAttempts {
+			status = digestFailed
+		}
+	}
+	digest.Status = status
+	if status != digestPending {
+		digestsTotal.WithLabelValues(status).Inc()
+	}
+
+	err = d.svc.db.WithContext(ctx).Model(digest).
+		Select("status", "attempts", "summary", "sent_at").
+		Updates(digest).Error
+	if err != nil {
+		logger.Error("failed to update digest", zap.Error(err))
+	}
+}
+
+// deliver sends the digest on the user's digest channel, falling back to
+// the other channel when it is not configured. It returns the digest's new
+// status.
+func (d *digestScheduler) deliver(ctx context.Context, digest *Digest) (string, error) {
+	var counts []digestCount
+	err := d.svc.db.WithContext(ctx).Model(&DigestItem{}).
+		Select("type, COUNT(*) AS count").
+		Where("digest_id = ?", digest.ID).
+		Group("type").
+		Scan(&counts).Error
+	if err != nil {
+		return "", err
+	}
+	if len(counts) == 0 {
+		return digestEmpty, nil
+	}
+
+	prefs, err := d.svc.preferences(ctx, digest.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	digest.Summary = summarizeDigest(counts, prefs.Locale)
+	n := &Notification{
+		ID:      digest.ID,
+		UserID:  digest.UserID,
+		Type:    TypeDigest,
+		Title:   localize(prefs.Locale, "digest.subject"),
+		Message: digest.Summary,
+	}
+
+	notifier := d.svc.notifiers[prefs.Digest.Channel]
+	if notifier == nil {
+		for _, channel := range []string{ChannelEmail, ChannelPush} {
+			if notifier = d.svc.notifiers[channel]; notifier != nil {
+				break
+			}
+		}
+	}
+	if notifier == nil {
+		return digestFailed, fmt.Errorf("%w: no digest channel configured", errUndeliverable)
+	}
+
+	if err := notifier.Notify(ctx, n, prefs); err != nil {
+		if errors.Is(err, errUndeliverable) {
+			return digestFailed, err
+		}
+		return "", err
+	}
+	return digestSent, nil
+}
+
+// digestCount is the number of a digest's items of one type
+type digestCount struct {
+	Type  string
+	Count int
+}
+
+// summarizeDigest describes the counts in the locale, most frequent first,
+// such as "5 new likes, 2 new followers"
+func summarizeDigest(counts []digestCount, locale string) string {
+	sorted := append([]digestCount(nil), counts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Type < sorted[j].Type
+	})
+
+	var parts []string
+	other := 0
+	for _, c := range sorted {
+		form := "other"
+		if c.Count == 1 {
+			form = "one"
+		}
+		key := "digest.types." + c.Type + "." + form
+		if message := localize(locale, key); message != key {
+			parts = append(parts, fmt.Sprintf(message, c.Count))
+			continue
+		}
+		other += c.Count
+	}
+
+	if other > 0 {
+		form := "digest.other.other"
+		if other == 1 {
+			form = "digest.other.one"
+		}
+		parts = append(parts, fmt.Sprintf(localize(locale, form), other))
+	}
+	return strings.Join(parts, localize(locale, "digest.separator"))
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDigestSchedule_Due(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	at := func(loc *time.Location, year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, loc)
+	}
+	daily := DigestSchedule{Frequency: DigestDaily, Time: "08:00"}
+	weekly := DigestSchedule{Frequency: DigestWeekly, Time: "08:00", Day: "monday"}
+
+	tests := []struct {
+		name     string
+		schedule DigestSchedule
+		now      time.Time
+		loc      *time.Location
+		want     time.Time
+	}{
+		{name: "daily after the time", schedule: daily, now: at(time.UTC, 2026, 10, 14, 9, 0), loc: time.UTC, want: at(time.UTC, 2026, 10, 14, 8, 0)},
+		{name: "daily at the time", schedule: daily, now: at(time.UTC, 2026, 10, 14, 8, 0), loc: time.UTC, want: at(time.UTC, 2026, 10, 14, 8, 0)},
+		{name: "daily before the time", schedule: daily, now: at(time.UTC, 2026, 10, 14, 7, 59), loc: time.UTC, want: at(time.UTC, 2026, 10, 13, 8, 0)},
+		{name: "daily in the user's zone", schedule: daily, now: at(time.UTC, 2026, 10, 14, 11, 0), loc: newYork, want: at(newYork, 2026, 10, 13, 8, 0)},
+		{name: "daily on the day clocks spring forward", schedule: daily, now: at(newYork, 2026, 3, 8, 9, 0), loc: newYork, want: at(newYork, 2026, 3, 8, 8, 0)},
+		{name: "daily the day after clocks spring forward", schedule: daily, now: at(newYork, 2026, 3, 9, 7, 0), loc: newYork, want: at(newYork, 2026, 3, 8, 8, 0)},
+		{name: "daily the day after clocks fall back", schedule: daily, now: at(newYork, 2026, 11, 2, 7, 0), loc: newYork, want: at(newYork, 2026, 11, 1, 8, 0)},
+		{name: "weekly later in the week", schedule: weekly, now: at(time.UTC, 2026, 10, 14, 12, 0), loc: time.UTC, want: at(time.UTC, 2026, 10, 12, 8, 0)},
+		{name: "weekly on the day", schedule: weekly, now: at(time.UTC, 2026, 10, 12, 8, 30), loc: time.UTC, want: at(time.UTC, 2026, 10, 12, 8, 0)},
+		{name: "weekly before the time on the day", schedule: weekly, now: at(time.UTC, 2026, 10, 12, 7, 0), loc: time.UTC, want: at(time.UTC, 2026, 10, 5, 8, 0)},
+		{name: "weekly across clocks springing forward", schedule: weekly, now: at(newYork, 2026, 3, 10, 12, 0), loc: newYork, want: at(newYork, 2026, 3, 9, 8, 0)},
+		{name: "weekly a week before clocks spring forward", schedule: DigestSchedule{Frequency: DigestWeekly, Time: "08:00", Day: "sunday"}, now: at(newYork, 2026, 3, 14, 12, 0), loc: newYork, want: at(newYork, 2026, 3, 8, 8, 0)},
+		{name: "invalid time falls back to 08:00", schedule: DigestSchedule{Frequency: DigestDaily, Time: "noon"}, now: at(time.UTC, 2026, 10, 14, 12, 0), loc: time.UTC, want: at(time.UTC, 2026, 10, 14, 8, 0)},
+		{name: "invalid day falls back to monday", schedule: DigestSchedule{Frequency: DigestWeekly, Time: "08:00", Day: "someday"}, now: at(time.UTC, 2026, 10, 14, 12, 0), loc: time.UTC, want: at(time.UTC, 2026, 10, 12, 8, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			due := tt.schedule.due(tt.now, tt.loc)
+			assert.True(t, tt.want.Equal(due), "want %s, got %s", tt.want.UTC(), due)
+			assert.Equal(t, time.UTC, due.Location())
+		})
+	}
+}
+
+func newTestScheduler(s *NotificationService) *digestScheduler {
+	cfg := DefaultDigestConfig()
+	cfg.BatchSize = 2
+	return newDigestScheduler(s, cfg, zap.NewNop())
+}
+
+func TestDigestScheduler_Lease(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	first, second := newTestScheduler(s), newTestScheduler(s)
+	now := time.Now()
+
+	held, err := first.acquireLease(ctx, now)
+	require.NoError(t, err)
+	assert.True(t, held, "a free lease is taken")
+
+	held, err = second.acquireLease(ctx, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, held, "a held lease is not taken")
+
+	held, err = first.acquireLease(ctx, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, held, "the holder renews the lease")
+
+	// Until three intervals after the last renewal
+	held, err = second.acquireLease(ctx, now.Add(4*time.Minute))
+	require.NoError(t, err)
+	assert.False(t, held)
+
+	held, err = second.acquireLease(ctx, now.Add(6*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, held, "an expired lease is taken over")
+
+	held, err = first.acquireLease(ctx, now.Add(6*time.Minute))
+	require.NoError(t, err)
+	assert.False(t, held, "the previous holder lost the lease")
+}
+
+// queueDigestItem queues a digest item for the user, created at createdAt
+func queueDigestItem(t *testing.T, s *NotificationService, userID string, createdAt time.Time) {
+	t.Helper()
+	require.NoError(t, s.db.DB().Create(&DigestItem{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Type:      "feed_liked",
+		Title:     "New like",
+		CreatedAt: createdAt,
+	}).Error)
+}
+
+func claimedDigests(t *testing.T, s *NotificationService) map[string]time.Time {
+	t.Helper()
+	var digests []Digest
+	require.NoError(t, s.db.DB().Find(&digests).Error)
+	claimed := make(map[string]time.Time, len(digests))
+	for _, d := range digests {
+		claimed[d.UserID] = d.ScheduledFor
+	}
+	return claimed
+}
+
+func TestDigestScheduler_ClaimDue(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+	scheduler := newTestScheduler(s)
+
+	// Users get a daily digest at 08:00 UTC by default
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	due := time.Date(2026, 10, 14, 8, 0, 0, 0, time.UTC)
+
+	// More users are due than fit in a batch, and those whose items are not
+	// due yet sort first
+	var dueUsers []string
+	for i := 0; i < 3; i++ {
+		userID := "0" + uuid.New().String()[1:]
+		queueDigestItem(t, s, userID, now)
+		queueDigestItem(t, s, userID, now.Add(-time.Minute))
+	}
+	for i := 0; i < 5; i++ {
+		userID := "f" + uuid.New().String()[1:]
+		queueDigestItem(t, s, userID, due.Add(-time.Hour))
+		queueDigestItem(t, s, userID, now)
+		dueUsers = append(dueUsers, userID)
+	}
+
+	require.NoError(t, scheduler.claimDue(ctx, now))
+	claimed := claimedDigests(t, s)
+	assert.Len(t, claimed, len(dueUsers))
+	for _, userID := range dueUsers {
+		if assert.Contains(t, claimed, userID) {
+			assert.True(t, due.Equal(claimed[userID]))
+		}
+	}
+
+	// Only items from before the schedule time go into the digest
+	var items int64
+	require.NoError(t, s.db.DB().Model(&DigestItem{}).Where("digest_id IS NOT NULL").Count(&items).Error)
+	assert.Equal(t, int64(len(dueUsers)), items)
+
+	// Claiming again adds nothing
+	require.NoError(t, scheduler.claimDue(ctx, now.Add(time.Minute)))
+	assert.Len(t, claimedDigests(t, s), len(dueUsers))
+
+	// The rest are claimed at the next schedule time
+	require.NoError(t, scheduler.claimDue(ctx, now.Add(24*time.Hour)))
+	var unclaimed int64
+	require.NoError(t, s.db.DB().Model(&DigestItem{}).Where("digest_id IS NULL").Count(&unclaimed).Error)
+	assert.Zero(t, unclaimed)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+	"github.com/Femi-lawal/udagram-app/pkg/database"
+	"github.com/Femi-lawal/udagram-app/pkg/mail"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+//go:embed templates/email
+var emailFS embed.FS
+
+// defaultLocale is used for users without a locale and for messages missing
+// from a locale's catalog
+const defaultLocale = "en"
+
+// defaultEmailTemplate renders notification types without a template of
+// their own
+const defaultEmailTemplate = "default"
+
+// suppressionBounce is the suppression reason for hard bounces. Complaints
+// are reported through EmailEvents.
+const suppressionBounce = "bounce"
+
+// emailCatalogs holds the email messages by locale, then by key
+var emailCatalogs = mustLoadCatalogs()
+
+// supportedLocale reports whether emails can be sent in the locale
+func supportedLocale(locale string) bool {
+	_, ok := emailCatalogs[locale]
+	return ok
+}
+
+// mustLoadCatalogs loads the embedded message catalogs, flattening nested
+// keys to dotted paths such as "welcome.subject"
+func mustLoadCatalogs() map[string]map[string]string {
+	files, err := fs.Glob(emailFS, "templates/email/locales/*.yaml")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := make(map[string]map[string]string, len(files))
+	for _, file := range files {
+		data, err := emailFS.ReadFile(file)
+		if err != nil {
+			panic(err)
+		}
+		var tree map[string]any
+		if err := yaml.Unmarshal(data, &tree); err != nil {
+			panic(fmt.Sprintf("email catalog %s: %v", file, err))
+		}
+
+		catalog := make(map[string]string)
+		flattenCatalog("", tree, catalog)
+		catalogs[strings.TrimSuffix(path.Base(file), ".yaml")] = catalog
+	}
+	return catalogs
+}
+
+func flattenCatalog(prefix string, tree map[string]any, catalog map[string]string) {
+	for key, value := range tree {
+		switch value := value.(type) {
+		case map[string]any:
+			flattenCatalog(prefix+key+".", value, catalog)
+		default:
+			catalog[prefix+key] = fmt.Sprint(value)
+		}
+	}
+}
+
+// emailData is what email templates render
+type emailData struct {
+	Notification   *Notification
+	Locale         string
+	Subject        string
+	AppURL         string
+	PreferencesURL string
+}
+
+// T returns a localized message
+func (d emailData) T(key string) string {
+	return localize(d.Locale, key)
+}
+
+// localize returns the message for key in the locale, falling back to the
+// default locale and then to the key itself
+func localize(locale, key string) string {
+	if message, ok := emailCatalogs[locale][key]; ok {
+		return message
+	}
+	if message, ok := emailCatalogs[defaultLocale][key]; ok {
+		return message
+	}
+	return key
+}
+
+// emailTemplate renders one notification type
+type emailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// emailTemplates holds the email templates by notification type
+var emailTemplates = mustLoadTemplates()
+
+// mustLoadTemplates parses the embedded templates. Each type has an HTML
+// template, rendered inside the shared layout, and a plain text one.
+func mustLoadTemplates() map[string]*emailTemplate {
+	files, err := fs.Glob(emailFS, "templates/email/*.html")
+	if err != nil {
+		panic(err)
+	}
+
+	templates := make(map[string]*emailTemplate)
+	for _, file := range files {
+		name := strings.TrimSuffix(path.Base(file), ".html")
+		if name == "layout" {
+			continue
+		}
+		templates[name] = &emailTemplate{
+			html: htmltemplate.Must(htmltemplate.ParseFS(emailFS, "templates/email/layout.html", file)),
+			text: texttemplate.Must(texttemplate.ParseFS(emailFS, "templates/email/"+name+".txt")),
+		}
+	}
+	if templates[defaultEmailTemplate] == nil {
+		panic("missing default email template")
+	}
+	return templates
+}
+
+// renderEmail renders a notification as an email in the locale
+func renderEmail(n *Notification, locale, appURL string) (*mail.Message, error) {
+	if !supportedLocale(locale) {
+		locale = defaultLocale
+	}
+
+	name := n.Type
+	tmpl, ok := emailTemplates[name]
+	if !ok {
+		name = defaultEmailTemplate
+		tmpl = emailTemplates[name]
+	}
+
+	data := emailData{
+		Notification:   n,
+		Locale:         locale,
+		AppURL:         appURL,
+		PreferencesURL: strings.TrimSuffix(appURL, "/") + "/settings/notifications",
+	}
+	data.Subject = data.T(n.Type + ".subject")
+	if data.Subject == n.Type+".subject" {
+		data.Subject = n.Title
+	}
+
+	var html, text bytes.Buffer
+	if err := tmpl.html.ExecuteTemplate(&html, "layout.html", data); err != nil {
+		return nil, fmt.Errorf("render %s email: %w", name, err)
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("render %s email: %w", name, err)
+	}
+
+	return &mail.Message{
+		Subject: data.Subject,
+		HTML:    html.String(),
+		Text:    text.String(),
+		Headers: map[string]string{
+			"List-Unsubscribe": "<" + data.PreferencesURL + ">",
+		},
+	}, nil
+}
+
+// NotificationRecipient is where a user's emails go, as learned from user
+// events
+type NotificationRecipient struct {
+	UserID    string `gorm:"primaryKey;type:uuid"`
+	Email     string `gorm:"type:varchar(255);not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TableName returns the table name for NotificationRecipient
+func (NotificationRecipient) TableName() string {
+	return "notification_recipients"
+}
+
+// EmailSuppression is an address that no longer gets email after a hard
+// bounce or a complaint
+type EmailSuppression struct {
+	Email     string `gorm:"primaryKey;type:varchar(255)"`
+	Reason    string `gorm:"type:varchar(20);not null"`
+	Detail    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TableName returns the table name for EmailSuppression
+func (EmailSuppression) TableName() string {
+	return "email_suppressions"
+}
+
+// mailer sends email; *mail.Client in production
+type mailer interface {
+	Send(ctx context.Context, msg *mail.Message) error
+}
+
+// emailNotifier sends notifications as email
+type emailNotifier struct {
+	db     *database.Client
+	mailer mailer
+	appURL string
+	logger *zap.Logger
+}
+
+func newEmailNotifier(db *database.Client, mailer mailer, appURL string, logger *zap.Logger) *emailNotifier {
+	return &emailNotifier{
+		db:     db,
+		mailer: mailer,
+		appURL: appURL,
+		logger: logger,
+	}
+}
+
+// Notify emails the notification to the user. Users without a known address
+// and suppressed addresses are undeliverable, and a hard bounce suppresses
+// the address. Other rejections, such as a wrong SMTP password, are the
+// sending side's problem and leave the address alone.
+func (e *emailNotifier) Notify(ctx context.Context, n *Notification, prefs *Preferences) error {
+	var recipient NotificationRecipient
+	if err := e.db.WithContext(ctx).First(&recipient, "user_id = ?", n.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: no email address", errUndeliverable)
+		}
+		return err
+	}
+
+	address := strings.ToLower(recipient.Email)
+	var suppression EmailSuppression
+	err := e.db.WithContext(ctx).First(&suppression, "email = ?", address).Error
+	switch {
+	case err == nil:
+		return fmt.Errorf("%w: address suppressed after %s", errUndeliverable, suppression.Reason)
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+
+	msg, err := renderEmail(n, prefs.Locale, e.appURL)
+	if err != nil {
+		return err
+	}
+	msg.To = recipient.Email
+
+	if err := e.mailer.Send(ctx, msg); err != nil {
+		switch {
+		case mail.IsBounce(err):
+			if err := suppressEmail(ctx, e.db, address, suppressionBounce, err.Error()); err != nil {
+				e.logger.Error("failed to record bounce", zap.Error(err))
+			}
+		case mail.IsPermanent(err):
+			e.logger.Error("mail server rejected email", zap.String("notification_id", n.ID), zap.Error(err))
+		}
+		return err
+	}
+	return nil
+}
+
+// saveRecipient records the user's email address
+func (s *NotificationService) saveRecipient(ctx context.Context, userID, email string) error {
+	recipient := NotificationRecipient{
+		UserID: userID,
+		Email:  email,
+	}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"email", "updated_at"}),
+	}).Create(&recipient).Error
+}
+
+// suppressEmail stops email to an address
+func suppressEmail(ctx context.Context, db *database.Client, address, reason, detail string) error {
+	suppression := EmailSuppression{
+		Email:  strings.ToLower(address),
+		Reason: reason,
+		Detail: detail,
+	}
+	return db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "email"}},
+		DoUpdates: clause.AssignmentColumns([]string{"reason", "detail", "updated_at"}),
+	}).Create(&suppression).Error
+}
+
+// EmailEvents records bounces and complaints reported by the mail provider.
+// Requests must carry the shared webhook secret.
+func (s *NotificationService) EmailEvents(secret string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Webhook-Secret")), []byte(secret)) != 1 {
+			common.UnauthorizedResponse(c, "invalid webhook secret")
+			return
+		}
+
+		var req struct {
+			Type   string `json:"type" binding:"required,oneof=bounce complaint"`
+			Email  string `json:"email" binding:"required,email,max=255"`
+			Detail string `json:"detail" binding:"max=1000"`
+		}
+		if !middleware.BindJSON(c, &req) {
+			return
+		}
+
+		if err := suppressEmail(c.Request.Context(), s.db, req.Email, req.Type, req.Detail); err != nil {
+			s.logger.Error("failed to record email event", zap.Error(err))
+			common.ErrorResponse(c, common.ErrInternalServer)
+			return
+		}
+
+		s.logger.Info("email address suppressed",
+			zap.String("reason", req.Type),
+		)
+		common.NoContentResponse(c)
+	}
+}
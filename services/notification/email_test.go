@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/mail"
+)
+
+func TestRenderEmail_Verification(t *testing.T) {
+	n := &Notification{
+		Type:  "verification",
+		Title: "Verify your email address",
+		Link:  "https://udagram.example.com/api/v1/auth/verify-email?token=abc&x=1",
+	}
+
+	for _, locale := range []string{"en", "es", "fr"} {
+		t.Run(locale, func(t *testing.T) {
+			msg, err := renderEmail(n, locale, "https://udagram.example.com")
+			require.NoError(t, err)
+
+			assert.Equal(t, localize(locale, "verification.subject"), msg.Subject)
+			assert.Contains(t, msg.HTML, `href="https://udagram.example.com/api/v1/auth/verify-email?token=abc&amp;x=1"`)
+			assert.Contains(t, msg.HTML, template.HTMLEscapeString(localize(locale, "verification.action")))
+			assert.Contains(t, msg.Text, localize(locale, "verification.action")+": "+n.Link)
+		})
+	}
+}
+
+// failingMailer rejects every message with err
+type failingMailer struct {
+	err error
+}
+
+func (m failingMailer) Send(ctx context.Context, msg *mail.Message) error {
+	return m.err
+}
+
+func TestEmailNotifier_OnlyBouncesSuppress(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		suppressed bool
+	}{
+		{"recipient rejected", &mail.Error{Stage: mail.StageRcpt, Code: 550, Message: "no such user"}, true},
+		{"recipient deferred", &mail.Error{Stage: mail.StageRcpt, Code: 450, Message: "mailbox busy"}, false},
+		{"wrong password", &mail.Error{Stage: mail.StageAuth, Code: 535, Message: "authentication failed"}, false},
+		{"sender rejected", &mail.Error{Stage: mail.StageMail, Code: 550, Message: "sender not allowed"}, false},
+		{"message rejected", &mail.Error{Stage: mail.StageData, Code: 554, Message: "message refused"}, false},
+		{"connection failed", errors.New("connection refused"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t)
+			userID := uuid.New().String()
+			require.NoError(t, s.saveRecipient(context.Background(), userID, "jane@example.com"))
+
+			notifier := newEmailNotifier(s.db, failingMailer{err: tt.err}, "https://udagram.example.com", zap.NewNop())
+			n := &Notification{ID: uuid.New().String(), UserID: userID, Type: "welcome", Title: "Welcome"}
+			err := notifier.Notify(context.Background(), n, &Preferences{Locale: "en"})
+			assert.ErrorIs(t, err, tt.err)
+
+			var count int64
+			require.NoError(t, s.db.DB().Model(&EmailSuppression{}).Where("email = ?", "jane@example.com").Count(&count).Error)
+			if tt.suppressed {
+				assert.Equal(t, int64(1), count)
+			} else {
+				assert.Zero(t, count)
+			}
+		})
+	}
+}
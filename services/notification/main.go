@@ -6,25 +6,53 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 
 	"github.com/Femi-lawal/udagram-app/pkg/cache"
 	"github.com/Femi-lawal/udagram-app/pkg/common"
+	"github.com/Femi-lawal/udagram-app/pkg/database"
+	"github.com/Femi-lawal/udagram-app/pkg/mail"
 	"github.com/Femi-lawal/udagram-app/pkg/messaging"
 	"github.com/Femi-lawal/udagram-app/pkg/middleware"
 	"github.com/Femi-lawal/udagram-app/pkg/telemetry"
+	"github.com/Femi-lawal/udagram-app/pkg/webpush"
 )
 
 // NotificationService handles notification processing
 type NotificationService struct {
+	db       *database.Client
 	cache    *cache.Client
 	producer *messaging.Producer
+	hub      *streamHub
 	logger   *zap.Logger
+
+	// notifiers deliver on channels other than in-app, by channel
+	notifiers map[string]Notifier
+	// pushKey is the VAPID public key, when Web Push is configured
+	pushKey string
+	// pushHosts are the push services subscriptions may point at
+	pushHosts []string
+
+	aggregation AggregationConfig
+
+	send        SendConfig
+	sendLimiter *middleware.UserRateLimiter
+
+	// deadLetters are the consumers' dead-letter queues, by consumed topic
+	deadLetters map[string]*messaging.DeadLetterQueue
+
+	streamConfig StreamConfig
+	// streams is canceled on shutdown to end open streams
+	streams context.Context
 }
 
 func main() {
@@ -47,7 +75,39 @@ func main() {
 		logger.Warn("failed to initialize telemetry", zap.Error(err))
 	}
 
-	// Initialize Redis
+	// Initialize database
+	db, err := database.NewClient(database.Config{
+		Host:            getEnv("POSTGRES_HOST", "localhost"),
+		Port:            getEnvInt("POSTGRES_PORT", 5432),
+		User:            getEnv("POSTGRES_USER", "udagram"),
+		Password:        getEnv("POSTGRES_PASSWORD", ""),
+		DBName:          getEnv("POSTGRES_DB", "udagram"),
+		SSLMode:         getEnv("POSTGRES_SSLMODE", "disable"),
+		MaxIdleConns:    10,
+		MaxOpenConns:    50,
+		ConnMaxLifetime: 30 * time.Minute,
+	}, logger)
+	if err != nil {
+		logger.Fatal("failed to connect to database", zap.Error(err))
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Error("failed to close database", zap.Error(err))
+		}
+	}()
+
+	// Run migrations
+	if err := db.Migrate(
+		&Notification{}, &NotificationSettings{}, &NotificationPreference{},
+		&NotificationRecipient{}, &EmailSuppression{}, &PushSubscription{},
+		&DigestItem{}, &Digest{}, &SchedulerLease{},
+		&ProcessedEvent{}, &NotificationActor{}, &AuditLog{},
+		&messaging.OutboxMessage{},
+	); err != nil {
+		logger.Fatal("failed to run migrations", zap.Error(err))
+	}
+
+	// Initialize Redis, used to cache unread counts and fan out streams
 	redisClient, err := cache.NewClient(cache.Config{
 		Host:         getEnv("REDIS_HOST", "localhost"),
 		Port:         getEnvInt("REDIS_PORT", 6379),
@@ -63,53 +123,198 @@ func main() {
 		logger.Warn("failed to connect to Redis", zap.Error(err))
 	}
 
-	// Initialize Kafka producer
-	var producer *messaging.Producer
+	// Initialize Kafka producer. MESSAGING_BROKER=memory keeps events in
+	// this process instead, which only suits running the service on its own:
+	// other services never see its events, nor it theirs.
 	kafkaBrokers := getEnv("KAFKA_BROKERS", "localhost:9092")
-	if kafkaBrokers != "" {
-		producer = messaging.NewProducer(messaging.Config{
-			Brokers: []string{kafkaBrokers},
-		}, logger)
+	messagingConfig := messaging.Config{
+		Brokers: []string{kafkaBrokers},
+	}
+	if getEnv("MESSAGING_BROKER", "kafka") == "memory" {
+		messagingConfig.Broker = messaging.NewMemoryBroker(1)
+		logger.Warn("using the in-memory message broker: events are not exchanged with other services")
+	}
+
+	var producer *messaging.Producer
+	if kafkaBrokers != "" || messagingConfig.Broker != nil {
+		producer = messaging.NewProducer(messagingConfig, logger)
+	}
+
+	// Sent notifications are written to the outbox with their audit records
+	// and published from there
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	if producer != nil {
+		outboxConfig := messaging.DefaultOutboxConfig()
+		outboxConfig.Interval = getEnvDuration("OUTBOX_INTERVAL", outboxConfig.Interval)
+		outboxConfig.BatchSize = getEnvInt("OUTBOX_BATCH_SIZE", outboxConfig.BatchSize)
+		go messaging.NewOutboxRelay(db, producer, outboxConfig, logger).Run(relayCtx)
 	}
 
+	// Real-time delivery, fanned out across replicas through Redis
+	streamConfig := DefaultStreamConfig()
+	streamConfig.Heartbeat = getEnvDuration("STREAM_HEARTBEAT_INTERVAL", streamConfig.Heartbeat)
+	streamConfig.MaxConnectionsPerUser = getEnvInt("STREAM_MAX_CONNECTIONS_PER_USER", streamConfig.MaxConnectionsPerUser)
+	streamConfig.ResumeLimit = getEnvInt("STREAM_RESUME_LIMIT", streamConfig.ResumeLimit)
+
+	var rdb *redis.Client
+	if redisClient != nil {
+		rdb = redisClient.Redis()
+	}
+	hub := newStreamHub(rdb, streamConfig, logger)
+
+	streamCtx, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
+	hub.Start(streamCtx)
+	defer hub.Close()
+
+	// Events about the same thing fold into one notification, and
+	// redelivered events are skipped
+	aggregation := DefaultAggregationConfig()
+	aggregation.Window = getEnvDuration("AGGREGATION_WINDOW", aggregation.Window)
+	aggregation.EventRetention = getEnvDuration("EVENT_DEDUP_RETENTION", aggregation.EventRetention)
+
+	// Only admins and internal services send notifications through the API
+	sendConfig := DefaultSendConfig()
+	if ids := getEnv("NOTIFICATION_ADMIN_USER_IDS", ""); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				sendConfig.AdminUserIDs = append(sendConfig.AdminUserIDs, id)
+			}
+		}
+	}
+	sendConfig.ServiceTokens, err = parseServiceTokens(getEnv("INTERNAL_API_TOKENS", ""))
+	if err != nil {
+		logger.Fatal("invalid INTERNAL_API_TOKENS", zap.Error(err))
+	}
+	sendConfig.RateLimit = getEnvFloat("SEND_RATE_LIMIT", sendConfig.RateLimit)
+	sendConfig.Burst = getEnvInt("SEND_RATE_BURST", sendConfig.Burst)
+
+	sendLimiter := middleware.NewUserRateLimiter(sendConfig.RateLimit, sendConfig.Burst, time.Minute)
+	defer sendLimiter.Stop()
+
 	// Create notification service
 	notificationService := &NotificationService{
-		cache:    redisClient,
-		producer: producer,
-		logger:   logger,
+		db:           db,
+		cache:        redisClient,
+		producer:     producer,
+		hub:          hub,
+		logger:       logger,
+		streamConfig: streamConfig,
+		streams:      streamCtx,
+		notifiers:    make(map[string]Notifier),
+		aggregation:  aggregation,
+		send:         sendConfig,
+		sendLimiter:  sendLimiter,
+		deadLetters:  make(map[string]*messaging.DeadLetterQueue),
+	}
+
+	appURL := getEnv("APP_URL", "http://localhost:4200")
+
+	// Email is sent when an SMTP server is configured
+	if smtpHost := getEnv("SMTP_HOST", ""); smtpHost != "" {
+		mailConfig := mail.DefaultConfig()
+		mailConfig.Host = smtpHost
+		mailConfig.Port = getEnvInt("SMTP_PORT", mailConfig.Port)
+		mailConfig.Username = getEnv("SMTP_USERNAME", "")
+		mailConfig.Password = getEnv("SMTP_PASSWORD", "")
+		mailConfig.From = getEnv("SMTP_FROM", mailConfig.From)
+		mailConfig.RequireTLS = getEnv("SMTP_REQUIRE_TLS", "false") == "true"
+
+		mailer, err := mail.NewClient(mailConfig, logger)
+		if err != nil {
+			logger.Fatal("invalid SMTP configuration", zap.Error(err))
+		}
+		notificationService.notifiers[ChannelEmail] = newEmailNotifier(db, mailer, appURL, logger)
+	}
+
+	// Web Push is sent when a VAPID key is configured, and only to known
+	// push services
+	notificationService.pushHosts = defaultPushHosts
+	if hosts := getEnv("PUSH_SERVICE_HOSTS", ""); hosts != "" {
+		notificationService.pushHosts = nil
+		for _, host := range strings.Split(hosts, ",") {
+			if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+				notificationService.pushHosts = append(notificationService.pushHosts, host)
+			}
+		}
+	}
+	if vapidKey := getEnv("VAPID_PRIVATE_KEY", ""); vapidKey != "" {
+		pushConfig := webpush.DefaultConfig()
+		pushConfig.PrivateKey = vapidKey
+		pushConfig.Subject = getEnv("VAPID_SUBJECT", "")
+		pushConfig.TTL = getEnvDuration("PUSH_TTL", pushConfig.TTL)
+
+		pushClient, err := webpush.NewClient(pushConfig)
+		if err != nil {
+			logger.Fatal("invalid Web Push configuration", zap.Error(err))
+		}
+		notificationService.notifiers[ChannelPush] = newPushNotifier(db, pushClient, appURL, notificationService.pushHosts, logger)
+		notificationService.pushKey = pushClient.PublicKey()
 	}
 
 	// Start Kafka consumers
 	consumerCtx, cancelConsumers := context.WithCancel(context.Background())
 	defer cancelConsumers()
 
-	// User created consumer
-	go func() {
-		consumer := messaging.NewConsumer(
-			messaging.Config{Brokers: []string{kafkaBrokers}},
-			messaging.TopicUserCreated,
-			"notification-group",
-			logger,
-			notificationService.handleUserCreated,
-		)
-		if err := consumer.Start(consumerCtx); err != nil && err != context.Canceled {
-			logger.Error("user created consumer error", zap.Error(err))
-		}
-	}()
+	// Digests batch notifications for users who chose them over instant
+	// delivery
+	notificationService.notifiers[ChannelDigest] = &digestNotifier{db: db}
+
+	digestConfig := DefaultDigestConfig()
+	digestConfig.Interval = getEnvDuration("DIGEST_INTERVAL", digestConfig.Interval)
+	digestConfig.BatchSize = getEnvInt("DIGEST_BATCH_SIZE", digestConfig.BatchSize)
+	go newDigestScheduler(notificationService, digestConfig, logger).Run(consumerCtx)
+
+	go notificationService.pruneEvents(consumerCtx)
+
+	const consumerGroup = "notification-group"
+
+	// Events that fail are retried in-process, then again after each retry
+	// delay, and finally kept in a dead-letter topic to inspect and replay
+	consumerConfig := messagingConfig
+	consumerConfig.Retry = messaging.DefaultRetryConfig()
+	consumerConfig.Retry.Attempts = getEnvInt("KAFKA_HANDLER_ATTEMPTS", consumerConfig.Retry.Attempts)
+	consumerConfig.Retry.Delays = getEnvDurations("KAFKA_RETRY_DELAYS", consumerConfig.Retry.Delays)
+	// Events with different keys are handled concurrently, those with the same key in order
+	consumerConfig.Concurrency = getEnvInt("KAFKA_CONSUMER_CONCURRENCY", 4)
+	consumerConfig.MaxInFlight = getEnvInt("KAFKA_MAX_IN_FLIGHT", 0)
+
+	handlers := map[string]messaging.MessageHandler{
+		messaging.TopicUserCreated:               messaging.Handle(notificationService.handleUserCreated),
+		messaging.TopicUserVerificationRequested: messaging.Handle(notificationService.handleVerificationRequested),
+		messaging.TopicFeedCreated:               messaging.Handle(notificationService.handleFeedCreated),
+		// Notification requests
+		messaging.TopicNotification: messaging.Handle(notificationService.handleNotification),
+	}
 
-	// Feed created consumer
-	go func() {
-		consumer := messaging.NewConsumer(
-			messaging.Config{Brokers: []string{kafkaBrokers}},
-			messaging.TopicFeedCreated,
-			"notification-group",
-			logger,
-			notificationService.handleFeedCreated,
-		)
-		if err := consumer.Start(consumerCtx); err != nil && err != context.Canceled {
-			logger.Error("feed created consumer error", zap.Error(err))
+	// Redelivered events are skipped. Without Redis they still are once
+	// stored, but may repeat the steps before.
+	var idempotency messaging.IdempotencyStore
+	if rdb != nil {
+		idempotencyConfig := messaging.DefaultIdempotencyConfig()
+		idempotencyConfig.TTL = getEnvDuration("KAFKA_DEDUP_TTL", idempotencyConfig.TTL)
+		idempotency = messaging.NewRedisIdempotencyStore(rdb, idempotencyConfig)
+	}
+
+	for topic, handler := range handlers {
+		if idempotency != nil {
+			handler = messaging.Idempotent(idempotency, consumerGroup, logger, handler)
 		}
-	}()
+		consumer := messaging.NewConsumer(consumerConfig, topic, consumerGroup, logger, handler)
+		notificationService.deadLetters[consumer.Topic()] = consumer.DeadLetters()
+
+		go func() {
+			defer func() {
+				if err := consumer.Close(); err != nil {
+					logger.Error("failed to close consumer", zap.String("topic", consumer.Topic()), zap.Error(err))
+				}
+			}()
+			if err := consumer.Start(consumerCtx); err != nil && err != context.Canceled {
+				logger.Error("consumer error", zap.String("topic", consumer.Topic()), zap.Error(err))
+			}
+		}()
+	}
 
 	// Setup router
 	if os.Getenv("ENVIRONMENT") == "production" {
@@ -119,21 +324,66 @@ func main() {
 	router := gin.New()
 	router.Use(gin.Recovery())
 	router.Use(middleware.RequestIDMiddleware())
+	router.Use(middleware.TracingMiddleware())
 	router.Use(middleware.LoggerMiddleware(logger))
 	router.Use(middleware.MetricsMiddleware())
 
+	compression := middleware.DefaultCompressionConfig()
+	compression.MinSize = getEnvInt("COMPRESSION_MIN_BYTES", compression.MinSize)
+	router.Use(middleware.CompressionMiddleware(compression))
+
+	router.Use(middleware.TimeoutMiddleware(middleware.TimeoutConfig{
+		Timeout: getEnvDuration("REQUEST_TIMEOUT", middleware.DefaultTimeoutConfig().Timeout),
+		Routes: map[string]time.Duration{
+			"GET /api/v1/notifications/stream": 0,
+		},
+	}))
+
+	bodyLimit := middleware.DefaultBodyLimitConfig()
+	bodyLimit.MaxBytes = int64(getEnvInt("MAX_BODY_BYTES", int(bodyLimit.MaxBytes)))
+	router.Use(middleware.BodyLimitMiddleware(bodyLimit))
+
 	// Health endpoints
 	router.GET("/health", middleware.HealthCheck())
 	router.GET("/ready", middleware.ReadinessCheck())
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/routes", middleware.RoutesHandler(router))
 
 	// Notification routes
 	api := router.Group("/api/v1/notifications")
 	{
 		api.GET("", notificationService.GetNotifications)
+		api.GET("/stream", notificationService.StreamNotifications)
+		api.GET("/unread-count", notificationService.GetUnreadCount)
+		api.GET("/preferences", notificationService.GetPreferences)
+		api.PUT("/preferences", notificationService.UpdatePreferences)
+		api.GET("/push/key", notificationService.GetPushKey)
+		api.GET("/push/subscriptions", notificationService.GetPushSubscriptions)
+		api.POST("/push/subscriptions", notificationService.RegisterPushSubscription)
+		api.DELETE("/push/subscriptions/:id", notificationService.DeletePushSubscription)
+		api.POST("/read-all", notificationService.MarkAllRead)
+		api.PUT("/:id/read", notificationService.MarkRead)
+		api.DELETE("/:id", notificationService.DeleteNotification)
 		api.POST("/send", notificationService.SendNotification)
 	}
 
+	// Bounce and complaint webhooks from the mail provider; not routed by the
+	// gateway
+	if secret := getEnv("EMAIL_WEBHOOK_SECRET", ""); secret != "" {
+		router.POST("/internal/email/events", notificationService.EmailEvents(secret))
+	}
+
+	// Dead-letter inspection and replay for internal services; not routed by
+	// the gateway
+	if len(sendConfig.ServiceTokens) > 0 {
+		internal := router.Group("/internal/dead-letters", notificationService.requireInternalService())
+		{
+			internal.GET("", notificationService.ListDeadLetterQueues)
+			internal.GET("/:topic", notificationService.GetDeadLetters)
+			internal.POST("/:topic/replay", notificationService.ReplayDeadLetter)
+		}
+	}
+
 	// Start server
 	port := getEnvInt("PORT", 8083)
 	srv := &http.Server{
@@ -143,6 +393,8 @@ func main() {
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	// Open streams would otherwise hold up a graceful shutdown
+	srv.RegisterOnShutdown(stopStreams)
 
 	go func() {
 		logger.Info("starting notification service", zap.Int("port", port))
@@ -176,96 +428,103 @@ func main() {
 	logger.Info("server exited")
 }
 
-func (s *NotificationService) handleUserCreated(ctx context.Context, event messaging.Event) error {
+func (s *NotificationService) handleUserCreated(ctx context.Context, event messaging.Event, user messaging.UserCreated) error {
 	s.logger.Info("handling user created event",
 		zap.String("event_id", event.ID),
-		zap.Any("data", event.Data),
+		zap.String("user_id", user.UserID),
+		zap.String("request_id", event.Metadata[messaging.MetadataRequestID]),
+		zap.String("trace_id", telemetry.TraceID(ctx)),
 	)
 
 	// Send welcome notification
-	userID, ok := event.Data["user_id"].(string)
-	if !ok {
-		return fmt.Errorf("invalid user_id in event")
-	}
-
-	notification := map[string]interface{}{
-		"type":    "welcome",
-		"user_id": userID,
-		"message": "Welcome to Udagram! Start sharing your moments.",
-		"sent_at": time.Now().UTC(),
+	if user.UserID == "" {
+		return messaging.Permanent(fmt.Errorf("invalid user_id in event"))
 	}
 
-	// Store notification in Redis
-	if s.cache != nil {
-		key := fmt.Sprintf("notifications:%s", userID)
-		if err := s.cache.LPush(ctx, key, notification); err != nil {
-			s.logger.Error("failed to push notification", zap.Error(err))
+	if user.Email != "" {
+		if err := s.saveRecipient(ctx, user.UserID, user.Email); err != nil {
+			return fmt.Errorf("save recipient: %w", err)
 		}
 	}
 
-	return nil
+	return s.deliver(ctx, &Notification{
+		UserID:  user.UserID,
+		Type:    TypeWelcome,
+		Title:   "Welcome to Udagram",
+		Message: "Welcome to Udagram! Start sharing your moments.",
+	}, origin{EventID: event.ID})
 }
 
-func (s *NotificationService) handleFeedCreated(ctx context.Context, event messaging.Event) error {
-	s.logger.Info("handling feed created event",
-		zap.String("event_id", event.ID),
-		zap.Any("data", event.Data),
-	)
+// handleVerificationRequested sends the user their email verification link
+func (s *NotificationService) handleVerificationRequested(ctx context.Context, event messaging.Event, req messaging.UserVerificationRequested) error {
+	if req.UserID == "" || req.Email == "" || req.URL == "" {
+		return messaging.Permanent(fmt.Errorf("invalid verification event"))
+	}
 
-	// In a real app, notify followers
-	// For now, just log the event
-	return nil
+	// Verification goes to the address being verified
+	if err := s.saveRecipient(ctx, req.UserID, req.Email); err != nil {
+		return fmt.Errorf("save recipient: %w", err)
+	}
+
+	return s.deliver(ctx, &Notification{
+		UserID: req.UserID,
+		Type:   TypeVerification,
+		Title:  "Verify your email address",
+		Link:   req.URL,
+	}, origin{EventID: event.ID})
 }
 
-// GetNotifications returns user notifications
-func (s *NotificationService) GetNotifications(c *gin.Context) {
-	userID := c.GetHeader("X-User-ID")
-	if userID == "" {
-		common.UnauthorizedResponse(c, "user not authenticated")
-		return
+// handleNotification delivers a requested notification
+func (s *NotificationService) handleNotification(ctx context.Context, event messaging.Event, req messaging.NotificationRequested) error {
+	if req.UserID == "" || !knownType(req.Type) {
+		return messaging.Permanent(fmt.Errorf("invalid notification event"))
 	}
-
-	if s.cache == nil {
-		common.SuccessResponse(c, []interface{}{})
-		return
+	if !optionalUUID(req.ReferenceID) || !optionalUUID(req.ActorID) {
+		return messaging.Permanent(fmt.Errorf("invalid reference_id or actor_id in notification event"))
+	}
+	title := req.Title
+	if title == "" {
+		title = defaultTitle
 	}
 
-	key := fmt.Sprintf("notifications:%s", userID)
-	notifications, err := s.cache.LRange(c.Request.Context(), key, 0, 50)
-	if err != nil {
-		s.logger.Error("failed to get notifications", zap.Error(err))
-		common.ErrorResponse(c, common.ErrInternalServer)
-		return
+	n := &Notification{
+		UserID:        req.UserID,
+		Type:          req.Type,
+		Title:         title,
+		Message:       req.Message,
+		ReferenceType: req.ReferenceType,
+	}
+	if req.ReferenceID != "" {
+		n.ReferenceID = &req.ReferenceID
 	}
 
-	common.SuccessResponse(c, notifications)
+	return s.deliver(ctx, n, origin{
+		EventID:   event.ID,
+		ActorID:   req.ActorID,
+		ActorName: req.ActorName,
+		Action:    req.Action,
+	})
 }
 
-// SendNotification sends a notification
-func (s *NotificationService) SendNotification(c *gin.Context) {
-	var req struct {
-		UserID  string `json:"user_id" binding:"required"`
-		Type    string `json:"type" binding:"required"`
-		Message string `json:"message" binding:"required"`
-	}
-
-	if err := c.ShouldBindJSON(&req); err != nil {
-		common.BadRequestResponse(c, "invalid request body")
-		return
+// optionalUUID reports whether value is empty or a UUID
+func optionalUUID(value string) bool {
+	if value == "" {
+		return true
 	}
+	_, err := uuid.Parse(value)
+	return err == nil
+}
 
-	if s.producer != nil {
-		event := messaging.NewEvent("notification", "notification-service", map[string]interface{}{
-			"user_id": req.UserID,
-			"type":    req.Type,
-			"message": req.Message,
-		})
-		s.producer.PublishAsync(c.Request.Context(), messaging.TopicNotification, req.UserID, event)
-	}
+func (s *NotificationService) handleFeedCreated(ctx context.Context, event messaging.Event, feed messaging.FeedCreated) error {
+	s.logger.Info("handling feed created event",
+		zap.String("event_id", event.ID),
+		zap.String("feed_id", feed.FeedID),
+		zap.String("user_id", feed.UserID),
+	)
 
-	common.SuccessResponse(c, gin.H{
-		"status": "sent",
-	})
+	// In a real app, notify followers
+	// For now, just log the event
+	return nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -285,3 +544,47 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		result, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return defaultValue
+		}
+		return result
+	}
+	return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		result, err := time.ParseDuration(value)
+		if err != nil {
+			return defaultValue
+		}
+		return result
+	}
+	return defaultValue
+}
+
+// getEnvDurations parses a comma-separated list of durations, where "none" is
+// an empty list
+func getEnvDurations(key string, defaultValue []time.Duration) []time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	if value == "none" {
+		return nil
+	}
+
+	var result []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || d <= 0 {
+			return defaultValue
+		}
+		result = append(result, d)
+	}
+	return result
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/database/databasetest"
+	"github.com/Femi-lawal/udagram-app/pkg/messaging"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestService returns a notification service backed by a test database,
+// without Redis, Kafka or any notifier configured
+func newTestService(t *testing.T) *NotificationService {
+	t.Helper()
+
+	db := databasetest.New(t,
+		&Notification{}, &NotificationSettings{}, &NotificationPreference{},
+		&NotificationRecipient{}, &EmailSuppression{}, &PushSubscription{},
+		&DigestItem{}, &Digest{}, &SchedulerLease{},
+		&ProcessedEvent{}, &NotificationActor{}, &AuditLog{},
+		&messaging.OutboxMessage{},
+	)
+
+	streams, stopStreams := context.WithCancel(context.Background())
+	t.Cleanup(stopStreams)
+
+	send := DefaultSendConfig()
+	limiter := middleware.NewUserRateLimiter(send.RateLimit, send.Burst, time.Minute)
+	t.Cleanup(limiter.Stop)
+
+	logger := zap.NewNop()
+	return &NotificationService{
+		db:           db,
+		hub:          newStreamHub(nil, DefaultStreamConfig(), logger),
+		logger:       logger,
+		streamConfig: DefaultStreamConfig(),
+		streams:      streams,
+		notifiers:    make(map[string]Notifier),
+		pushHosts:    defaultPushHosts,
+		aggregation:  DefaultAggregationConfig(),
+		send:         send,
+		sendLimiter:  limiter,
+		deadLetters:  make(map[string]*messaging.DeadLetterQueue),
+	}
+}
+
+func TestHandleVerificationRequested(t *testing.T) {
+	s := newTestService(t)
+	email := &recordingNotifier{}
+	s.notifiers[ChannelEmail] = email
+
+	// Verification is sent during quiet hours too
+	userID := uuid.New().String()
+	setQuietHours(t, s, userID, true)
+
+	req := messaging.UserVerificationRequested{
+		UserID: userID,
+		Email:  "jane@example.com",
+		URL:    "https://udagram.example.com/api/v1/auth/verify-email?token=abc",
+	}
+	event, err := messaging.NewEvent("auth-service", req)
+	require.NoError(t, err)
+	require.NoError(t, s.handleVerificationRequested(context.Background(), event, req))
+
+	sent := email.notifications()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "verification", sent[0].Type)
+	assert.Equal(t, req.URL, sent[0].Link)
+
+	var recipient NotificationRecipient
+	require.NoError(t, s.db.DB().First(&recipient, "user_id = ?", userID).Error)
+	assert.Equal(t, req.Email, recipient.Email)
+
+	// The link is only emailed
+	var stored int64
+	require.NoError(t, s.db.DB().Model(&Notification{}).Count(&stored).Error)
+	assert.Zero(t, stored)
+
+	// A redelivered event is not sent again
+	require.NoError(t, s.handleVerificationRequested(context.Background(), event, req))
+	assert.Len(t, email.notifications(), 1)
+}
+
+func TestHandleVerificationRequested_Invalid(t *testing.T) {
+	s := newTestService(t)
+
+	req := messaging.UserVerificationRequested{UserID: uuid.New().String(), Email: "jane@example.com"}
+	event, err := messaging.NewEvent("auth-service", req)
+	require.NoError(t, err)
+
+	err = s.handleVerificationRequested(context.Background(), event, req)
+	assert.True(t, messaging.IsPermanent(err))
+}
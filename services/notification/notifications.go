@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+)
+
+// Notification statuses
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusRead    = "read"
+	StatusFailed  = "failed"
+)
+
+// notificationStatuses are all notification statuses. The notifications
+// table's chk_notifications_status constraint allows exactly these.
+var notificationStatuses = []string{StatusPending, StatusSent, StatusRead, StatusFailed}
+
+// statusUnread filters on every status but read
+const statusUnread = "unread"
+
+// Page sizes for listing notifications
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// defaultTitle is used for notifications sent without a title
+const defaultTitle = "New notification"
+
+// unreadCountTTL bounds how stale a cached unread count can get if an
+// invalidation is lost
+const unreadCountTTL = 10 * time.Minute
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// Notification is a message for a user
+type Notification struct {
+	ID            string     `gorm:"primaryKey;type:uuid;index:idx_notifications_user_cursor,priority:3,sort:desc" json:"id"`
+	UserID        string     `gorm:"type:uuid;not null;index:idx_notifications_user_cursor,priority:1;index:idx_notifications_group,priority:1" json:"user_id"`
+	Type          string     `gorm:"type:varchar(50);not null;check:chk_notifications_type,type IN ('welcome', 'verification', 'digest', 'feed_created', 'feed_liked', 'feed_commented', 'user_followed', 'user_mentioned', 'system_announcement')" json:"type"`
+	Title         string     `gorm:"type:varchar(255);not null" json:"title"`
+	Message       string     `json:"message"`
+	Status        string     `gorm:"type:varchar(20);not null;default:pending;check:chk_notifications_status,status IN ('pending', 'sent', 'read', 'failed')" json:"status"`
+	ReadAt        *time.Time `json:"read_at,omitempty"`
+	SentAt        *time.Time `json:"sent_at,omitempty"`
+	ReferenceID   *string    `gorm:"type:uuid" json:"reference_id,omitempty"`
+	ReferenceType string     `gorm:"type:varchar(50)" json:"reference_type,omitempty"`
+	GroupKey      string     `gorm:"type:varchar(255);index:idx_notifications_group,priority:2" json:"-"`
+	ActorCount    int        `gorm:"not null;default:1" json:"actor_count"`
+	CreatedAt     time.Time  `gorm:"index:idx_notifications_user_cursor,priority:2,sort:desc" json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+
+	// Link is where an email's action leads, such as a verification link.
+	// It may hold a secret, so it is neither stored nor streamed.
+	Link string `gorm:"-" json:"-"`
+}
+
+// TableName returns the table name for Notification
+func (Notification) TableName() string {
+	return "notifications"
+}
+
+// NotificationPage is one page of a user's notifications, newest first
+type NotificationPage struct {
+	Items      []Notification `json:"items"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// notificationFilter narrows a listing
+type notificationFilter struct {
+	Type   string
+	Status string
+}
+
+// encodeCursor returns an opaque cursor that sorts at n. Notifications are
+// ordered by creation time, then ID.
+func encodeCursor(n Notification) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(n.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + n.ID))
+}
+
+// decodeCursor parses a cursor from encodeCursor
+func decodeCursor(cursor string) (time.Time, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", errInvalidCursor
+	}
+
+	createdAt, id, ok := strings.Cut(string(data), "|")
+	if !ok {
+		return time.Time{}, "", errInvalidCursor
+	}
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return time.Time{}, "", errInvalidCursor
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return time.Time{}, "", errInvalidCursor
+	}
+	return t, id, nil
+}
+
+// create stores a new notification as sent
+func create(tx *gorm.DB, n *Notification) error {
+	// Postgres keeps microseconds; truncate so cursors round-trip exactly
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	n.ID = uuid.New().String()
+	n.Status = StatusSent
+	n.SentAt = &now
+	n.ActorCount = 1
+	n.CreatedAt = now
+	n.UpdatedAt = now
+
+	return tx.Create(n).Error
+}
+
+// listNotifications returns a page of the user's notifications after cursor
+func (s *NotificationService) listNotifications(ctx context.Context, userID string, filter notificationFilter, cursor string, limit int) (*NotificationPage, error) {
+	query := s.db.WithContext(ctx).Where("user_id = ?", userID)
+
+	if filter.Type != "" {
+		query = query.Where("type = ?", filter.Type)
+	}
+	switch filter.Status {
+	case "":
+	case statusUnread:
+		query = query.Where("status <> ?", StatusRead)
+	default:
+		query = query.Where("status = ?", filter.Status)
+	}
+
+	if cursor != "" {
+		createdAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("(created_at, id) < (?, ?)", createdAt, id)
+	}
+
+	// Fetch one extra to know whether there is a next page
+	var items []Notification
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&items).Error; err != nil {
+		return nil, err
+	}
+
+	page := &NotificationPage{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = encodeCursor(page.Items[limit-1])
+	}
+	return page, nil
+}
+
+// notificationsSince returns up to limit of the user's notifications after
+// cursor, oldest first
+func (s *NotificationService) notificationsSince(ctx context.Context, userID, cursor string, limit int) ([]Notification, error) {
+	createdAt, id, err := decodeCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []Notification
+	err = s.db.WithContext(ctx).
+		Where("user_id = ? AND (created_at, id) > (?, ?)", userID, createdAt, id).
+		Order("created_at, id").
+		Limit(limit).
+		Find(&items).Error
+	return items, err
+}
+
+// unreadCount returns the number of unread notifications, cached in Redis
+func (s *NotificationService) unreadCount(ctx context.Context, userID string) (int64, error) {
+	key := unreadCountKey(userID)
+	if s.cache != nil {
+		if cached, err := s.cache.Get(ctx, key); err == nil && cached != "" {
+			if count, err := strconv.ParseInt(cached, 10, 64); err == nil {
+				return count, nil
+			}
+		}
+	}
+
+	var count int64
+	err := s.db.WithContext(ctx).Model(&Notification{}).
+		Where("user_id = ? AND status <> ?", userID, StatusRead).
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	if s.cache != nil {
+		if err := s.cache.Set(ctx, key, count, unreadCountTTL); err != nil {
+			s.logger.Warn("failed to cache unread count", zap.Error(err))
+		}
+	}
+	return count, nil
+}
+
+// invalidateCache drops the user's cached unread count
+func (s *NotificationService) invalidateCache(ctx context.Context, userID string) {
+	if s.cache == nil {
+		return
+	}
+	if err := s.cache.Delete(ctx, unreadCountKey(userID)); err != nil {
+		s.logger.Warn("failed to invalidate notification cache", zap.Error(err))
+	}
+}
+
+func unreadCountKey(userID string) string {
+	return fmt.Sprintf("notifications:%s:unread", userID)
+}
+
+// GetNotifications returns a page of the user's notifications, newest first.
+// They can be filtered by type and by status, where "unread" matches every
+// status but read.
+func (s *NotificationService) GetNotifications(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	limit := defaultPageSize
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxPageSize {
+			common.BadRequestResponse(c, fmt.Sprintf("limit must be between 1 and %d", maxPageSize))
+			return
+		}
+		limit = parsed
+	}
+
+	filter := notificationFilter{
+		Type:   c.Query("type"),
+		Status: c.Query("status"),
+	}
+	if filter.Type != "" && !knownType(filter.Type) {
+		common.BadRequestResponse(c, fmt.Sprintf("unknown notification type %q", filter.Type))
+		return
+	}
+	if filter.Status != "" && filter.Status != statusUnread && !slices.Contains(notificationStatuses, filter.Status) {
+		common.BadRequestResponse(c, "status must be one of "+statusUnread+", "+strings.Join(notificationStatuses, ", "))
+		return
+	}
+
+	page, err := s.listNotifications(c.Request.Context(), userID, filter, c.Query("cursor"), limit)
+	if err != nil {
+		if errors.Is(err, errInvalidCursor) {
+			common.BadRequestResponse(c, "invalid cursor")
+			return
+		}
+		s.logger.Error("failed to get notifications", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, page)
+}
+
+// GetUnreadCount returns the number of unread notifications
+func (s *NotificationService) GetUnreadCount(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	count, err := s.unreadCount(c.Request.Context(), userID)
+	if err != nil {
+		s.logger.Error("failed to count unread notifications", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{"count": count})
+}
+
+// MarkRead marks one of the user's notifications as read
+func (s *NotificationService) MarkRead(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		common.NotFoundResponse(c, "notification not found")
+		return
+	}
+
+	ctx := c.Request.Context()
+	var n Notification
+	if err := s.db.WithContext(ctx).First(&n, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			common.NotFoundResponse(c, "notification not found")
+			return
+		}
+		s.logger.Error("failed to get notification", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	if n.Status != StatusRead {
+		now := time.Now().UTC()
+		n.Status = StatusRead
+		n.ReadAt = &now
+		if err := s.db.WithContext(ctx).Model(&n).Select("status", "read_at").Updates(&n).Error; err != nil {
+			s.logger.Error("failed to mark notification read", zap.Error(err))
+			common.ErrorResponse(c, common.ErrInternalServer)
+			return
+		}
+		s.invalidateCache(ctx, userID)
+	}
+
+	common.SuccessResponse(c, n)
+}
+
+// MarkAllRead marks all of the user's notifications as read
+func (s *NotificationService) MarkAllRead(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	ctx := c.Request.Context()
+	result := s.db.WithContext(ctx).Model(&Notification{}).
+		Where("user_id = ? AND status <> ?", userID, StatusRead).
+		Updates(map[string]interface{}{
+			"status":  StatusRead,
+			"read_at": time.Now().UTC(),
+		})
+	if result.Error != nil {
+		s.logger.Error("failed to mark notifications read", zap.Error(result.Error))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+	s.invalidateCache(ctx, userID)
+
+	common.SuccessResponse(c, gin.H{"updated": result.RowsAffected})
+}
+
+// DeleteNotification deletes one of the user's notifications
+func (s *NotificationService) DeleteNotification(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		common.NotFoundResponse(c, "notification not found")
+		return
+	}
+
+	ctx := c.Request.Context()
+	result := s.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&Notification{})
+	if result.Error != nil {
+		s.logger.Error("failed to delete notification", zap.Error(result.Error))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+	if result.RowsAffected == 0 {
+		common.NotFoundResponse(c, "notification not found")
+		return
+	}
+	s.invalidateCache(ctx, userID)
+
+	common.NoContentResponse(c)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	n := Notification{
+		ID:        uuid.New().String(),
+		CreatedAt: time.Date(2026, 10, 18, 12, 30, 0, 123456000, time.FixedZone("CEST", 2*60*60)),
+	}
+
+	createdAt, id, err := decodeCursor(encodeCursor(n))
+	require.NoError(t, err)
+	assert.True(t, n.CreatedAt.Equal(createdAt))
+	assert.Equal(t, n.ID, id)
+
+	invalid := map[string]string{
+		"not base64":   "!!!",
+		"no separator": base64.RawURLEncoding.EncodeToString([]byte("2026-10-18T12:30:00Z")),
+		"bad time":     base64.RawURLEncoding.EncodeToString([]byte("yesterday|" + n.ID)),
+		"bad id":       base64.RawURLEncoding.EncodeToString([]byte("2026-10-18T12:30:00Z|42")),
+		"empty id":     base64.RawURLEncoding.EncodeToString([]byte("2026-10-18T12:30:00Z|")),
+		"empty":        "",
+	}
+	for name, cursor := range invalid {
+		t.Run(name, func(t *testing.T) {
+			_, _, err := decodeCursor(cursor)
+			assert.ErrorIs(t, err, errInvalidCursor)
+		})
+	}
+}
+
+func TestListNotifications_Pages(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	userID := uuid.New().String()
+	stored := storeNotifications(t, s, userID, "1", "2", "3", "4", "5")
+	storeNotifications(t, s, uuid.New().String(), "someone else's")
+
+	// Notifications created in the same instant are ordered by ID
+	tied := storeNotifications(t, s, userID, "6", "7")
+	require.NoError(t, s.db.DB().Model(&Notification{}).
+		Where("id = ?", tied[1].ID).
+		Update("created_at", tied[0].CreatedAt).Error)
+	tied[1].CreatedAt = tied[0].CreatedAt
+	stored = append(stored, tied...)
+
+	var seen []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		require.Less(t, pages, len(stored), "paging does not end")
+
+		page, err := s.listNotifications(ctx, userID, notificationFilter{}, cursor, 2)
+		require.NoError(t, err)
+		for _, n := range page.Items {
+			seen = append(seen, n.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		assert.Len(t, page.Items, 2)
+		cursor = page.NextCursor
+	}
+
+	// Newest first, each notification exactly once
+	tiedNewest, tiedOldest := tied[0].ID, tied[1].ID
+	if tiedNewest < tiedOldest {
+		tiedNewest, tiedOldest = tiedOldest, tiedNewest
+	}
+	want := []string{tiedNewest, tiedOldest}
+	for i := 4; i >= 0; i-- {
+		want = append(want, stored[i].ID)
+	}
+	assert.Equal(t, want, seen)
+}
+
+func TestListNotifications_Filters(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	userID := uuid.New().String()
+	stored := storeNotifications(t, s, userID, "liked", "read", "followed")
+	require.NoError(t, s.db.DB().Model(&Notification{}).
+		Where("id = ?", stored[1].ID).
+		Update("status", StatusRead).Error)
+	require.NoError(t, s.db.DB().Model(&Notification{}).
+		Where("id = ?", stored[2].ID).
+		Update("type", "user_followed").Error)
+
+	ids := func(filter notificationFilter) []string {
+		page, err := s.listNotifications(ctx, userID, filter, "", defaultPageSize)
+		require.NoError(t, err)
+		var ids []string
+		for _, n := range page.Items {
+			ids = append(ids, n.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []string{stored[2].ID, stored[0].ID}, ids(notificationFilter{Status: statusUnread}))
+	assert.Equal(t, []string{stored[1].ID}, ids(notificationFilter{Status: StatusRead}))
+	assert.Equal(t, []string{stored[2].ID}, ids(notificationFilter{Type: "user_followed"}))
+	assert.Equal(t, []string{stored[0].ID}, ids(notificationFilter{Type: "feed_liked", Status: statusUnread}))
+}
+
+func TestNotification_Checks(t *testing.T) {
+	s := newTestService(t)
+
+	n := Notification{UserID: uuid.New().String(), Type: "like", Title: "unknown type"}
+	assert.Error(t, create(s.db.DB(), &n))
+
+	n = Notification{UserID: uuid.New().String(), Type: "feed_liked", Title: "known type"}
+	require.NoError(t, create(s.db.DB(), &n))
+	assert.Error(t, s.db.DB().Model(&n).Update("status", "archived").Error)
+}
+
+func TestGetNotifications(t *testing.T) {
+	s := newTestService(t)
+	router := gin.New()
+	router.GET("/notifications", s.GetNotifications)
+
+	userID := uuid.New().String()
+	stored := storeNotifications(t, s, userID, "first", "second")
+
+	tests := []struct {
+		name   string
+		userID string
+		query  string
+		status int
+		items  []string
+		next   bool
+	}{
+		{name: "unauthenticated", status: http.StatusUnauthorized},
+		{name: "first page", userID: userID, query: "?limit=1", status: http.StatusOK, items: []string{stored[1].ID}, next: true},
+		{name: "next page", userID: userID, query: "?limit=1&cursor=" + encodeCursor(stored[1]), status: http.StatusOK, items: []string{stored[0].ID}},
+		{name: "limit too small", userID: userID, query: "?limit=0", status: http.StatusBadRequest},
+		{name: "limit too large", userID: userID, query: "?limit=101", status: http.StatusBadRequest},
+		{name: "limit not a number", userID: userID, query: "?limit=ten", status: http.StatusBadRequest},
+		{name: "unknown status", userID: userID, query: "?status=archived", status: http.StatusBadRequest},
+		{name: "invalid cursor", userID: userID, query: "?cursor=garbage", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/notifications"+tt.query, nil)
+			if tt.userID != "" {
+				req.Header.Set("X-User-ID", tt.userID)
+			}
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Data NotificationPage `json:"data"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			var items []string
+			for _, n := range body.Data.Items {
+				items = append(items, n.ID)
+			}
+			assert.Equal(t, tt.items, items)
+			assert.Equal(t, tt.next, body.Data.NextCursor != "")
+		})
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+// Delivery channels
+const (
+	ChannelInApp  = "in_app"
+	ChannelEmail  = "email"
+	ChannelPush   = "push"
+	ChannelDigest = "digest"
+)
+
+// defaultType is the preference type that applies to every notification type
+// without a preference of its own
+const defaultType = "default"
+
+// maxPreferenceTypes caps the notification types a user can set channels for
+const maxPreferenceTypes = 50
+
+// clockLayout is the layout of quiet hour times
+const clockLayout = "15:04"
+
+// ChannelPreference selects the channels a notification type is delivered on
+type ChannelPreference struct {
+	InApp  bool `json:"in_app"`
+	Email  bool `json:"email"`
+	Push   bool `json:"push"`
+	Digest bool `json:"digest"`
+}
+
+// DefaultChannelPreference returns the channels used until a user sets their
+// own: in-app and push, but no email
+func DefaultChannelPreference() ChannelPreference {
+	return ChannelPreference{
+		InApp: true,
+		Push:  true,
+	}
+}
+
+// enabled reports whether the channel is selected
+func (p ChannelPreference) enabled(channel string) bool {
+	switch channel {
+	case ChannelInApp:
+		return p.InApp
+	case ChannelEmail:
+		return p.Email
+	case ChannelPush:
+		return p.Push
+	case ChannelDigest:
+		return p.Digest
+	default:
+		return false
+	}
+}
+
+// QuietHours is a daily window, in the user's time zone, during which email
+// and push notifications are held back for the digest. The window may span
+// midnight.
+type QuietHours struct {
+	Start string `json:"start" binding:"required"`
+	End   string `json:"end" binding:"required"`
+}
+
+// contains reports whether the local time t falls within the window
+func (q QuietHours) contains(t time.Time) bool {
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(q.End)
+	if err != nil {
+		return false
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	if start <= end {
+		return start <= now && now < end
+	}
+	return now >= start || now < end
+}
+
+// normalize checks that both ends are HH:MM times that differ, and returns
+// them zero-padded
+func (q QuietHours) normalize() (*QuietHours, error) {
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return nil, fmt.Errorf("quiet_hours.start must be a time as HH:MM")
+	}
+	end, err := parseClock(q.End)
+	if err != nil {
+		return nil, fmt.Errorf("quiet_hours.end must be a time as HH:MM")
+	}
+	if start == end {
+		return nil, fmt.Errorf("quiet_hours.start and quiet_hours.end must differ")
+	}
+	return &QuietHours{
+		Start: fmt.Sprintf("%02d:%02d", start/60, start%60),
+		End:   fmt.Sprintf("%02d:%02d", end/60, end%60),
+	}, nil
+}
+
+// parseClock returns the minutes since midnight of an HH:MM time
+func parseClock(value string) (int, error) {
+	t, err := time.Parse(clockLayout, value)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Preferences are a user's notification preferences
+type Preferences struct {
+	Locale     string                       `json:"locale"`
+	TimeZone   string                       `json:"time_zone"`
+	QuietHours *QuietHours                  `json:"quiet_hours"`
+	Digest     DigestSchedule               `json:"digest"`
+	Default    ChannelPreference            `json:"default"`
+	Types      map[string]ChannelPreference `json:"types"`
+
+	location *time.Location
+}
+
+// DefaultPreferences returns the preferences of a user who has not set any
+func DefaultPreferences() *Preferences {
+	return &Preferences{
+		Locale:   defaultLocale,
+		TimeZone: "UTC",
+		Digest:   DefaultDigestSchedule(),
+		Default:  DefaultChannelPreference(),
+		Types:    make(map[string]ChannelPreference),
+		location: time.UTC,
+	}
+}
+
+// channels returns the channels for a notification type: the user's choice
+// for the type, else the type's own defaults, else the user's default
+func (p *Preferences) channels(notificationType string) ChannelPreference {
+	if channels, ok := p.Types[notificationType]; ok {
+		return channels
+	}
+	if channels := notificationTypes[notificationType].Channels; channels != nil {
+		return *channels
+	}
+	return p.Default
+}
+
+// quiet reports whether t falls within the user's quiet hours
+func (p *Preferences) quiet(t time.Time) bool {
+	if p.QuietHours == nil {
+		return false
+	}
+	loc := p.location
+	if loc == nil {
+		loc = time.UTC
+	}
+	return p.QuietHours.contains(t.In(loc))
+}
+
+// NotificationSettings holds the preferences that apply across types
+type NotificationSettings struct {
+	UserID          string  `gorm:"primaryKey;type:uuid"`
+	Locale          string  `gorm:"type:varchar(10);not null;default:en"`
+	TimeZone        string  `gorm:"type:varchar(64);not null"`
+	QuietHoursStart *string `gorm:"type:varchar(5)"`
+	QuietHoursEnd   *string `gorm:"type:varchar(5)"`
+	DigestFrequency string  `gorm:"type:varchar(10);not null;default:daily"`
+	DigestTime      string  `gorm:"type:varchar(5);not null;default:08:00"`
+	DigestDay       string  `gorm:"type:varchar(9);not null;default:monday"`
+	DigestChannel   string  `gorm:"type:varchar(10);not null;default:email"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// TableName returns the table name for NotificationSettings
+func (NotificationSettings) TableName() string {
+	return "notification_settings"
+}
+
+// NotificationPreference holds a user's channels for one notification type
+type NotificationPreference struct {
+	UserID    string `gorm:"primaryKey;type:uuid"`
+	Type      string `gorm:"primaryKey;type:varchar(50)"`
+	InApp     bool   `gorm:"not null"`
+	Email     bool   `gorm:"not null"`
+	Push      bool   `gorm:"not null"`
+	Digest    bool   `gorm:"not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TableName returns the table name for NotificationPreference
+func (NotificationPreference) TableName() string {
+	return "notification_preferences"
+}
+
+func (p NotificationPreference) channels() ChannelPreference {
+	return ChannelPreference{
+		InApp:  p.InApp,
+		Email:  p.Email,
+		Push:   p.Push,
+		Digest: p.Digest,
+	}
+}
+
+// preferences loads a user's preferences, falling back to the defaults for
+// anything they have not set
+func (s *NotificationService) preferences(ctx context.Context, userID string) (*Preferences, error) {
+	prefs := DefaultPreferences()
+
+	var settings NotificationSettings
+	err := s.db.WithContext(ctx).First(&settings, "user_id = ?", userID).Error
+	switch {
+	case err == nil:
+		prefs.Locale = settings.Locale
+		prefs.TimeZone = settings.TimeZone
+		if loc, err := time.LoadLocation(settings.TimeZone); err == nil {
+			prefs.location = loc
+		} else {
+			s.logger.Warn("unknown time zone in notification settings",
+				zap.String("user_id", userID),
+				zap.String("time_zone", settings.TimeZone),
+			)
+		}
+		prefs.Digest = DigestSchedule{
+			Frequency: settings.DigestFrequency,
+			Time:      settings.DigestTime,
+			Day:       settings.DigestDay,
+			Channel:   settings.DigestChannel,
+		}
+		if settings.QuietHoursStart != nil && settings.QuietHoursEnd != nil {
+			prefs.QuietHours = &QuietHours{
+				Start: *settings.QuietHoursStart,
+				End:   *settings.QuietHoursEnd,
+			}
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+	default:
+		return nil, fmt.Errorf("load notification settings: %w", err)
+	}
+
+	var rows []NotificationPreference
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("load notification preferences: %w", err)
+	}
+	for _, row := range rows {
+		if row.Type == defaultType {
+			prefs.Default = row.channels()
+			continue
+		}
+		prefs.Types[row.Type] = row.channels()
+	}
+
+	return prefs, nil
+}
+
+// savePreferences replaces a user's preferences
+func (s *NotificationService) savePreferences(ctx context.Context, userID string, prefs *Preferences) error {
+	settings := NotificationSettings{
+		UserID:          userID,
+		Locale:          prefs.Locale,
+		TimeZone:        prefs.TimeZone,
+		DigestFrequency: prefs.Digest.Frequency,
+		DigestTime:      prefs.Digest.Time,
+		DigestDay:       prefs.Digest.Day,
+		DigestChannel:   prefs.Digest.Channel,
+	}
+	if prefs.QuietHours != nil {
+		settings.QuietHoursStart = &prefs.QuietHours.Start
+		settings.QuietHoursEnd = &prefs.QuietHours.End
+	}
+
+	rows := []NotificationPreference{preferenceRow(userID, defaultType, prefs.Default)}
+	for notificationType, channels := range prefs.Types {
+		rows = append(rows, preferenceRow(userID, notificationType, channels))
+	}
+
+	return s.db.Transaction(ctx, func(tx *gorm.DB) error {
+		upsert := clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{
+				"locale", "time_zone", "quiet_hours_start", "quiet_hours_end",
+				"digest_frequency", "digest_time", "digest_day", "digest_channel", "updated_at",
+			}),
+		}
+		if err := tx.Clauses(upsert).Create(&settings).Error; err != nil {
+			return fmt.Errorf("save notification settings: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&NotificationPreference{}).Error; err != nil {
+			return fmt.Errorf("clear notification preferences: %w", err)
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return fmt.Errorf("save notification preferences: %w", err)
+		}
+		return nil
+	})
+}
+
+func preferenceRow(userID, notificationType string, channels ChannelPreference) NotificationPreference {
+	return NotificationPreference{
+		UserID: userID,
+		Type:   notificationType,
+		InApp:  channels.InApp,
+		Email:  channels.Email,
+		Push:   channels.Push,
+		Digest: channels.Digest,
+	}
+}
+
+// GetPreferences returns the user's notification preferences
+func (s *NotificationService) GetPreferences(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	prefs, err := s.preferences(c.Request.Context(), userID)
+	if err != nil {
+		s.logger.Error("failed to get notification preferences", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, prefs)
+}
+
+// UpdatePreferences replaces the user's notification preferences. Types left
+// out fall back to the default channels, leaving out quiet_hours turns them
+// off, and leaving out digest restores the default schedule.
+func (s *NotificationService) UpdatePreferences(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	var req struct {
+		Locale     string                       `json:"locale"`
+		TimeZone   string                       `json:"time_zone" binding:"max=64"`
+		QuietHours *QuietHours                  `json:"quiet_hours"`
+		Digest     *DigestSchedule              `json:"digest"`
+		Default    *ChannelPreference           `json:"default"`
+		Types      map[string]ChannelPreference `json:"types"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	prefs := DefaultPreferences()
+	if req.Locale != "" {
+		if !supportedLocale(req.Locale) {
+			common.BadRequestResponse(c, fmt.Sprintf("unsupported locale %q", req.Locale))
+			return
+		}
+		prefs.Locale = req.Locale
+	}
+	if req.TimeZone != "" {
+		loc, err := time.LoadLocation(req.TimeZone)
+		if err != nil || req.TimeZone == "Local" {
+			common.BadRequestResponse(c, "time_zone must be an IANA time zone such as Europe/London")
+			return
+		}
+		prefs.TimeZone = req.TimeZone
+		prefs.location = loc
+	}
+	if req.QuietHours != nil {
+		quietHours, err := req.QuietHours.normalize()
+		if err != nil {
+			common.BadRequestResponse(c, err.Error())
+			return
+		}
+		prefs.QuietHours = quietHours
+	}
+	if req.Digest != nil {
+		digest, err := req.Digest.normalize()
+		if err != nil {
+			common.BadRequestResponse(c, err.Error())
+			return
+		}
+		prefs.Digest = *digest
+	}
+	if req.Default != nil {
+		prefs.Default = *req.Default
+	}
+
+	if len(req.Types) > maxPreferenceTypes {
+		common.BadRequestResponse(c, fmt.Sprintf("at most %d types may have preferences", maxPreferenceTypes))
+		return
+	}
+	for notificationType, channels := range req.Types {
+		if !knownType(notificationType) {
+			common.BadRequestResponse(c, fmt.Sprintf("invalid notification type %q", notificationType))
+			return
+		}
+		prefs.Types[notificationType] = channels
+	}
+
+	if err := s.savePreferences(c.Request.Context(), userID, prefs); err != nil {
+		s.logger.Error("failed to update notification preferences", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, prefs)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuietHours_Contains(t *testing.T) {
+	tests := []struct {
+		name  string
+		quiet QuietHours
+		clock string
+		want  bool
+	}{
+		{name: "within a daytime window", quiet: QuietHours{Start: "09:00", End: "17:00"}, clock: "12:00", want: true},
+		{name: "at the start", quiet: QuietHours{Start: "09:00", End: "17:00"}, clock: "09:00", want: true},
+		{name: "at the end", quiet: QuietHours{Start: "09:00", End: "17:00"}, clock: "17:00", want: false},
+		{name: "before a daytime window", quiet: QuietHours{Start: "09:00", End: "17:00"}, clock: "08:59", want: false},
+		{name: "late in an overnight window", quiet: QuietHours{Start: "22:00", End: "07:00"}, clock: "23:30", want: true},
+		{name: "early in an overnight window", quiet: QuietHours{Start: "22:00", End: "07:00"}, clock: "06:59", want: true},
+		{name: "midnight in an overnight window", quiet: QuietHours{Start: "22:00", End: "07:00"}, clock: "00:00", want: true},
+		{name: "outside an overnight window", quiet: QuietHours{Start: "22:00", End: "07:00"}, clock: "12:00", want: false},
+		{name: "at the end of an overnight window", quiet: QuietHours{Start: "22:00", End: "07:00"}, clock: "07:00", want: false},
+		{name: "invalid start", quiet: QuietHours{Start: "late", End: "07:00"}, clock: "23:00", want: false},
+		{name: "invalid end", quiet: QuietHours{Start: "22:00", End: "25:00"}, clock: "23:00", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock, err := time.Parse(clockLayout, tt.clock)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tt.quiet.contains(clock))
+		})
+	}
+}
+
+func TestQuietHours_Normalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		quiet   QuietHours
+		want    QuietHours
+		message string
+	}{
+		{name: "padded", quiet: QuietHours{Start: "22:00", End: "07:30"}, want: QuietHours{Start: "22:00", End: "07:30"}},
+		{name: "unpadded", quiet: QuietHours{Start: "9:05", End: "7:00"}, want: QuietHours{Start: "09:05", End: "07:00"}},
+		{name: "invalid start", quiet: QuietHours{Start: "10pm", End: "07:00"}, message: "quiet_hours.start must be a time as HH:MM"},
+		{name: "invalid end", quiet: QuietHours{Start: "22:00", End: "24:00"}, message: "quiet_hours.end must be a time as HH:MM"},
+		{name: "empty window", quiet: QuietHours{Start: "22:00", End: "22:00"}, message: "quiet_hours.start and quiet_hours.end must differ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.quiet.normalize()
+			if tt.message != "" {
+				assert.EqualError(t, err, tt.message)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *got)
+		})
+	}
+}
+
+func TestPreferences_Quiet(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	prefs := DefaultPreferences()
+	assert.False(t, prefs.quiet(time.Now()), "no quiet hours by default")
+
+	prefs.QuietHours = &QuietHours{Start: "22:00", End: "07:00"}
+	prefs.location = newYork
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want bool
+	}{
+		// 03:00 UTC is 23:00 in New York during daylight saving time
+		{name: "night in the user's zone", now: time.Date(2026, 7, 1, 3, 0, 0, 0, time.UTC), want: true},
+		// 12:00 UTC is 08:00 in New York
+		{name: "morning in the user's zone", now: time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC), want: false},
+		// 11:30 UTC is 06:30 in New York in winter but 07:30 in summer
+		{name: "before the end in standard time", now: time.Date(2026, 1, 15, 11, 30, 0, 0, time.UTC), want: true},
+		{name: "after the end in daylight saving time", now: time.Date(2026, 7, 15, 11, 30, 0, 0, time.UTC), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, prefs.quiet(tt.now))
+		})
+	}
+}
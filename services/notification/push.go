@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm/clause"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+	"github.com/Femi-lawal/udagram-app/pkg/database"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+	"github.com/Femi-lawal/udagram-app/pkg/webpush"
+)
+
+// maxPushSubscriptions caps the devices per user; registering another drops
+// the least recently updated
+const maxPushSubscriptions = 20
+
+// defaultPushHosts are the push services of the major browsers: Chrome,
+// Firefox, Edge and Safari. A leading dot matches subdomains.
+var defaultPushHosts = []string{
+	"fcm.googleapis.com",
+	"updates.push.services.mozilla.com",
+	".notify.windows.com",
+	"web.push.apple.com",
+}
+
+// allowedPushEndpoint reports whether endpoint is an https URL on one of the
+// push service hosts. Endpoints come from clients, so anything else could
+// point the service at internal addresses.
+func allowedPushEndpoint(endpoint string, hosts []string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.User != nil {
+		return false
+	}
+	if port := u.Port(); port != "" && port != "443" {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range hosts {
+		if strings.HasPrefix(allowed, ".") {
+			if strings.HasSuffix(host, allowed) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// PushSubscription is a browser the user receives push notifications on
+type PushSubscription struct {
+	ID         string     `gorm:"primaryKey;type:uuid" json:"id"`
+	UserID     string     `gorm:"type:uuid;not null;index" json:"-"`
+	Endpoint   string     `gorm:"type:text;not null;uniqueIndex" json:"endpoint"`
+	P256dh     string     `gorm:"type:varchar(128);not null" json:"-"`
+	Auth       string     `gorm:"type:varchar(64);not null" json:"-"`
+	Device     string     `gorm:"type:varchar(100)" json:"device,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// TableName returns the table name for PushSubscription
+func (PushSubscription) TableName() string {
+	return "push_subscriptions"
+}
+
+// pushPayload is what the service worker receives
+type pushPayload struct {
+	ID        string    `json:"id,omitempty"`
+	Type      string    `json:"type"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body,omitempty"`
+	URL       string    `json:"url"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// pushNotifier sends notifications to the user's browsers over Web Push
+type pushNotifier struct {
+	db     *database.Client
+	client *webpush.Client
+	appURL string
+	// hosts are the push services sent to
+	hosts  []string
+	logger *zap.Logger
+}
+
+func newPushNotifier(db *database.Client, client *webpush.Client, appURL string, hosts []string, logger *zap.Logger) *pushNotifier {
+	return &pushNotifier{
+		db:     db,
+		client: client,
+		appURL: appURL,
+		hosts:  hosts,
+		logger: logger,
+	}
+}
+
+// Notify pushes the notification to each of the user's subscriptions,
+// removing those the push service reports as gone. It succeeds if any
+// device got the notification.
+func (p *pushNotifier) Notify(ctx context.Context, n *Notification, prefs *Preferences) error {
+	var subs []PushSubscription
+	if err := p.db.WithContext(ctx).Where("user_id = ?", n.UserID).Find(&subs).Error; err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return fmt.Errorf("%w: no push subscriptions", errUndeliverable)
+	}
+
+	payload, err := json.Marshal(pushPayload{
+		ID:        n.ID,
+		Type:      n.Type,
+		Title:     n.Title,
+		Body:      n.Message,
+		URL:       p.appURL,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	var delivered []string
+	var errs []error
+	for _, sub := range subs {
+		if !allowedPushEndpoint(sub.Endpoint, p.hosts) {
+			p.logger.Warn("skipping push subscription to an unknown push service",
+				zap.String("user_id", n.UserID),
+				zap.String("subscription_id", sub.ID),
+			)
+			continue
+		}
+
+		err := p.client.Send(ctx, webpush.Subscription{
+			Endpoint: sub.Endpoint,
+			Keys:     webpush.Keys{P256dh: sub.P256dh, Auth: sub.Auth},
+		}, payload, webpush.Options{})
+
+		switch {
+		case err == nil:
+			delivered = append(delivered, sub.ID)
+		case webpush.IsGone(err):
+			// The browser unsubscribed or the subscription expired
+			if err := p.db.WithContext(ctx).Delete(&PushSubscription{}, "id = ?", sub.ID).Error; err != nil {
+				p.logger.Warn("failed to prune push subscription", zap.Error(err))
+			}
+			p.logger.Info("pruned expired push subscription",
+				zap.String("user_id", n.UserID),
+				zap.String("subscription_id", sub.ID),
+			)
+		default:
+			errs = append(errs, err)
+		}
+	}
+
+	if len(delivered) > 0 {
+		err := p.db.WithContext(ctx).Model(&PushSubscription{}).
+			Where("id IN ?", delivered).
+			UpdateColumn("last_used_at", time.Now().UTC()).Error
+		if err != nil {
+			p.logger.Warn("failed to update push subscriptions", zap.Error(err))
+		}
+		return nil
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("%w: no push subscription could be sent to", errUndeliverable)
+	}
+	return errors.Join(errs...)
+}
+
+// GetPushKey returns the VAPID public key that browsers subscribe with
+func (s *NotificationService) GetPushKey(c *gin.Context) {
+	if s.pushKey == "" {
+		common.ErrorResponse(c, common.ErrServiceUnavailable)
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{"public_key": s.pushKey})
+}
+
+// GetPushSubscriptions lists the user's push subscriptions
+func (s *NotificationService) GetPushSubscriptions(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	var subs []PushSubscription
+	err := s.db.WithContext(c.Request.Context()).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&subs).Error
+	if err != nil {
+		s.logger.Error("failed to get push subscriptions", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, subs)
+}
+
+// RegisterPushSubscription stores a browser's push subscription. Registering
+// an endpoint again updates its keys, and moves it to the user if another
+// user had it.
+func (s *NotificationService) RegisterPushSubscription(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	var req struct {
+		Endpoint string       `json:"endpoint" binding:"required,url,max=2048"`
+		Keys     webpush.Keys `json:"keys" binding:"required"`
+		Device   string       `json:"device" binding:"max=100"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	// Only known push services are sent to
+	if !allowedPushEndpoint(req.Endpoint, s.pushHosts) {
+		common.BadRequestResponse(c, "endpoint must be an https URL of a supported push service")
+		return
+	}
+	if err := webpush.ValidateKeys(req.Keys); err != nil {
+		common.BadRequestResponse(c, "invalid subscription keys")
+		return
+	}
+
+	sub := PushSubscription{
+		ID:       uuid.New().String(),
+		UserID:   userID,
+		Endpoint: req.Endpoint,
+		P256dh:   req.Keys.P256dh,
+		Auth:     req.Keys.Auth,
+		Device:   req.Device,
+	}
+
+	ctx := c.Request.Context()
+	err := s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "endpoint"}},
+		DoUpdates: clause.AssignmentColumns([]string{"user_id", "p256dh", "auth", "device", "updated_at"}),
+	}).Create(&sub).Error
+	if err != nil {
+		s.logger.Error("failed to register push subscription", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	// Read back the row, whose ID is the existing one on re-registration
+	if err := s.db.WithContext(ctx).First(&sub, "endpoint = ?", req.Endpoint).Error; err != nil {
+		s.logger.Error("failed to get push subscription", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+	s.prunePushSubscriptions(ctx, userID)
+
+	common.CreatedResponse(c, sub)
+}
+
+// prunePushSubscriptions drops the user's least recently updated
+// subscriptions over maxPushSubscriptions
+func (s *NotificationService) prunePushSubscriptions(ctx context.Context, userID string) {
+	stale := s.db.WithContext(ctx).Model(&PushSubscription{}).
+		Select("id").
+		Where("user_id = ?", userID).
+		Order("updated_at DESC").
+		Offset(maxPushSubscriptions)
+
+	if err := s.db.WithContext(ctx).Where("id IN (?)", stale).Delete(&PushSubscription{}).Error; err != nil {
+		s.logger.Warn("failed to prune push subscriptions", zap.Error(err))
+	}
+}
+
+// DeletePushSubscription removes one of the user's push subscriptions
+func (s *NotificationService) DeletePushSubscription(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := uuid.Parse(id); err != nil {
+		common.NotFoundResponse(c, "push subscription not found")
+		return
+	}
+
+	result := s.db.WithContext(c.Request.Context()).Where("id = ? AND user_id = ?", id, userID).Delete(&PushSubscription{})
+	if result.Error != nil {
+		s.logger.Error("failed to delete push subscription", zap.Error(result.Error))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+	if result.RowsAffected == 0 {
+		common.NotFoundResponse(c, "push subscription not found")
+		return
+	}
+
+	common.NoContentResponse(c)
+}
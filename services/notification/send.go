@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+	"github.com/Femi-lawal/udagram-app/pkg/messaging"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+// internalTokenHeader carries an internal service's API token. The gateway
+// strips it from client requests.
+const internalTokenHeader = "X-Internal-Token"
+
+// maxParamLength bounds a template parameter
+const maxParamLength = 100
+
+// sendTemplate is the wording of a notification sent through the API, which
+// callers fill in with parameters rather than free text
+type sendTemplate struct {
+	Type  string
+	Title string
+	// Message is rendered with the parameters
+	Message *template.Template
+	// Action describes what the actor did, for aggregated notifications
+	Action string
+	// ReferenceType is what reference_id refers to
+	ReferenceType string
+	// Params are the parameters Message takes, all required
+	Params []string
+}
+
+// sendTemplates are the templates by name. A request's template defaults to
+// its type.
+var sendTemplates = map[string]sendTemplate{
+	TypeFeedCreated:   actorTemplate(TypeFeedCreated, "New post", "shared a new post", "feed_item"),
+	TypeFeedLiked:     actorTemplate(TypeFeedLiked, "New like", "liked your post", "feed_item"),
+	TypeFeedCommented: actorTemplate(TypeFeedCommented, "New comment", "commented on your post", "feed_item"),
+	TypeUserFollowed:  actorTemplate(TypeUserFollowed, "New follower", "started following you", "user"),
+	TypeUserMentioned: actorTemplate(TypeUserMentioned, "New mention", "mentioned you", "feed_item"),
+	"system_maintenance": {
+		Type:    TypeSystemAnnouncement,
+		Title:   "Scheduled maintenance",
+		Message: mustParseMessage("Udagram will be unavailable from {{.start}} to {{.end}}."),
+		Params:  []string{"start", "end"},
+	},
+}
+
+// actorTemplate returns a template for something an actor did, such as
+// "Alice liked your post"
+func actorTemplate(notificationType, title, action, referenceType string) sendTemplate {
+	return sendTemplate{
+		Type:          notificationType,
+		Title:         title,
+		Message:       mustParseMessage("{{.actor_name}} " + action),
+		Action:        action,
+		ReferenceType: referenceType,
+		Params:        []string{"actor_name"},
+	}
+}
+
+func mustParseMessage(text string) *template.Template {
+	return template.Must(template.New("message").Option("missingkey=error").Parse(text))
+}
+
+// render returns the message for params, which must be exactly the
+// template's parameters
+func (t sendTemplate) render(params map[string]string) (string, error) {
+	for _, name := range t.Params {
+		if params[name] == "" {
+			return "", fmt.Errorf("params.%s is required", name)
+		}
+	}
+	if len(params) > len(t.Params) {
+		return "", fmt.Errorf("params must be %s", strings.Join(t.Params, ", "))
+	}
+	for name, value := range params {
+		if len(value) > maxParamLength {
+			return "", fmt.Errorf("params.%s must be at most %d characters", name, maxParamLength)
+		}
+		if strings.IndexFunc(value, unicode.IsControl) >= 0 {
+			return "", fmt.Errorf("params.%s must not contain control characters", name)
+		}
+	}
+
+	var message strings.Builder
+	if err := t.Message.Execute(&message, params); err != nil {
+		return "", err
+	}
+	return message.String(), nil
+}
+
+// SendConfig holds who may send notifications through the API, and how
+// often
+type SendConfig struct {
+	// AdminUserIDs are the users who may send
+	AdminUserIDs []string
+	// ServiceTokens are the API tokens of internal services, by service name
+	ServiceTokens map[string]string
+	// RateLimit is the sustained sends per second per sender
+	RateLimit float64
+	// Burst is how many sends a sender can make at once
+	Burst int
+}
+
+// DefaultSendConfig returns default send configuration, which lets nobody
+// send
+func DefaultSendConfig() SendConfig {
+	return SendConfig{
+		ServiceTokens: make(map[string]string),
+		RateLimit:     5,
+		Burst:         20,
+	}
+}
+
+// parseServiceTokens parses service tokens listed as name:token,name:token
+func parseServiceTokens(value string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(entry, ":")
+		if !ok || name == "" || len(token) < 16 {
+			return nil, fmt.Errorf("invalid service token %q: want name:token with a token of 16 characters or more", name)
+		}
+		tokens[name] = token
+	}
+	return tokens, nil
+}
+
+// AuditLog records a sensitive action in the audit_logs table
+type AuditLog struct {
+	ID         string  `gorm:"primaryKey;type:uuid"`
+	UserID     *string `gorm:"type:uuid;index"`
+	Action     string  `gorm:"type:varchar(50);not null;index"`
+	EntityType string  `gorm:"type:varchar(100);not null"`
+	EntityID   *string `gorm:"type:uuid"`
+	NewValues  string  `gorm:"type:jsonb"`
+	IPAddress  *string `gorm:"type:inet"`
+	UserAgent  string
+	Metadata   string `gorm:"type:jsonb"`
+	CreatedAt  time.Time
+}
+
+// TableName returns the table name for AuditLog
+func (AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+// sender identifies the caller of the send API as service:<name> for an
+// internal service token or user:<id> for an admin. It responds and returns
+// false when the caller may not send.
+func (s *NotificationService) sender(c *gin.Context) (string, bool) {
+	if token := c.GetHeader(internalTokenHeader); token != "" {
+		name, ok := s.internalService(token)
+		if !ok {
+			common.UnauthorizedResponse(c, "invalid internal token")
+			return "", false
+		}
+		return "service:" + name, true
+	}
+
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return "", false
+	}
+	for _, admin := range s.send.AdminUserIDs {
+		if userID == admin {
+			return "user:" + userID, true
+		}
+	}
+	common.ForbiddenResponse(c, "only admins and internal services may send notifications")
+	return "", false
+}
+
+// internalService returns the name of the internal service a token belongs
+// to
+func (s *NotificationService) internalService(token string) (string, bool) {
+	// Compare with every token so the time taken reveals nothing
+	name := ""
+	for service, expected := range s.send.ServiceTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			name = service
+		}
+	}
+	return name, name != ""
+}
+
+// requireInternalService only lets internal services with a valid token
+// through
+func (s *NotificationService) requireInternalService() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		name, ok := s.internalService(c.GetHeader(internalTokenHeader))
+		if !ok {
+			common.UnauthorizedResponse(c, "invalid internal token")
+			c.Abort()
+			return
+		}
+		c.Set("service", name)
+		c.Next()
+	}
+}
+
+// SendNotification sends a notification rendered from a template. Only
+// internal services and admins may send, each at a limited rate, and every
+// send is audited.
+func (s *NotificationService) SendNotification(c *gin.Context) {
+	sender, ok := s.sender(c)
+	if !ok {
+		return
+	}
+	if !s.sendLimiter.Allow(sender) {
+		common.TooManyRequestsResponse(c)
+		return
+	}
+
+	var req struct {
+		UserID      string            `json:"user_id" binding:"required,uuid"`
+		Type        string            `json:"type" binding:"required,max=50"`
+		Template    string            `json:"template" binding:"max=50"`
+		Params      map[string]string `json:"params" binding:"max=10"`
+		ReferenceID string            `json:"reference_id" binding:"omitempty,uuid"`
+		ActorID     string            `json:"actor_id" binding:"omitempty,uuid"`
+	}
+	if !middleware.BindJSON(c, &req) {
+		return
+	}
+
+	if !notificationTypes[req.Type].Sendable {
+		sendable := typeNames(func(t notificationType) bool { return t.Sendable })
+		common.BadRequestResponse(c, "type must be one of "+strings.Join(sendable, ", "))
+		return
+	}
+	if req.Template == "" {
+		req.Template = req.Type
+	}
+	tmpl, ok := sendTemplates[req.Template]
+	if !ok || tmpl.Type != req.Type {
+		common.BadRequestResponse(c, fmt.Sprintf("no template %q for type %s", req.Template, req.Type))
+		return
+	}
+	message, err := tmpl.render(req.Params)
+	if err != nil {
+		common.BadRequestResponse(c, err.Error())
+		return
+	}
+
+	event, err := messaging.NewEvent("notification-service", messaging.NotificationRequested{
+		UserID:        req.UserID,
+		Type:          tmpl.Type,
+		Title:         tmpl.Title,
+		Message:       message,
+		ReferenceID:   req.ReferenceID,
+		ReferenceType: tmpl.ReferenceType,
+		ActorID:       req.ActorID,
+		ActorName:     req.Params["actor_name"],
+		Action:        tmpl.Action,
+	})
+	if err != nil {
+		s.logger.Error("failed to create notification event", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	// The send is audited and the event queued together, so neither happens
+	// without the other
+	err = s.db.Transaction(c.Request.Context(), func(tx *gorm.DB) error {
+		if err := s.audit(tx, c, sender, event.ID, req.Template, event.Data); err != nil {
+			return fmt.Errorf("audit: %w", err)
+		}
+		return messaging.Enqueue(tx, messaging.TopicNotification, req.UserID, event)
+	})
+	if err != nil {
+		s.logger.Error("failed to send notification", zap.Error(err))
+		common.ErrorResponse(c, common.ErrInternalServer)
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"status":   "sent",
+		"event_id": event.ID,
+	})
+}
+
+// audit records who sent what in tx
+func (s *NotificationService) audit(tx *gorm.DB, c *gin.Context, sender, eventID, templateName string, data json.RawMessage) error {
+	metadata, err := json.Marshal(map[string]string{
+		"sender":     sender,
+		"event_id":   eventID,
+		"template":   templateName,
+		"request_id": c.GetString("request_id"),
+	})
+	if err != nil {
+		return err
+	}
+
+	record := AuditLog{
+		ID:         uuid.New().String(),
+		Action:     "send",
+		EntityType: "notification",
+		NewValues:  string(data),
+		UserAgent:  c.Request.UserAgent(),
+		Metadata:   string(metadata),
+	}
+	if userID, ok := strings.CutPrefix(sender, "user:"); ok {
+		record.UserID = &userID
+	}
+	if ip := c.ClientIP(); ip != "" {
+		record.IPAddress = &ip
+	}
+
+	return tx.Create(&record).Error
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/Femi-lawal/udagram-app/pkg/messaging"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+)
+
+func TestSendTemplate_Render(t *testing.T) {
+	liked := sendTemplates[TypeFeedLiked]
+	maintenance := sendTemplates["system_maintenance"]
+
+	tests := []struct {
+		name    string
+		tmpl    sendTemplate
+		params  map[string]string
+		want    string
+		message string
+	}{
+		{name: "actor", tmpl: liked, params: map[string]string{"actor_name": "Alice"}, want: "Alice liked your post"},
+		{name: "several params", tmpl: maintenance, params: map[string]string{"start": "22:00", "end": "23:00"}, want: "Udagram will be unavailable from 22:00 to 23:00."},
+		{name: "not escaped for HTML", tmpl: liked, params: map[string]string{"actor_name": "<b>Alice</b>"}, want: "<b>Alice</b> liked your post"},
+		{name: "missing param", tmpl: maintenance, params: map[string]string{"start": "22:00"}, message: "params.end is required"},
+		{name: "empty param", tmpl: liked, params: map[string]string{"actor_name": ""}, message: "params.actor_name is required"},
+		{name: "no params", tmpl: liked, message: "params.actor_name is required"},
+		{name: "unknown param", tmpl: liked, params: map[string]string{"actor_name": "Alice", "link": "https://example.com"}, message: "params must be actor_name"},
+		{name: "param too long", tmpl: liked, params: map[string]string{"actor_name": strings.Repeat("a", maxParamLength+1)}, message: "params.actor_name must be at most 100 characters"},
+		{name: "control characters", tmpl: liked, params: map[string]string{"actor_name": "Alice\nliked nothing"}, message: "params.actor_name must not contain control characters"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.tmpl.render(tt.params)
+			if tt.message != "" {
+				assert.EqualError(t, err, tt.message)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseServiceTokens(t *testing.T) {
+	tokens, err := parseServiceTokens(" feed:0123456789abcdef , analytics:fedcba9876543210,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"feed": "0123456789abcdef", "analytics": "fedcba9876543210"}, tokens)
+
+	tokens, err = parseServiceTokens("")
+	require.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	for _, value := range []string{"feed", "feed:short", ":0123456789abcdef"} {
+		_, err := parseServiceTokens(value)
+		assert.Error(t, err, value)
+	}
+}
+
+const testServiceToken = "0123456789abcdef"
+
+// newSendRouter serves the send API of a service with one admin and one
+// internal service
+func newSendRouter(t *testing.T) (*gin.Engine, *NotificationService, string) {
+	s := newTestService(t)
+	admin := uuid.New().String()
+	s.send.AdminUserIDs = []string{admin}
+	s.send.ServiceTokens = map[string]string{"feed": testServiceToken}
+
+	router := gin.New()
+	router.POST("/send", s.SendNotification)
+	return router, s, admin
+}
+
+func send(router *gin.Engine, headers map[string]string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/send", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestSendNotification(t *testing.T) {
+	recipient := uuid.New().String()
+	liked := `{"user_id":"` + recipient + `","type":"feed_liked","params":{"actor_name":"Alice"}}`
+
+	tests := []struct {
+		name    string
+		headers func(admin string) map[string]string
+		body    string
+		status  int
+		sender  string
+	}{
+		{
+			name:    "unauthenticated",
+			headers: func(string) map[string]string { return nil },
+			body:    liked,
+			status:  http.StatusUnauthorized,
+		},
+		{
+			name:    "not an admin",
+			headers: func(string) map[string]string { return map[string]string{"X-User-ID": uuid.New().String()} },
+			body:    liked,
+			status:  http.StatusForbidden,
+		},
+		{
+			name: "invalid internal token",
+			headers: func(admin string) map[string]string {
+				// An invalid token is not made up for by the user
+				return map[string]string{internalTokenHeader: "fedcba9876543210", "X-User-ID": admin}
+			},
+			body:   liked,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:    "admin",
+			headers: func(admin string) map[string]string { return map[string]string{"X-User-ID": admin} },
+			body:    liked,
+			status:  http.StatusOK,
+			sender:  "user:",
+		},
+		{
+			name:    "internal service",
+			headers: func(string) map[string]string { return map[string]string{internalTokenHeader: testServiceToken} },
+			body:    liked,
+			status:  http.StatusOK,
+			sender:  "service:feed",
+		},
+		{
+			name:    "type that cannot be sent",
+			headers: func(admin string) map[string]string { return map[string]string{"X-User-ID": admin} },
+			body:    `{"user_id":"` + recipient + `","type":"welcome"}`,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "template of another type",
+			headers: func(admin string) map[string]string { return map[string]string{"X-User-ID": admin} },
+			body:    `{"user_id":"` + recipient + `","type":"feed_liked","template":"system_maintenance","params":{"start":"22:00","end":"23:00"}}`,
+			status:  http.StatusBadRequest,
+		},
+		{
+			name:    "missing params",
+			headers: func(admin string) map[string]string { return map[string]string{"X-User-ID": admin} },
+			body:    `{"user_id":"` + recipient + `","type":"feed_liked"}`,
+			status:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, s, admin := newSendRouter(t)
+
+			w := send(router, tt.headers(admin), tt.body)
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+
+			var events []messaging.OutboxMessage
+			require.NoError(t, s.db.DB().Find(&events).Error)
+			var audits []AuditLog
+			require.NoError(t, s.db.DB().Find(&audits).Error)
+			if tt.status != http.StatusOK {
+				assert.Empty(t, events)
+				assert.Empty(t, audits)
+				return
+			}
+
+			// The send is queued and audited together
+			require.Len(t, events, 1)
+			assert.Equal(t, messaging.TopicNotification, events[0].Topic)
+			assert.Equal(t, recipient, events[0].Key)
+			require.Len(t, audits, 1)
+			assert.Contains(t, audits[0].Metadata, `"sender":"`+tt.sender)
+			assert.Contains(t, audits[0].Metadata, events[0].EventID)
+		})
+	}
+}
+
+func TestSendNotification_RateLimit(t *testing.T) {
+	router, s, admin := newSendRouter(t)
+	limiter := middleware.NewUserRateLimiter(0.001, 2, time.Minute)
+	t.Cleanup(limiter.Stop)
+	s.sendLimiter = limiter
+
+	body := `{"user_id":"` + uuid.New().String() + `","type":"feed_liked","params":{"actor_name":"Alice"}}`
+	asAdmin := map[string]string{"X-User-ID": admin}
+	asService := map[string]string{internalTokenHeader: testServiceToken}
+
+	for i := 0; i < 2; i++ {
+		w := send(router, asAdmin, body)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	w := send(router, asAdmin, body)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// Each sender has their own limit
+	w = send(router, asService, body)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
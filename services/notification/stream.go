@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/common"
+)
+
+// Metrics
+var (
+	activeStreams = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "notification_streams_active",
+			Help: "Number of open notification streams on this replica",
+		},
+	)
+
+	streamEvents = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "notification_stream_events_total",
+			Help: "Total number of notifications pushed to streams",
+		},
+	)
+
+	streamsClosed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "notification_streams_closed_total",
+			Help: "Total number of notification streams closed by the server by reason",
+		},
+		[]string{"reason"},
+	)
+)
+
+// streamRetry is the reconnect delay suggested to clients
+const streamRetry = 3 * time.Second
+
+// StreamConfig holds notification stream configuration
+type StreamConfig struct {
+	// Heartbeat is how often an idle stream gets a comment line, which keeps
+	// proxies from closing it and detects dead clients
+	Heartbeat time.Duration
+	// MaxConnectionsPerUser caps the open streams per user across replicas
+	MaxConnectionsPerUser int
+	// ResumeLimit caps how many missed notifications are replayed on resume
+	ResumeLimit int
+	// Buffer is how many notifications may queue for a slow stream before it
+	// is closed and left to resume
+	Buffer int
+}
+
+// DefaultStreamConfig returns default stream configuration
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{
+		Heartbeat:             15 * time.Second,
+		MaxConnectionsPerUser: 5,
+		ResumeLimit:           100,
+		Buffer:                16,
+	}
+}
+
+// streamHub fans notifications out to the streams open on this replica.
+// With Redis, notifications are published on a per-user channel that the
+// hub subscribes to while the user has streams here, so that a notification
+// reaches the user whichever replica handled it.
+type streamHub struct {
+	rdb    *redis.Client
+	cfg    StreamConfig
+	logger *zap.Logger
+
+	mu          sync.Mutex
+	subscribers map[string]map[*subscriber]struct{}
+	// slots counts each user's claimed stream slots when there is no Redis
+	slots  map[string]int
+	pubsub *redis.PubSub
+}
+
+// subscriber is one open stream
+type subscriber struct {
+	userID string
+	events chan Notification
+	// lagged is closed when the stream fell too far behind
+	lagged chan struct{}
+	once   sync.Once
+}
+
+func newStreamHub(rdb *redis.Client, cfg StreamConfig, logger *zap.Logger) *streamHub {
+	return &streamHub{
+		rdb:         rdb,
+		cfg:         cfg,
+		logger:      logger,
+		subscribers: make(map[string]map[*subscriber]struct{}),
+		slots:       make(map[string]int),
+	}
+}
+
+// Start listens for notifications published by any replica
+func (h *streamHub) Start(ctx context.Context) {
+	if h.rdb == nil {
+		return
+	}
+
+	h.mu.Lock()
+	h.pubsub = h.rdb.Subscribe(ctx)
+	messages := h.pubsub.Channel()
+	h.mu.Unlock()
+
+	go func() {
+		for msg := range messages {
+			var n Notification
+			if err := json.Unmarshal([]byte(msg.Payload), &n); err != nil {
+				h.logger.Warn("invalid notification on stream channel",
+					zap.String("channel", msg.Channel),
+					zap.Error(err),
+				)
+				continue
+			}
+			h.dispatch(n)
+		}
+	}()
+}
+
+// Close stops listening for notifications
+func (h *streamHub) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.pubsub == nil {
+		return nil
+	}
+	return h.pubsub.Close()
+}
+
+// publish sends a stored notification to the user's open streams
+func (h *streamHub) publish(ctx context.Context, n Notification) error {
+	if h.rdb == nil {
+		h.dispatch(n)
+		return nil
+	}
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return h.rdb.Publish(ctx, streamChannel(n.UserID), data).Err()
+}
+
+// dispatch queues a notification on the user's local streams
+func (h *streamHub) dispatch(n Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscribers[n.UserID] {
+		select {
+		case sub.events <- n:
+		default:
+			sub.once.Do(func() { close(sub.lagged) })
+		}
+	}
+}
+
+// subscribe registers a stream for the user
+func (h *streamHub) subscribe(ctx context.Context, userID string) (*subscriber, error) {
+	sub := &subscriber{
+		userID: userID,
+		events: make(chan Notification, h.cfg.Buffer),
+		lagged: make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := h.subscribers[userID]
+	if subs == nil {
+		if h.pubsub != nil {
+			if err := h.pubsub.Subscribe(ctx, streamChannel(userID)); err != nil {
+				return nil, err
+			}
+		}
+		subs = make(map[*subscriber]struct{})
+		h.subscribers[userID] = subs
+	}
+	subs[sub] = struct{}{}
+
+	return sub, nil
+}
+
+// unsubscribe removes a stream, dropping the user's channel subscription
+// with their last stream on this replica
+func (h *streamHub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	subs := h.subscribers[sub.userID]
+	delete(subs, sub)
+	if len(subs) > 0 {
+		return
+	}
+
+	delete(h.subscribers, sub.userID)
+	if h.pubsub != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := h.pubsub.Unsubscribe(ctx, streamChannel(sub.userID)); err != nil {
+			h.logger.Warn("failed to unsubscribe stream channel", zap.Error(err))
+		}
+	}
+}
+
+// acquire claims one of the user's stream slots. Slots are tracked in Redis
+// so the limit holds across replicas; slots of replicas that died without
+// releasing them expire after a few missed heartbeats. Without Redis they are
+// counted locally.
+func (h *streamHub) acquire(ctx context.Context, userID, connID string) (bool, error) {
+	if h.rdb == nil {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.slots[userID] >= h.cfg.MaxConnectionsPerUser {
+			return false, nil
+		}
+		h.slots[userID]++
+		return true, nil
+	}
+
+	key := streamSlotsKey(userID)
+	now := time.Now()
+	var count *redis.IntCmd
+	_, err := h.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-h.slotTTL()).UnixMilli(), 10))
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: connID})
+		count = pipe.ZCard(ctx, key)
+		pipe.Expire(ctx, key, h.slotTTL())
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if count.Val() > int64(h.cfg.MaxConnectionsPerUser) {
+		h.release(ctx, userID, connID)
+		return false, nil
+	}
+	return true, nil
+}
+
+// refresh keeps a stream slot alive
+func (h *streamHub) refresh(ctx context.Context, userID, connID string) {
+	if h.rdb == nil {
+		return
+	}
+
+	key := streamSlotsKey(userID)
+	_, err := h.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(time.Now().UnixMilli()), Member: connID})
+		pipe.Expire(ctx, key, h.slotTTL())
+		return nil
+	})
+	if err != nil {
+		h.logger.Warn("failed to refresh stream slot", zap.Error(err))
+	}
+}
+
+// release frees a stream slot
+func (h *streamHub) release(ctx context.Context, userID, connID string) {
+	if h.rdb == nil {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.slots[userID]--; h.slots[userID] <= 0 {
+			delete(h.slots, userID)
+		}
+		return
+	}
+
+	if err := h.rdb.ZRem(ctx, streamSlotsKey(userID), connID).Err(); err != nil {
+		h.logger.Warn("failed to release stream slot", zap.Error(err))
+	}
+}
+
+func (h *streamHub) slotTTL() time.Duration {
+	return 3 * h.cfg.Heartbeat
+}
+
+func streamChannel(userID string) string {
+	return "notifications:live:" + userID
+}
+
+func streamSlotsKey(userID string) string {
+	return "notifications:streams:" + userID
+}
+
+// StreamNotifications pushes the user's notifications as server-sent events.
+// Clients that reconnect with Last-Event-ID first get the notifications they
+// missed, up to ResumeLimit.
+func (s *NotificationService) StreamNotifications(c *gin.Context) {
+	userID := c.GetHeader("X-User-ID")
+	if userID == "" {
+		common.UnauthorizedResponse(c, "user not authenticated")
+		return
+	}
+
+	ctx := c.Request.Context()
+	lastEventID := c.GetHeader("Last-Event-ID")
+
+	connID := uuid.New().String()
+	ok, err := s.hub.acquire(ctx, userID, connID)
+	if err != nil {
+		// Rather an extra stream than none at all
+		s.logger.Warn("failed to check stream limit", zap.Error(err))
+		ok = true
+	}
+	if !ok {
+		streamsClosed.WithLabelValues("limit").Inc()
+		common.WriteErrorJSON(c.Writer, http.StatusTooManyRequests, "TOO_MANY_STREAMS", "too many open notification streams")
+		return
+	}
+	defer s.hub.release(context.Background(), userID, connID)
+
+	// Subscribe before catching up so nothing published meanwhile is lost
+	sub, err := s.hub.subscribe(ctx, userID)
+	if err != nil {
+		s.logger.Error("failed to subscribe to notifications", zap.Error(err))
+		common.ErrorResponse(c, common.ErrServiceUnavailable)
+		return
+	}
+	defer s.hub.unsubscribe(sub)
+
+	activeStreams.Inc()
+	defer activeStreams.Dec()
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(c.Writer)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		s.logger.Warn("could not lift stream write deadline", zap.Error(err))
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func(format string, args ...any) bool {
+		if _, err := fmt.Fprintf(c.Writer, format, args...); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	if !send("retry: %d\n\n", streamRetry.Milliseconds()) {
+		return
+	}
+
+	// Notifications published while catching up arrive twice; an aggregated
+	// notification updated since arrives again with a later UpdatedAt
+	replayed := make(map[string]time.Time)
+	if lastEventID != "" {
+		missed, err := s.notificationsSince(ctx, userID, lastEventID, s.streamConfig.ResumeLimit)
+		if err != nil {
+			s.logger.Warn("failed to load missed notifications", zap.Error(err))
+		}
+		for _, n := range missed {
+			if !sendNotification(send, n) {
+				return
+			}
+			replayed[n.ID] = n.UpdatedAt
+		}
+	}
+
+	heartbeat := time.NewTicker(s.streamConfig.Heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.streams.Done():
+			streamsClosed.WithLabelValues("shutdown").Inc()
+			return
+		case <-sub.lagged:
+			streamsClosed.WithLabelValues("lagged").Inc()
+			return
+		case n := <-sub.events:
+			if sent, ok := replayed[n.ID]; ok && !n.UpdatedAt.After(sent) {
+				continue
+			}
+			if !sendNotification(send, n) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(": heartbeat\n\n") {
+				return
+			}
+			s.hub.refresh(ctx, userID, connID)
+		}
+	}
+}
+
+func sendNotification(send func(format string, args ...any) bool, n Notification) bool {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return false
+	}
+	streamEvents.Inc()
+	// The event ID is a cursor, so a reconnecting client resumes after it
+	return send("id: %s\nevent: notification\ndata: %s\n\n", encodeCursor(n), data)
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeNotifications stores notifications for the user, oldest first
+func storeNotifications(t *testing.T, s *NotificationService, userID string, titles ...string) []Notification {
+	t.Helper()
+
+	stored := make([]Notification, 0, len(titles))
+	for _, title := range titles {
+		n := Notification{UserID: userID, Type: "feed_liked", Title: title}
+		require.NoError(t, create(s.db.DB(), &n))
+		stored = append(stored, n)
+		// Keep creation times distinct
+		time.Sleep(time.Millisecond)
+	}
+	return stored
+}
+
+// sseEvent is one server-sent event
+type sseEvent struct {
+	ID   string
+	Data Notification
+}
+
+// openStream opens a notification stream for the user and returns its events
+func openStream(t *testing.T, server *httptest.Server, userID, lastEventID string) (*http.Response, <-chan sseEvent) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/stream", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-User-ID", userID)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		var event sseEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Data)
+			case line == "" && event.ID != "":
+				events <- event
+				event = sseEvent{}
+			}
+		}
+	}()
+	return resp, events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "stream closed")
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event on the stream")
+		return sseEvent{}
+	}
+}
+
+// newStreamServer serves the service's streams until the test ends. Streams
+// opened later are closed first, so the server can shut down.
+func newStreamServer(t *testing.T, s *NotificationService) *httptest.Server {
+	router := gin.New()
+	router.GET("/stream", s.StreamNotifications)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStreamNotifications_Resume(t *testing.T) {
+	s := newTestService(t)
+	server := newStreamServer(t, s)
+
+	userID := uuid.New().String()
+	stored := storeNotifications(t, s, userID, "first", "second", "third")
+	storeNotifications(t, s, uuid.New().String(), "someone else's")
+
+	// Resuming after the first replays the rest, oldest first
+	resp, events := openStream(t, server, userID, encodeCursor(stored[0]))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	for _, want := range stored[1:] {
+		event := nextEvent(t, events)
+		assert.Equal(t, want.ID, event.Data.ID)
+		// Each event ID resumes after its notification
+		assert.Equal(t, encodeCursor(want), event.ID)
+	}
+
+	// A replayed notification published meanwhile is not sent twice, but
+	// one updated since is
+	require.NoError(t, s.hub.publish(context.Background(), stored[2]))
+	updated := stored[1]
+	updated.ActorCount = 2
+	updated.UpdatedAt = updated.UpdatedAt.Add(time.Second)
+	require.NoError(t, s.hub.publish(context.Background(), updated))
+
+	event := nextEvent(t, events)
+	assert.Equal(t, updated.ID, event.Data.ID)
+	assert.Equal(t, 2, event.Data.ActorCount)
+}
+
+func TestStreamNotifications_ConnectionLimit(t *testing.T) {
+	s := newTestService(t)
+	s.hub.cfg.MaxConnectionsPerUser = 1
+	server := newStreamServer(t, s)
+
+	userID := uuid.New().String()
+	_, events := openStream(t, server, userID, "")
+
+	// The first stream is subscribed once its live notifications arrive
+	require.Eventually(t, func() bool {
+		_ = s.hub.publish(context.Background(), Notification{ID: uuid.New().String(), UserID: userID})
+		select {
+		case <-events:
+			return true
+		default:
+			return false
+		}
+	}, 2*time.Second, 20*time.Millisecond)
+
+	resp, _ := openStream(t, server, userID, "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Other users have their own slots
+	resp, _ = openStream(t, server, uuid.New().String(), "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestStreamHub_ConcurrentConnectionLimit(t *testing.T) {
+	s := newTestService(t)
+	hub := s.hub
+	hub.cfg.MaxConnectionsPerUser = 3
+
+	// Streams opening at once all claim their slots before any of them
+	// subscribes
+	userID := uuid.New().String()
+	n := hub.cfg.MaxConnectionsPerUser + 1
+	acquired := make(chan string, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			connID := uuid.New().String()
+			ok, err := hub.acquire(context.Background(), userID, connID)
+			if err == nil && ok {
+				acquired <- connID
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(acquired)
+
+	var conns []string
+	for connID := range acquired {
+		conns = append(conns, connID)
+	}
+	require.Len(t, conns, hub.cfg.MaxConnectionsPerUser)
+
+	// A released slot can be claimed again
+	hub.release(context.Background(), userID, conns[0])
+	ok, err := hub.acquire(context.Background(), userID, uuid.New().String())
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestStreamNotifications_Unauthenticated(t *testing.T) {
+	s := newTestService(t)
+	server := newStreamServer(t, s)
+
+	resp, err := http.Get(server.URL + "/stream")
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestStreamHub_ClosesLaggingStreams(t *testing.T) {
+	cfg := DefaultStreamConfig()
+	cfg.Buffer = 1
+	hub := newStreamHub(nil, cfg, nil)
+
+	sub, err := hub.subscribe(context.Background(), "user-1")
+	require.NoError(t, err)
+	defer hub.unsubscribe(sub)
+
+	hub.dispatch(Notification{UserID: "user-1", ID: "a"})
+	hub.dispatch(Notification{UserID: "user-2", ID: "b"})
+	select {
+	case <-sub.lagged:
+		t.Fatal("stream closed before its buffer filled")
+	default:
+	}
+
+	hub.dispatch(Notification{UserID: "user-1", ID: "c"})
+	select {
+	case <-sub.lagged:
+	default:
+		t.Fatal("lagging stream was not closed")
+	}
+}
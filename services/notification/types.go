@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+)
+
+// Notification types
+const (
+	TypeWelcome            = "welcome"
+	TypeVerification       = "verification"
+	TypeDigest             = "digest"
+	TypeFeedCreated        = "feed_created"
+	TypeFeedLiked          = "feed_liked"
+	TypeFeedCommented      = "feed_commented"
+	TypeUserFollowed       = "user_followed"
+	TypeUserMentioned      = "user_mentioned"
+	TypeSystemAnnouncement = "system_announcement"
+)
+
+// notificationType describes how notifications of a type are delivered
+type notificationType struct {
+	// Channels are the type's default channels, when they differ from the
+	// user's default
+	Channels *ChannelPreference
+	// Urgent types are ones users wait on, sent during quiet hours too
+	Urgent bool
+	// Sendable types may be sent through the send API
+	Sendable bool
+}
+
+// notificationTypes are all notification types. The notifications table's
+// chk_notifications_type constraint allows exactly these, so adding one
+// takes a migration too.
+var notificationTypes = map[string]notificationType{
+	// Account emails go out unless the user turns them off for the type
+	TypeWelcome:            {Channels: &ChannelPreference{InApp: true, Email: true}},
+	TypeVerification:       {Channels: &ChannelPreference{Email: true}, Urgent: true},
+	TypeDigest:             {},
+	TypeFeedCreated:        {Sendable: true},
+	TypeFeedLiked:          {Sendable: true},
+	TypeFeedCommented:      {Sendable: true},
+	TypeUserFollowed:       {Sendable: true},
+	TypeUserMentioned:      {Sendable: true},
+	TypeSystemAnnouncement: {Sendable: true},
+}
+
+// knownType reports whether notificationType is a notification type
+func knownType(notificationType string) bool {
+	_, ok := notificationTypes[notificationType]
+	return ok
+}
+
+// typeNames returns the names of the notification types that match, sorted
+func typeNames(match func(notificationType) bool) []string {
+	var names []string
+	for name, t := range notificationTypes {
+		if match(t) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// quotedValues returns the single-quoted values in s, sorted
+func quotedValues(s string) []string {
+	var values []string
+	for _, match := range regexp.MustCompile(`'([^']*)'`).FindAllStringSubmatch(s, -1) {
+		values = append(values, match[1])
+	}
+	sort.Strings(values)
+	return values
+}
+
+// assertCheckConstraint checks that the model field's check constraint and
+// the latest migration adding it allow exactly values
+func assertCheckConstraint(t *testing.T, fieldName, column, constraint string, values []string) {
+	t.Helper()
+
+	want := append([]string(nil), values...)
+	sort.Strings(want)
+
+	field, ok := reflect.TypeOf(Notification{}).FieldByName(fieldName)
+	require.True(t, ok)
+	_, check, ok := strings.Cut(field.Tag.Get("gorm"), "check:"+constraint+",")
+	require.True(t, ok, "the %s column has a check constraint", column)
+	assert.Equal(t, want, quotedValues(check), "the model's %s allows exactly the Go values", constraint)
+
+	// Migrations replace the constraint to change it, so the last one to add
+	// it is current
+	migrations, err := filepath.Glob("../../migrations/[0-9]*.sql")
+	require.NoError(t, err)
+	pattern := regexp.MustCompile(`(?s)ADD CONSTRAINT ` + constraint + ` CHECK \(` + column + ` IN \((.*?)\)\)`)
+	var latest, latestFile string
+	for _, path := range migrations {
+		migration, err := os.ReadFile(path)
+		require.NoError(t, err)
+		for _, match := range pattern.FindAllSubmatch(migration, -1) {
+			latest, latestFile = string(match[1]), filepath.Base(path)
+		}
+	}
+	require.NotEmpty(t, latestFile, "a migration adds %s", constraint)
+	assert.Equal(t, want, quotedValues(latest), "%s in %s allows exactly the Go values", constraint, latestFile)
+}
+
+func TestNotificationTypes_MatchSchema(t *testing.T) {
+	all := typeNames(func(notificationType) bool { return true })
+	assertCheckConstraint(t, "Type", "type", "chk_notifications_type", all)
+}
+
+func TestNotificationStatuses_MatchSchema(t *testing.T) {
+	assertCheckConstraint(t, "Status", "status", "chk_notifications_status", notificationStatuses)
+}
+
+func TestNotificationTypes_Templates(t *testing.T) {
+	// Send templates are for sendable types, and every sendable type has one
+	templated := make(map[string]bool)
+	for name, tmpl := range sendTemplates {
+		assert.True(t, notificationTypes[tmpl.Type].Sendable, "template %s has type %s, which cannot be sent", name, tmpl.Type)
+		templated[tmpl.Type] = true
+	}
+	for _, name := range typeNames(func(t notificationType) bool { return t.Sendable }) {
+		assert.True(t, templated[name], "sendable type %s has no send template", name)
+	}
+
+	// Email templates are named after types
+	for name := range emailTemplates {
+		if name != defaultEmailTemplate {
+			assert.True(t, knownType(name), "email template %s is not a notification type", name)
+		}
+	}
+
+	// Digests only count known types
+	for locale, catalog := range emailCatalogs {
+		for key := range catalog {
+			if rest, ok := strings.CutPrefix(key, "digest.types."); ok {
+				name, _, _ := strings.Cut(rest, ".")
+				assert.True(t, knownType(name), "%s digest message %s is not for a notification type", locale, key)
+			}
+		}
+	}
+}
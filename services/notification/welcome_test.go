@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/Femi-lawal/udagram-app/pkg/database/databasetest"
+	"github.com/Femi-lawal/udagram-app/pkg/messaging"
+	"github.com/Femi-lawal/udagram-app/pkg/middleware"
+	auth "github.com/Femi-lawal/udagram-app/services/auth/server"
+)
+
+// The welcome flow end to end: registering with the auth service writes
+// user.created to its outbox, the relay publishes it and the notification
+// service turns it into a welcome notification
+func TestWelcomeFlow(t *testing.T) {
+	s := newTestService(t)
+	email := &recordingNotifier{}
+	s.notifiers[ChannelEmail] = email
+
+	logger := zap.NewNop()
+	cfg := messaging.Config{Broker: messaging.NewMemoryBroker(1)}
+	producer := messaging.NewProducer(cfg, logger)
+	defer producer.Close()
+
+	authDB := databasetest.New(t, &auth.User{}, &auth.RefreshToken{}, &auth.EmailVerification{}, &messaging.OutboxMessage{})
+	authService := auth.NewAuthService(authDB, nil, producer, logger, middleware.JWTConfig{
+		Secret:        "test-secret",
+		Issuer:        "udagram",
+		Audience:      "udagram-users",
+		AccessExpiry:  time.Minute,
+		RefreshExpiry: time.Hour,
+	}, "https://udagram.example.com/api/v1/auth/verify-email")
+	router := gin.New()
+	authService.RegisterRoutes(router)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	var consumers []*messaging.Consumer
+	defer func() {
+		cancel()
+		wg.Wait()
+		for _, consumer := range consumers {
+			_ = consumer.Close()
+		}
+	}()
+
+	outbox := messaging.DefaultOutboxConfig()
+	outbox.Interval = 10 * time.Millisecond
+	relay := messaging.NewOutboxRelay(authDB, producer, outbox, logger)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		relay.Run(ctx)
+	}()
+
+	handlers := map[string]messaging.MessageHandler{
+		messaging.TopicUserCreated:               messaging.Handle(s.handleUserCreated),
+		messaging.TopicUserVerificationRequested: messaging.Handle(s.handleVerificationRequested),
+	}
+	for topic, handler := range handlers {
+		consumer := messaging.NewConsumer(cfg, topic, "notification-service", logger, handler)
+		consumers = append(consumers, consumer)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = consumer.Start(ctx)
+		}()
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/register",
+		strings.NewReader(`{"email":"jane@example.com","password":"correct horse"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var body struct {
+		Data auth.TokenResponse `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	userID, _ := body.Data.User["id"].(string)
+	require.NotEmpty(t, userID)
+
+	var welcome Notification
+	require.Eventually(t, func() bool {
+		return s.db.DB().Where("user_id = ? AND type = ?", userID, TypeWelcome).First(&welcome).Error == nil
+	}, 5*time.Second, 10*time.Millisecond, "no welcome notification was stored")
+	assert.Equal(t, "Welcome to Udagram", welcome.Title)
+
+	// The welcome email and the verification link reach the address the
+	// user registered with
+	require.Eventually(t, func() bool {
+		return len(email.notifications()) == 2
+	}, 5*time.Second, 10*time.Millisecond, "welcome and verification emails were not sent")
+	types := map[string]Notification{}
+	for _, n := range email.notifications() {
+		assert.Equal(t, userID, n.UserID)
+		types[n.Type] = n
+	}
+	require.Contains(t, types, TypeWelcome)
+	require.Contains(t, types, TypeVerification)
+	assert.Contains(t, types[TypeVerification].Link, "https://udagram.example.com/api/v1/auth/verify-email?token=")
+
+	var recipient NotificationRecipient
+	require.NoError(t, s.db.DB().Where("user_id = ?", userID).First(&recipient).Error)
+	assert.Equal(t, "jane@example.com", recipient.Email)
+}